@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/fsutil"
+	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/internal/strdist"
+)
+
+var shortAnalyzeHelp = "Map an existing root filesystem onto slices"
+var longAnalyzeHelp = `
+The analyze command matches the files in an existing root filesystem,
+such as the one of a Debian-based image, against the contents of the
+slices in the release, and reports which slices would cover them, along
+with the number of files each of them covers. Files not covered by any
+slice are listed afterwards, as they need new slices or a different
+approach before the image can be cut with chisel.
+
+The root may be either a directory or a tarball, optionally compressed
+with gzip. Directories are not reported, as slices create them as
+needed, and several slices may cover the same file.
+
+By default it uses the release for the same Ubuntu version as the
+current host, unless the --release flag or the CHISEL_RELEASE
+environment variable are used.
+`
+
+var analyzeDescs = map[string]string{
+	"release": "Chisel release name or directory (e.g. ubuntu-22.04)",
+}
+
+type cmdAnalyze struct {
+	Release string `long:"release" value-name:"<dir>"`
+
+	Positional struct {
+		Root string `positional-arg-name:"<root>" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func init() {
+	addCommand("analyze", shortAnalyzeHelp, longAnalyzeHelp, func() flags.Commander { return &cmdAnalyze{} }, analyzeDescs, nil)
+}
+
+func (cmd *cmdAnalyze) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	entries, err := readRoot(cmd.Positional.Root)
+	if err != nil {
+		return err
+	}
+	release, err := obtainRelease(optionOrEnv(cmd.Release, releaseEnv))
+	if err != nil {
+		return err
+	}
+
+	coverage, uncovered := analyzeRoot(release, entries)
+	return printAnalysis(coverage, uncovered)
+}
+
+type sliceCoverage struct {
+	Slice setup.SliceKey
+	Paths []string
+}
+
+// analyzeRoot returns the slices in release covering any of the paths in
+// entries other than directories, sorted by name, and the paths not covered
+// by any of them, sorted.
+func analyzeRoot(release *setup.Release, entries map[string]*fsutil.Entry) (coverage []sliceCoverage, uncovered []string) {
+	// Most content paths are not globs, so those are looked up directly
+	// and only the rest are matched against every path.
+	exact := make(map[string][]setup.SliceKey)
+	globs := make(map[string][]setup.SliceKey)
+	for _, pkg := range release.Packages {
+		for _, slice := range pkg.Slices {
+			key := setup.SliceKey{Package: pkg.Name, Slice: slice.Name}
+			for contentPath, pathInfo := range slice.Contents {
+				if pathInfo.Kind == setup.GeneratePath {
+					continue
+				}
+				if pathInfo.Kind == setup.GlobPath {
+					globs[contentPath] = append(globs[contentPath], key)
+				} else {
+					exact[contentPath] = append(exact[contentPath], key)
+				}
+			}
+		}
+	}
+
+	covered := make(map[setup.SliceKey][]string)
+	for path := range entries {
+		if strings.HasSuffix(path, "/") {
+			continue
+		}
+		keys := exact[path]
+		for glob, globKeys := range globs {
+			if strdist.GlobPath(glob, path) {
+				keys = append(keys, globKeys...)
+			}
+		}
+		if len(keys) == 0 {
+			uncovered = append(uncovered, path)
+			continue
+		}
+		seen := make(map[setup.SliceKey]bool)
+		for _, key := range keys {
+			if !seen[key] {
+				seen[key] = true
+				covered[key] = append(covered[key], path)
+			}
+		}
+	}
+
+	for key, paths := range covered {
+		sort.Strings(paths)
+		coverage = append(coverage, sliceCoverage{Slice: key, Paths: paths})
+	}
+	sort.Slice(coverage, func(i, j int) bool {
+		return coverage[i].Slice.String() < coverage[j].Slice.String()
+	})
+	sort.Strings(uncovered)
+	return coverage, uncovered
+}
+
+func printAnalysis(coverage []sliceCoverage, uncovered []string) error {
+	w := tabWriter()
+	fmt.Fprintf(w, "Slice\tFiles\n")
+	for _, c := range coverage {
+		fmt.Fprintf(w, "%s\t%d\n", c.Slice, len(c.Paths))
+	}
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+	if len(uncovered) == 0 {
+		return nil
+	}
+	fmt.Fprintf(Stdout, "\nFiles not covered by any slice:\n")
+	for _, path := range uncovered {
+		fmt.Fprintf(Stdout, "  %s\n", path)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/fsutil"
+	"github.com/canonical/chisel/internal/setup"
+)
+
+func (s *ChiselSuite) TestAnalyze(c *C) {
+	release := &setup.Release{
+		Packages: map[string]*setup.Package{
+			"libc6": {
+				Name: "libc6",
+				Slices: map[string]*setup.Slice{
+					"libs": {
+						Package: "libc6",
+						Name:    "libs",
+						Contents: map[string]setup.PathInfo{
+							"/usr/lib/*-linux-*/libc.so.*": {Kind: setup.GlobPath},
+						},
+					},
+					"config": {
+						Package: "libc6",
+						Name:    "config",
+						Contents: map[string]setup.PathInfo{
+							"/etc/ld.so.conf": {Kind: setup.CopyPath},
+						},
+					},
+				},
+			},
+			"base-files": {
+				Name: "base-files",
+				Slices: map[string]*setup.Slice{
+					"base": {
+						Package: "base-files",
+						Name:    "base",
+						Contents: map[string]setup.PathInfo{
+							"/etc/":               {Kind: setup.DirPath},
+							"/etc/os-release":     {Kind: setup.CopyPath},
+							"/usr/lib/os-release": {Kind: setup.CopyPath},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	root := c.MkDir()
+	for _, path := range []string{
+		"etc/os-release",
+		"etc/ld.so.conf",
+		"etc/hostname",
+		"usr/lib/os-release",
+		"usr/lib/x86_64-linux-gnu/libc.so.6",
+		"usr/bin/bash",
+	} {
+		c.Assert(os.MkdirAll(filepath.Dir(filepath.Join(root, path)), 0755), IsNil)
+		c.Assert(os.WriteFile(filepath.Join(root, path), nil, 0644), IsNil)
+	}
+	entries, err := fsutil.ReadTree(root)
+	c.Assert(err, IsNil)
+
+	err = chisel.PrintAnalysis(release, entries)
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, ""+
+		"Slice            Files\n"+
+		"base-files_base  2\n"+
+		"libc6_config     1\n"+
+		"libc6_libs       1\n"+
+		"\n"+
+		"Files not covered by any slice:\n"+
+		"  /etc/hostname\n"+
+		"  /usr/bin/bash\n")
+}
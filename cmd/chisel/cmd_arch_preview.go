@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/deb"
+	"github.com/canonical/chisel/internal/setup"
+)
+
+var shortArchPreviewHelp = "Show which paths are cut on each architecture"
+var longArchPreviewHelp = `
+The arch-preview command shows, for each architecture, which paths of the
+provided slices are cut and which are skipped due to their "arch" field,
+so that slices that end up empty on some architecture may be caught
+before they are cut there.
+
+Only paths restricted to some architectures are listed. Slices left with
+no paths at all on some architecture are reported after the table.
+Slices may be referred to as with the cut command, including pkg_* and
+@set references.
+
+By default every known architecture is shown, unless --arch is used,
+which may be repeated. By default it uses the release for the same
+Ubuntu version as the current host, unless the --release flag or the
+CHISEL_RELEASE environment variable are used.
+`
+
+var archPreviewDescs = map[string]string{
+	"release": "Chisel release name or directory (e.g. ubuntu-22.04)",
+	"arch":    "Package architecture to show, may be repeated",
+}
+
+type cmdArchPreview struct {
+	Release string   `long:"release" value-name:"<branch|dir>"`
+	Arch    []string `long:"arch" value-name:"<arch>"`
+
+	Positional struct {
+		SliceRefs []string `positional-arg-name:"<slice names>" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func init() {
+	addCommand("arch-preview", shortArchPreviewHelp, longArchPreviewHelp, func() flags.Commander { return &cmdArchPreview{} }, archPreviewDescs, nil)
+}
+
+func (cmd *cmdArchPreview) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	archs := cmd.Arch
+	if len(archs) == 0 {
+		archs = deb.KnownArchs()
+	}
+	for _, arch := range archs {
+		if err := deb.ValidateArch(arch); err != nil {
+			return &usageError{err}
+		}
+	}
+
+	release, err := obtainReleaseFor(optionOrEnv(cmd.Release, releaseEnv), cmd.Positional.SliceRefs)
+	if err != nil {
+		return err
+	}
+	sliceKeys, err := parseSliceRefs(release, cmd.Positional.SliceRefs)
+	if err != nil {
+		return err
+	}
+	return printArchPreview(release, sliceKeys, archs)
+}
+
+// printArchPreview prints a table showing on which of archs each path of the
+// slices restricted to some architectures is cut, followed by the slices
+// which have no paths at all on some of them.
+func printArchPreview(release *setup.Release, sliceKeys []setup.SliceKey, archs []string) error {
+	sort.Slice(sliceKeys, func(i, j int) bool {
+		return sliceKeys[i].String() < sliceKeys[j].String()
+	})
+
+	w := tabWriter()
+	fmt.Fprintf(w, "Slice\tPath\t%s\n", strings.Join(archs, "\t"))
+	var empty []string
+	for _, key := range sliceKeys {
+		slice := release.Packages[key.Package].Slices[key.Slice]
+		paths := make([]string, 0, len(slice.Contents))
+		for path := range slice.Contents {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		active := make(map[string]int)
+		for _, path := range paths {
+			info := slice.Contents[path]
+			cells := make([]string, len(archs))
+			for i, arch := range archs {
+				if len(info.Arch) == 0 || slices.Contains(info.Arch, arch) {
+					active[arch]++
+					cells[i] = "yes"
+				} else {
+					cells[i] = "-"
+				}
+			}
+			if len(info.Arch) > 0 {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", slice, path, strings.Join(cells, "\t"))
+			}
+		}
+		if len(paths) == 0 {
+			continue
+		}
+		var missing []string
+		for _, arch := range archs {
+			if active[arch] == 0 {
+				missing = append(missing, arch)
+			}
+		}
+		if len(missing) > 0 {
+			empty = append(empty, fmt.Sprintf("Slice %s has no paths on %s\n", slice, strings.Join(missing, ", ")))
+		}
+	}
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+	for _, line := range empty {
+		fmt.Fprint(Stderr, line)
+	}
+	return nil
+}
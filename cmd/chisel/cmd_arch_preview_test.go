@@ -0,0 +1,52 @@
+package main_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/setup"
+)
+
+func (s *ChiselSuite) TestPrintArchPreview(c *C) {
+	release := &setup.Release{
+		Packages: map[string]*setup.Package{
+			"mypkg": {
+				Name: "mypkg",
+				Slices: map[string]*setup.Slice{
+					"bins": {
+						Package: "mypkg",
+						Name:    "bins",
+						Contents: map[string]setup.PathInfo{
+							"/usr/bin/foo":     {Kind: setup.CopyPath},
+							"/usr/bin/foo-x86": {Kind: setup.CopyPath, Arch: []string{"amd64", "i386"}},
+						},
+					},
+					"libs": {
+						Package: "mypkg",
+						Name:    "libs",
+						Contents: map[string]setup.PathInfo{
+							"/usr/lib/x86_64-linux-gnu/libfoo.so.1":  {Kind: setup.CopyPath, Arch: []string{"amd64"}},
+							"/usr/lib/aarch64-linux-gnu/libfoo.so.1": {Kind: setup.CopyPath, Arch: []string{"arm64"}},
+						},
+					},
+					"empty": {Package: "mypkg", Name: "empty"},
+				},
+			},
+		},
+	}
+	sliceKeys := []setup.SliceKey{{"mypkg", "libs"}, {"mypkg", "bins"}, {"mypkg", "empty"}}
+	err := chisel.PrintArchPreview(release, sliceKeys, []string{"amd64", "arm64", "s390x"})
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, ""+
+		"Slice       Path                                    amd64  arm64  s390x\n"+
+		"mypkg_bins  /usr/bin/foo-x86                        yes    -      -\n"+
+		"mypkg_libs  /usr/lib/aarch64-linux-gnu/libfoo.so.1  -      yes    -\n"+
+		"mypkg_libs  /usr/lib/x86_64-linux-gnu/libfoo.so.1   yes    -      -\n")
+	c.Assert(s.Stderr(), Equals, "Slice mypkg_libs has no paths on s390x\n")
+}
+
+func (s *ChiselSuite) TestArchPreviewInvalidArch(c *C) {
+	defer fakeArgs("chisel", "arch-preview", "--arch", "foo", "mypkg_bins")()
+	err := chisel.RunMain()
+	c.Assert(err, ErrorMatches, `invalid package architecture: foo`)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/deb"
+)
+
+var shortConfigHelp = "Show the effective configuration"
+var longConfigHelp = `
+The config command shows the values that other commands would use
+for common options, along with where each value comes from.
+
+Options provided in the command line take precedence over the
+CHISEL_RELEASE, CHISEL_ARCH and CHISEL_ROOT environment variables,
+which in turn take precedence over the values inferred from the host.
+`
+
+var configDescs = map[string]string{
+	"release": "Chisel release name or directory (e.g. ubuntu-22.04)",
+	"arch":    "Package architecture",
+	"root":    "Root for generated content",
+}
+
+// Environment variables providing defaults for common options.
+const (
+	releaseEnv = "CHISEL_RELEASE"
+	archEnv    = "CHISEL_ARCH"
+	rootEnv    = "CHISEL_ROOT"
+)
+
+type cmdConfig struct {
+	Release string `long:"release" value-name:"<dir>"`
+	Arch    string `long:"arch" value-name:"<arch>"`
+	RootDir string `long:"root" value-name:"<dir>"`
+}
+
+func init() {
+	addCommand("config", shortConfigHelp, longConfigHelp, func() flags.Commander { return &cmdConfig{} }, configDescs, nil)
+}
+
+func (cmd *cmdConfig) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	release, releaseSource := optionSource(cmd.Release, releaseEnv)
+	if release == "" {
+		label, version, err := readReleaseInfo()
+		if err == nil {
+			release, releaseSource = label+"-"+version, "host"
+		}
+	}
+	arch, archSource := optionSource(cmd.Arch, archEnv)
+	if arch == "" {
+		hostArch, err := deb.InferArch()
+		if err == nil {
+			arch, archSource = hostArch, "host"
+		}
+	}
+	root, rootSource := optionSource(cmd.RootDir, rootEnv)
+
+	w := tabWriter()
+	fmt.Fprintf(w, "Option\tValue\tSource\n")
+	fmt.Fprintf(w, "release\t%s\t%s\n", orDash(release), orDash(releaseSource))
+	fmt.Fprintf(w, "arch\t%s\t%s\n", orDash(arch), orDash(archSource))
+	fmt.Fprintf(w, "root\t%s\t%s\n", orDash(root), orDash(rootSource))
+	w.Flush()
+	return nil
+}
+
+// optionSource returns value if it was provided as an option, or otherwise
+// the content of the environment variable env. The second result describes
+// where the value came from, and is empty if it wasn't found anywhere.
+func optionSource(value, env string) (string, string) {
+	if value != "" {
+		return value, "option"
+	}
+	if value := os.Getenv(env); value != "" {
+		return value, env
+	}
+	return "", ""
+}
+
+// optionOrEnv returns value if not empty, or otherwise the content of the
+// environment variable env.
+func optionOrEnv(value, env string) string {
+	value, _ = optionSource(value, env)
+	return value
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
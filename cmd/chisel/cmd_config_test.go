@@ -0,0 +1,54 @@
+package main_test
+
+import (
+	"os"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+)
+
+func fakeEnv(name, value string) (restore func()) {
+	old, ok := os.LookupEnv(name)
+	if value == "" {
+		os.Unsetenv(name)
+	} else {
+		os.Setenv(name, value)
+	}
+	return func() {
+		if ok {
+			os.Setenv(name, old)
+		} else {
+			os.Unsetenv(name)
+		}
+	}
+}
+
+func (s *ChiselSuite) TestConfig(c *C) {
+	defer fakeEnv("CHISEL_RELEASE", "ubuntu-22.04")()
+	defer fakeEnv("CHISEL_ARCH", "amd64")()
+	defer fakeEnv("CHISEL_ROOT", "")()
+	defer fakeArgs("chisel", "config", "--arch", "arm64")()
+
+	err := chisel.RunMain()
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, ""+
+		"Option   Value         Source\n"+
+		"release  ubuntu-22.04  CHISEL_RELEASE\n"+
+		"arch     arm64         option\n"+
+		"root     -             -\n")
+}
+
+func (s *ChiselSuite) TestCutRootFromEnv(c *C) {
+	defer fakeEnv("CHISEL_ROOT", "")()
+	defer fakeArgs("chisel", "cut", "foo_bar")()
+
+	err := chisel.RunMain()
+	c.Assert(err, ErrorMatches, "the required flag `--root' was not specified")
+
+	// The root is found, so the failure happens later.
+	defer fakeEnv("CHISEL_ROOT", c.MkDir())()
+	defer fakeEnv("CHISEL_RELEASE", "foo")()
+	err = chisel.RunMain()
+	c.Assert(err, ErrorMatches, `invalid release reference: "foo"`)
+}
@@ -1,10 +1,30 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
 	"github.com/jessevdk/go-flags"
 
 	"github.com/canonical/chisel/internal/archive"
 	"github.com/canonical/chisel/internal/cache"
+	"github.com/canonical/chisel/internal/deb"
+	"github.com/canonical/chisel/internal/fsutil"
+	"github.com/canonical/chisel/internal/progress"
 	"github.com/canonical/chisel/internal/setup"
 	"github.com/canonical/chisel/internal/slicer"
 )
@@ -15,23 +35,247 @@ The cut command uses the provided selection of package slices
 to create a new filesystem tree in the root location.
 
 By default it fetches the slices for the same Ubuntu version as the
-current host, unless the --release flag is used.
+current host, unless the --release flag or the CHISEL_RELEASE
+environment variable are used.
+
+The --arch and --root flags may similarly be replaced by the CHISEL_ARCH
+and CHISEL_ROOT environment variables.
+
+When the --timestamp flag or the SOURCE_DATE_EPOCH environment variable
+are set, entries extracted from packages keep the modification time they
+have there, and no entry cut into the root is left with a modification
+time later than the provided number of seconds since the Unix epoch, so
+that cutting the same slices always yields the same tree. Content which
+was in the root before, and the root itself, are left alone.
+
+Slices may also be listed in the file provided via --from-file, one per
+line, where empty lines and everything following a # are ignored. Use -
+as the file name to read the list from the standard input.
+
+Sets of slices defined by the release may be selected by their name
+prefixed with @ (e.g. @web-runtime), in place of listing every slice
+in the set. Similarly, every slice of a package may be selected with
+<package>_* (e.g. 'python3.12_*', quoted so the shell does not expand it)
+or with --all-slices-of, which may be repeated. Deprecated slices are
+left out, and the slices selected are printed as a confirmation.
+
+With --partial-release only the slice definitions of the packages needed
+by the selection are read from the release, which is faster for large
+releases, but conflicts with the slices of other packages are then not
+reported. The whole release is still read with --check-elf, in order to
+suggest slices providing the missing libraries.
+
+With --with-manifest a manifest listing the packages, slices and paths
+that were cut is written into the provided directory of the new tree,
+or into /var/lib/chisel/ if no directory is provided, even when none of
+the selected slices generate one.
+
+With --manifest-path the manifest is also written to the provided file
+outside of the new tree, such as next to it, where {arch} is replaced by
+the architecture name as in --root-template. Use --no-tree-manifest to
+only write it there, leaving out of the tree the manifests of the
+selected slices, for images which must not contain them.
+
+Paths matching any of the patterns provided via --exclude are not
+created, even if selected. Patterns are absolute paths which may use
+the same wildcards supported by slice definitions: ? and * match any
+character except /, and ** also matches /.
+
+Device nodes and FIFOs are not created by default, and files are created
+without their setuid and setgid bits, with a warning in either case. Use
+--allow-special to create them as packaged, which requires privileges
+for device nodes.
+
+With --check-elf the ELF files in the new tree are inspected once the
+cut is complete, and the shared libraries they require but which were
+not cut are listed, along with the slices that may provide them.
+
+With --summary statistics about the cut are printed once it is complete.
+They list the files and bytes written for every slice, the bytes written
+and downloaded and the time spent fetching and extracting every package,
+and the bytes downloaded and the cache hits and misses of every archive.
+Use --summary=json to obtain them in the JSON format instead.
+
+Several architectures may be cut at once by listing them separated by
+commas in --arch, and providing via --root-template the location of the
+root for each of them, where {arch} is replaced by the architecture name
+(e.g. --arch amd64,arm64 --root-template ./out/{arch}). The release is
+only read once, and the trees are cut in parallel.
+
+With --explain-archives the archive every package is obtained from is
+listed once the cut is complete, along with the reason for using it, and
+the suite, component and version selected. Packages use the default
+archive unless their definition selects another one via the archive
+field, and the suite is selected according to the suite-policy of the
+archive, which picks the highest version by default.
+
+With --explain every slice in the selection is listed once the cut is
+complete, in the order they were cut, along with the chain of essential
+slices that pulled it in from one of the requested slices.
+
+With --digest=sha512 the SHA512 digest of packages and of the content of
+files is recorded in manifests as well, next to the SHA256 one which is
+always present. The option may be repeated as more digests are supported.
+
+With --trusted-key, which may be repeated, the InRelease files of the
+archives are only accepted when signed by one of the provided keys,
+rather than by any of the keys listed for the archive in the release.
+Keys are identified by their fingerprint or long key ID, and must be
+listed by the release as well. The cut fails if any archive is left
+without a trusted key.
+
+Selecting slices which were deprecated by the release, or whose package
+was, prints a warning with the reason. Use --strict-deprecations to
+fail instead.
+
+With --atomic the tree is first created in a temporary directory next
+to the root location, and only moved into place once the cut succeeds.
+The root location must then be missing or be an empty directory.
+
+With --restartable the paths created by the cut are recorded in the
+cache directory as they are created, so that the output of the cut is
+not mistaken for a complete tree if it is interrupted: cutting again
+into the same root fails until either --restart or --clean is used.
+With --clean the paths created by the interrupted cut are removed and
+nothing is cut. With --restart they are removed as well, and the cut
+starts over, taking the packages already fetched from the cache rather
+than downloading them again. A cut which fails for any other reason
+leaves nothing recorded.
+
+With --append-tar the tree is appended to the provided uncompressed tar
+archive instead of being cut into a root location, so that both form a
+single layer. Directories already in the archive are kept as they are,
+and the cut fails without modifying the archive if any other path of
+the tree is already in it. Appended entries are owned by root, and have
+the modes declared by packages and slice definitions, regardless of the
+filesystem of the host. This is the only output supported on Windows.
+The tree is staged in the temporary directory of the command, which may
+be placed elsewhere with the global --tmp-dir option.
+
+The global --timeout option sets a deadline for the whole cut, from
+reading the release to writing manifests, so that a cut stuck on a slow
+or unresponsive archive fails instead of hanging. A cut that runs out
+of time, or is interrupted, leaves the root location as an interrupted
+cut, and moves nothing into place with --atomic or --append-tar.
+
+With --dry-run nothing is written to the root location, and the paths
+that would be created are listed instead, along with their mode, size
+and the SHA256 digest of files. Packages are still fetched and read, but
+mutation scripts are not run, and neither manifests nor other content
+are generated.
+
+With --prune the well-known classes of paths provided, separated by
+commas, are removed from the new tree once the mutation scripts have
+run, even if selected. The manifest still lists pruned paths, marked as
+such. The supported classes are:
+
+    docs     documentation under /usr/share/doc/ and /usr/share/info/,
+             except for the copyright files of packages
+    locales  translations and locale data under /usr/share/locale/
+             and /usr/lib/locale/
+    man      manual pages under /usr/share/man/
+
+With --strip the content of the debug sections of the ELF executables
+and shared libraries in the new tree is removed once the mutation scripts
+have run, keeping their section headers. The manifest records both the
+original and the final digests of stripped files.
+
+With --debug-root the debug information removed by --strip is written
+into the provided directory instead of being dropped, as separate files
+under /usr/lib/debug/ named after the build ID of each stripped file,
+when it has one, as done by debug packages. A manifest listing them is
+written into /var/lib/chisel/ within it, unless --no-tree-manifest is
+used. As with --manifest-path, {arch} is replaced by the architecture
+name.
+
+With --dedupe hardlink the files of the new tree which have the same
+content, mode and extended attributes are hard linked together once
+pruned, to save space. The manifest records the path each of them was
+linked to.
+
+With --max-size the cut fails when the files of the new tree take more
+than the given number of bytes, with an optional K, M or G suffix for
+binary multiples. Along with --atomic, the root is then left untouched.
 `
 
 var cutDescs = map[string]string{
-	"release": "Chisel release name or directory (e.g. ubuntu-22.04)",
-	"root":    "Root for generated content",
-	"arch":    "Package architecture",
+	"release":          "Chisel release name or directory (e.g. ubuntu-22.04)",
+	"root":             "Root for generated content",
+	"arch":             "Package architecture",
+	"quiet":            "Do not report the progress of downloads and extraction",
+	"timestamp":        "Latest modification time of the new content, in seconds since the epoch",
+	"atomic":           "Only create the root once the whole tree is successfully cut",
+	"from-file":        "Read additional slice names from file, or stdin if -",
+	"with-manifest":    "Write a manifest into the given directory of the new tree",
+	"exclude":          "Do not create paths matching the given glob (can be repeated)",
+	"check-elf":        "List shared libraries required by the new tree but missing from it",
+	"allow-special":    "Create device nodes, FIFOs and setuid or setgid files",
+	"summary":          "Print statistics about the cut",
+	"root-template":    "Root for each architecture, where {arch} is replaced by its name",
+	"explain-archives": "List the archive, suite and version every package is obtained from",
+	"explain":          "List why every slice in the selection was selected",
+
+	"strict-deprecations": "Fail if any of the selected slices is deprecated",
+	"append-tar":          "Append the tree to the given tar archive instead of cutting it into a root",
+	"dry-run":             "List the paths that would be created without writing anything",
+	"prune":               "Remove the given classes of paths once cut: docs, locales, man",
+	"strip":               "Remove the debug sections of ELF files once cut",
+	"debug-root":          "Write the debug information removed by --strip into the given directory",
+	"dedupe":              "Deduplicate files with the same content once cut: hardlink",
+	"max-size":            "Fail if the new tree takes more than the given number of bytes (e.g. 500M)",
+	"restartable":         "Record the paths created so that an interrupted cut can be cleaned",
+	"restart":             "Remove the output of an interrupted cut into the root and cut again",
+	"clean":               "Remove the output of an interrupted cut into the root and exit",
+	"all-slices-of":       "Select every slice of the given package which is not deprecated",
+	"manifest-path":       "Also write the manifest to the given file outside of the new tree",
+	"no-tree-manifest":    "Do not write any manifest into the new tree",
+	"trusted-key":         "Only accept archive signatures by the given key fingerprint (can be repeated)",
+	"digest":              "Also record the given digest in manifests besides sha256: sha512 (can be repeated)",
+	"partial-release":     "Only read the slice definitions needed by the selection",
 }
 
 type cmdCut struct {
-	Release string `long:"release" value-name:"<dir>"`
-	RootDir string `long:"root" value-name:"<dir>" required:"yes"`
-	Arch    string `long:"arch" value-name:"<arch>"`
+	Release   string   `long:"release" value-name:"<dir>"`
+	RootDir   string   `long:"root" value-name:"<dir>"`
+	Arch      string   `long:"arch" value-name:"<arch>[,...]"`
+	Quiet     bool     `long:"quiet"`
+	Timestamp string   `long:"timestamp" value-name:"<seconds>"`
+	Atomic    bool     `long:"atomic"`
+	FromFile  string   `long:"from-file" value-name:"<file>"`
+	Exclude   []string `long:"exclude" value-name:"<glob>"`
+	CheckELF  bool     `long:"check-elf"`
+
+	AllowSpecial bool `long:"allow-special"`
+
+	WithManifest string `long:"with-manifest" value-name:"<dir>" optional:"yes" optional-value:"/var/lib/chisel/"`
+	Summary      string `long:"summary" value-name:"<text|json>" optional:"yes" optional-value:"text"`
+	RootTemplate string `long:"root-template" value-name:"<dir>"`
+
+	ExplainArchives    bool     `long:"explain-archives"`
+	Explain            bool     `long:"explain"`
+	StrictDeprecations bool     `long:"strict-deprecations"`
+	AppendTar          string   `long:"append-tar" value-name:"<file>"`
+	DryRun             bool     `long:"dry-run"`
+	Prune              string   `long:"prune" value-name:"<class>[,...]"`
+	Strip              bool     `long:"strip"`
+	DebugRoot          string   `long:"debug-root" value-name:"<dir>"`
+	Dedupe             string   `long:"dedupe" value-name:"<mode>"`
+	MaxSize            string   `long:"max-size" value-name:"<bytes>"`
+	Restartable        bool     `long:"restartable"`
+	Restart            bool     `long:"restart"`
+	Clean              bool     `long:"clean"`
+	AllSlicesOf        []string `long:"all-slices-of" value-name:"<pkg>"`
+	ManifestPath       string   `long:"manifest-path" value-name:"<file>"`
+	NoTreeManifest     bool     `long:"no-tree-manifest"`
+	TrustedKeys        []string `long:"trusted-key" value-name:"<fingerprint>"`
+	Digests            []string `long:"digest" value-name:"<algorithm>"`
+	PartialRelease     bool     `long:"partial-release"`
 
 	Positional struct {
-		SliceRefs []string `positional-arg-name:"<slice names>" required:"yes"`
+		SliceRefs []string `positional-arg-name:"<slice names>"`
 	} `positional-args:"yes"`
+
+	maxSize int64
 }
 
 func init() {
@@ -42,17 +286,136 @@ func (cmd *cmdCut) Execute(args []string) error {
 	if len(args) > 0 {
 		return ErrExtraArgs
 	}
+	started := time.Now()
 
-	sliceKeys := make([]setup.SliceKey, len(cmd.Positional.SliceRefs))
-	for i, sliceRef := range cmd.Positional.SliceRefs {
-		sliceKey, err := setup.ParseSliceKey(sliceRef)
+	switch cmd.Summary {
+	case "", "text", "json":
+	default:
+		return &usageError{fmt.Errorf("invalid summary format %q, must be \"text\" or \"json\"", cmd.Summary)}
+	}
+	if cmd.Prune != "" {
+		classes := slicer.PruneClasses()
+		for _, class := range strings.Split(cmd.Prune, ",") {
+			if !slices.Contains(classes, class) {
+				return &usageError{fmt.Errorf("invalid prune class %q, must be one of: %s", class, strings.Join(classes, ", "))}
+			}
+		}
+	}
+	if cmd.Dedupe != "" {
+		modes := slicer.DedupeModes()
+		if !slices.Contains(modes, cmd.Dedupe) {
+			return &usageError{fmt.Errorf("invalid dedupe mode %q, must be one of: %s", cmd.Dedupe, strings.Join(modes, ", "))}
+		}
+	}
+	if cmd.MaxSize != "" {
+		var ok bool
+		cmd.maxSize, ok = parseBytes(cmd.MaxSize)
+		if !ok {
+			return &usageError{fmt.Errorf("invalid maximum size %q, must be a positive number of bytes (e.g. 500M)", cmd.MaxSize)}
+		}
+	}
+	if cmd.DebugRoot != "" && !cmd.Strip {
+		return &usageError{fmt.Errorf("cannot use --debug-root without --strip")}
+	}
+	if cmd.DryRun && (cmd.Atomic || cmd.AppendTar != "" || cmd.CheckELF || cmd.ManifestPath != "") {
+		return &usageError{fmt.Errorf("cannot use --dry-run with --atomic, --append-tar, --check-elf or --manifest-path")}
+	}
+	if cmd.Restart && cmd.Clean {
+		return &usageError{fmt.Errorf("cannot use both --restart and --clean")}
+	}
+	if (cmd.Restartable || cmd.Restart || cmd.Clean) && (cmd.DryRun || cmd.Atomic || cmd.AppendTar != "") {
+		return &usageError{fmt.Errorf("cannot use --restartable, --restart or --clean with --dry-run, --atomic or --append-tar")}
+	}
+	if cmd.NoTreeManifest && cmd.WithManifest != "" {
+		return &usageError{fmt.Errorf("cannot use both --with-manifest and --no-tree-manifest")}
+	}
+	for _, digest := range cmd.Digests {
+		if digest != "sha256" && digest != "sha512" {
+			return &usageError{fmt.Errorf("invalid digest %q, must be \"sha256\" or \"sha512\"", digest)}
+		}
+	}
+
+	trustedKeys, err := parseKeyFingerprints(cmd.TrustedKeys)
+	if err != nil {
+		return err
+	}
+
+	cmd.Release = optionOrEnv(cmd.Release, releaseEnv)
+	cmd.Arch = optionOrEnv(cmd.Arch, archEnv)
+	targets, err := cmd.targets()
+	if err != nil {
+		return err
+	}
+	if cmd.Clean {
+		cleaned := false
+		for _, target := range targets {
+			found, err := cleanInterruptedCut(target.rootDir)
+			if err != nil {
+				return err
+			}
+			cleaned = cleaned || found
+		}
+		if !cleaned {
+			return fmt.Errorf("no interrupted cut found in %s", targets[0].rootDir)
+		}
+		return nil
+	}
+
+	for _, target := range targets {
+		if target.rootDir == "" || cmd.DryRun {
+			continue
+		}
+		if cmd.Restart {
+			_, err = cleanInterruptedCut(target.rootDir)
+		} else {
+			err = checkInterruptedCut(target.rootDir)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	sliceRefs := cmd.Positional.SliceRefs
+	if cmd.FromFile != "" {
+		fileRefs, err := readSliceRefs(cmd.FromFile)
 		if err != nil {
 			return err
 		}
-		sliceKeys[i] = sliceKey
+		sliceRefs = append(sliceRefs, fileRefs...)
+	}
+	for _, pkgName := range cmd.AllSlicesOf {
+		sliceRefs = append(sliceRefs, pkgName+"_*")
+	}
+	if len(sliceRefs) == 0 {
+		return &usageError{fmt.Errorf("no slices provided, see the --from-file option")}
+	}
+	err = checkSliceRefs(sliceRefs)
+	if err != nil {
+		return err
+	}
+
+	timestamp, err := parseTimestamp(cmd.Timestamp)
+	if err != nil {
+		return err
+	}
+
+	// The whole release is needed to suggest slices providing missing
+	// libraries.
+	var release *setup.Release
+	if cmd.PartialRelease && !cmd.CheckELF {
+		release, err = obtainReleaseFor(cmd.Release, sliceRefs)
+	} else {
+		release, err = obtainRelease(cmd.Release)
+	}
+	if err != nil {
+		return err
+	}
+	err = restrictArchiveKeys(release, trustedKeys)
+	if err != nil {
+		return err
 	}
 
-	release, err := obtainRelease(cmd.Release)
+	sliceKeys, err := parseSliceRefs(release, sliceRefs)
 	if err != nil {
 		return err
 	}
@@ -61,28 +424,795 @@ func (cmd *cmdCut) Execute(args []string) error {
 	if err != nil {
 		return err
 	}
+	if cmd.StrictDeprecations {
+		for _, slice := range selection.Slices {
+			if slice.Deprecated != "" {
+				return fmt.Errorf("slice %s is deprecated: %s", slice, slice.Deprecated)
+			}
+		}
+	}
 
-	archives := make(map[string]archive.Archive)
-	for archiveName, archiveInfo := range release.Archives {
-		openArchive, err := archive.Open(&archive.Options{
-			Label:      archiveName,
-			Version:    archiveInfo.Version,
-			Arch:       cmd.Arch,
-			Suites:     archiveInfo.Suites,
-			Components: archiveInfo.Components,
-			CacheDir:   cache.DefaultDir("chisel"),
-			PubKeys:    archiveInfo.PubKeys,
-		})
+	reporter := progressReporter(cmd.Quiet)
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target *cutTarget) {
+			defer wg.Done()
+			errs[i] = cmd.cut(release, selection, timestamp, target, reporter)
+		}(i, target)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil && len(targets) > 1 {
+			return fmt.Errorf("cannot cut %s tree: %w", targets[i].arch, err)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if cmd.Explain {
+		err = printSelectionChains(selection, sliceKeys)
+		if err != nil {
+			return err
+		}
+	}
+	for _, target := range targets {
+		if len(targets) > 1 && (cmd.CheckELF && len(target.missing) > 0 || cmd.Summary == "text" || cmd.ExplainArchives || cmd.DryRun) {
+			fmt.Fprintf(Stdout, "Architecture: %s\n", target.arch)
+		}
+		if cmd.DryRun {
+			err = printDryRun(target.report)
+			if err != nil {
+				return err
+			}
+		}
+		if cmd.ExplainArchives {
+			err = printArchiveChoices(target.choices)
+			if err != nil {
+				return err
+			}
+		}
+		if cmd.CheckELF {
+			err = printMissingLibraries(target.missing, release)
+			if err != nil {
+				return err
+			}
+		}
+		if cmd.Summary != "" {
+			summary := cutStats(selection, target.report, target.recorder.Operations(), target.stats, time.Since(started))
+			summary.Arch = target.arch
+			err = printCutStats(cmd.Summary, summary)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// cutTarget holds the architecture and location of a tree to be cut, along
+// with the results of cutting it.
+type cutTarget struct {
+	arch         string
+	rootDir      string
+	tarPath      string
+	manifestPath string
+	debugDir     string
+	recorder     *progress.Recorder
+	stats        map[string]*archive.Stats
+	report       *slicer.Report
+	missing      []slicer.MissingLibrary
+	choices      []archiveChoice
+}
+
+// targets returns the trees to be cut, one for each architecture provided
+// via --arch when a root template is used, or a single one otherwise.
+func (cmd *cmdCut) targets() ([]*cutTarget, error) {
+	if cmd.AppendTar != "" {
+		if cmd.RootDir != "" || cmd.RootTemplate != "" {
+			return nil, &usageError{fmt.Errorf("cannot use --append-tar with --root or --root-template")}
+		}
+		if cmd.Atomic {
+			return nil, &usageError{fmt.Errorf("cannot use both --append-tar and --atomic")}
+		}
+		if strings.Contains(cmd.Arch, ",") {
+			return nil, &usageError{fmt.Errorf("cannot append multiple architectures to a tar archive")}
+		}
+		return []*cutTarget{{arch: cmd.Arch, tarPath: cmd.AppendTar, manifestPath: cmd.ManifestPath, debugDir: cmd.DebugRoot}}, nil
+	}
+	if runtime.GOOS == "windows" && !cmd.DryRun {
+		return nil, &usageError{fmt.Errorf("cannot cut into a root directory on %s, use --append-tar instead", runtime.GOOS)}
+	}
+	if cmd.RootTemplate == "" {
+		if strings.Contains(cmd.Arch, ",") {
+			return nil, &usageError{fmt.Errorf("cannot cut multiple architectures without --root-template")}
+		}
+		cmd.RootDir = optionOrEnv(cmd.RootDir, rootEnv)
+		if cmd.RootDir == "" {
+			return nil, &usageError{fmt.Errorf("the required flag `--root' was not specified")}
+		}
+		return []*cutTarget{{arch: cmd.Arch, rootDir: cmd.RootDir, manifestPath: cmd.ManifestPath, debugDir: cmd.DebugRoot}}, nil
+	}
+
+	if cmd.RootDir != "" {
+		return nil, &usageError{fmt.Errorf("cannot use both --root and --root-template")}
+	}
+	if !strings.Contains(cmd.RootTemplate, "{arch}") {
+		return nil, &usageError{fmt.Errorf("root template %q does not contain {arch}", cmd.RootTemplate)}
+	}
+	archs := strings.Split(cmd.Arch, ",")
+	if cmd.Arch == "" {
+		arch, err := deb.InferArch()
+		if err != nil {
+			return nil, err
+		}
+		archs = []string{arch}
+	}
+	if len(archs) > 1 && cmd.ManifestPath != "" && !strings.Contains(cmd.ManifestPath, "{arch}") {
+		return nil, &usageError{fmt.Errorf("manifest path %q does not contain {arch}", cmd.ManifestPath)}
+	}
+	if len(archs) > 1 && cmd.DebugRoot != "" && !strings.Contains(cmd.DebugRoot, "{arch}") {
+		return nil, &usageError{fmt.Errorf("debug root %q does not contain {arch}", cmd.DebugRoot)}
+	}
+	var targets []*cutTarget
+	seen := make(map[string]bool)
+	for _, arch := range archs {
+		if arch == "" {
+			return nil, &usageError{fmt.Errorf("invalid architecture list %q", cmd.Arch)}
+		}
+		if seen[arch] {
+			return nil, &usageError{fmt.Errorf("architecture %q provided twice", arch)}
+		}
+		seen[arch] = true
+		rootDir := strings.ReplaceAll(cmd.RootTemplate, "{arch}", arch)
+		manifestPath := strings.ReplaceAll(cmd.ManifestPath, "{arch}", arch)
+		debugDir := strings.ReplaceAll(cmd.DebugRoot, "{arch}", arch)
+		targets = append(targets, &cutTarget{arch: arch, rootDir: rootDir, manifestPath: manifestPath, debugDir: debugDir})
+	}
+	return targets, nil
+}
+
+// cut cuts the selection into the tree described by target, and records
+// the results there.
+func (cmd *cmdCut) cut(release *setup.Release, selection *setup.Selection, timestamp time.Time, target *cutTarget, reporter progress.Reporter) (err error) {
+	if cmd.Summary != "" {
+		target.recorder = progress.NewRecorder(reporter)
+		target.stats = make(map[string]*archive.Stats)
+		reporter = target.recorder
+	}
+	archives, err := openArchives(release, target.arch, reporter, target.stats)
+	if err != nil {
+		return err
+	}
+	if target.arch == "" {
+		target.arch = archives[release.DefaultArchive].Options().Arch
+	}
+	if cmd.ExplainArchives {
+		target.choices, err = explainArchives(release, selection, archives)
+		if err != nil {
+			return err
+		}
+	}
+
+	targetDir := target.rootDir
+	if target.tarPath != "" {
+		var tmpDir string
+		tmpDir, err = tempDir()
+		if err != nil {
+			return err
+		}
+		targetDir, err = os.MkdirTemp(tmpDir, "root-")
+		if err != nil {
+			return fmt.Errorf("cannot create temporary root: %w", err)
+		}
+		defer os.RemoveAll(targetDir)
+	} else if cmd.Atomic {
+		targetDir, err = atomicRootDir(target.rootDir)
+		if err != nil {
+			return err
+		}
+		// Once renamed into place, there's nothing left to remove.
+		defer os.RemoveAll(targetDir)
+	}
+
+	// Only cuts started from the command line with --restartable or
+	// --restart record the paths they create.
+	var state *cutState
+	var pathCreated func(path string) error
+	if (cmd.Restartable || cmd.Restart) && target.rootDir != "" && !cmd.DryRun {
+		state, err = startCutState(target.rootDir)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				state.abort()
+			}
+		}()
+		pathCreated = state.record
+	}
+
+	var prune []string
+	if cmd.Prune != "" {
+		prune = strings.Split(cmd.Prune, ",")
+	}
+
+	var manifestDirs, manifestPaths []string
+	if cmd.WithManifest != "" {
+		manifestDirs = append(manifestDirs, cmd.WithManifest)
+	}
+	if target.manifestPath != "" {
+		manifestPaths = append(manifestPaths, target.manifestPath)
+	}
+
+	target.report, err = slicer.Run(&slicer.RunOptions{
+		Selection:       selection,
+		Archives:        archives,
+		TargetDir:       targetDir,
+		Progress:        reporter,
+		Timestamp:       timestamp,
+		ManifestDirs:    manifestDirs,
+		ManifestPaths:   manifestPaths,
+		NoTreeManifests: cmd.NoTreeManifest,
+		Exclude:         cmd.Exclude,
+		ChiselVersion:   chiselVersion(),
+		AllowSpecial:    cmd.AllowSpecial,
+		DryRun:          cmd.DryRun,
+		Prune:           prune,
+		Strip:           cmd.Strip,
+		DebugDir:        target.debugDir,
+		Dedupe:          cmd.Dedupe,
+		SHA512:          slices.Contains(cmd.Digests, "sha512"),
+		Placeholders:    target.tarPath != "" && runtime.GOOS == "windows",
+		PathCreated:     pathCreated,
+		Context:         commandContext,
+	})
+	if err != nil {
+		return err
+	}
+	err = checkTreeSize(target.report, cmd.maxSize)
+	if err != nil {
+		return err
+	}
+
+	if cmd.CheckELF {
+		target.missing, err = slicer.CheckLibraries(target.report)
 		if err != nil {
 			return err
 		}
-		archives[archiveName] = openArchive
 	}
 
-	_, err = slicer.Run(&slicer.RunOptions{
-		Selection: selection,
-		Archives:  archives,
-		TargetDir: cmd.RootDir,
+	// Nothing is moved into place once the cut was cancelled.
+	err = commandContext.Err()
+	if err != nil {
+		return err
+	}
+	if target.tarPath != "" {
+		return fsutil.AppendTar(target.tarPath, targetDir, reportTarEntries(target.report))
+	}
+	if cmd.Atomic {
+		// Unlike os.Rename, this replaces an existing empty directory.
+		err = syscall.Rename(targetDir, target.rootDir)
+		if err != nil {
+			return fmt.Errorf("cannot move new tree into place at %s: %w", target.rootDir, err)
+		}
+	} else if state != nil {
+		return state.finish()
+	}
+	return nil
+}
+
+// checkTreeSize returns an error if the files in report take more than
+// maxSize bytes, unless it is zero.
+func checkTreeSize(report *slicer.Report, maxSize int64) error {
+	if maxSize == 0 {
+		return nil
+	}
+	if total := report.Usage().Total; total > maxSize {
+		return fmt.Errorf("tree takes %d bytes, more than the maximum of %d", total, maxSize)
+	}
+	return nil
+}
+
+// reportTarEntries returns the mode, link and extended attributes of the
+// entries in report, in the form used by fsutil.AppendTar, so that the
+// appended archive holds what the packages declare rather than what the
+// filesystem of the host preserved.
+func reportTarEntries(report *slicer.Report) map[string]*fsutil.Entry {
+	entries := make(map[string]*fsutil.Entry, len(report.Entries))
+	for path, entry := range report.Entries {
+		entries[path] = &fsutil.Entry{Path: path, Mode: entry.Mode, Link: entry.Link, Xattrs: entry.Xattrs}
+	}
+	return entries
+}
+
+type sliceStats struct {
+	Name    string `json:"name"`
+	Files   int    `json:"files"`
+	Written int64  `json:"written"`
+}
+
+type packageStats struct {
+	Name           string  `json:"name"`
+	Written        int64   `json:"written"`
+	Downloaded     int64   `json:"downloaded"`
+	FetchSeconds   float64 `json:"fetch-seconds"`
+	ExtractSeconds float64 `json:"extract-seconds"`
+}
+
+type archiveStats struct {
+	Name        string `json:"name"`
+	Downloaded  int64  `json:"downloaded"`
+	CacheHits   int64  `json:"cache-hits"`
+	CacheMisses int64  `json:"cache-misses"`
+}
+
+type cutSummary struct {
+	Arch           string         `json:"arch"`
+	Slices         []sliceStats   `json:"slices"`
+	Packages       []packageStats `json:"packages"`
+	Archives       []archiveStats `json:"archives"`
+	Files          int            `json:"files"`
+	Written        int64          `json:"written"`
+	Downloaded     int64          `json:"downloaded"`
+	ElapsedSeconds float64        `json:"elapsed-seconds"`
+}
+
+// cutStats computes the statistics of a cut from the content in report and
+// the operations recorded while fetching and extracting packages. Files
+// shared by several slices are accounted for in each of them, but only once
+// in the totals, as are files hard linked to others. Pruned files are not
+// accounted for. Downloads of archive indexes are only accounted for in the
+// totals, and in the statistics of their archive.
+func cutStats(selection *setup.Selection, report *slicer.Report, ops []progress.Operation, stats map[string]*archive.Stats, elapsed time.Duration) *cutSummary {
+	summary := &cutSummary{ElapsedSeconds: elapsed.Seconds()}
+
+	usage := report.Usage()
+	for _, slice := range selection.Slices {
+		stats := sliceStats{Name: slice.String(), Written: usage.Slices[slice]}
+		for _, entry := range report.Entries {
+			if entry.Slices[slice] && !entry.Mode.IsDir() && !entry.Pruned {
+				stats.Files++
+			}
+		}
+		summary.Slices = append(summary.Slices, stats)
+	}
+	for _, entry := range report.Entries {
+		if !entry.Mode.IsDir() && !entry.Pruned {
+			summary.Files++
+		}
+	}
+	summary.Written = usage.Total
+
+	packages := make(map[string]*packageStats)
+	var order []string
+	for _, slice := range selection.Slices {
+		if packages[slice.Package] == nil {
+			packages[slice.Package] = &packageStats{Name: slice.Package, Written: usage.Packages[slice.Package]}
+			order = append(order, slice.Package)
+		}
+	}
+	for _, op := range ops {
+		if name, ok := strings.CutPrefix(op.Label, "Fetching "); ok {
+			summary.Downloaded += op.Processed
+			// Packages are fetched from the pool as <name>_<version>_<arch>.deb.
+			pkg, _, _ := strings.Cut(name, "_")
+			if stats := packages[pkg]; stats != nil && strings.HasSuffix(name, ".deb") {
+				stats.Downloaded += op.Processed
+				stats.FetchSeconds += op.Elapsed.Seconds()
+			}
+		} else if pkg, ok := strings.CutPrefix(op.Label, "Extracting "); ok {
+			if stats := packages[pkg]; stats != nil {
+				stats.ExtractSeconds += op.Elapsed.Seconds()
+			}
+		}
+	}
+	for _, pkg := range order {
+		summary.Packages = append(summary.Packages, *packages[pkg])
+	}
+	for name, archiveStat := range stats {
+		summary.Archives = append(summary.Archives, archiveStats{
+			Name:        name,
+			Downloaded:  archiveStat.Downloaded.Load(),
+			CacheHits:   archiveStat.CacheHits.Load(),
+			CacheMisses: archiveStat.CacheMisses.Load(),
+		})
+	}
+	sort.Slice(summary.Archives, func(i, j int) bool {
+		return summary.Archives[i].Name < summary.Archives[j].Name
 	})
+	return summary
+}
+
+// printCutStats prints summary in the provided format, either "text" or
+// "json".
+func printCutStats(format string, summary *cutSummary) error {
+	if format == "json" {
+		data, err := json.MarshalIndent(summary, "", "\t")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(Stdout, "%s\n", data)
+		return err
+	}
+
+	w := tabWriter()
+	fmt.Fprintf(w, "Slice\tFiles\tWritten\n")
+	for _, stats := range summary.Slices {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", stats.Name, stats.Files, progress.FormatSize(stats.Written))
+	}
+	fmt.Fprintf(w, "Total\t%d\t%s\n", summary.Files, progress.FormatSize(summary.Written))
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(Stdout)
+	w = tabWriter()
+	fmt.Fprintf(w, "Package\tWritten\tDownloaded\tFetching\tExtracting\n")
+	for _, stats := range summary.Packages {
+		fetching := "-"
+		if stats.Downloaded > 0 {
+			fetching = formatSeconds(stats.FetchSeconds)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", stats.Name, progress.FormatSize(stats.Written), progress.FormatSize(stats.Downloaded), fetching, formatSeconds(stats.ExtractSeconds))
+	}
+	fmt.Fprintf(w, "Total\t%s\t%s\n", progress.FormatSize(summary.Written), progress.FormatSize(summary.Downloaded))
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(Stdout)
+	w = tabWriter()
+	fmt.Fprintf(w, "Archive\tDownloaded\tCache Hits\tCache Misses\n")
+	for _, stats := range summary.Archives {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\n", stats.Name, progress.FormatSize(stats.Downloaded), stats.CacheHits, stats.CacheMisses)
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(Stdout, "\nElapsed: %s\n", formatSeconds(summary.ElapsedSeconds))
 	return err
 }
+
+// printDryRun lists the entries in report, which were not created.
+func printDryRun(report *slicer.Report) error {
+	paths := make([]string, 0, len(report.Entries))
+	for path := range report.Entries {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	w := tabWriter()
+	fmt.Fprintf(w, "Path\tMode\tSize\tSHA256\n")
+	for _, path := range paths {
+		entry := report.Entries[path]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", path, entry.Mode, progress.FormatSize(int64(entry.Size)), orDash(entry.Hash))
+	}
+	return w.Flush()
+}
+
+func formatSeconds(seconds float64) string {
+	return fmt.Sprintf("%.1fs", seconds)
+}
+
+// archiveChoice describes where a selected package is obtained from.
+type archiveChoice struct {
+	archive string
+	reason  string
+	info    *archive.PackageInfo
+}
+
+// explainArchives returns where every package in selection is obtained
+// from, in selection order.
+func explainArchives(release *setup.Release, selection *setup.Selection, archives map[string]archive.Archive) ([]archiveChoice, error) {
+	var choices []archiveChoice
+	seen := make(map[string]bool)
+	for _, slice := range selection.Slices {
+		if seen[slice.Package] {
+			continue
+		}
+		seen[slice.Package] = true
+		archiveName := release.Packages[slice.Package].Archive
+		reason := "default archive"
+		if archiveName != release.DefaultArchive {
+			reason = "package archive field"
+		}
+		pkgArchive, ok := archives[archiveName]
+		if !ok {
+			return nil, fmt.Errorf("archive %q not defined", archiveName)
+		}
+		info, err := archive.Info(pkgArchive, slice.Package)
+		if err != nil {
+			return nil, err
+		}
+		choices = append(choices, archiveChoice{archive: archiveName, reason: reason, info: info})
+	}
+	return choices, nil
+}
+
+func printArchiveChoices(choices []archiveChoice) error {
+	w := tabWriter()
+	fmt.Fprintf(w, "Package\tArchive\tReason\tSuite\tComponent\tVersion\tArch\n")
+	for _, choice := range choices {
+		info := choice.info
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", info.Name, choice.archive, choice.reason,
+			orDash(info.Suite), orDash(info.Component), info.Version, info.Arch)
+	}
+	return w.Flush()
+}
+
+// selectionChains returns, for every slice in selection, the shortest chain
+// of essential slices leading to it from one of the requested slices,
+// starting with that slice and ending with the slice itself.
+func selectionChains(selection *setup.Selection, requested []setup.SliceKey) map[setup.SliceKey][]setup.SliceKey {
+	slices := make(map[setup.SliceKey]*setup.Slice, len(selection.Slices))
+	for _, slice := range selection.Slices {
+		slices[setup.SliceKey{Package: slice.Package, Slice: slice.Name}] = slice
+	}
+	chains := make(map[setup.SliceKey][]setup.SliceKey)
+	var pending []setup.SliceKey
+	for _, key := range requested {
+		if _, ok := chains[key]; !ok {
+			chains[key] = []setup.SliceKey{key}
+			pending = append(pending, key)
+		}
+	}
+	for len(pending) > 0 {
+		key := pending[0]
+		pending = pending[1:]
+		slice, ok := slices[key]
+		if !ok {
+			continue
+		}
+		for _, essential := range slice.Essential {
+			if _, ok := chains[essential]; ok {
+				continue
+			}
+			chain := append([]setup.SliceKey(nil), chains[key]...)
+			chains[essential] = append(chain, essential)
+			pending = append(pending, essential)
+		}
+	}
+	return chains
+}
+
+func printSelectionChains(selection *setup.Selection, requested []setup.SliceKey) error {
+	chains := selectionChains(selection, requested)
+	w := tabWriter()
+	fmt.Fprintf(w, "Slice\tReason\n")
+	for _, slice := range selection.Slices {
+		key := setup.SliceKey{Package: slice.Package, Slice: slice.Name}
+		chain := chains[key]
+		reason := "requested"
+		if len(chain) > 1 {
+			names := make([]string, len(chain))
+			for i, chainKey := range chain {
+				names[i] = chainKey.String()
+			}
+			reason = "essential: " + strings.Join(names, " -> ")
+		}
+		fmt.Fprintf(w, "%s\t%s\n", key, reason)
+	}
+	return w.Flush()
+}
+
+// printMissingLibraries lists the missing shared libraries, suggesting the
+// slices from release with paths named after them.
+func printMissingLibraries(missing []slicer.MissingLibrary, release *setup.Release) error {
+	if len(missing) == 0 {
+		return nil
+	}
+	w := tabWriter()
+	fmt.Fprintf(w, "Path\tMissing\tSlices\n")
+	for _, lib := range missing {
+		var slices []string
+		for _, key := range slicesProviding(release, lib.Soname) {
+			slices = append(slices, key.String())
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", lib.Path, lib.Soname, orDash(strings.Join(slices, ",")))
+	}
+	return w.Flush()
+}
+
+// slicesProviding returns the slices in release with a path whose name
+// matches the provided file name, sorted.
+func slicesProviding(release *setup.Release, name string) []setup.SliceKey {
+	var keys []setup.SliceKey
+	for _, pkg := range release.Packages {
+		for _, slice := range pkg.Slices {
+			for contentPath, pathInfo := range slice.Contents {
+				if pathInfo.Kind == setup.GeneratePath {
+					continue
+				}
+				// Patterns such as /usr/lib/* match any name and don't
+				// suggest anything useful.
+				base := path.Base(contentPath)
+				if strings.Trim(base, "*?") == "" {
+					continue
+				}
+				matched, _ := path.Match(base, name)
+				if matched {
+					keys = append(keys, setup.SliceKey{Package: pkg.Name, Slice: slice.Name})
+					break
+				}
+			}
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+	return keys
+}
+
+// atomicRootDir creates a temporary directory next to rootDir, where the new
+// tree can be cut before being renamed into place. The rootDir location must
+// either not exist or be an empty directory, which gets replaced.
+func atomicRootDir(rootDir string) (string, error) {
+	rootDir = filepath.Clean(rootDir)
+	mode := fs.FileMode(0755)
+	info, err := os.Lstat(rootDir)
+	if err == nil {
+		if !info.IsDir() {
+			return "", fmt.Errorf("cannot cut atomically into %s: not a directory", rootDir)
+		}
+		entries, err := os.ReadDir(rootDir)
+		if err != nil {
+			return "", err
+		}
+		if len(entries) > 0 {
+			return "", fmt.Errorf("cannot cut atomically into %s: directory is not empty", rootDir)
+		}
+		mode = info.Mode().Perm()
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+	// Unlike other temporary files, the tree is kept next to the root
+	// rather than under --tmp-dir, so that it may be renamed into place.
+	dir, err := os.MkdirTemp(filepath.Dir(rootDir), "."+filepath.Base(rootDir)+".chisel-")
+	if err != nil {
+		return "", fmt.Errorf("cannot create temporary root: %w", err)
+	}
+	err = os.Chmod(dir, mode)
+	if err != nil {
+		os.Remove(dir)
+		return "", fmt.Errorf("cannot create temporary root: %w", err)
+	}
+	return dir, nil
+}
+
+// cutState records in the cache the paths created by a cut into a root
+// while it is in progress, so that they may be removed if it is
+// interrupted. Paths are recorded before they are created, one JSON
+// string per line.
+type cutState struct {
+	path string
+	mu   sync.Mutex
+	file *os.File
+}
+
+// cutStatePath returns the location of the state of a cut into rootDir,
+// which is keyed by its absolute path.
+func cutStatePath(rootDir string) (string, error) {
+	rootDir, err := filepath.Abs(rootDir)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(rootDir))
+	return filepath.Join(cache.DefaultDir("chisel"), "cuts", hex.EncodeToString(sum[:])+".json"), nil
+}
+
+// checkInterruptedCut fails if the state of an interrupted cut into
+// rootDir is found.
+func checkInterruptedCut(rootDir string) error {
+	statePath, err := cutStatePath(rootDir)
+	if err != nil {
+		return err
+	}
+	_, err = os.Lstat(statePath)
+	if err == nil {
+		return fmt.Errorf("cannot cut into %s: previous cut was interrupted, use --restart or --clean", rootDir)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// startCutState starts recording the paths created by a cut into rootDir.
+// It fails if the state of an interrupted cut is found there.
+func startCutState(rootDir string) (*cutState, error) {
+	statePath, err := cutStatePath(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(filepath.Dir(statePath), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("cannot record cut state: %w", err)
+	}
+	file, err := os.OpenFile(statePath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if os.IsExist(err) {
+		return nil, fmt.Errorf("cannot cut into %s: previous cut was interrupted, use --restart or --clean", rootDir)
+	} else if err != nil {
+		return nil, fmt.Errorf("cannot record cut state: %w", err)
+	}
+	return &cutState{path: statePath, file: file}, nil
+}
+
+// record adds path to the state before it is created.
+func (s *cutState) record(path string) error {
+	data, err := json.Marshal(path)
+	if err != nil {
+		return fmt.Errorf("cannot record cut state: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.file.Write(append(data, '\n'))
+	if err != nil {
+		return fmt.Errorf("cannot record cut state: %w", err)
+	}
+	return nil
+}
+
+// finish removes the state once the cut ends without being interrupted.
+func (s *cutState) finish() error {
+	s.file.Close()
+	err := os.Remove(s.path)
+	if err != nil {
+		return fmt.Errorf("cannot remove cut state: %w", err)
+	}
+	return nil
+}
+
+// abort removes the state of a cut which failed, unless it failed because
+// the command was interrupted, in which case the state is kept so that the
+// next cut may restart or clean it.
+func (s *cutState) abort() {
+	if commandContext.Err() != nil {
+		s.file.Close()
+		return
+	}
+	s.finish()
+}
+
+// cleanInterruptedCut removes the paths created in rootDir by an interrupted
+// cut, and then its state. It returns false if there is no state of an
+// interrupted cut.
+func cleanInterruptedCut(rootDir string) (found bool, err error) {
+	statePath, err := cutStatePath(rootDir)
+	if err != nil {
+		return false, err
+	}
+	file, err := os.Open(statePath)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("cannot read cut state: %w", err)
+	}
+	defer file.Close()
+
+	dec := json.NewDecoder(file)
+	for {
+		var path string
+		err = dec.Decode(&path)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// A path whose record was cut short was not created.
+			break
+		} else if err != nil {
+			return false, fmt.Errorf("cannot read cut state: %w", err)
+		}
+		// Directories created by the cut only hold what it created.
+		err = os.RemoveAll(path)
+		if err != nil {
+			return false, fmt.Errorf("cannot clean interrupted cut: %w", err)
+		}
+	}
+	err = os.Remove(statePath)
+	if err != nil {
+		return false, fmt.Errorf("cannot remove cut state: %w", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,700 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/openpgp/packet"
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/progress"
+	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/internal/slicer"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+type atomicRootDirTest struct {
+	summary string
+	hackdir func(c *C, root string)
+	mode    os.FileMode
+	error   string
+}
+
+var atomicRootDirTests = []atomicRootDirTest{{
+	summary: "Missing root",
+	mode:    0755,
+}, {
+	summary: "Empty root keeps its permissions",
+	hackdir: func(c *C, root string) {
+		c.Assert(os.Mkdir(root, 0750), IsNil)
+		c.Assert(os.Chmod(root, 0750), IsNil)
+	},
+	mode: 0750,
+}, {
+	summary: "Non-empty root",
+	hackdir: func(c *C, root string) {
+		c.Assert(os.Mkdir(root, 0755), IsNil)
+		c.Assert(os.WriteFile(filepath.Join(root, "file"), nil, 0644), IsNil)
+	},
+	error: `cannot cut atomically into .*/root: directory is not empty`,
+}, {
+	summary: "Root is not a directory",
+	hackdir: func(c *C, root string) {
+		c.Assert(os.WriteFile(root, nil, 0644), IsNil)
+	},
+	error: `cannot cut atomically into .*/root: not a directory`,
+}}
+
+func (s *ChiselSuite) TestAtomicRootDir(c *C) {
+	for _, test := range atomicRootDirTests {
+		c.Logf("Summary: %s", test.summary)
+		parent := c.MkDir()
+		root := filepath.Join(parent, "root")
+		if test.hackdir != nil {
+			test.hackdir(c, root)
+		}
+		dir, err := chisel.AtomicRootDir(root + "/")
+		if test.error != "" {
+			c.Assert(err, ErrorMatches, test.error)
+			continue
+		}
+		c.Assert(err, IsNil)
+		c.Assert(filepath.Dir(dir), Equals, parent)
+		c.Assert(strings.HasPrefix(filepath.Base(dir), ".root.chisel-"), Equals, true)
+		info, err := os.Stat(dir)
+		c.Assert(err, IsNil)
+		c.Assert(info.Mode(), Equals, os.ModeDir|test.mode)
+		// The temporary directory may replace the root.
+		c.Assert(syscall.Rename(dir, root), IsNil)
+	}
+}
+
+const sliceList = `
+# Base system.
+base-files_base
+base-passwd_data  # Users and groups.
+
+	openssl_bins
+`
+
+func (s *ChiselSuite) TestReadSliceRefs(c *C) {
+	expected := []string{"base-files_base", "base-passwd_data", "openssl_bins"}
+
+	path := filepath.Join(c.MkDir(), "slices.txt")
+	c.Assert(os.WriteFile(path, []byte(sliceList), 0644), IsNil)
+	sliceRefs, err := chisel.ReadSliceRefs(path)
+	c.Assert(err, IsNil)
+	c.Assert(sliceRefs, DeepEquals, expected)
+
+	s.stdin.WriteString(sliceList)
+	sliceRefs, err = chisel.ReadSliceRefs("-")
+	c.Assert(err, IsNil)
+	c.Assert(sliceRefs, DeepEquals, expected)
+
+	_, err = chisel.ReadSliceRefs(filepath.Join(c.MkDir(), "missing"))
+	c.Assert(err, ErrorMatches, `cannot read slice list: open .*/missing: no such file or directory`)
+}
+
+func (s *ChiselSuite) TestParseSliceRefs(c *C) {
+	release := &setup.Release{
+		Sets: map[string][]setup.SliceKey{
+			"base": {{"base-files", "base"}, {"base-passwd", "data"}},
+		},
+		Packages: map[string]*setup.Package{
+			"python3": {
+				Name: "python3",
+				Slices: map[string]*setup.Slice{
+					"core":   {Package: "python3", Name: "core"},
+					"bins":   {Package: "python3", Name: "bins"},
+					"legacy": {Package: "python3", Name: "legacy", Deprecated: "Use core."},
+				},
+			},
+		},
+	}
+
+	sliceKeys, err := chisel.ParseSliceRefs(release, []string{"openssl_bins", "@base"})
+	c.Assert(err, IsNil)
+	c.Assert(sliceKeys, DeepEquals, []setup.SliceKey{
+		{"openssl", "bins"}, {"base-files", "base"}, {"base-passwd", "data"},
+	})
+
+	sliceKeys, err = chisel.ParseSliceRefs(release, []string{"python3_*"})
+	c.Assert(err, IsNil)
+	c.Assert(sliceKeys, DeepEquals, []setup.SliceKey{{"python3", "bins"}, {"python3", "core"}})
+	c.Assert(s.Stderr(), Equals, "Selected all slices of python3: bins, core\n")
+
+	_, err = chisel.ParseSliceRefs(release, []string{"python2_*"})
+	c.Assert(err, ErrorMatches, `slices of package "python2" not found`)
+
+	_, err = chisel.ParseSliceRefs(release, []string{"@other"})
+	c.Assert(err, ErrorMatches, `unknown slice set "other"`)
+
+	_, err = chisel.ParseSliceRefs(release, []string{"openssl"})
+	c.Assert(err, ErrorMatches, `invalid slice reference: "openssl"`)
+}
+
+func (s *ChiselSuite) TestCutNoSlices(c *C) {
+	defer fakeArgs("chisel", "cut", "--root", c.MkDir(), "--from-file", "-")()
+	s.stdin.WriteString("# Nothing here.\n")
+	err := chisel.RunMain()
+	c.Assert(err, ErrorMatches, `no slices provided, see the --from-file option`)
+}
+
+var cutTargetsTests = []struct {
+	summary string
+	args    []string
+	error   string
+}{{
+	summary: "Multiple architectures need a root template",
+	args:    []string{"--arch", "amd64,arm64", "--root", "out"},
+	error:   `cannot cut multiple architectures without --root-template`,
+}, {
+	summary: "Root template must refer to the architecture",
+	args:    []string{"--arch", "amd64,arm64", "--root-template", "out"},
+	error:   `root template "out" does not contain {arch}`,
+}, {
+	summary: "Root and root template are exclusive",
+	args:    []string{"--arch", "amd64", "--root", "out", "--root-template", "out/{arch}"},
+	error:   `cannot use both --root and --root-template`,
+}, {
+	summary: "Empty architecture",
+	args:    []string{"--arch", "amd64,,arm64", "--root-template", "out/{arch}"},
+	error:   `invalid architecture list "amd64,,arm64"`,
+}, {
+	summary: "Repeated architecture",
+	args:    []string{"--arch", "amd64,arm64,amd64", "--root-template", "out/{arch}"},
+	error:   `architecture "amd64" provided twice`,
+}, {
+	summary: "Appending to a tar archive replaces the root",
+	args:    []string{"--root", "out", "--append-tar", "base.tar"},
+	error:   `cannot use --append-tar with --root or --root-template`,
+}, {
+	summary: "Appending to a tar archive is not atomic",
+	args:    []string{"--append-tar", "base.tar", "--atomic"},
+	error:   `cannot use both --append-tar and --atomic`,
+}, {
+	summary: "Appending to a tar archive supports a single architecture",
+	args:    []string{"--arch", "amd64,arm64", "--append-tar", "base.tar"},
+	error:   `cannot append multiple architectures to a tar archive`,
+}, {
+	summary: "Appending to a tar archive proceeds with the cut",
+	args:    []string{"--append-tar", "base.tar"},
+	error:   `no slices provided, see the --from-file option`,
+}, {
+	summary: "Dry runs write nothing",
+	args:    []string{"--root", "out", "--dry-run", "--atomic"},
+	error:   `cannot use --dry-run with --atomic, --append-tar, --check-elf or --manifest-path`,
+}, {
+	summary: "Dry runs write no manifest",
+	args:    []string{"--root", "out", "--dry-run", "--manifest-path", "manifest.wall"},
+	error:   `cannot use --dry-run with --atomic, --append-tar, --check-elf or --manifest-path`,
+}, {
+	summary: "Manifests cannot be both written into the tree and left out of it",
+	args:    []string{"--root", "out", "--with-manifest", "--no-tree-manifest"},
+	error:   `cannot use both --with-manifest and --no-tree-manifest`,
+}, {
+	summary: "Manifest path must refer to the architecture",
+	args:    []string{"--arch", "amd64,arm64", "--root-template", "out/{arch}", "--manifest-path", "manifest.wall"},
+	error:   `manifest path "manifest.wall" does not contain {arch}`,
+}, {
+	summary: "Manifest path of a single architecture",
+	args:    []string{"--arch", "amd64", "--root-template", "out/{arch}", "--manifest-path", "manifest.wall"},
+	error:   `no slices provided, see the --from-file option`,
+}, {
+	summary: "Unknown prune class",
+	args:    []string{"--root", "out", "--prune", "docs,tests"},
+	error:   `invalid prune class "tests", must be one of: docs, locales, man`,
+}, {
+	summary: "Debug root requires stripping",
+	args:    []string{"--root", "out", "--debug-root", "debug"},
+	error:   `cannot use --debug-root without --strip`,
+}, {
+	summary: "Debug root must refer to the architecture",
+	args:    []string{"--arch", "amd64,arm64", "--root-template", "out/{arch}", "--strip", "--debug-root", "debug"},
+	error:   `debug root "debug" does not contain {arch}`,
+}, {
+	summary: "Unknown dedupe mode",
+	args:    []string{"--root", "out", "--dedupe", "reflink"},
+	error:   `invalid dedupe mode "reflink", must be one of: hardlink`,
+}, {
+	summary: "Invalid maximum size",
+	args:    []string{"--root", "out", "--max-size", "10T"},
+	error:   `invalid maximum size "10T", must be a positive number of bytes \(e.g. 500M\)`,
+}, {
+	summary: "Restarting and cleaning are exclusive",
+	args:    []string{"--root", "out", "--restart", "--clean"},
+	error:   `cannot use both --restart and --clean`,
+}, {
+	summary: "Atomic cuts cannot be restarted",
+	args:    []string{"--root", "out", "--atomic", "--restart"},
+	error:   `cannot use --restartable, --restart or --clean with --dry-run, --atomic or --append-tar`,
+}, {
+	summary: "Dry runs record nothing to restart",
+	args:    []string{"--root", "out", "--dry-run", "--restartable"},
+	error:   `cannot use --restartable, --restart or --clean with --dry-run, --atomic or --append-tar`,
+}, {
+	summary: "Cleaning requires an interrupted cut",
+	args:    []string{"--root", "out", "--clean"},
+	error:   `no interrupted cut found in out`,
+}, {
+	summary: "Unknown digest",
+	args:    []string{"--root", "out", "--digest", "blake3"},
+	error:   `invalid digest "blake3", must be "sha256" or "sha512"`,
+}, {
+	summary: "Invalid trusted key",
+	args:    []string{"--root", "out", "--trusted-key", "854BAF1AA9D766"},
+	error:   `invalid trusted key "854BAF1AA9D766", must be a key fingerprint or long key ID`,
+}, {
+	summary: "Valid targets proceed with the cut",
+	args:    []string{"--arch", "amd64,arm64", "--root-template", "out/{arch}"},
+	error:   `no slices provided, see the --from-file option`,
+}}
+
+func (s *ChiselSuite) TestCutTargets(c *C) {
+	defer fakeEnv("CHISEL_ROOT", "")()
+	defer fakeEnv("CHISEL_ARCH", "")()
+	for _, test := range cutTargetsTests {
+		c.Logf("Summary: %s", test.summary)
+		restore := fakeArgs(append([]string{"chisel", "cut"}, test.args...)...)
+		err := chisel.RunMain()
+		restore()
+		c.Assert(err, ErrorMatches, test.error)
+	}
+}
+
+var restrictArchiveKeysTests = []struct {
+	summary     string
+	trustedKeys []string
+	archiveKeys map[string][]string
+	error       string
+}{{
+	summary:     "No trusted keys",
+	archiveKeys: map[string][]string{"ubuntu": {"key1", "key2"}, "other": {"key2"}},
+}, {
+	summary:     "Only trusted keys are kept",
+	trustedKeys: []string{"9568570379bf1f43"},
+	archiveKeys: map[string][]string{"ubuntu": {"key2"}, "other": {"key2"}},
+}, {
+	summary:     "Full fingerprint with spaces",
+	trustedKeys: []string{"fingerprint:key2", "854BAF1AA9D76600"},
+	archiveKeys: map[string][]string{"ubuntu": {"key1", "key2"}, "other": {"key2"}},
+}, {
+	summary:     "Archive left without keys",
+	trustedKeys: []string{"854BAF1AA9D76600"},
+	error:       `archive "other" has no trusted key, its keys are: 9568570379BF1F43`,
+}, {
+	summary:     "Unknown key",
+	trustedKeys: []string{"854BAF1AA9D76600", "9568570379BF1F43", "0123456789ABCDEF"},
+	error:       `trusted key 0123456789ABCDEF is not used by any archive of the release`,
+}}
+
+func (s *ChiselSuite) TestRestrictArchiveKeys(c *C) {
+	keyNames := map[string]string{}
+	for _, name := range []string{"key1", "key2"} {
+		keyNames[testutil.PGPKeys[name].ID] = name
+	}
+	for _, test := range restrictArchiveKeysTests {
+		c.Logf("Summary: %s", test.summary)
+		release := &setup.Release{
+			Archives: map[string]*setup.Archive{
+				"ubuntu": {Name: "ubuntu", PubKeys: []*packet.PublicKey{testutil.PGPKeys["key1"].PubKey, testutil.PGPKeys["key2"].PubKey}},
+				"other":  {Name: "other", PubKeys: []*packet.PublicKey{testutil.PGPKeys["key2"].PubKey}},
+			},
+		}
+		var values []string
+		for _, value := range test.trustedKeys {
+			if name, ok := strings.CutPrefix(value, "fingerprint:"); ok {
+				// Fingerprints are often shown in groups of four digits.
+				fingerprint := fmt.Sprintf("%X", testutil.PGPKeys[name].PubKey.Fingerprint)
+				var groups []string
+				for i := 0; i < len(fingerprint); i += 4 {
+					groups = append(groups, fingerprint[i:i+4])
+				}
+				value = strings.Join(groups, " ")
+			}
+			values = append(values, value)
+		}
+		trustedKeys, err := chisel.ParseKeyFingerprints(values)
+		c.Assert(err, IsNil)
+		err = chisel.RestrictArchiveKeys(release, trustedKeys)
+		if test.error != "" {
+			c.Assert(err, ErrorMatches, test.error)
+			continue
+		}
+		c.Assert(err, IsNil)
+		archiveKeys := make(map[string][]string)
+		for archiveName, archiveInfo := range release.Archives {
+			for _, pubKey := range archiveInfo.PubKeys {
+				archiveKeys[archiveName] = append(archiveKeys[archiveName], keyNames[pubKey.KeyIdString()])
+			}
+		}
+		c.Assert(archiveKeys, DeepEquals, test.archiveKeys)
+	}
+}
+
+func (s *ChiselSuite) TestSlicesProviding(c *C) {
+	release := &setup.Release{
+		Packages: map[string]*setup.Package{
+			"libfoo1": {
+				Name: "libfoo1",
+				Slices: map[string]*setup.Slice{
+					"libs": {
+						Package: "libfoo1",
+						Name:    "libs",
+						Contents: map[string]setup.PathInfo{
+							"/usr/lib/*-linux-*/libfoo.so.1*": {Kind: setup.GlobPath},
+						},
+					},
+					"copyright": {
+						Package: "libfoo1",
+						Name:    "copyright",
+						Contents: map[string]setup.PathInfo{
+							"/usr/share/doc/libfoo1/copyright": {Kind: setup.CopyPath},
+						},
+					},
+				},
+			},
+			"libc6": {
+				Name: "libc6",
+				Slices: map[string]*setup.Slice{
+					"libs": {
+						Package: "libc6",
+						Name:    "libs",
+						Contents: map[string]setup.PathInfo{
+							"/usr/lib/x86_64-linux-gnu/libc.so.6": {Kind: setup.CopyPath},
+							"/usr/lib/x86_64-linux-gnu/*":         {Kind: setup.GlobPath},
+						},
+					},
+				},
+			},
+		},
+	}
+	c.Assert(chisel.SlicesProviding(release, "libfoo.so.1"), DeepEquals, []setup.SliceKey{{"libfoo1", "libs"}})
+	c.Assert(chisel.SlicesProviding(release, "libc.so.6"), DeepEquals, []setup.SliceKey{{"libc6", "libs"}})
+	c.Assert(chisel.SlicesProviding(release, "libbar.so.2"), HasLen, 0)
+}
+
+func (s *ChiselSuite) TestPrintCutStats(c *C) {
+	fooBins := &setup.Slice{Package: "foo", Name: "bins"}
+	fooDocs := &setup.Slice{Package: "foo", Name: "docs"}
+	barLibs := &setup.Slice{Package: "bar", Name: "libs"}
+	selection := &setup.Selection{Slices: []*setup.Slice{fooBins, fooDocs, barLibs}}
+	report := &slicer.Report{Entries: map[string]slicer.ReportEntry{
+		"/usr/":            {Mode: fs.ModeDir | 0755, Slices: map[*setup.Slice]bool{fooBins: true, barLibs: true}},
+		"/usr/bin/foo":     {Mode: 0755, Size: 2048, Slices: map[*setup.Slice]bool{fooBins: true}},
+		"/usr/bin/foo-sh":  {Mode: fs.ModeSymlink | 0777, Link: "foo", Slices: map[*setup.Slice]bool{fooBins: true}},
+		"/usr/lib/libbar":  {Mode: 0644, Size: 1024, Slices: map[*setup.Slice]bool{barLibs: true}},
+		"/usr/share/doc/x": {Mode: 0644, Size: 10, Slices: map[*setup.Slice]bool{fooDocs: true, barLibs: true}},
+	}}
+	ops := []progress.Operation{
+		{Label: "Fetching InRelease", Processed: 100, Elapsed: time.Second},
+		{Label: "Fetching foo_1.0_amd64.deb", Processed: 4096, Elapsed: 2 * time.Second},
+		{Label: "Extracting foo", Processed: 4096, Elapsed: 500 * time.Millisecond},
+		{Label: "Extracting bar", Processed: 2048, Elapsed: 250 * time.Millisecond},
+	}
+
+	ubuntu := &archive.Stats{}
+	ubuntu.CacheHits.Add(3)
+	ubuntu.CacheMisses.Add(1)
+	ubuntu.Downloaded.Add(4196)
+	stats := map[string]*archive.Stats{"ubuntu": ubuntu, "other": {}}
+
+	err := chisel.PrintCutStats("text", selection, report, ops, stats, 5*time.Second)
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, ""+
+		"Slice     Files  Written\n"+
+		"foo_bins  2      2.0KiB\n"+
+		"foo_docs  1      10B\n"+
+		"bar_libs  2      1.0KiB\n"+
+		"Total     4      3.0KiB\n"+
+		"\n"+
+		"Package  Written  Downloaded  Fetching  Extracting\n"+
+		"foo      2.0KiB   4.0KiB      2.0s      0.5s\n"+
+		"bar      1.0KiB   0B          -         0.2s\n"+
+		"Total    3.0KiB   4.1KiB\n"+
+		"\n"+
+		"Archive  Downloaded  Cache Hits  Cache Misses\n"+
+		"other    0B          0           0\n"+
+		"ubuntu   4.1KiB      3           1\n"+
+		"\n"+
+		"Elapsed: 5.0s\n")
+
+	s.ResetStdStreams()
+	err = chisel.PrintCutStats("json", selection, report, ops, stats, 5*time.Second)
+	c.Assert(err, IsNil)
+	var summary map[string]any
+	c.Assert(json.Unmarshal([]byte(s.Stdout()), &summary), IsNil)
+	c.Assert(summary["slices"], DeepEquals, []any{
+		map[string]any{"name": "foo_bins", "files": 2.0, "written": 2048.0},
+		map[string]any{"name": "foo_docs", "files": 1.0, "written": 10.0},
+		map[string]any{"name": "bar_libs", "files": 2.0, "written": 1034.0},
+	})
+	c.Assert(summary["packages"], DeepEquals, []any{
+		map[string]any{"name": "foo", "written": 2058.0, "downloaded": 4096.0, "fetch-seconds": 2.0, "extract-seconds": 0.5},
+		map[string]any{"name": "bar", "written": 1034.0, "downloaded": 0.0, "fetch-seconds": 0.0, "extract-seconds": 0.25},
+	})
+	c.Assert(summary["archives"], DeepEquals, []any{
+		map[string]any{"name": "other", "downloaded": 0.0, "cache-hits": 0.0, "cache-misses": 0.0},
+		map[string]any{"name": "ubuntu", "downloaded": 4196.0, "cache-hits": 3.0, "cache-misses": 1.0},
+	})
+	c.Assert(summary["files"], Equals, 4.0)
+	c.Assert(summary["written"], Equals, 3082.0)
+	c.Assert(summary["downloaded"], Equals, 4196.0)
+	c.Assert(summary["elapsed-seconds"], Equals, 5.0)
+}
+
+func (s *ChiselSuite) TestCleanInterruptedCut(c *C) {
+	cacheDir := c.MkDir()
+	defer fakeEnv("XDG_CACHE_HOME", cacheDir)()
+	parent := c.MkDir()
+	root := filepath.Join(parent, "root")
+	c.Assert(os.MkdirAll(filepath.Join(root, "etc"), 0755), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(root, "etc", "hosts"), nil, 0644), IsNil)
+
+	// Paths are recorded as the cut creates them.
+	state, err := chisel.StartCutState(root)
+	c.Assert(err, IsNil)
+	create := func(path string, dir bool) {
+		path = filepath.Join(root, path)
+		c.Assert(state.Record(path), IsNil)
+		if dir {
+			c.Assert(os.Mkdir(path, 0755), IsNil)
+		} else {
+			c.Assert(os.WriteFile(path, nil, 0644), IsNil)
+		}
+	}
+	create("etc/ssl", true)
+	create("etc/ssl/cert", false)
+	create("usr", true)
+	create("usr/bin", true)
+	create("usr/bin/foo", false)
+	// A path recorded but not created yet is skipped.
+	c.Assert(state.Record(filepath.Join(root, "usr", "bin", "bar")), IsNil)
+
+	// The cut is interrupted, so it cannot start again.
+	err = chisel.CheckInterruptedCut(root)
+	c.Assert(err, ErrorMatches, `cannot cut into .*/root: previous cut was interrupted, use --restart or --clean`)
+	_, err = chisel.StartCutState(root)
+	c.Assert(err, ErrorMatches, `cannot cut into .*/root: previous cut was interrupted, use --restart or --clean`)
+
+	found, err := chisel.CleanInterruptedCut(root)
+	c.Assert(err, IsNil)
+	c.Assert(found, Equals, true)
+	c.Assert(testutil.TreeDump(root), DeepEquals, map[string]string{
+		"/etc/":      "dir 0755",
+		"/etc/hosts": "file 0644 empty",
+	})
+	found, err = chisel.CleanInterruptedCut(root)
+	c.Assert(err, IsNil)
+	c.Assert(found, Equals, false)
+	c.Assert(chisel.CheckInterruptedCut(root), IsNil)
+
+	// Once finished, no state is left behind, and none is kept next to
+	// the root either.
+	state, err = chisel.StartCutState(root)
+	c.Assert(err, IsNil)
+	c.Assert(state.Finish(), IsNil)
+	entries, err := os.ReadDir(parent)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 1)
+	c.Assert(entries[0].Name(), Equals, "root")
+	entries, err = os.ReadDir(filepath.Join(cacheDir, "chisel", "cuts"))
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 0)
+
+	// A cut failing on its own leaves no state behind either.
+	state, err = chisel.StartCutState(root)
+	c.Assert(err, IsNil)
+	state.Abort()
+	found, err = chisel.CleanInterruptedCut(root)
+	c.Assert(err, IsNil)
+	c.Assert(found, Equals, false)
+
+	// Unless the command was interrupted.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	restore := chisel.FakeCommandContext(ctx)
+	defer restore()
+	state, err = chisel.StartCutState(root)
+	c.Assert(err, IsNil)
+	state.Abort()
+	restore()
+	err = chisel.CheckInterruptedCut(root)
+	c.Assert(err, ErrorMatches, `cannot cut into .*/root: previous cut was interrupted, use --restart or --clean`)
+	found, err = chisel.CleanInterruptedCut(root)
+	c.Assert(err, IsNil)
+	c.Assert(found, Equals, true)
+}
+
+func (s *ChiselSuite) TestCheckTreeSize(c *C) {
+	slice := &setup.Slice{Package: "foo", Name: "bins"}
+	report := &slicer.Report{Entries: map[string]slicer.ReportEntry{
+		"/usr/":        {Mode: fs.ModeDir | 0755, Slices: map[*setup.Slice]bool{slice: true}},
+		"/usr/bin/foo": {Mode: 0755, Size: 2048, Slices: map[*setup.Slice]bool{slice: true}},
+		"/usr/bin/bar": {Mode: 0755, Size: 1024, Slices: map[*setup.Slice]bool{slice: true}},
+	}}
+
+	c.Assert(chisel.CheckTreeSize(report, 0), IsNil)
+	c.Assert(chisel.CheckTreeSize(report, 3072), IsNil)
+	c.Assert(chisel.CheckTreeSize(report, 3071), ErrorMatches, `tree takes 3072 bytes, more than the maximum of 3071`)
+}
+
+func (s *ChiselSuite) TestCutTimeout(c *C) {
+	releaseDir := c.MkDir()
+	release := map[string]string{
+		"chisel.yaml": `
+			format: v1
+			archives:
+				ubuntu:
+					version: 22.04
+					components: [main]
+					suites: [jammy]
+					public-keys: [test-key]
+			public-keys:
+				test-key:
+					id: ` + testutil.PGPKeys["key1"].ID + `
+					armor: |` + "\n" + testutil.PrefixEachLine(testutil.PGPKeys["key1"].PubKeyArmor, "\t\t\t\t\t\t") + `
+		`,
+		"slices/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+		`,
+	}
+	for path, data := range release {
+		fpath := filepath.Join(releaseDir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+	defer fakeEnv("XDG_CACHE_HOME", c.MkDir())()
+
+	// The deadline expires before the archive is reached.
+	rootDir := filepath.Join(c.MkDir(), "root")
+	defer fakeArgs("chisel", "cut", "--timeout", "1ns", "--release", releaseDir, "--root", rootDir, "--atomic", "mypkg_myslice")()
+	err := chisel.RunMain()
+	c.Assert(err, ErrorMatches, `timed out after 1ns: cannot talk to archive: .*context deadline exceeded`)
+	var buf bytes.Buffer
+	c.Assert(chisel.PrintError(&buf, err, "json"), Equals, 7)
+	c.Assert(buf.String(), Matches, `\{"kind":"cancelled",.*\n`)
+	_, err = os.Stat(rootDir)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *ChiselSuite) TestPrintDryRun(c *C) {
+	slice := &setup.Slice{Package: "foo", Name: "bins"}
+	report := &slicer.Report{Entries: map[string]slicer.ReportEntry{
+		"/usr/":           {Path: "/usr/", Mode: fs.ModeDir | 0755, Slices: map[*setup.Slice]bool{slice: true}},
+		"/usr/bin/foo":    {Path: "/usr/bin/foo", Mode: 0755, Size: 2048, Hash: "5b41362bc82b7f3d56edc5a306db22105707d01ff4819e26faef9724a2d406c9", Slices: map[*setup.Slice]bool{slice: true}},
+		"/usr/bin/foo-sh": {Path: "/usr/bin/foo-sh", Mode: fs.ModeSymlink | 0777, Link: "foo", Slices: map[*setup.Slice]bool{slice: true}},
+	}}
+
+	err := chisel.PrintDryRun(report)
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, ""+
+		"Path             Mode        Size    SHA256\n"+
+		"/usr/            drwxr-xr-x  0B      -\n"+
+		"/usr/bin/foo     -rwxr-xr-x  2.0KiB  5b41362bc82b7f3d56edc5a306db22105707d01ff4819e26faef9724a2d406c9\n"+
+		"/usr/bin/foo-sh  Lrwxrwxrwx  0B      -\n")
+}
+
+type fakeArchive struct {
+	name  string
+	infos map[string]*archive.PackageInfo
+}
+
+func (a *fakeArchive) Options() *archive.Options { return &archive.Options{Label: a.name} }
+func (a *fakeArchive) Fetch(pkg string) (io.ReadCloser, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+func (a *fakeArchive) Exists(pkg string) bool { return a.infos[pkg] != nil }
+
+func (a *fakeArchive) Packages() []string {
+	var names []string
+	for name := range a.infos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (a *fakeArchive) Info(pkg string) (*archive.PackageInfo, error) {
+	if info, ok := a.infos[pkg]; ok {
+		return info, nil
+	}
+	return nil, fmt.Errorf("cannot find package %q in archive", pkg)
+}
+
+func (s *ChiselSuite) TestPrintSelectionChains(c *C) {
+	selection := &setup.Selection{Slices: []*setup.Slice{
+		{Package: "libc6", Name: "libs"},
+		{Package: "base-files", Name: "base"},
+		{Package: "libssl3", Name: "libs", Essential: []setup.SliceKey{{"libc6", "libs"}}},
+		{Package: "openssl", Name: "config"},
+		{Package: "openssl", Name: "bins", Essential: []setup.SliceKey{
+			{"libssl3", "libs"},
+			{"openssl", "config"},
+		}},
+	}}
+	requested := []setup.SliceKey{{"openssl", "bins"}, {"base-files", "base"}}
+
+	err := chisel.PrintSelectionChains(selection, requested)
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, ""+
+		"Slice            Reason\n"+
+		"libc6_libs       essential: openssl_bins -> libssl3_libs -> libc6_libs\n"+
+		"base-files_base  requested\n"+
+		"libssl3_libs     essential: openssl_bins -> libssl3_libs\n"+
+		"openssl_config   essential: openssl_bins -> openssl_config\n"+
+		"openssl_bins     requested\n")
+}
+
+func (s *ChiselSuite) TestPrintArchiveChoices(c *C) {
+	release := &setup.Release{
+		DefaultArchive: "ubuntu",
+		Packages: map[string]*setup.Package{
+			"foo": {Name: "foo", Archive: "ubuntu"},
+			"bar": {Name: "bar", Archive: "backports"},
+		},
+	}
+	archives := map[string]archive.Archive{
+		"ubuntu": &fakeArchive{name: "ubuntu", infos: map[string]*archive.PackageInfo{
+			"foo": {Name: "foo", Version: "1.1", Arch: "amd64", Suite: "jammy-updates", Component: "main"},
+		}},
+		"backports": &fakeArchive{name: "backports", infos: map[string]*archive.PackageInfo{
+			"bar": {Name: "bar", Version: "2.0", Arch: "all", Suite: "jammy-backports", Component: "universe"},
+		}},
+	}
+	selection := &setup.Selection{Slices: []*setup.Slice{
+		{Package: "foo", Name: "bins"},
+		{Package: "bar", Name: "data"},
+		{Package: "foo", Name: "libs"},
+	}}
+
+	err := chisel.PrintArchiveChoices(release, selection, archives)
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, ""+
+		"Package  Archive    Reason                 Suite            Component  Version  Arch\n"+
+		"foo      ubuntu     default archive        jammy-updates    main       1.1      amd64\n"+
+		"bar      backports  package archive field  jammy-backports  universe   2.0      all\n")
+
+	release.Packages["baz"] = &setup.Package{Name: "baz", Archive: "ubuntu"}
+	selection.Slices = append(selection.Slices, &setup.Slice{Package: "baz", Name: "bins"})
+	err = chisel.PrintArchiveChoices(release, selection, archives)
+	c.Assert(err, ErrorMatches, `cannot find package "baz" in archive`)
+}
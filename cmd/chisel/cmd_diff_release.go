@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/setup"
+)
+
+var shortDiffReleaseHelp = "Compare the slices of two releases"
+var longDiffReleaseHelp = `
+The diff-release command compares the slice definitions of two releases,
+such as two checkouts of a release repository before and after a change,
+and lists the slices, essential slices, mutation scripts and paths which
+were added, removed or changed from the old one to the new one.
+
+Changes which affect root filesystems already cut from the old release
+are marked in the Impact column: removed slices and paths, changed paths
+and scripts, and added or removed essential slices, which change the
+slices selected along with them.
+
+Each release may be either a directory or a release name, as accepted by
+the --release flag of other commands.
+`
+
+type cmdDiffRelease struct {
+	Positional struct {
+		OldRelease string `positional-arg-name:"<old release>" required:"yes"`
+		NewRelease string `positional-arg-name:"<new release>" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func init() {
+	addCommand("diff-release", shortDiffReleaseHelp, longDiffReleaseHelp, func() flags.Commander { return &cmdDiffRelease{} }, nil, nil)
+}
+
+func (cmd *cmdDiffRelease) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	oldRelease, err := obtainRelease(cmd.Positional.OldRelease)
+	if err != nil {
+		return err
+	}
+	newRelease, err := obtainRelease(cmd.Positional.NewRelease)
+	if err != nil {
+		return err
+	}
+
+	changes := diffReleases(oldRelease, newRelease)
+	if len(changes) == 0 {
+		fmt.Fprintf(Stderr, "No differences found\n")
+		return nil
+	}
+	return printReleaseChanges(changes)
+}
+
+type releaseChange struct {
+	Kind  string
+	Slice string
+	// Entry is the path, "essential" or "mutate" for changes within the
+	// slice, or empty for the slice itself.
+	Entry string
+	Old   string
+	New   string
+	// Impact is set when the change affects root filesystems cut before.
+	Impact bool
+}
+
+// diffReleases returns the changes to the slices from the old release to the
+// new one, sorted by slice and entry.
+func diffReleases(oldRelease, newRelease *setup.Release) []releaseChange {
+	oldSlices := releaseSlices(oldRelease)
+	newSlices := releaseSlices(newRelease)
+
+	var changes []releaseChange
+	for name, oldSlice := range oldSlices {
+		newSlice, ok := newSlices[name]
+		if !ok {
+			changes = append(changes, releaseChange{Kind: "removed", Slice: name, Old: "slice", Impact: true})
+			continue
+		}
+		changes = append(changes, diffSlices(oldSlice, newSlice)...)
+	}
+	for name := range newSlices {
+		if _, ok := oldSlices[name]; !ok {
+			changes = append(changes, releaseChange{Kind: "added", Slice: name, New: "slice"})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Slice != changes[j].Slice {
+			return changes[i].Slice < changes[j].Slice
+		}
+		if changes[i].Entry != changes[j].Entry {
+			return changes[i].Entry < changes[j].Entry
+		}
+		return changes[i].Old < changes[j].Old
+	})
+	return changes
+}
+
+func releaseSlices(release *setup.Release) map[string]*setup.Slice {
+	result := make(map[string]*setup.Slice)
+	for _, pkg := range release.Packages {
+		for _, slice := range pkg.Slices {
+			result[slice.String()] = slice
+		}
+	}
+	return result
+}
+
+// diffSlices returns the changes from the old definition of a slice to the
+// new one.
+func diffSlices(oldSlice, newSlice *setup.Slice) []releaseChange {
+	name := oldSlice.String()
+	var changes []releaseChange
+	for _, key := range oldSlice.Essential {
+		if !slices.Contains(newSlice.Essential, key) {
+			changes = append(changes, releaseChange{Kind: "removed", Slice: name, Entry: "essential", Old: key.String(), Impact: true})
+		}
+	}
+	for _, key := range newSlice.Essential {
+		if !slices.Contains(oldSlice.Essential, key) {
+			changes = append(changes, releaseChange{Kind: "added", Slice: name, Entry: "essential", New: key.String(), Impact: true})
+		}
+	}
+	if oldSlice.Scripts.Mutate != newSlice.Scripts.Mutate {
+		change := releaseChange{Kind: "changed", Slice: name, Entry: "mutate", Old: "script", New: "script", Impact: true}
+		if oldSlice.Scripts.Mutate == "" {
+			change.Kind, change.Old = "added", ""
+		} else if newSlice.Scripts.Mutate == "" {
+			change.Kind, change.New = "removed", ""
+		}
+		changes = append(changes, change)
+	}
+	for path, oldInfo := range oldSlice.Contents {
+		newInfo, ok := newSlice.Contents[path]
+		switch {
+		case !ok:
+			changes = append(changes, releaseChange{Kind: "removed", Slice: name, Entry: path, Old: describePathInfo(oldInfo), Impact: true})
+		case !reflect.DeepEqual(oldInfo, newInfo):
+			changes = append(changes, releaseChange{Kind: "changed", Slice: name, Entry: path, Old: describePathInfo(oldInfo), New: describePathInfo(newInfo), Impact: true})
+		}
+	}
+	for path, newInfo := range newSlice.Contents {
+		if _, ok := oldSlice.Contents[path]; !ok {
+			changes = append(changes, releaseChange{Kind: "added", Slice: name, Entry: path, New: describePathInfo(newInfo)})
+		}
+	}
+	return changes
+}
+
+// describePathInfo returns a short description of the path details in a
+// slice definition.
+func describePathInfo(info setup.PathInfo) string {
+	var parts []string
+	switch info.Kind {
+	case setup.TextPath:
+		sum := sha256.Sum256([]byte(info.Info))
+		parts = append(parts, "text", hex.EncodeToString(sum[:])[:8])
+	case setup.GeneratePath:
+		parts = append(parts, "generate", string(info.Generate))
+	default:
+		parts = append(parts, string(info.Kind))
+		if info.Info != "" {
+			parts = append(parts, info.Info)
+		}
+	}
+	if info.Mode != 0 {
+		parts = append(parts, fmt.Sprintf("%#o", info.Mode))
+	}
+	if info.Mutable {
+		parts = append(parts, "mutable")
+	}
+	if info.Until != setup.UntilNone {
+		parts = append(parts, "until:"+string(info.Until))
+	}
+	if len(info.Arch) > 0 {
+		parts = append(parts, "arch:"+strings.Join(info.Arch, ","))
+	}
+	if info.Detail != "" {
+		parts = append(parts, "detail:"+string(info.Detail))
+	}
+	return strings.Join(parts, " ")
+}
+
+func printReleaseChanges(changes []releaseChange) error {
+	w := tabWriter()
+	fmt.Fprintf(w, "Change\tSlice\tEntry\tOld\tNew\tImpact\n")
+	for _, change := range changes {
+		impact := "-"
+		if change.Impact {
+			impact = "yes"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", change.Kind, change.Slice, orDash(change.Entry), orDash(change.Old), orDash(change.New), impact)
+	}
+	return w.Flush()
+}
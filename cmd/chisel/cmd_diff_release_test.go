@@ -0,0 +1,89 @@
+package main_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/setup"
+)
+
+func (s *ChiselSuite) TestDiffReleases(c *C) {
+	oldRelease := &setup.Release{
+		Packages: map[string]*setup.Package{
+			"mypkg": {
+				Name: "mypkg",
+				Slices: map[string]*setup.Slice{
+					"bins": {
+						Package:   "mypkg",
+						Name:      "bins",
+						Essential: []setup.SliceKey{{"mypkg", "config"}},
+						Contents: map[string]setup.PathInfo{
+							"/usr/bin/foo":  {Kind: setup.CopyPath},
+							"/usr/bin/bar":  {Kind: setup.CopyPath, Mode: 0755},
+							"/usr/bin/link": {Kind: setup.SymlinkPath, Info: "foo"},
+						},
+						Scripts: setup.SliceScripts{Mutate: "content.list('/')"},
+					},
+					"config": {
+						Package: "mypkg",
+						Name:    "config",
+						Contents: map[string]setup.PathInfo{
+							"/etc/foo.conf": {Kind: setup.TextPath, Info: "data1", Mutable: true},
+						},
+					},
+					"docs": {Package: "mypkg", Name: "docs"},
+				},
+			},
+		},
+	}
+	newRelease := &setup.Release{
+		Packages: map[string]*setup.Package{
+			"mypkg": {
+				Name: "mypkg",
+				Slices: map[string]*setup.Slice{
+					"bins": {
+						Package:   "mypkg",
+						Name:      "bins",
+						Essential: []setup.SliceKey{{"mypkg", "config"}, {"libfoo", "libs"}},
+						Contents: map[string]setup.PathInfo{
+							"/usr/bin/foo":  {Kind: setup.CopyPath},
+							"/usr/bin/bar":  {Kind: setup.CopyPath, Mode: 0750},
+							"/usr/bin/baz*": {Kind: setup.GlobPath, Arch: []string{"amd64"}},
+						},
+					},
+					"config": {
+						Package: "mypkg",
+						Name:    "config",
+						Contents: map[string]setup.PathInfo{
+							"/etc/foo.conf": {Kind: setup.TextPath, Info: "data2", Mutable: true},
+						},
+					},
+				},
+			},
+			"libfoo": {
+				Name: "libfoo",
+				Slices: map[string]*setup.Slice{
+					"libs": {Package: "libfoo", Name: "libs"},
+				},
+			},
+		},
+	}
+
+	err := chisel.DiffReleases(oldRelease, newRelease)
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, ""+
+		"Change   Slice         Entry          Old                    New                    Impact\n"+
+		"added    libfoo_libs   -              -                      slice                  -\n"+
+		"changed  mypkg_bins    /usr/bin/bar   copy 0755              copy 0750              yes\n"+
+		"added    mypkg_bins    /usr/bin/baz*  -                      glob arch:amd64        -\n"+
+		"removed  mypkg_bins    /usr/bin/link  symlink foo            -                      yes\n"+
+		"added    mypkg_bins    essential      -                      libfoo_libs            yes\n"+
+		"removed  mypkg_bins    mutate         script                 -                      yes\n"+
+		"changed  mypkg_config  /etc/foo.conf  text 5b41362b mutable  text d98cf53e mutable  yes\n"+
+		"removed  mypkg_docs    -              slice                  -                      yes\n")
+
+	s.ResetStdStreams()
+	err = chisel.DiffReleases(oldRelease, oldRelease)
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, "Change  Slice  Entry  Old  New  Impact\n")
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/fsutil"
+	"github.com/canonical/chisel/internal/progress"
+)
+
+var shortDiffRootHelp = "Compare two root filesystems"
+var longDiffRootHelp = `
+The diff-root command compares the content of two root filesystems, such
+as those created by the cut command, and lists the paths which were
+added, removed or changed from the old one to the new one, along with
+their type, permissions and content hash.
+
+Each root may be either a directory or a tarball, optionally compressed
+with gzip. Manifests are not consulted, so any two trees may be compared.
+`
+
+type cmdDiffRoot struct {
+	Positional struct {
+		OldRoot string `positional-arg-name:"<old root>" required:"yes"`
+		NewRoot string `positional-arg-name:"<new root>" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func init() {
+	addCommand("diff-root", shortDiffRootHelp, longDiffRootHelp, func() flags.Commander { return &cmdDiffRoot{} }, nil, nil)
+}
+
+func (cmd *cmdDiffRoot) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	oldEntries, err := readRoot(cmd.Positional.OldRoot)
+	if err != nil {
+		return err
+	}
+	newEntries, err := readRoot(cmd.Positional.NewRoot)
+	if err != nil {
+		return err
+	}
+
+	changes := diffRoots(oldEntries, newEntries)
+	if len(changes) == 0 {
+		fmt.Fprintf(Stderr, "No differences found\n")
+		return nil
+	}
+
+	w := tabWriter()
+	fmt.Fprintf(w, "Change\tPath\tOld\tNew\n")
+	for _, change := range changes {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", change.Kind, change.Path, describeEntry(change.Old), describeEntry(change.New))
+	}
+	return w.Flush()
+}
+
+// readRoot returns the entries in the root filesystem at path, which is
+// either a directory or a tarball.
+func readRoot(path string) (map[string]*fsutil.Entry, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read root: %w", err)
+	}
+	if info.IsDir() {
+		entries, err := fsutil.ReadTree(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read root: %w", err)
+		}
+		return entries, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read root: %w", err)
+	}
+	defer f.Close()
+	entries, err := fsutil.ReadTarTree(f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read root %s: %w", path, err)
+	}
+	return entries, nil
+}
+
+type rootChange struct {
+	Kind string
+	Path string
+	Old  *fsutil.Entry
+	New  *fsutil.Entry
+}
+
+// diffRoots returns the paths which differ between the old and new entries,
+// sorted by path. Paths are reported as "added", "removed" or "changed",
+// where changes take into account the type, permissions, content and link
+// target of entries, but not their modification times or ownership.
+func diffRoots(oldEntries, newEntries map[string]*fsutil.Entry) []rootChange {
+	var changes []rootChange
+	for path, oldEntry := range oldEntries {
+		newEntry, ok := newEntries[path]
+		switch {
+		case !ok:
+			changes = append(changes, rootChange{Kind: "removed", Path: path, Old: oldEntry})
+		case oldEntry.Mode != newEntry.Mode || oldEntry.Hash != newEntry.Hash || oldEntry.Link != newEntry.Link:
+			changes = append(changes, rootChange{Kind: "changed", Path: path, Old: oldEntry, New: newEntry})
+		}
+	}
+	for path, newEntry := range newEntries {
+		if _, ok := oldEntries[path]; !ok {
+			changes = append(changes, rootChange{Kind: "added", Path: path, New: newEntry})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
+
+// describeEntry returns a short description of entry, or "-" if it is nil.
+func describeEntry(entry *fsutil.Entry) string {
+	if entry == nil {
+		return "-"
+	}
+	perm := entry.Mode.Perm()
+	if entry.Mode&fs.ModeSetuid != 0 {
+		perm |= 04000
+	}
+	if entry.Mode&fs.ModeSetgid != 0 {
+		perm |= 02000
+	}
+	if entry.Mode&fs.ModeSticky != 0 {
+		perm |= 01000
+	}
+	switch entry.Mode.Type() {
+	case fs.ModeDir:
+		return fmt.Sprintf("dir %#o", perm)
+	case fs.ModeSymlink:
+		return fmt.Sprintf("symlink %s", entry.Link)
+	case 0:
+		return fmt.Sprintf("file %#o %s %s", perm, entry.Hash[:8], progress.FormatSize(int64(entry.Size)))
+	case fs.ModeNamedPipe:
+		return fmt.Sprintf("fifo %#o", perm)
+	case fs.ModeDevice, fs.ModeDevice | fs.ModeCharDevice:
+		return fmt.Sprintf("device %#o", perm)
+	default:
+		return fmt.Sprintf("other %#o", perm)
+	}
+}
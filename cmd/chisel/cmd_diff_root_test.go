@@ -0,0 +1,58 @@
+package main_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+func (s *ChiselSuite) TestDiffRoot(c *C) {
+	oldRoot := c.MkDir()
+	c.Assert(os.MkdirAll(filepath.Join(oldRoot, "usr/bin"), 0755), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(oldRoot, "usr/bin/foo"), []byte("data1"), 0755), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(oldRoot, "usr/bin/same"), []byte("same"), 0644), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(oldRoot, "usr/bin/mode"), []byte("same"), 0644), IsNil)
+	c.Assert(os.Symlink("foo", filepath.Join(oldRoot, "usr/bin/link")), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(oldRoot, "removed"), nil, 0644), IsNil)
+
+	newTarball := filepath.Join(c.MkDir(), "new.tar")
+	err := os.WriteFile(newTarball, testutil.MustMakeTar([]testutil.TarEntry{
+		testutil.Dir(0755, "./"),
+		testutil.Dir(0755, "./usr/"),
+		testutil.Dir(0755, "./usr/bin/"),
+		testutil.Reg(0755, "./usr/bin/foo", "data2"),
+		testutil.Reg(0644, "./usr/bin/same", "same"),
+		testutil.Reg(0755, "./usr/bin/mode", "same"),
+		testutil.Lnk(0777, "./usr/bin/link", "bar"),
+		testutil.Dir(0755, "./added/"),
+	}), 0644)
+	c.Assert(err, IsNil)
+
+	defer fakeArgs("chisel", "diff-root", oldRoot, newTarball)()
+	err = chisel.RunMain()
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, ""+
+		"Change   Path           Old                    New\n"+
+		"added    /added/        -                      dir 0755\n"+
+		"removed  /removed       file 0644 e3b0c442 0B  -\n"+
+		"changed  /usr/bin/foo   file 0755 5b41362b 5B  file 0755 d98cf53e 5B\n"+
+		"changed  /usr/bin/link  symlink foo            symlink bar\n"+
+		"changed  /usr/bin/mode  file 0644 0967115f 4B  file 0755 0967115f 4B\n")
+
+	s.ResetStdStreams()
+	defer fakeArgs("chisel", "diff-root", oldRoot, oldRoot)()
+	err = chisel.RunMain()
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, "")
+	c.Assert(s.Stderr(), Equals, "No differences found\n")
+}
+
+func (s *ChiselSuite) TestDiffRootMissing(c *C) {
+	defer fakeArgs("chisel", "diff-root", c.MkDir(), "/non-existent")()
+	err := chisel.RunMain()
+	c.Assert(err, ErrorMatches, `cannot read root: stat /non-existent: no such file or directory`)
+}
@@ -15,10 +15,12 @@ import (
 var shortFindHelp = "Find existing slices"
 var longFindHelp = `
 The find command queries the slice definitions for matching slices.
-Globs (* and ?) are allowed in the query.
+Globs (* and ?) are allowed in the query. Slices are listed with their
+summary, and deprecated ones with the reason for their deprecation.
 
 By default it fetches the slices for the same Ubuntu version as the
-current host, unless the --release flag is used.
+current host, unless the --release flag or the CHISEL_RELEASE
+environment variable are used.
 `
 
 var findDescs = map[string]string{
@@ -42,7 +44,7 @@ func (cmd *cmdFind) Execute(args []string) error {
 		return ErrExtraArgs
 	}
 
-	release, err := obtainRelease(cmd.Release)
+	release, err := obtainRelease(optionOrEnv(cmd.Release, releaseEnv))
 	if err != nil {
 		return err
 	}
@@ -59,7 +61,13 @@ func (cmd *cmdFind) Execute(args []string) error {
 	w := tabWriter()
 	fmt.Fprintf(w, "Slice\tSummary\n")
 	for _, s := range slices {
-		fmt.Fprintf(w, "%s\t%s\n", s, "-")
+		summary := orDash(s.Summary)
+		if s.Deprecated != "" && s.Summary == "" {
+			summary = "Deprecated: " + s.Deprecated
+		} else if s.Deprecated != "" {
+			summary += " (deprecated: " + s.Deprecated + ")"
+		}
+		fmt.Fprintf(w, "%s\t%s\n", s, summary)
 	}
 	w.Flush()
 
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/setup"
+)
+
+var shortFmtHelp = "Format slice definition files"
+var longFmtHelp = `
+The fmt command rewrites slice definition files in canonical form, so
+that changes to release repositories are kept to a minimum: the fields
+of packages, slices and paths are written in a fixed order, the paths in
+the contents of slices are sorted, and everything is indented with two
+spaces. Comments and the order of slices are preserved, and the files
+are only rewritten when they define the same slices once formatted.
+
+Directories, such as the slices directory of a release, are searched for
+slice definition files. With --check, files are not modified, but the
+ones which are not formatted are listed and the command fails.
+`
+
+var fmtDescs = map[string]string{
+	"check": "List files which are not formatted instead of rewriting them",
+}
+
+type cmdFmt struct {
+	Check bool `long:"check"`
+
+	Positional struct {
+		Paths []string `positional-arg-name:"<path>" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func init() {
+	addCommand("fmt", shortFmtHelp, longFmtHelp, func() flags.Commander { return &cmdFmt{} }, fmtDescs, nil)
+}
+
+func (cmd *cmdFmt) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	files, err := sliceDefinitionFiles(cmd.Positional.Paths)
+	if err != nil {
+		return err
+	}
+	var unformatted []string
+	for _, file := range files {
+		changed, err := formatFile(file, cmd.Check)
+		if err != nil {
+			return err
+		}
+		if changed {
+			unformatted = append(unformatted, file)
+		}
+	}
+	if cmd.Check && len(unformatted) > 0 {
+		for _, file := range unformatted {
+			fmt.Fprintf(Stdout, "%s\n", file)
+		}
+		return fmt.Errorf("%d file(s) not formatted", len(unformatted))
+	}
+	return nil
+}
+
+// sliceDefinitionFiles returns the provided files together with the YAML
+// files found in the provided directories.
+func sliceDefinitionFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && strings.HasSuffix(d.Name(), ".yaml") {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// formatFile formats the slice definition file at path, rewriting it unless
+// check is set, and returns whether it was not formatted.
+func formatFile(path string, check bool) (changed bool, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	formatted, err := setup.FormatSliceDefinition(path, data)
+	if err != nil {
+		return false, err
+	}
+	if bytes.Equal(data, formatted) {
+		return false, nil
+	}
+	if !check {
+		info, err := os.Stat(path)
+		if err != nil {
+			return false, err
+		}
+		err = os.WriteFile(path, formatted, info.Mode().Perm())
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
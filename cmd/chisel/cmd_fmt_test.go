@@ -0,0 +1,81 @@
+package main_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+)
+
+const unformattedSlices = `package: mypkg
+slices:
+    bins:
+        contents:
+            /usr/bin/foo:
+            /usr/bin/bar:
+        essential:
+            - mypkg_config
+    config:
+        contents:
+            /etc/foo.conf:
+`
+
+const formattedSlices = `package: mypkg
+slices:
+  bins:
+    essential:
+      - mypkg_config
+    contents:
+      /usr/bin/bar:
+      /usr/bin/foo:
+  config:
+    contents:
+      /etc/foo.conf:
+`
+
+func (s *ChiselSuite) TestFmt(c *C) {
+	dir := c.MkDir()
+	unformatted := filepath.Join(dir, "slices", "mypkg.yaml")
+	formatted := filepath.Join(dir, "slices", "sub", "otherpkg.yaml")
+	c.Assert(os.MkdirAll(filepath.Dir(formatted), 0755), IsNil)
+	c.Assert(os.WriteFile(unformatted, []byte(unformattedSlices), 0644), IsNil)
+	otherSlices := []byte("package: otherpkg\nslices:\n  libs:\n    contents:\n      /usr/lib/libfoo.so:\n")
+	c.Assert(os.WriteFile(formatted, otherSlices, 0644), IsNil)
+
+	restore := fakeArgs("chisel", "fmt", "--check", filepath.Join(dir, "slices"))
+	err := chisel.RunMain()
+	restore()
+	c.Assert(err, ErrorMatches, `1 file\(s\) not formatted`)
+	c.Assert(s.Stdout(), Equals, unformatted+"\n")
+	data, err := os.ReadFile(unformatted)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, unformattedSlices)
+
+	s.ResetStdStreams()
+	restore = fakeArgs("chisel", "fmt", filepath.Join(dir, "slices"))
+	err = chisel.RunMain()
+	restore()
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, "")
+	data, err = os.ReadFile(unformatted)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, formattedSlices)
+	data, err = os.ReadFile(formatted)
+	c.Assert(err, IsNil)
+	c.Assert(data, DeepEquals, otherSlices)
+
+	restore = fakeArgs("chisel", "fmt", "--check", unformatted)
+	err = chisel.RunMain()
+	restore()
+	c.Assert(err, IsNil)
+}
+
+func (s *ChiselSuite) TestFmtInvalid(c *C) {
+	path := filepath.Join(c.MkDir(), "mypkg.yaml")
+	c.Assert(os.WriteFile(path, []byte("package: otherpkg\n"), 0644), IsNil)
+	defer fakeArgs("chisel", "fmt", path)()
+	err := chisel.RunMain()
+	c.Assert(err, ErrorMatches, `.*/mypkg.yaml: filename and 'package' field \("otherpkg"\) disagree`)
+}
@@ -155,11 +155,11 @@ type helpCategory struct {
 var helpCategories = []helpCategory{{
 	Label:       "Basic",
 	Description: "general operations",
-	Commands:    []string{"find", "help", "version"},
+	Commands:    []string{"analyze", "arch-preview", "config", "diff-release", "diff-root", "find", "fmt", "help", "licenses", "manifest", "schema", "search", "sizes", "version", "which"},
 }, {
 	Label:       "Action",
 	Description: "make things happen",
-	Commands:    []string{"cut"},
+	Commands:    []string{"cut", "rpc", "serve", "verify-cache"},
 }}
 
 var (
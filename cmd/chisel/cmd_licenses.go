@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/control"
+)
+
+var shortLicensesHelp = "Summarize the licenses of a root filesystem"
+var longLicensesHelp = `
+The licenses command collects the copyright files installed under
+/usr/share/doc/ in the root filesystem, such as one created by the cut
+command, and lists the licenses of every package found there.
+
+Licenses are only known for copyright files in the machine-readable
+format, and are shown as "-" otherwise. Use --verbose to see the files
+that could not be interpreted.
+
+The --root flag may be replaced by the CHISEL_ROOT environment variable.
+`
+
+var licensesDescs = map[string]string{
+	"root": "Root filesystem to inspect",
+}
+
+type cmdLicenses struct {
+	RootDir string `long:"root" value-name:"<dir>"`
+}
+
+func init() {
+	addCommand("licenses", shortLicensesHelp, longLicensesHelp, func() flags.Commander { return &cmdLicenses{} }, licensesDescs, nil)
+}
+
+func (cmd *cmdLicenses) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	rootDir := optionOrEnv(cmd.RootDir, rootEnv)
+	if rootDir == "" {
+		return &usageError{fmt.Errorf("the required flag `--root' was not specified")}
+	}
+
+	licenses, err := readLicenses(rootDir)
+	if err != nil {
+		return err
+	}
+	if len(licenses) == 0 {
+		fmt.Fprintf(Stderr, "No copyright files found in %s\n", filepath.Join(rootDir, copyrightDir))
+		return nil
+	}
+
+	packages := make([]string, 0, len(licenses))
+	for pkg := range licenses {
+		packages = append(packages, pkg)
+	}
+	sort.Strings(packages)
+
+	w := tabWriter()
+	fmt.Fprintf(w, "Package\tLicenses\n")
+	for _, pkg := range packages {
+		fmt.Fprintf(w, "%s\t%s\n", pkg, orDash(strings.Join(licenses[pkg], ", ")))
+	}
+	return w.Flush()
+}
+
+// copyrightDir is the directory holding the copyright file of every package,
+// relative to the root filesystem.
+const copyrightDir = "/usr/share/doc/"
+
+// readLicenses returns the licenses of the packages with a copyright file in
+// the root filesystem at rootDir, indexed by package name. Packages whose
+// copyright file is not machine-readable are present with no licenses.
+func readLicenses(rootDir string) (map[string][]string, error) {
+	docDir := filepath.Join(rootDir, copyrightDir)
+	dirEntries, err := os.ReadDir(docDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read copyright files: %w", err)
+	}
+	licenses := make(map[string][]string)
+	for _, dirEntry := range dirEntries {
+		// Symlinks are not followed, as they may point outside the root.
+		if !dirEntry.IsDir() {
+			continue
+		}
+		pkg := dirEntry.Name()
+		path := filepath.Join(docDir, pkg, "copyright")
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) || err == nil && !info.Mode().IsRegular() {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot read copyright files: %w", err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read copyright files: %w", err)
+		}
+		pkgLicenses, ok := parseCopyright(string(data))
+		if !ok {
+			debugf("Copyright file of %s is not machine-readable", pkg)
+		}
+		licenses[pkg] = pkgLicenses
+	}
+	return licenses, nil
+}
+
+// parseCopyright returns the licenses listed in content, sorted and without
+// duplicates, if content follows the machine-readable copyright format.
+// Only the short names of licenses are returned, as found in the first line
+// of the License field of paragraphs with a Files field.
+//
+// See https://www.debian.org/doc/packaging-manuals/copyright-format/1.0/
+func parseCopyright(content string) (licenses []string, ok bool) {
+	sections := control.ParseSections(content)
+	if len(sections) == 0 {
+		return nil, false
+	}
+	// Early versions of the format were known as DEP-5.
+	format := sections[0].Get("Format") + sections[0].Get("Format-Specification")
+	if !strings.Contains(format, "copyright-format") && !strings.Contains(format, "dep5") {
+		return nil, false
+	}
+	seen := make(map[string]bool)
+	for _, section := range sections[1:] {
+		if section.Get("Files") == "" {
+			continue
+		}
+		license, _, _ := strings.Cut(section.Get("License"), "\n")
+		license = strings.TrimSpace(license)
+		if license != "" && !seen[license] {
+			seen[license] = true
+			licenses = append(licenses, license)
+		}
+	}
+	sort.Strings(licenses)
+	return licenses, true
+}
@@ -0,0 +1,76 @@
+package main_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+)
+
+var machineReadableCopyright = `Format: https://www.debian.org/doc/packaging-manuals/copyright-format/1.0/
+Upstream-Name: foo
+Source: https://example.com/foo
+
+Files: *
+Copyright: 2020 Foo Authors
+License: GPL-2+
+
+Files: debian/*
+Copyright: 2021 Foo Maintainers
+License: GPL-2+
+
+Files: lib/*
+Copyright: 2019 Bar Authors
+License: MIT
+ Permission is hereby granted...
+
+License: GPL-2+
+ This program is free software...
+`
+
+var dep5Copyright = `Format: http://dep.debian.net/deps/dep5
+
+Files: *
+Copyright: 2010 Old Authors
+License: BSD-3-clause
+`
+
+var freeFormCopyright = `This package was debianized by Someone.
+
+It is licensed under the GPL.
+`
+
+func (s *ChiselSuite) TestLicenses(c *C) {
+	root := c.MkDir()
+	writeCopyright := func(pkg, content string) {
+		dir := filepath.Join(root, "usr/share/doc", pkg)
+		c.Assert(os.MkdirAll(dir, 0755), IsNil)
+		c.Assert(os.WriteFile(filepath.Join(dir, "copyright"), []byte(content), 0644), IsNil)
+	}
+	writeCopyright("foo", machineReadableCopyright)
+	writeCopyright("old", dep5Copyright)
+	writeCopyright("plain", freeFormCopyright)
+	// Directories without a copyright file and symlinks are ignored.
+	c.Assert(os.MkdirAll(filepath.Join(root, "usr/share/doc/empty"), 0755), IsNil)
+	c.Assert(os.Symlink("foo", filepath.Join(root, "usr/share/doc/link")), IsNil)
+
+	defer fakeArgs("chisel", "licenses", "--root", root)()
+	err := chisel.RunMain()
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, ""+
+		"Package  Licenses\n"+
+		"foo      GPL-2+, MIT\n"+
+		"old      BSD-3-clause\n"+
+		"plain    -\n")
+}
+
+func (s *ChiselSuite) TestLicensesNoCopyright(c *C) {
+	defer fakeEnv("CHISEL_ROOT", c.MkDir())()
+	defer fakeArgs("chisel", "licenses")()
+	err := chisel.RunMain()
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, "")
+	c.Assert(s.Stderr(), Matches, "No copyright files found in .*/usr/share/doc\n")
+}
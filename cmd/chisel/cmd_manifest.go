@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/manifest"
+	"github.com/canonical/chisel/internal/setup"
+)
+
+type cmdManifest struct{}
+
+var shortManifestHelp = "Work with the manifests of root filesystems"
+var longManifestHelp = `
+The manifest command contains sub-commands working on the manifests
+written by the cut command.
+`
+
+var shortManifestExportHelp = "Export a manifest in another format"
+var longManifestExportHelp = `
+The export command converts the manifest of a root filesystem created by
+the cut command into another format, and writes it to standard output.
+
+The "dpkg-status" format produces a document compatible with the
+/var/lib/dpkg/status file, listing every package in the manifest as
+installed, so that tools such as vulnerability scanners may inspect root
+filesystems cut without it. Packages are enriched with the control data
+found for the same version in the archives of the release, such as their
+source package and dependencies. Packages whose version is no longer in
+the archives only list the data recorded in the manifest.
+
+The manifest is read from /var/lib/chisel/manifest.wall by default, as
+written by the --with-manifest flag of the cut command. Use --manifest
+to read it from another location within the root filesystem.
+
+By default it uses the release for the same Ubuntu version as the
+current host, unless the --release flag or the CHISEL_RELEASE
+environment variable are used. The architecture defaults to the one of
+the packages in the manifest, and may also be set via the CHISEL_ARCH
+environment variable. The --root flag may be replaced by the CHISEL_ROOT
+environment variable.
+`
+
+var manifestExportDescs = map[string]string{
+	"root":     "Root filesystem to inspect",
+	"manifest": "Location of the manifest within the root filesystem",
+	"format":   "Format of the exported document (dpkg-status)",
+	"release":  "Chisel release name or directory (e.g. ubuntu-22.04)",
+	"arch":     "Package architecture",
+}
+
+type cmdManifestExport struct {
+	RootDir  string `long:"root" value-name:"<dir>"`
+	Manifest string `long:"manifest" value-name:"<path>"`
+	Format   string `long:"format" value-name:"<format>"`
+	Release  string `long:"release" value-name:"<branch|dir>"`
+	Arch     string `long:"arch" value-name:"<arch>"`
+}
+
+func init() {
+	addManifestCommand("export", shortManifestExportHelp, longManifestExportHelp, func() flags.Commander { return &cmdManifestExport{} }, manifestExportDescs, nil)
+}
+
+func (cmd *cmdManifestExport) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	if cmd.Format != "dpkg-status" {
+		return &usageError{fmt.Errorf("invalid export format %q, must be \"dpkg-status\"", cmd.Format)}
+	}
+	rootDir := optionOrEnv(cmd.RootDir, rootEnv)
+	if rootDir == "" {
+		return &usageError{fmt.Errorf("the required flag `--root' was not specified")}
+	}
+
+	mfest, err := readRootManifest(rootDir, cmd.Manifest)
+	if err != nil {
+		return err
+	}
+	arch := optionOrEnv(cmd.Arch, archEnv)
+	if arch == "" {
+		arch, err = manifestArch(mfest)
+		if err != nil {
+			return err
+		}
+	}
+
+	release, err := obtainRelease(optionOrEnv(cmd.Release, releaseEnv))
+	if err != nil {
+		return err
+	}
+	archives, err := openArchives(release, arch, nil, nil)
+	if err != nil {
+		return err
+	}
+	return writeDpkgStatus(Stdout, mfest, release, archives)
+}
+
+// manifestArch returns the architecture of the packages in mfest, or an
+// empty string if all of them are architecture independent.
+func manifestArch(mfest *manifest.Manifest) (string, error) {
+	var arch string
+	err := mfest.IteratePackages(func(pkg *manifest.Package) error {
+		if arch == "" && pkg.Arch != "all" {
+			arch = pkg.Arch
+		}
+		return nil
+	})
+	return arch, err
+}
+
+// dpkgStatusFields are the fields written for every package in the
+// dpkg-status format, in the order used by dpkg itself.
+var dpkgStatusFields = []string{
+	"Package",
+	"Essential",
+	"Status",
+	"Priority",
+	"Section",
+	"Installed-Size",
+	"Maintainer",
+	"Architecture",
+	"Multi-Arch",
+	"Source",
+	"Version",
+	"Replaces",
+	"Provides",
+	"Depends",
+	"Pre-Depends",
+	"Recommends",
+	"Suggests",
+	"Breaks",
+	"Conflicts",
+	"Enhances",
+	"Built-Using",
+	"Description",
+	"Homepage",
+	"Original-Maintainer",
+}
+
+// writeDpkgStatus writes an entry in the format of /var/lib/dpkg/status for
+// every package in mfest into w, enriched with the control data of the
+// same package version in archives.
+func writeDpkgStatus(w io.Writer, mfest *manifest.Manifest, release *setup.Release, archives map[string]archive.Archive) error {
+	first := true
+	return mfest.IteratePackages(func(pkg *manifest.Package) error {
+		info, err := findPackageVersion(release, archives, pkg)
+		if err != nil {
+			return err
+		}
+		if info == nil {
+			logf("Warning: cannot find package %s %s in the archives, exporting manifest data only", pkg.Name, pkg.Version)
+		}
+		var b strings.Builder
+		if !first {
+			b.WriteString("\n")
+		}
+		first = false
+		for _, field := range dpkgStatusFields {
+			var value string
+			switch field {
+			case "Package":
+				value = pkg.Name
+			case "Status":
+				value = "install ok installed"
+			case "Architecture":
+				value = pkg.Arch
+			case "Version":
+				value = pkg.Version
+			default:
+				if info != nil {
+					value = info.Get(field)
+				}
+			}
+			if value == "" {
+				continue
+			}
+			// Continuation lines are indented, with empty ones
+			// written as a single dot as documented in deb-control(5).
+			lines := strings.Split(value, "\n")
+			fmt.Fprintf(&b, "%s: %s\n", field, lines[0])
+			for _, line := range lines[1:] {
+				if line == "" {
+					line = "."
+				}
+				fmt.Fprintf(&b, " %s\n", line)
+			}
+		}
+		_, err = io.WriteString(w, b.String())
+		return err
+	})
+}
+
+// findPackageVersion returns the information on the version of pkg in the
+// manifest from the first archive that has it, trying the archive of the
+// package in the release first, or nil if no archive has that version.
+func findPackageVersion(release *setup.Release, archives map[string]archive.Archive, pkg *manifest.Package) (*archive.PackageInfo, error) {
+	preferred := release.DefaultArchive
+	if relPkg, ok := release.Packages[pkg.Name]; ok && relPkg.Archive != "" {
+		preferred = relPkg.Archive
+	}
+	names := make([]string, 0, len(archives))
+	for name := range archives {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if names[i] == preferred || names[j] == preferred {
+			return names[i] == preferred
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names {
+		pkgArchive := archives[name]
+		if !pkgArchive.Exists(pkg.Name) {
+			continue
+		}
+		info, err := archive.Info(pkgArchive, pkg.Name)
+		if err != nil {
+			return nil, err
+		}
+		if info.Version == pkg.Version {
+			return info, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,137 @@
+package main_test
+
+import (
+	"bytes"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/control"
+	"github.com/canonical/chisel/internal/manifest"
+	"github.com/canonical/chisel/internal/setup"
+)
+
+func fakePackageInfo(c *C, fields string) *archive.PackageInfo {
+	file, err := control.ParseString("Package", fields)
+	c.Assert(err, IsNil)
+	keys := file.Keys()
+	c.Assert(keys, HasLen, 1)
+	info, err := archive.NewPackageInfo(file.Section(keys[0]))
+	c.Assert(err, IsNil)
+	return info
+}
+
+func (s *ChiselSuite) TestWriteDpkgStatus(c *C) {
+	mw := manifest.NewWriter()
+	c.Assert(mw.AddPackage(&manifest.Package{Name: "hello", Version: "2.10-2", Arch: "amd64"}), IsNil)
+	c.Assert(mw.AddPackage(&manifest.Package{Name: "base-files", Version: "12ubuntu4", Arch: "amd64"}), IsNil)
+	c.Assert(mw.AddPackage(&manifest.Package{Name: "tzdata", Version: "2024a-0ubuntu1", Arch: "all"}), IsNil)
+	var buf bytes.Buffer
+	c.Assert(mw.Write(&buf), IsNil)
+	mfest, err := manifest.Read(&buf)
+	c.Assert(err, IsNil)
+
+	release := &setup.Release{
+		DefaultArchive: "ubuntu",
+		Packages: map[string]*setup.Package{
+			"hello": {Name: "hello", Archive: "backports"},
+		},
+	}
+	archives := map[string]archive.Archive{
+		"ubuntu": &fakeArchive{name: "ubuntu", infos: map[string]*archive.PackageInfo{
+			// Only the version in the manifest is used.
+			"hello": fakePackageInfo(c, "Package: hello\nVersion: 2.10-3\nArchitecture: amd64\nSource: hello-old\n"),
+			"base-files": fakePackageInfo(c, strings.Join([]string{
+				"Package: base-files",
+				"Essential: yes",
+				"Priority: required",
+				"Section: admin",
+				"Installed-Size: 394",
+				"Maintainer: Ubuntu Developers <ubuntu-devel-discuss@lists.ubuntu.com>",
+				"Architecture: amd64",
+				"Version: 12ubuntu4",
+				"Replaces: base, dpkg (<= 1.15.0)",
+				"Depends: libcrypt1",
+				"Pre-Depends: awk",
+				"Filename: pool/main/b/base-files/base-files_12ubuntu4_amd64.deb",
+				"Size: 63018",
+				"SHA256: 0123",
+				"Description: Debian base system miscellaneous files",
+				" This package contains the basic filesystem hierarchy.",
+				" .",
+				" It also contains some files.",
+				"Task: minimal",
+			}, "\n")+"\n"),
+		}},
+		"backports": &fakeArchive{name: "backports", infos: map[string]*archive.PackageInfo{
+			"hello": fakePackageInfo(c, "Package: hello\nVersion: 2.10-2\nArchitecture: amd64\nSource: hello\nDepends: libc6 (>= 2.34)\nDescription: example package based on GNU hello\nHomepage: https://www.gnu.org/software/hello/\n"),
+		}},
+	}
+
+	var out bytes.Buffer
+	err = chisel.WriteDpkgStatus(&out, mfest, release, archives)
+	c.Assert(err, IsNil)
+	c.Assert(out.String(), Equals, ""+
+		"Package: base-files\n"+
+		"Essential: yes\n"+
+		"Status: install ok installed\n"+
+		"Priority: required\n"+
+		"Section: admin\n"+
+		"Installed-Size: 394\n"+
+		"Maintainer: Ubuntu Developers <ubuntu-devel-discuss@lists.ubuntu.com>\n"+
+		"Architecture: amd64\n"+
+		"Version: 12ubuntu4\n"+
+		"Replaces: base, dpkg (<= 1.15.0)\n"+
+		"Depends: libcrypt1\n"+
+		"Pre-Depends: awk\n"+
+		"Description: Debian base system miscellaneous files\n"+
+		" This package contains the basic filesystem hierarchy.\n"+
+		" .\n"+
+		" It also contains some files.\n"+
+		"\n"+
+		"Package: hello\n"+
+		"Status: install ok installed\n"+
+		"Architecture: amd64\n"+
+		"Source: hello\n"+
+		"Version: 2.10-2\n"+
+		"Depends: libc6 (>= 2.34)\n"+
+		"Description: example package based on GNU hello\n"+
+		"Homepage: https://www.gnu.org/software/hello/\n"+
+		"\n"+
+		"Package: tzdata\n"+
+		"Status: install ok installed\n"+
+		"Architecture: all\n"+
+		"Version: 2024a-0ubuntu1\n")
+}
+
+var manifestExportErrorTests = []struct {
+	summary string
+	args    []string
+	error   string
+}{{
+	summary: "Missing format",
+	args:    []string{"--root", "/"},
+	error:   `invalid export format "", must be "dpkg-status"`,
+}, {
+	summary: "Unknown format",
+	args:    []string{"--root", "/", "--format", "spdx"},
+	error:   `invalid export format "spdx", must be "dpkg-status"`,
+}, {
+	summary: "Missing root",
+	args:    []string{"--format", "dpkg-status"},
+	error:   "the required flag `--root' was not specified",
+}}
+
+func (s *ChiselSuite) TestManifestExportErrors(c *C) {
+	for _, test := range manifestExportErrorTests {
+		c.Logf("Summary: %s", test.summary)
+		restore := fakeEnv("CHISEL_ROOT", "")
+		restoreArgs := fakeArgs(append([]string{"chisel", "manifest", "export"}, test.args...)...)
+		err := chisel.RunMain()
+		restoreArgs()
+		restore()
+		c.Assert(err, ErrorMatches, test.error)
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/progress"
+	"github.com/canonical/chisel/internal/setup"
+)
+
+var shortRPCHelp = "Drive chisel through JSON messages over stdio"
+var longRPCHelp = `
+The rpc command reads requests from the standard input and writes the
+progress and results of each of them to the standard output, as JSON
+documents one per line, so that other tools may drive chisel without
+parsing the output meant for humans. Requests are handled one at a time,
+in the order received, sharing the releases read and the download cache,
+until the standard input is closed. Logs are still written to the
+standard error.
+
+Every request carries an id, which is repeated in the messages about it:
+
+    {"id": ..., "method": "release", "params": {"release": ...}}
+    {"id": ..., "method": "resolve", "params": {"release": ..., "slices": [...]}}
+    {"id": ..., "method": "cut", "params": {"release": ..., "arch": ...,
+                                            "slices": [...], "root": ...}}
+
+The methods list the archives, packages, slices and sets of a release,
+list the slices selected including their essential slices, and cut the
+slices into root, respectively. Requests not selecting a release use the
+one provided via --release, or the release for the same Ubuntu version
+as the host.
+
+While a request is handled, its progress is reported as:
+
+    {"id": ..., "progress": {"label": ..., "total": ..., "current": ..., "done": ...}}
+
+and the request is completed by one of:
+
+    {"id": ..., "result": {...}}
+    {"id": ..., "error": {"kind": ..., "message": ..., "exit-code": ...}}
+
+where the error is in the same format used by --errors=json.
+`
+
+var rpcDescs = map[string]string{
+	"release": "Chisel release name or directory used by default (e.g. ubuntu-22.04)",
+}
+
+type cmdRPC struct {
+	Release string `long:"release" value-name:"<dir>"`
+}
+
+func init() {
+	addCommand("rpc", shortRPCHelp, longRPCHelp, func() flags.Commander { return &cmdRPC{} }, rpcDescs, nil)
+}
+
+func (cmd *cmdRPC) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	server := newServer(optionOrEnv(cmd.Release, releaseEnv), obtainRelease)
+	return serveRPC(server, Stdin, Stdout)
+}
+
+// rpcRequest is a request read by the rpc command.
+type rpcRequest struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params serveRequest    `json:"params"`
+}
+
+// rpcMessage is a message written by the rpc command about a request.
+type rpcMessage struct {
+	ID       json.RawMessage `json:"id"`
+	Progress *rpcProgress    `json:"progress,omitempty"`
+	Result   any             `json:"result,omitempty"`
+	Error    *jsonError      `json:"error,omitempty"`
+}
+
+type rpcProgress struct {
+	Label   string `json:"label"`
+	Total   int64  `json:"total"`
+	Current int64  `json:"current"`
+	Done    bool   `json:"done"`
+}
+
+// maxRPCRequestSize limits the size of every line read by the rpc command.
+const maxRPCRequestSize = 1 << 20
+
+// serveRPC handles the requests read from r one at a time, writing the
+// messages about them to w, until r is exhausted.
+func serveRPC(s *server, r io.Reader, w io.Writer) error {
+	out := &rpcWriter{encoder: json.NewEncoder(w)}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxRPCRequestSize)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var req rpcRequest
+		err := json.Unmarshal(line, &req)
+		if err != nil {
+			err = out.write(&rpcMessage{Error: newJSONError(&usageError{fmt.Errorf("cannot decode request: %w", err)})})
+		} else {
+			err = s.handleRPC(&req, out)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("cannot read request: %w", err)
+	}
+	return nil
+}
+
+// handleRPC handles req, writing its progress and outcome to out. Only
+// the errors writing messages are returned, ending the session.
+func (s *server) handleRPC(req *rpcRequest, out *rpcWriter) error {
+	var result any
+	var err error
+	switch req.Method {
+	case "release":
+		var release *setup.Release
+		release, err = s.release(req.Params.Release)
+		if err == nil {
+			result = releaseInfo(release)
+		}
+	case "resolve":
+		var selection *setup.Selection
+		_, selection, err = s.selection(&req.Params)
+		if err == nil {
+			result = selectionSlices(selection)
+		}
+	case "cut":
+		result, err = s.cutRPC(req, out)
+	default:
+		err = &usageError{fmt.Errorf("unknown method %q", req.Method)}
+	}
+	if err != nil {
+		return out.write(&rpcMessage{ID: req.ID, Error: newJSONError(err)})
+	}
+	return out.write(&rpcMessage{ID: req.ID, Result: result})
+}
+
+func (s *server) cutRPC(req *rpcRequest, out *rpcWriter) (any, error) {
+	if req.Params.Root == "" {
+		return nil, &usageError{fmt.Errorf("no root provided")}
+	}
+	release, selection, err := s.selection(&req.Params)
+	if err != nil {
+		return nil, err
+	}
+	target := &cutTarget{arch: req.Params.Arch, rootDir: req.Params.Root}
+	reporter := &rpcReporter{id: req.ID, out: out}
+	cmd := &cmdCut{}
+	err = cmd.cut(release, selection, time.Time{}, target, reporter)
+	if err != nil {
+		return nil, err
+	}
+	return selectionSlices(selection), nil
+}
+
+// rpcWriter writes messages of the rpc command, one per line.
+type rpcWriter struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+func (w *rpcWriter) write(msg *rpcMessage) error {
+	if msg.ID == nil {
+		msg.ID = json.RawMessage("null")
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.encoder.Encode(msg)
+}
+
+// rpcProgressInterval is the minimum interval between progress messages
+// about the same operation.
+const rpcProgressInterval = 500 * time.Millisecond
+
+// rpcReporter is a progress.Reporter writing progress messages about the
+// request with the provided id.
+type rpcReporter struct {
+	id  json.RawMessage
+	out *rpcWriter
+}
+
+func (r *rpcReporter) Start(label string, total int64) progress.Task {
+	task := &rpcTask{reporter: r, progress: rpcProgress{Label: label, Total: total}}
+	task.send()
+	return task
+}
+
+type rpcTask struct {
+	mu       sync.Mutex
+	reporter *rpcReporter
+	progress rpcProgress
+	sent     time.Time
+}
+
+func (t *rpcTask) Add(n int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.progress.Current += n
+	if time.Since(t.sent) < rpcProgressInterval {
+		return
+	}
+	t.send()
+}
+
+func (t *rpcTask) Done() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.progress.Done {
+		return
+	}
+	t.progress.Done = true
+	t.send()
+}
+
+// send writes the current progress of t, which must be locked.
+func (t *rpcTask) send() {
+	t.sent = time.Now()
+	current := t.progress
+	err := t.reporter.out.write(&rpcMessage{ID: t.reporter.id, Progress: &current})
+	if err != nil {
+		debugf("Cannot write progress: %v", err)
+	}
+}
@@ -0,0 +1,40 @@
+package main_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/setup"
+)
+
+const rpcRequests = `
+{"id": 1, "method": "resolve", "params": {"slices": ["python3.10_libs"]}}
+{"id": "two", "method": "release", "params": {"release": "ubuntu-10.04"}}
+{"id": 3, "method": "resolve", "params": {"slices": ["python3.10"]}}
+{"id": 4, "method": "cut", "params": {"slices": ["python3.10_libs"]}}
+{"id": 5, "method": "remove"}
+not json
+`
+
+func (s *ChiselSuite) TestServeRPC(c *C) {
+	obtain := func(releaseStr string) (*setup.Release, error) {
+		if releaseStr != "sample" {
+			return nil, fmt.Errorf("release %s not found", releaseStr)
+		}
+		return sampleRelease, nil
+	}
+	var out bytes.Buffer
+	err := chisel.ServeRPC("sample", obtain, strings.NewReader(rpcRequests), &out)
+	c.Assert(err, IsNil)
+	c.Assert(out.String(), Equals, ""+
+		`{"id":1,"result":{"slices":["python3.10_libs"]}}`+"\n"+
+		`{"id":"two","error":{"kind":"error","message":"release ubuntu-10.04 not found","exit-code":1}}`+"\n"+
+		`{"id":3,"error":{"kind":"usage","message":"invalid slice reference: \"python3.10\"","exit-code":2}}`+"\n"+
+		`{"id":4,"error":{"kind":"usage","message":"no root provided","exit-code":2}}`+"\n"+
+		`{"id":5,"error":{"kind":"usage","message":"unknown method \"remove\"","exit-code":2}}`+"\n"+
+		`{"id":null,"error":{"kind":"usage","message":"cannot decode request: invalid character 'o' in literal null (expecting 'u')","exit-code":2}}`+"\n")
+}
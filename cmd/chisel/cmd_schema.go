@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/jsonschema"
+	"github.com/canonical/chisel/internal/manifest"
+	"github.com/canonical/chisel/internal/setup"
+)
+
+var shortSchemaHelp = "Show the JSON Schema of a chisel file format"
+var longSchemaHelp = `
+The schema command shows the JSON Schema describing one of the file
+formats used by chisel, so that other tools may validate those files:
+
+    release   the chisel.yaml file of releases
+    slices    the slice definition files of releases
+    manifest  the entries of the manifest written by the cut command
+
+The YAML files of releases may be validated against their schema once
+converted to JSON, as most YAML-aware editors do.
+`
+
+type cmdSchema struct {
+	Positional struct {
+		Format string `positional-arg-name:"<format>" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func init() {
+	addCommand("schema", shortSchemaHelp, longSchemaHelp, func() flags.Commander { return &cmdSchema{} }, nil, nil)
+}
+
+var schemas = map[string]func() *jsonschema.Schema{
+	"release":  setup.ReleaseSchema,
+	"slices":   setup.SliceDefinitionSchema,
+	"manifest": manifest.EntrySchema,
+}
+
+func (cmd *cmdSchema) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	schema, ok := schemas[cmd.Positional.Format]
+	if !ok {
+		return &usageError{fmt.Errorf("unknown format %q, expected release, slices or manifest", cmd.Positional.Format)}
+	}
+	data, err := json.MarshalIndent(schema(), "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(Stdout, "%s\n", data)
+	return nil
+}
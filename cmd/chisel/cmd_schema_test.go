@@ -0,0 +1,39 @@
+package main_test
+
+import (
+	"encoding/json"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+)
+
+var schemaTests = []struct {
+	format string
+	title  string
+	err    string
+}{
+	{format: "release", title: "Chisel release configuration"},
+	{format: "slices", title: "Chisel slice definition"},
+	{format: "manifest", title: "Chisel manifest entry"},
+	{format: "sdf", err: `unknown format "sdf", expected release, slices or manifest`},
+}
+
+func (s *ChiselSuite) TestSchema(c *C) {
+	for _, test := range schemaTests {
+		c.Logf("Format: %s", test.format)
+		s.ResetStdStreams()
+		restore := fakeArgs("chisel", "schema", test.format)
+		err := chisel.RunMain()
+		restore()
+		if test.err != "" {
+			c.Assert(err, ErrorMatches, test.err)
+			continue
+		}
+		c.Assert(err, IsNil)
+		var schema map[string]any
+		c.Assert(json.Unmarshal([]byte(s.Stdout()), &schema), IsNil)
+		c.Assert(schema["$schema"], Equals, "https://json-schema.org/draft/2020-12/schema")
+		c.Assert(schema["title"], Equals, test.title)
+	}
+}
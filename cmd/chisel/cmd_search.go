@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/setup"
+)
+
+var shortSearchHelp = "Search packages in the archives"
+var longSearchHelp = `
+The search command queries the package indexes of the archives used by
+the release, rather than the slice definitions, for packages whose name
+or summary contains every term of the query, ignoring case. This helps
+finding the package providing some functionality before looking for its
+slices with the find command, or writing new ones.
+
+Packages are listed with the archive providing them, the number of slices
+defined for them in the release, if any, and their summary. Slices are
+only counted for the archive they are cut from.
+
+By default it uses the release for the same Ubuntu version as the
+current host, unless the --release flag or the CHISEL_RELEASE
+environment variable are used.
+The architecture may also be set via the CHISEL_ARCH environment
+variable.
+`
+
+var searchDescs = map[string]string{
+	"release": "Chisel release name or directory (e.g. ubuntu-22.04)",
+	"arch":    "Package architecture",
+}
+
+type cmdSearch struct {
+	Release string `long:"release" value-name:"<branch|dir>"`
+	Arch    string `long:"arch" value-name:"<arch>"`
+
+	Positional struct {
+		Query []string `positional-arg-name:"<query>" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func init() {
+	addCommand("search", shortSearchHelp, longSearchHelp, func() flags.Commander { return &cmdSearch{} }, searchDescs, nil)
+}
+
+func (cmd *cmdSearch) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	release, err := obtainRelease(optionOrEnv(cmd.Release, releaseEnv))
+	if err != nil {
+		return err
+	}
+	archives, err := openArchives(release, optionOrEnv(cmd.Arch, archEnv), nil, nil)
+	if err != nil {
+		return err
+	}
+
+	matches, err := searchPackages(archives, cmd.Positional.Query)
+	if err != nil {
+		return err
+	}
+	if len(matches) == 0 {
+		fmt.Fprintf(Stderr, "No matching packages for \"%s\"\n", strings.Join(cmd.Positional.Query, " "))
+		return nil
+	}
+	return printPackageMatches(release, matches)
+}
+
+type packageMatch struct {
+	Archive string
+	Info    *archive.PackageInfo
+}
+
+// searchPackages returns the packages in archives whose name or summary
+// contains every term of query, ignoring case, sorted by name and archive.
+func searchPackages(archives map[string]archive.Archive, query []string) ([]packageMatch, error) {
+	var terms []string
+	for _, term := range query {
+		terms = append(terms, strings.Fields(strings.ToLower(term))...)
+	}
+	if len(terms) == 0 {
+		return nil, &usageError{fmt.Errorf("no search terms provided")}
+	}
+
+	var matches []packageMatch
+	for archiveName, pkgArchive := range archives {
+		for _, pkgName := range pkgArchive.Packages() {
+			info, err := archive.Info(pkgArchive, pkgName)
+			if err != nil {
+				return nil, err
+			}
+			text := strings.ToLower(pkgName + "\n" + packageSummary(info))
+			matched := true
+			for _, term := range terms {
+				if !strings.Contains(text, term) {
+					matched = false
+					break
+				}
+			}
+			if matched {
+				matches = append(matches, packageMatch{Archive: archiveName, Info: info})
+			}
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Info.Name != matches[j].Info.Name {
+			return matches[i].Info.Name < matches[j].Info.Name
+		}
+		return matches[i].Archive < matches[j].Archive
+	})
+	return matches, nil
+}
+
+// packageSummary returns the first line of the description of the package.
+func packageSummary(info *archive.PackageInfo) string {
+	summary, _, _ := strings.Cut(info.Description, "\n")
+	return summary
+}
+
+func printPackageMatches(release *setup.Release, matches []packageMatch) error {
+	w := tabWriter()
+	fmt.Fprintf(w, "Package\tArchive\tSlices\tSummary\n")
+	for _, m := range matches {
+		// Slices are only cut from the archive of their package.
+		slices := "-"
+		if pkg, ok := release.Packages[m.Info.Name]; ok && len(pkg.Slices) > 0 {
+			pkgArchive := pkg.Archive
+			if pkgArchive == "" {
+				pkgArchive = release.DefaultArchive
+			}
+			if pkgArchive == m.Archive {
+				slices = strconv.Itoa(len(pkg.Slices))
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", m.Info.Name, m.Archive, slices, orDash(packageSummary(m.Info)))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,93 @@
+package main_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/setup"
+)
+
+var searchTests = []struct {
+	summary string
+	query   []string
+	stdout  string
+	error   string
+}{{
+	summary: "Match on name",
+	query:   []string{"openssl"},
+	stdout: "" +
+		"Package  Archive    Slices  Summary\n" +
+		"openssl  backports  -       Secure Sockets Layer toolkit\n" +
+		"openssl  ubuntu     2       Secure Sockets Layer toolkit\n",
+}, {
+	summary: "Match on summary ignoring case",
+	query:   []string{"SECURE"},
+	stdout: "" +
+		"Package  Archive    Slices  Summary\n" +
+		"libssl3  ubuntu     -       Secure Sockets Layer toolkit - shared libraries\n" +
+		"openssl  backports  -       Secure Sockets Layer toolkit\n" +
+		"openssl  ubuntu     2       Secure Sockets Layer toolkit\n",
+}, {
+	summary: "Package without description",
+	query:   []string{"scanner"},
+	stdout: "" +
+		"Package     Archive  Slices  Summary\n" +
+		"sslscanner  ubuntu   -       -\n",
+}, {
+	summary: "Every term must match",
+	query:   []string{"ssl shared", "libraries"},
+	stdout: "" +
+		"Package  Archive  Slices  Summary\n" +
+		"libssl3  ubuntu   -       Secure Sockets Layer toolkit - shared libraries\n",
+}, {
+	summary: "Summary is only the first line of the description",
+	query:   []string{"cryptographic"},
+	stdout:  "",
+}, {
+	summary: "No search terms",
+	query:   []string{" "},
+	error:   `no search terms provided`,
+}}
+
+func (s *ChiselSuite) TestSearch(c *C) {
+	release := &setup.Release{
+		DefaultArchive: "ubuntu",
+		Packages: map[string]*setup.Package{
+			"openssl": {
+				Name: "openssl",
+				Slices: map[string]*setup.Slice{
+					"bins":   {Package: "openssl", Name: "bins"},
+					"config": {Package: "openssl", Name: "config"},
+				},
+			},
+		},
+	}
+	archives := map[string]archive.Archive{
+		"ubuntu": &fakeArchive{name: "ubuntu", infos: map[string]*archive.PackageInfo{
+			"openssl":    {Name: "openssl", Description: "Secure Sockets Layer toolkit\nThis package is part of the OpenSSL project's\nimplementation of the SSL and TLS cryptographic protocols."},
+			"libssl3":    {Name: "libssl3", Description: "Secure Sockets Layer toolkit - shared libraries"},
+			"sslscanner": {Name: "sslscanner"},
+			"hello":      {Name: "hello", Description: "example package based on GNU hello"},
+		}},
+		"backports": &fakeArchive{name: "backports", infos: map[string]*archive.PackageInfo{
+			"openssl": {Name: "openssl", Description: "Secure Sockets Layer toolkit"},
+		}},
+	}
+
+	for _, test := range searchTests {
+		c.Logf("Summary: %s", test.summary)
+		s.ResetStdStreams()
+		err := chisel.SearchPackages(release, archives, test.query)
+		if test.error != "" {
+			c.Assert(err, ErrorMatches, test.error)
+			continue
+		}
+		c.Assert(err, IsNil)
+		if test.stdout == "" {
+			c.Assert(s.Stdout(), Equals, "Package  Archive  Slices  Summary\n")
+			continue
+		}
+		c.Assert(s.Stdout(), Equals, test.stdout)
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/fsutil"
+	"github.com/canonical/chisel/internal/progress"
+	"github.com/canonical/chisel/internal/setup"
+)
+
+var shortServeHelp = "Serve an HTTP API to cut trees"
+var longServeHelp = `
+The serve command runs a long-lived HTTP server offering the features of
+the cut command, so that build farms avoid the cost of starting chisel
+and reading the release for every tree. Releases are read once, when
+first used, and kept for as long as the server runs, and every request
+shares the same download cache.
+
+Releases provided via --release are read before the server starts, and
+requests not selecting a release use the first of them, or the release
+for the same Ubuntu version as the host if none is provided. Restart the
+server to pick up changes to the releases.
+
+The API accepts and returns JSON documents, and errors are reported as
+{"error": "<message>"} with a 4xx or 5xx status:
+
+    GET  /v1/release?release=<release>
+         List the archives, packages, slices and sets of the release.
+
+    POST /v1/resolve  {"release": ..., "slices": [...]}
+         List the slices selected, including their essential slices.
+
+    POST /v1/cut  {"release": ..., "arch": ..., "slices": [...], "root": ...}
+         Cut the slices into root, a directory on the server, or stream
+         the tree as an uncompressed tar archive if no root is provided.
+
+Slices are referenced as in the cut command, including sets and every
+slice of a package.
+`
+
+var serveDescs = map[string]string{
+	"listen":  "Address to listen on (default: localhost:8080)",
+	"release": "Chisel release name or directory to read upfront (can be repeated)",
+}
+
+type cmdServe struct {
+	Listen  string   `long:"listen" value-name:"<addr>"`
+	Release []string `long:"release" value-name:"<dir>"`
+}
+
+func init() {
+	addCommand("serve", shortServeHelp, longServeHelp, func() flags.Commander { return &cmdServe{} }, serveDescs, nil)
+}
+
+func (cmd *cmdServe) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	listen := cmd.Listen
+	if listen == "" {
+		listen = "localhost:8080"
+	}
+	defaultRelease := optionOrEnv("", releaseEnv)
+	if len(cmd.Release) > 0 {
+		defaultRelease = cmd.Release[0]
+	}
+
+	server := newServer(defaultRelease, obtainRelease)
+	for _, releaseStr := range cmd.Release {
+		_, err := server.release(releaseStr)
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(Stderr, "Listening on %s\n", listen)
+	httpServer := &http.Server{Addr: listen, Handler: server.handler()}
+	stopped := make(chan error, 1)
+	go func() {
+		// Requests in progress are cancelled along with the command.
+		<-commandContext.Done()
+		stopped <- httpServer.Close()
+	}()
+	err := httpServer.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return <-stopped
+	}
+	return err
+}
+
+// server serves the HTTP API of the serve command, keeping the releases
+// read so far for later requests.
+type server struct {
+	defaultRelease string
+	obtain         func(releaseStr string) (*setup.Release, error)
+
+	mu       sync.Mutex
+	releases map[string]*setup.Release
+}
+
+func newServer(defaultRelease string, obtain func(releaseStr string) (*setup.Release, error)) *server {
+	return &server{
+		defaultRelease: defaultRelease,
+		obtain:         obtain,
+		releases:       make(map[string]*setup.Release),
+	}
+}
+
+// release returns the release referenced by releaseStr, or the default
+// release if empty, reading it only if not read before. Releases are read
+// one at a time, so that concurrent requests do not fetch the same one.
+func (s *server) release(releaseStr string) (*setup.Release, error) {
+	if releaseStr == "" {
+		releaseStr = s.defaultRelease
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if release, ok := s.releases[releaseStr]; ok {
+		return release, nil
+	}
+	release, err := s.obtain(releaseStr)
+	if err != nil {
+		return nil, err
+	}
+	s.releases[releaseStr] = release
+	return release, nil
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/release", s.handleRelease)
+	mux.HandleFunc("/v1/resolve", s.handleResolve)
+	mux.HandleFunc("/v1/cut", s.handleCut)
+	return mux
+}
+
+// serveRequest is the body of the POST requests of the API.
+type serveRequest struct {
+	Release string   `json:"release"`
+	Arch    string   `json:"arch"`
+	Slices  []string `json:"slices"`
+	Root    string   `json:"root"`
+}
+
+type releaseResponse struct {
+	Archives []string            `json:"archives"`
+	Packages map[string][]string `json:"packages"`
+	Sets     map[string][]string `json:"sets,omitempty"`
+}
+
+type selectionResponse struct {
+	Slices []string `json:"slices"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// maxRequestSize limits the size of the body of requests.
+const maxRequestSize = 1 << 20
+
+func (s *server) handleRelease(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	release, err := s.release(r.URL.Query().Get("release"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, releaseInfo(release))
+}
+
+// releaseInfo returns the archives, packages, slices and sets of release.
+func releaseInfo(release *setup.Release) *releaseResponse {
+	resp := &releaseResponse{Packages: make(map[string][]string)}
+	for archiveName := range release.Archives {
+		resp.Archives = append(resp.Archives, archiveName)
+	}
+	sort.Strings(resp.Archives)
+	for pkgName, pkg := range release.Packages {
+		sliceNames := []string{}
+		for sliceName := range pkg.Slices {
+			sliceNames = append(sliceNames, sliceName)
+		}
+		sort.Strings(sliceNames)
+		resp.Packages[pkgName] = sliceNames
+	}
+	if len(release.Sets) > 0 {
+		resp.Sets = make(map[string][]string)
+		for setName, sliceKeys := range release.Sets {
+			for _, sliceKey := range sliceKeys {
+				resp.Sets[setName] = append(resp.Sets[setName], sliceKey.String())
+			}
+		}
+	}
+	return resp
+}
+
+func (s *server) handleResolve(w http.ResponseWriter, r *http.Request) {
+	req, ok := readServeRequest(w, r)
+	if !ok {
+		return
+	}
+	_, selection, err := s.selection(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, selectionSlices(selection))
+}
+
+func (s *server) handleCut(w http.ResponseWriter, r *http.Request) {
+	req, ok := readServeRequest(w, r)
+	if !ok {
+		return
+	}
+	release, selection, err := s.selection(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	target := &cutTarget{arch: req.Arch, rootDir: req.Root}
+	if req.Root == "" {
+		var tmpDir string
+		tmpDir, err = tempDir()
+		if err == nil {
+			target.rootDir, err = os.MkdirTemp(tmpDir, "root-")
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Errorf("cannot create temporary root: %w", err))
+			return
+		}
+		defer os.RemoveAll(target.rootDir)
+	}
+	cmd := &cmdCut{}
+	err = cmd.cut(release, selection, time.Time{}, target, progress.NewLog(progressLogInterval))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if req.Root != "" {
+		writeJSON(w, http.StatusOK, selectionSlices(selection))
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-tar")
+	err = fsutil.WriteTar(w, target.rootDir)
+	if err != nil {
+		// The status was sent with the first entry, so the client only
+		// notices the truncated archive.
+		logf("Cannot stream tree: %v", err)
+	}
+}
+
+// selection returns the release of req and the selection of its slices.
+func (s *server) selection(req *serveRequest) (*setup.Release, *setup.Selection, error) {
+	if len(req.Slices) == 0 {
+		return nil, nil, fmt.Errorf("no slices provided")
+	}
+	err := checkSliceRefs(req.Slices)
+	if err != nil {
+		return nil, nil, err
+	}
+	release, err := s.release(req.Release)
+	if err != nil {
+		return nil, nil, err
+	}
+	sliceKeys, err := parseSliceRefs(release, req.Slices)
+	if err != nil {
+		return nil, nil, err
+	}
+	selection, err := setup.Select(release, sliceKeys)
+	if err != nil {
+		return nil, nil, err
+	}
+	return release, selection, nil
+}
+
+func selectionSlices(selection *setup.Selection) *selectionResponse {
+	resp := &selectionResponse{Slices: []string{}}
+	for _, slice := range selection.Slices {
+		resp.Slices = append(resp.Slices, slice.String())
+	}
+	return resp
+}
+
+// readServeRequest decodes the body of the POST request r, replying with an
+// error and returning false if that is not possible.
+func readServeRequest(w http.ResponseWriter, r *http.Request) (*serveRequest, bool) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return nil, false
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	decoder.DisallowUnknownFields()
+	req := &serveRequest{}
+	err := decoder.Decode(req)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("request is too large"))
+		} else {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("cannot decode request: %w", err))
+		}
+		return nil, false
+	}
+	return req, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		logf("Cannot write response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, &errorResponse{Error: err.Error()})
+}
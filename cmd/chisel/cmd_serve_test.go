@@ -0,0 +1,113 @@
+package main_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/setup"
+)
+
+var serveTests = []struct {
+	summary string
+	method  string
+	path    string
+	body    string
+	status  int
+	result  string
+}{{
+	summary: "Query the default release",
+	method:  "GET",
+	path:    "/v1/release",
+	status:  http.StatusOK,
+	result:  `{"archives":["ubuntu"],"packages":{"openjdk-8-jdk":["bins","config","core","libs","utils"],"python3.10":["bins","config","core","libs","utils"]}}`,
+}, {
+	summary: "Query an unknown release",
+	method:  "GET",
+	path:    "/v1/release?release=ubuntu-10.04",
+	status:  http.StatusBadRequest,
+	result:  `{"error":"release ubuntu-10.04 not found"}`,
+}, {
+	summary: "Resolve a selection",
+	method:  "POST",
+	path:    "/v1/resolve",
+	body:    `{"slices": ["python3.10_libs", "python3.10_bins"]}`,
+	status:  http.StatusOK,
+	result:  `{"slices":["python3.10_bins","python3.10_libs"]}`,
+}, {
+	summary: "Resolve every slice of a package",
+	method:  "POST",
+	path:    "/v1/resolve",
+	body:    `{"release": "sample", "slices": ["openjdk-8-jdk_*"]}`,
+	status:  http.StatusOK,
+	result:  `{"slices":["openjdk-8-jdk_bins","openjdk-8-jdk_config","openjdk-8-jdk_core","openjdk-8-jdk_libs","openjdk-8-jdk_utils"]}`,
+}, {
+	summary: "Resolve a missing slice",
+	method:  "POST",
+	path:    "/v1/resolve",
+	body:    `{"slices": ["python3.10_other"]}`,
+	status:  http.StatusBadRequest,
+	result:  `{"error":"slice python3.10_other not found"}`,
+}, {
+	summary: "Resolve an invalid slice reference",
+	method:  "POST",
+	path:    "/v1/resolve",
+	body:    `{"slices": ["python3.10"]}`,
+	status:  http.StatusBadRequest,
+	result:  `{"error":"invalid slice reference: \"python3.10\""}`,
+}, {
+	summary: "Resolve without slices",
+	method:  "POST",
+	path:    "/v1/resolve",
+	body:    `{}`,
+	status:  http.StatusBadRequest,
+	result:  `{"error":"no slices provided"}`,
+}, {
+	summary: "Unknown fields are rejected",
+	method:  "POST",
+	path:    "/v1/resolve",
+	body:    `{"slice": ["python3.10_libs"]}`,
+	status:  http.StatusBadRequest,
+	result:  `{"error":"cannot decode request: json: unknown field \"slice\""}`,
+}, {
+	summary: "Cut requires POST",
+	method:  "GET",
+	path:    "/v1/cut",
+	status:  http.StatusMethodNotAllowed,
+	result:  `{"error":"method GET not allowed"}`,
+}}
+
+func (s *ChiselSuite) TestServe(c *C) {
+	obtained := 0
+	obtain := func(releaseStr string) (*setup.Release, error) {
+		if releaseStr != "sample" {
+			return nil, fmt.Errorf("release %s not found", releaseStr)
+		}
+		obtained++
+		return sampleRelease, nil
+	}
+	server := httptest.NewServer(chisel.ServeHandler("sample", obtain))
+	defer server.Close()
+
+	for _, test := range serveTests {
+		c.Logf("Summary: %s", test.summary)
+		req, err := http.NewRequest(test.method, server.URL+test.path, strings.NewReader(test.body))
+		c.Assert(err, IsNil)
+		resp, err := http.DefaultClient.Do(req)
+		c.Assert(err, IsNil)
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Assert(resp.StatusCode, Equals, test.status)
+		c.Assert(resp.Header.Get("Content-Type"), Equals, "application/json")
+		c.Assert(string(data), Equals, test.result+"\n")
+	}
+
+	// The release was only read once.
+	c.Assert(obtained, Equals, 1)
+}
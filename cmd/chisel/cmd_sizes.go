@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/progress"
+	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/internal/slicer"
+)
+
+var shortSizesHelp = "Estimate the size of selected slices"
+var longSizesHelp = `
+The sizes command estimates the disk space that would be taken by
+each of the provided slices, and by the whole selection, once cut.
+
+The estimation is based on the size of the content of every path
+listed by the slices, so the packages are still fetched but nothing
+is written to disk. The installed size of the full package, as
+listed in the archive index, is shown for comparison.
+
+By default it fetches the slices for the same Ubuntu version as the
+current host, unless the --release flag or the CHISEL_RELEASE
+environment variable are used.
+The architecture may also be set via the CHISEL_ARCH environment
+variable.
+`
+
+var sizesDescs = map[string]string{
+	"release": "Chisel release name or directory (e.g. ubuntu-22.04)",
+	"arch":    "Package architecture",
+}
+
+type cmdSizes struct {
+	Release string `long:"release" value-name:"<dir>"`
+	Arch    string `long:"arch" value-name:"<arch>"`
+
+	Positional struct {
+		SliceRefs []string `positional-arg-name:"<slice names>" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func init() {
+	addCommand("sizes", shortSizesHelp, longSizesHelp, func() flags.Commander { return &cmdSizes{} }, sizesDescs, nil)
+}
+
+func (cmd *cmdSizes) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	err := checkSliceRefs(cmd.Positional.SliceRefs)
+	if err != nil {
+		return err
+	}
+
+	release, err := obtainRelease(optionOrEnv(cmd.Release, releaseEnv))
+	if err != nil {
+		return err
+	}
+
+	sliceKeys, err := parseSliceRefs(release, cmd.Positional.SliceRefs)
+	if err != nil {
+		return err
+	}
+
+	selection, err := setup.Select(release, sliceKeys)
+	if err != nil {
+		return err
+	}
+
+	archives, err := openArchives(release, optionOrEnv(cmd.Arch, archEnv), nil, nil)
+	if err != nil {
+		return err
+	}
+
+	sizes, err := slicer.EstimateSizes(&slicer.RunOptions{
+		Selection: selection,
+		Archives:  archives,
+	})
+	if err != nil {
+		return err
+	}
+
+	w := tabWriter()
+	fmt.Fprintf(w, "Slice\tSize\tPackage size\n")
+	var packagesTotal int64
+	for _, slice := range selection.Slices {
+		installed := sizes.Packages[slice.Package].InstalledSize
+		fmt.Fprintf(w, "%s\t%s\t%s\n", slice, progress.FormatSize(sizes.Slices[slice]), progress.FormatSize(installed))
+	}
+	for _, info := range sizes.Packages {
+		packagesTotal += info.InstalledSize
+	}
+	fmt.Fprintf(w, "Total\t%s\t%s\n", progress.FormatSize(sizes.Total), progress.FormatSize(packagesTotal))
+	return w.Flush()
+}
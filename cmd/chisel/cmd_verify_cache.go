@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jessevdk/go-flags"
+	"golang.org/x/crypto/openpgp/packet"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/cache"
+)
+
+var shortVerifyCacheHelp = "Verify the integrity of the download cache"
+var longVerifyCacheHelp = `
+The verify-cache command checks every package and index in the download
+cache against the digest it was stored under, and every InRelease file
+against the public keys of the archives in the release. Entries failing
+verification are removed, so they are fetched again when next needed.
+
+By default it uses the release for the same Ubuntu version as the
+current host, unless the --release flag or the CHISEL_RELEASE
+environment variable are used. Cached InRelease files of archives
+which are not part of the release are removed as well.
+`
+
+var verifyCacheDescs = map[string]string{
+	"release": "Chisel release name or directory (e.g. ubuntu-22.04)",
+}
+
+type cmdVerifyCache struct {
+	Release string `long:"release" value-name:"<dir>"`
+}
+
+func init() {
+	addCommand("verify-cache", shortVerifyCacheHelp, longVerifyCacheHelp, func() flags.Commander { return &cmdVerifyCache{} }, verifyCacheDescs, nil)
+}
+
+func (cmd *cmdVerifyCache) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	release, err := obtainRelease(optionOrEnv(cmd.Release, releaseEnv))
+	if err != nil {
+		return err
+	}
+
+	var pubKeys []*packet.PublicKey
+	for _, archiveInfo := range release.Archives {
+		pubKeys = append(pubKeys, archiveInfo.PubKeys...)
+	}
+
+	checked, bad, err := archive.VerifyCache(cache.DefaultDir("chisel"), pubKeys)
+	if err != nil {
+		return err
+	}
+	for _, entry := range bad {
+		fmt.Fprintf(Stdout, "Removed %s: %v\n", entry.Digest, entry.Err)
+	}
+	fmt.Fprintf(Stdout, "Checked %d cache entries, removed %d\n", checked, len(bad))
+	return nil
+}
@@ -2,21 +2,33 @@ package main
 
 import (
 	"fmt"
+	"runtime"
+	"runtime/debug"
 
 	"github.com/jessevdk/go-flags"
 
 	"github.com/canonical/chisel/cmd"
+	"github.com/canonical/chisel/internal/manifest"
 )
 
 var shortVersionHelp = "Show version details"
 var longVersionHelp = `
 The version command displays the versions of the running client and server.
+
+With --details it also displays the git commit and the Go version used to
+build chisel, and the manifest schema versions it supports.
 `
 
-type cmdVersion struct{}
+var versionDescs = map[string]string{
+	"details": "Show build details as well",
+}
+
+type cmdVersion struct {
+	Details bool `long:"details"`
+}
 
 func init() {
-	addCommand("version", shortVersionHelp, longVersionHelp, func() flags.Commander { return &cmdVersion{} }, nil, nil)
+	addCommand("version", shortVersionHelp, longVersionHelp, func() flags.Commander { return &cmdVersion{} }, versionDescs, nil)
 }
 
 func (cmd cmdVersion) Execute(args []string) error {
@@ -24,6 +36,9 @@ func (cmd cmdVersion) Execute(args []string) error {
 		return ErrExtraArgs
 	}
 
+	if cmd.Details {
+		return printVersionDetails()
+	}
 	return printVersions()
 }
 
@@ -31,3 +46,40 @@ func printVersions() error {
 	fmt.Fprintf(Stdout, "%s\n", cmd.Version)
 	return nil
 }
+
+// chiselVersion returns the version of the running chisel.
+func chiselVersion() string {
+	return cmd.Version
+}
+
+// readBuildInfo is overridden for testing.
+var readBuildInfo = debug.ReadBuildInfo
+
+func printVersionDetails() error {
+	commit := "unknown"
+	if info, ok := readBuildInfo(); ok {
+		var revision, modified string
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				revision = setting.Value
+			case "vcs.modified":
+				modified = setting.Value
+			}
+		}
+		if revision != "" {
+			commit = revision
+			if modified == "true" {
+				commit += "-dirty"
+			}
+		}
+	}
+
+	w := tabWriter()
+	fmt.Fprintf(w, "version:\t%s\n", cmd.Version)
+	fmt.Fprintf(w, "commit:\t%s\n", commit)
+	fmt.Fprintf(w, "go:\t%s\n", runtime.Version())
+	fmt.Fprintf(w, "manifest-schemas:\t%s, %s\n", manifest.Schema, manifest.PathsOnlySchema)
+	w.Flush()
+	return nil
+}
@@ -1,6 +1,9 @@
 package main_test
 
 import (
+	"runtime"
+	"runtime/debug"
+
 	. "gopkg.in/check.v1"
 
 	chisel "github.com/canonical/chisel/cmd/chisel"
@@ -15,3 +18,24 @@ func (s *ChiselSuite) TestVersionCommand(c *C) {
 	c.Assert(s.Stdout(), Equals, "4.56\n")
 	c.Assert(s.Stderr(), Equals, "")
 }
+
+func (s *ChiselSuite) TestVersionDetails(c *C) {
+	restore := fakeVersion("4.56")
+	defer restore()
+	restore = chisel.FakeBuildInfo(&debug.BuildInfo{
+		Settings: []debug.BuildSetting{
+			{Key: "vcs.revision", Value: "0123456789abcdef"},
+			{Key: "vcs.modified", Value: "true"},
+		},
+	})
+	defer restore()
+
+	_, err := chisel.Parser().ParseArgs([]string{"version", "--details"})
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, ""+
+		"version:           4.56\n"+
+		"commit:            0123456789abcdef-dirty\n"+
+		"go:                "+runtime.Version()+"\n"+
+		"manifest-schemas:  1.0, 1.0-paths-only\n")
+	c.Assert(s.Stderr(), Equals, "")
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/manifest"
+	"github.com/canonical/chisel/internal/setup"
+)
+
+var shortWhichHelp = "Show which slices own a path of a root filesystem"
+var longWhichHelp = `
+The which command looks up the provided path in the manifest of a root
+filesystem created by the cut command, and shows the slices that own it,
+their packages and versions, and the digest recorded for it.
+
+The status compares the path in the root filesystem with the manifest,
+and is "ok" when they match, "modified" when the content, link target or
+type of the path changed since it was cut, and "missing" when the path
+no longer exists, or "pruned" when it was removed by the --prune flag of
+the cut command.
+
+The manifest is read from /var/lib/chisel/manifest.wall by default, as
+written by the --with-manifest flag of the cut command. Use --manifest
+to read it from another location within the root filesystem.
+
+The --root flag may be replaced by the CHISEL_ROOT environment variable.
+`
+
+var whichDescs = map[string]string{
+	"root":     "Root filesystem to inspect",
+	"manifest": "Location of the manifest within the root filesystem",
+}
+
+type cmdWhich struct {
+	RootDir  string `long:"root" value-name:"<dir>"`
+	Manifest string `long:"manifest" value-name:"<path>"`
+
+	Positional struct {
+		Path string `positional-arg-name:"<path>" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+func init() {
+	addCommand("which", shortWhichHelp, longWhichHelp, func() flags.Commander { return &cmdWhich{} }, whichDescs, nil)
+}
+
+func (cmd *cmdWhich) Execute(args []string) error {
+	if len(args) > 0 {
+		return ErrExtraArgs
+	}
+
+	rootDir := optionOrEnv(cmd.RootDir, rootEnv)
+	if rootDir == "" {
+		return &usageError{fmt.Errorf("the required flag `--root' was not specified")}
+	}
+
+	mfest, err := readRootManifest(rootDir, cmd.Manifest)
+	if err != nil {
+		return err
+	}
+
+	entry, err := findManifestPath(mfest, cmd.Positional.Path)
+	if err != nil {
+		return err
+	}
+
+	versions := make(map[string]string)
+	err = mfest.IteratePackages(func(pkg *manifest.Package) error {
+		versions[pkg.Name] = pkg.Version
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	var packages []string
+	seen := make(map[string]bool)
+	for _, slice := range entry.Slices {
+		key, err := setup.ParseSliceKey(slice)
+		if err != nil || seen[key.Package] {
+			continue
+		}
+		seen[key.Package] = true
+		packages = append(packages, key.Package+" "+orDash(versions[key.Package]))
+	}
+	sort.Strings(packages)
+
+	status, err := manifestPathStatus(rootDir, entry)
+	if err != nil {
+		return err
+	}
+
+	digest := entry.FinalSHA256
+	if digest == "" {
+		digest = entry.SHA256
+	}
+	slices := strings.Join(entry.Slices, ", ")
+	if entry.Implicit {
+		slices += " (implicit)"
+	}
+
+	w := tabWriter()
+	fmt.Fprintf(w, "Path:\t%s\n", entry.Path)
+	fmt.Fprintf(w, "Slices:\t%s\n", orDash(slices))
+	fmt.Fprintf(w, "Packages:\t%s\n", orDash(strings.Join(packages, ", ")))
+	fmt.Fprintf(w, "SHA256:\t%s\n", orDash(digest))
+	fmt.Fprintf(w, "Status:\t%s\n", status)
+	return w.Flush()
+}
+
+// findManifestPath returns the entry of mfest for the provided path, which
+// may omit the trailing "/" of directories.
+func findManifestPath(mfest *manifest.Manifest, pathStr string) (*manifest.Path, error) {
+	cleanPath := path.Clean("/" + pathStr)
+	var found *manifest.Path
+	err := mfest.IteratePaths(cleanPath, func(entry *manifest.Path) error {
+		if entry.Path == cleanPath || entry.Path == cleanPath+"/" {
+			found = entry
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, fmt.Errorf("path %s is not listed in the manifest", cleanPath)
+	}
+	return found, nil
+}
+
+// manifestPathStatus returns whether the path in rootDir described by entry
+// is "ok", "modified" or "missing".
+func manifestPathStatus(rootDir string, entry *manifest.Path) (string, error) {
+	fpath := filepath.Join(rootDir, entry.Path)
+	info, err := os.Lstat(fpath)
+	if os.IsNotExist(err) && entry.Pruned {
+		return "pruned", nil
+	}
+	if os.IsNotExist(err) {
+		return "missing", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	modified := false
+	switch {
+	case strings.HasSuffix(entry.Path, "/"):
+		modified = !info.IsDir()
+	case entry.Link != "":
+		if info.Mode()&os.ModeSymlink == 0 {
+			modified = true
+			break
+		}
+		link, err := os.Readlink(fpath)
+		if err != nil {
+			return "", err
+		}
+		modified = link != entry.Link
+	default:
+		if !info.Mode().IsRegular() {
+			modified = true
+			break
+		}
+		digest, err := fileSHA256(fpath)
+		if err != nil {
+			return "", err
+		}
+		expected := entry.FinalSHA256
+		if expected == "" {
+			expected = entry.SHA256
+		}
+		// The manifests list themselves without a digest.
+		modified = expected != "" && digest != expected
+	}
+	if modified {
+		return "modified", nil
+	}
+	return "ok", nil
+}
+
+func fileSHA256(fpath string) (string, error) {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
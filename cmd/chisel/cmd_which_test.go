@@ -0,0 +1,142 @@
+package main_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+	"github.com/canonical/chisel/internal/manifest"
+)
+
+var whichTests = []struct {
+	summary string
+	path    string
+	hack    func(c *C, root string)
+	stdout  string
+	error   string
+}{{
+	summary: "File matching the manifest",
+	path:    "/usr/bin/hello",
+	stdout: "" +
+		"Path:      /usr/bin/hello\n" +
+		"Slices:    hello_bins\n" +
+		"Packages:  hello 2.10-2\n" +
+		"SHA256:    5b41362bc82b7f3d56edc5a306db22105707d01ff4819e26faef9724a2d406c9\n" +
+		"Status:    ok\n",
+}, {
+	summary: "Modified file",
+	path:    "/usr/bin/hello",
+	hack: func(c *C, root string) {
+		c.Assert(os.WriteFile(filepath.Join(root, "usr/bin/hello"), []byte("data2"), 0755), IsNil)
+	},
+	stdout: "" +
+		"Path:      /usr/bin/hello\n" +
+		"Slices:    hello_bins\n" +
+		"Packages:  hello 2.10-2\n" +
+		"SHA256:    5b41362bc82b7f3d56edc5a306db22105707d01ff4819e26faef9724a2d406c9\n" +
+		"Status:    modified\n",
+}, {
+	summary: "Missing symlink",
+	path:    "/usr/bin/hi",
+	hack: func(c *C, root string) {
+		c.Assert(os.Remove(filepath.Join(root, "usr/bin/hi")), IsNil)
+	},
+	stdout: "" +
+		"Path:      /usr/bin/hi\n" +
+		"Slices:    hello_bins\n" +
+		"Packages:  hello 2.10-2\n" +
+		"SHA256:    -\n" +
+		"Status:    missing\n",
+}, {
+	summary: "Implicit directory shared by packages, without a trailing slash",
+	path:    "usr/bin",
+	stdout: "" +
+		"Path:      /usr/bin/\n" +
+		"Slices:    base_bins, hello_bins (implicit)\n" +
+		"Packages:  base 1.0, hello 2.10-2\n" +
+		"SHA256:    -\n" +
+		"Status:    ok\n",
+}, {
+	summary: "Pruned path",
+	path:    "/usr/share/man/man1/hello.1",
+	stdout: "" +
+		"Path:      /usr/share/man/man1/hello.1\n" +
+		"Slices:    hello_bins\n" +
+		"Packages:  hello 2.10-2\n" +
+		"SHA256:    5b41362bc82b7f3d56edc5a306db22105707d01ff4819e26faef9724a2d406c9\n" +
+		"Status:    pruned\n",
+}, {
+	summary: "Path not in the manifest",
+	path:    "/usr/bin/hell",
+	error:   `path /usr/bin/hell is not listed in the manifest`,
+}}
+
+func (s *ChiselSuite) TestWhich(c *C) {
+	for _, test := range whichTests {
+		c.Logf("Summary: %s", test.summary)
+		root := c.MkDir()
+		c.Assert(os.MkdirAll(filepath.Join(root, "usr/bin"), 0755), IsNil)
+		c.Assert(os.WriteFile(filepath.Join(root, "usr/bin/hello"), []byte("data1"), 0755), IsNil)
+		c.Assert(os.Symlink("hello", filepath.Join(root, "usr/bin/hi")), IsNil)
+
+		mw := manifest.NewWriter()
+		c.Assert(mw.AddPackage(&manifest.Package{Name: "hello", Version: "2.10-2"}), IsNil)
+		c.Assert(mw.AddPackage(&manifest.Package{Name: "base", Version: "1.0"}), IsNil)
+		c.Assert(mw.AddPath(&manifest.Path{
+			Path:   "/usr/bin/hello",
+			Mode:   "0755",
+			Slices: []string{"hello_bins"},
+			SHA256: "5b41362bc82b7f3d56edc5a306db22105707d01ff4819e26faef9724a2d406c9",
+			Size:   5,
+		}), IsNil)
+		c.Assert(mw.AddPath(&manifest.Path{
+			Path:   "/usr/bin/hi",
+			Mode:   "0777",
+			Slices: []string{"hello_bins"},
+			Link:   "hello",
+		}), IsNil)
+		c.Assert(mw.AddPath(&manifest.Path{
+			Path:     "/usr/bin/",
+			Mode:     "0755",
+			Slices:   []string{"base_bins", "hello_bins"},
+			Implicit: true,
+		}), IsNil)
+		c.Assert(mw.AddPath(&manifest.Path{
+			Path:   "/usr/share/man/man1/hello.1",
+			Mode:   "0644",
+			Slices: []string{"hello_bins"},
+			SHA256: "5b41362bc82b7f3d56edc5a306db22105707d01ff4819e26faef9724a2d406c9",
+			Size:   5,
+			Pruned: true,
+		}), IsNil)
+		manifestDir := filepath.Join(root, "var/lib/chisel")
+		c.Assert(os.MkdirAll(manifestDir, 0755), IsNil)
+		f, err := os.Create(filepath.Join(manifestDir, manifest.Filename))
+		c.Assert(err, IsNil)
+		c.Assert(mw.Write(f), IsNil)
+		c.Assert(f.Close(), IsNil)
+
+		if test.hack != nil {
+			test.hack(c, root)
+		}
+
+		s.ResetStdStreams()
+		restore := fakeArgs("chisel", "which", "--root", root, test.path)
+		err = chisel.RunMain()
+		restore()
+		if test.error != "" {
+			c.Assert(err, ErrorMatches, test.error)
+			continue
+		}
+		c.Assert(err, IsNil)
+		c.Assert(s.Stdout(), Equals, test.stdout)
+	}
+}
+
+func (s *ChiselSuite) TestWhichMissingManifest(c *C) {
+	defer fakeArgs("chisel", "which", "--root", c.MkDir(), "--manifest", "/other/manifest.wall", "/usr/bin/hello")()
+	err := chisel.RunMain()
+	c.Assert(err, ErrorMatches, `cannot read manifest: open .*/other/manifest.wall: no such file or directory`)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+
+	"github.com/jessevdk/go-flags"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/cache"
+	"github.com/canonical/chisel/internal/setup"
+)
+
+// Exit codes used by chisel so that callers may tell apart the different
+// kinds of failure without parsing error messages.
+const (
+	exitError        = 1
+	exitUsage        = 2
+	exitRelease      = 3
+	exitConflict     = 4
+	exitNetwork      = 5
+	exitVerification = 6
+	exitCancelled    = 7
+)
+
+type errorKind string
+
+const (
+	errorKindGeneric      errorKind = "error"
+	errorKindUsage        errorKind = "usage"
+	errorKindRelease      errorKind = "release"
+	errorKindConflict     errorKind = "conflict"
+	errorKindNetwork      errorKind = "network"
+	errorKindVerification errorKind = "verification"
+	errorKindCancelled    errorKind = "cancelled"
+)
+
+// usageError wraps errors caused by an incorrect invocation of chisel.
+type usageError struct {
+	err error
+}
+
+func (e *usageError) Error() string { return e.err.Error() }
+func (e *usageError) Unwrap() error { return e.err }
+
+// releaseError wraps errors found while obtaining or validating the
+// chisel release.
+type releaseError struct {
+	err error
+}
+
+func (e *releaseError) Error() string { return e.err.Error() }
+func (e *releaseError) Unwrap() error { return e.err }
+
+// cancelError wraps errors caused by the command being interrupted, or
+// running for longer than allowed by --timeout.
+type cancelError struct {
+	err error
+}
+
+func (e *cancelError) Error() string { return e.err.Error() }
+func (e *cancelError) Unwrap() error { return e.err }
+
+// classifyError returns the kind of err and the respective exit code.
+func classifyError(err error) (errorKind, int) {
+	var usageErr *usageError
+	var flagsErr *flags.Error
+	var conflictErr *setup.ConflictError
+	var verifyErr *archive.VerifyError
+	var digestErr *cache.DigestError
+	var urlErr *url.Error
+	var netErr net.Error
+	var releaseErr *releaseError
+	var cancelErr *cancelError
+	switch {
+	case errors.As(err, &usageErr), errors.As(err, &flagsErr), errors.Is(err, ErrExtraArgs):
+		return errorKindUsage, exitUsage
+	case errors.As(err, &cancelErr):
+		return errorKindCancelled, exitCancelled
+	case errors.As(err, &conflictErr):
+		return errorKindConflict, exitConflict
+	case errors.As(err, &verifyErr), errors.As(err, &digestErr):
+		return errorKindVerification, exitVerification
+	case errors.As(err, &urlErr), errors.As(err, &netErr):
+		return errorKindNetwork, exitNetwork
+	case errors.As(err, &releaseErr):
+		return errorKindRelease, exitRelease
+	}
+	return errorKindGeneric, exitError
+}
+
+type jsonError struct {
+	Kind     errorKind `json:"kind"`
+	Message  string    `json:"message"`
+	ExitCode int       `json:"exit-code"`
+	Slices   []string  `json:"slices,omitempty"`
+	Paths    []string  `json:"paths,omitempty"`
+}
+
+// newJSONError returns the representation of err in the JSON format.
+func newJSONError(err error) *jsonError {
+	kind, code := classifyError(err)
+	jerr := &jsonError{
+		Kind:     kind,
+		Message:  err.Error(),
+		ExitCode: code,
+	}
+	var conflictErr *setup.ConflictError
+	if errors.As(err, &conflictErr) {
+		jerr.Slices = []string{conflictErr.Slices[0].String(), conflictErr.Slices[1].String()}
+		jerr.Paths = []string{conflictErr.Paths[0], conflictErr.Paths[1]}
+	}
+	return jerr
+}
+
+// printError writes err to w in the provided format, as selected via the
+// --errors option, and returns the exit code matching it.
+func printError(w io.Writer, err error, format string) int {
+	_, code := classifyError(err)
+	if format != "json" {
+		fmt.Fprintf(w, errorPrefix+"%v\n", err)
+		return code
+	}
+	data, jsonErr := json.Marshal(newJSONError(err))
+	if jsonErr != nil {
+		fmt.Fprintf(w, errorPrefix+"%v\n", err)
+		return code
+	}
+	fmt.Fprintf(w, "%s\n", data)
+	return code
+}
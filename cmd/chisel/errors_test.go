@@ -0,0 +1,158 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/cache"
+	"github.com/canonical/chisel/internal/setup"
+
+	chisel "github.com/canonical/chisel/cmd/chisel"
+)
+
+type printErrorTest struct {
+	summary string
+	err     error
+	format  string
+	output  string
+	code    int
+}
+
+var printErrorTests = []printErrorTest{{
+	summary: "Generic error",
+	err:     fmt.Errorf("boom"),
+	output:  "error: boom\n",
+	code:    1,
+}, {
+	summary: "Generic error in JSON",
+	err:     fmt.Errorf("boom"),
+	format:  "json",
+	output:  `{"kind":"error","message":"boom","exit-code":1}` + "\n",
+	code:    1,
+}, {
+	summary: "Usage error",
+	err:     chisel.ErrExtraArgs,
+	format:  "json",
+	output:  `{"kind":"usage","message":"too many arguments for command","exit-code":2}` + "\n",
+	code:    2,
+}, {
+	summary: "Conflict error",
+	err: fmt.Errorf("cannot select slices: %w", &setup.ConflictError{
+		Slices: [2]setup.SliceKey{{"mypkg1", "myslice1"}, {"mypkg2", "myslice1"}},
+		Paths:  [2]string{"/path1", "/path1"},
+	}),
+	format: "json",
+	output: `{"kind":"conflict","message":"cannot select slices: slices mypkg1_myslice1 and mypkg2_myslice1 conflict on /path1",` +
+		`"exit-code":4,"slices":["mypkg1_myslice1","mypkg2_myslice1"],"paths":["/path1","/path1"]}` + "\n",
+	code: 4,
+}, {
+	summary: "Conflict error in text",
+	err: &setup.ConflictError{
+		Slices: [2]setup.SliceKey{{"mypkg1", "myslice1"}, {"mypkg2", "myslice1"}},
+		Paths:  [2]string{"/path1", "/path*"},
+	},
+	output: "error: slices mypkg1_myslice1 and mypkg2_myslice1 conflict on /path1 and /path*\n",
+	code:   4,
+}, {
+	summary: "Network error",
+	err:     fmt.Errorf("cannot talk to archive: %w", &url.Error{Op: "Get", URL: "http://example.com", Err: fmt.Errorf("no route")}),
+	format:  "json",
+	output:  `{"kind":"network","message":"cannot talk to archive: Get \"http://example.com\": no route","exit-code":5}` + "\n",
+	code:    5,
+}, {
+	summary: "Verification error",
+	err:     fmt.Errorf("cannot fetch: %w", &cache.DigestError{Expected: "abc", Got: "def"}),
+	format:  "json",
+	output:  `{"kind":"verification","message":"cannot fetch: expected digest abc, got def","exit-code":6}` + "\n",
+	code:    6,
+}, {
+	summary: "Cancelled error",
+	err: chisel.CancelledError(cancelledContext(), fmt.Errorf("cannot talk to archive: %w",
+		&url.Error{Op: "Get", URL: "http://example.com", Err: context.Canceled}), 0),
+	format: "json",
+	output: `{"kind":"cancelled","message":"interrupted: cannot talk to archive: Get \"http://example.com\": context canceled","exit-code":7}` + "\n",
+	code:   7,
+}}
+
+func cancelledContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
+
+func (s *ChiselSuite) TestPrintError(c *C) {
+	for _, test := range printErrorTests {
+		c.Logf("Summary: %s", test.summary)
+		var buf bytes.Buffer
+		code := chisel.PrintError(&buf, test.err, test.format)
+		c.Assert(buf.String(), Equals, test.output)
+		c.Assert(code, Equals, test.code)
+	}
+}
+
+type runErrorTest struct {
+	summary string
+	args    []string
+	kind    string
+	code    int
+}
+
+var runErrorTests = []runErrorTest{{
+	summary: "Unknown command",
+	args:    []string{"chisel", "foo"},
+	kind:    "usage",
+	code:    2,
+}, {
+	summary: "Unknown option",
+	args:    []string{"chisel", "version", "--foo"},
+	kind:    "usage",
+	code:    2,
+}, {
+	summary: "Invalid slice reference",
+	args:    []string{"chisel", "cut", "--root", "/tmp", "foo"},
+	kind:    "usage",
+	code:    2,
+}, {
+	summary: "Invalid release reference",
+	args:    []string{"chisel", "find", "--release", "foo", "bar"},
+	kind:    "usage",
+	code:    2,
+}, {
+	summary: "Invalid errors format",
+	args:    []string{"chisel", "--errors", "xml", "version"},
+	kind:    "usage",
+	code:    2,
+}, {
+	summary: "Invalid conflict policy",
+	args:    []string{"chisel", "--conflict-policy", "lax", "version"},
+	kind:    "usage",
+	code:    2,
+}, {
+	summary: "Invalid download limit",
+	args:    []string{"chisel", "--download-limit", "fast", "version"},
+	kind:    "usage",
+	code:    2,
+}, {
+	summary: "Missing release directory",
+	args:    []string{"chisel", "find", "--release", "/non-existent/", "foo"},
+	kind:    "release",
+	code:    3,
+}}
+
+func (s *ChiselSuite) TestRunErrors(c *C) {
+	for _, test := range runErrorTests {
+		c.Logf("Summary: %s", test.summary)
+		restore := fakeArgs(test.args...)
+		err := chisel.RunMain()
+		restore()
+		c.Assert(err, NotNil)
+		var buf bytes.Buffer
+		code := chisel.PrintError(&buf, err, "json")
+		c.Assert(buf.String(), Matches, fmt.Sprintf(`{"kind":"%s","message":".*","exit-code":%d}\n`, test.kind, test.code))
+		c.Assert(code, Equals, test.code)
+	}
+}
@@ -1,5 +1,19 @@
 package main
 
+import (
+	"context"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/fsutil"
+	"github.com/canonical/chisel/internal/progress"
+	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/internal/slicer"
+)
+
 var RunMain = run
 
 func FakeIsStdoutTTY(t bool) (restore func()) {
@@ -19,3 +33,85 @@ func FakeIsStdinTTY(t bool) (restore func()) {
 }
 
 var FindSlices = findSlices
+
+var PrintError = printError
+var AtomicRootDir = atomicRootDir
+var ReadSliceRefs = readSliceRefs
+var ParseSliceRefs = parseSliceRefs
+var SlicesProviding = slicesProviding
+var PrintDryRun = printDryRun
+var CheckTreeSize = checkTreeSize
+var StartCutState = startCutState
+var CheckInterruptedCut = checkInterruptedCut
+var CleanInterruptedCut = cleanInterruptedCut
+var SetTempDirBase = setTempDirBase
+var TempDir = tempDir
+var RemoveTempDir = removeTempDir
+var NewCommandContext = newCommandContext
+var CancelledError = cancelledError
+
+type CutState = cutState
+
+func (s *CutState) Record(path string) error { return s.record(path) }
+func (s *CutState) Finish() error            { return s.finish() }
+func (s *CutState) Abort()                   { s.abort() }
+
+func FakeCommandContext(ctx context.Context) (restore func()) {
+	old := commandContext
+	commandContext = ctx
+	return func() { commandContext = old }
+}
+
+func FakeBuildInfo(info *debug.BuildInfo) (restore func()) {
+	old := readBuildInfo
+	readBuildInfo = func() (*debug.BuildInfo, bool) { return info, info != nil }
+	return func() { readBuildInfo = old }
+}
+
+func PrintCutStats(format string, selection *setup.Selection, report *slicer.Report, ops []progress.Operation, stats map[string]*archive.Stats, elapsed time.Duration) error {
+	return printCutStats(format, cutStats(selection, report, ops, stats, elapsed))
+}
+
+func PrintArchiveChoices(release *setup.Release, selection *setup.Selection, archives map[string]archive.Archive) error {
+	choices, err := explainArchives(release, selection, archives)
+	if err != nil {
+		return err
+	}
+	return printArchiveChoices(choices)
+}
+
+func PrintSelectionChains(selection *setup.Selection, requested []setup.SliceKey) error {
+	return printSelectionChains(selection, requested)
+}
+
+func ServeHandler(defaultRelease string, obtain func(releaseStr string) (*setup.Release, error)) http.Handler {
+	return newServer(defaultRelease, obtain).handler()
+}
+
+func ServeRPC(defaultRelease string, obtain func(releaseStr string) (*setup.Release, error), r io.Reader, w io.Writer) error {
+	return serveRPC(newServer(defaultRelease, obtain), r, w)
+}
+
+func PrintAnalysis(release *setup.Release, entries map[string]*fsutil.Entry) error {
+	coverage, uncovered := analyzeRoot(release, entries)
+	return printAnalysis(coverage, uncovered)
+}
+
+func SearchPackages(release *setup.Release, archives map[string]archive.Archive, query []string) error {
+	matches, err := searchPackages(archives, query)
+	if err != nil {
+		return err
+	}
+	return printPackageMatches(release, matches)
+}
+
+func DiffReleases(oldRelease, newRelease *setup.Release) error {
+	return printReleaseChanges(diffReleases(oldRelease, newRelease))
+}
+
+var WriteDpkgStatus = writeDpkgStatus
+
+var PrintArchPreview = printArchPreview
+
+var ParseKeyFingerprints = parseKeyFingerprints
+var RestrictArchiveKeys = restrictArchiveKeys
@@ -1,11 +1,35 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"regexp"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"golang.org/x/crypto/openpgp/packet"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/cache"
+	"github.com/canonical/chisel/internal/manifest"
+	"github.com/canonical/chisel/internal/progress"
 	"github.com/canonical/chisel/internal/setup"
 )
 
@@ -16,7 +40,7 @@ var releaseExp = regexp.MustCompile(`^([a-z](?:-?[a-z0-9]){2,})-([0-9]+(?:\.?[0-
 func parseReleaseInfo(release string) (label, version string, err error) {
 	match := releaseExp.FindStringSubmatch(release)
 	if match == nil {
-		return "", "", fmt.Errorf("invalid release reference: %q", release)
+		return "", "", &usageError{fmt.Errorf("invalid release reference: %q", release)}
 	}
 	return match[1], match[2], nil
 }
@@ -47,8 +71,45 @@ func readReleaseInfo() (label, version string, err error) {
 // * the path to a directory containing a previously fetched release,
 // * "" and Chisel will attempt to read the release label from the host.
 func obtainRelease(releaseStr string) (release *setup.Release, err error) {
+	release, err = readOrFetchRelease(releaseStr, nil, nil)
+	if err != nil {
+		return nil, &releaseError{err}
+	}
+	return release, nil
+}
+
+// obtainReleaseFor is like obtainRelease, but only reads the slice
+// definitions needed to select the provided slice references, as checked
+// by checkSliceRefs.
+func obtainReleaseFor(releaseStr string, sliceRefs []string) (release *setup.Release, err error) {
+	var pkgNames, setNames []string
+	for _, sliceRef := range sliceRefs {
+		if setName, ok := strings.CutPrefix(sliceRef, "@"); ok {
+			setNames = append(setNames, setName)
+		} else if pkgName, ok := strings.CutSuffix(sliceRef, "_*"); ok {
+			pkgNames = append(pkgNames, pkgName)
+		} else if sliceKey, err := setup.ParseSliceKey(sliceRef); err == nil {
+			pkgNames = append(pkgNames, sliceKey.Package)
+		}
+	}
+	release, err = readOrFetchRelease(releaseStr, pkgNames, setNames)
+	if err != nil {
+		return nil, &releaseError{err}
+	}
+	return release, nil
+}
+
+// readOrFetchRelease reads or fetches the release for releaseStr. If either
+// pkgNames or setNames is not empty, only the slice definitions needed by
+// those packages and sets are read.
+func readOrFetchRelease(releaseStr string, pkgNames, setNames []string) (release *setup.Release, err error) {
 	if strings.Contains(releaseStr, "/") {
-		release, err = setup.ReadRelease(releaseStr)
+		release, err = setup.ReadReleaseWithOptions(releaseStr, &setup.ReadOptions{
+			ConflictPolicy: setup.ConflictPolicy(optionsData.ConflictPolicy),
+			ExtraSlices:    optionsData.ExtraSlices,
+			Packages:       pkgNames,
+			Sets:           setNames,
+		})
 	} else {
 		var label, version string
 		if releaseStr == "" {
@@ -60,8 +121,14 @@ func obtainRelease(releaseStr string) (release *setup.Release, err error) {
 			return nil, err
 		}
 		release, err = setup.FetchRelease(&setup.FetchOptions{
-			Label:   label,
-			Version: version,
+			Label:          label,
+			Version:        version,
+			ConflictPolicy: setup.ConflictPolicy(optionsData.ConflictPolicy),
+			ExtraSlices:    optionsData.ExtraSlices,
+			Packages:       pkgNames,
+			Sets:           setNames,
+			CACerts:        caCerts,
+			Context:        commandContext,
 		})
 	}
 	if err != nil {
@@ -69,3 +136,526 @@ func obtainRelease(releaseStr string) (release *setup.Release, err error) {
 	}
 	return release, nil
 }
+
+// openArchives opens all the archives defined in the release for the provided
+// architecture, indexed by their name. The reporter, if not nil, is notified
+// about the progress of downloads, and stats, if not nil, is filled with the
+// statistics of the data fetched by every archive, by name.
+func openArchives(release *setup.Release, arch string, reporter progress.Reporter, stats map[string]*archive.Stats) (map[string]archive.Archive, error) {
+	archives := make(map[string]archive.Archive)
+	for archiveName, archiveInfo := range release.Archives {
+		var archiveStats *archive.Stats
+		if stats != nil {
+			archiveStats = &archive.Stats{}
+			stats[archiveName] = archiveStats
+		}
+		archiveCACerts := archiveInfo.CACerts
+		if len(caCerts) > 0 {
+			archiveCACerts = bytes.Join([][]byte{archiveCACerts, caCerts}, []byte("\n"))
+		}
+		openArchive, err := archive.Open(&archive.Options{
+			Label:          archiveName,
+			Version:        archiveInfo.Version,
+			Arch:           arch,
+			Suites:         archiveInfo.Suites,
+			Components:     archiveInfo.Components,
+			CacheDir:       cache.DefaultDir("chisel"),
+			PubKeys:        archiveInfo.PubKeys,
+			Progress:       reporter,
+			SuitePolicy:    archiveInfo.SuitePolicy,
+			Stats:          archiveStats,
+			Limits:         archiveLimits,
+			CACerts:        archiveCACerts,
+			NoVerifyDigest: optionsData.NoVerifyDigest,
+			Context:        commandContext,
+		})
+		if err != nil {
+			return nil, err
+		}
+		archives[archiveName] = openArchive
+	}
+	return archives, nil
+}
+
+// checkSliceRefs returns a usage error if any of the provided references
+// is invalid, so that mistakes are reported before obtaining the release
+// needed to parse them. References to sets and to all slices of a package
+// are only checked once parsed.
+func checkSliceRefs(sliceRefs []string) error {
+	for _, sliceRef := range sliceRefs {
+		if strings.HasPrefix(sliceRef, "@") || strings.HasSuffix(sliceRef, "_*") {
+			continue
+		}
+		_, err := setup.ParseSliceKey(sliceRef)
+		if err != nil {
+			return &usageError{err}
+		}
+	}
+	return nil
+}
+
+// parseSliceRefs parses the provided slice references into slice keys.
+// References to sets of slices, as "@<set>", are replaced by the slices of
+// the set with that name in release, and references to all slices of a
+// package, as "<pkg>_*", by the slices of that package which are not
+// deprecated.
+func parseSliceRefs(release *setup.Release, sliceRefs []string) ([]setup.SliceKey, error) {
+	var sliceKeys []setup.SliceKey
+	for _, sliceRef := range sliceRefs {
+		if setName, ok := strings.CutPrefix(sliceRef, "@"); ok {
+			set, ok := release.Sets[setName]
+			if !ok {
+				return nil, &usageError{fmt.Errorf("unknown slice set %q", setName)}
+			}
+			sliceKeys = append(sliceKeys, set...)
+			continue
+		}
+		if pkgName, ok := strings.CutSuffix(sliceRef, "_*"); ok {
+			pkgKeys, err := packageSliceKeys(release, pkgName)
+			if err != nil {
+				return nil, err
+			}
+			sliceKeys = append(sliceKeys, pkgKeys...)
+			continue
+		}
+		sliceKey, err := setup.ParseSliceKey(sliceRef)
+		if err != nil {
+			return nil, &usageError{err}
+		}
+		sliceKeys = append(sliceKeys, sliceKey)
+	}
+	return sliceKeys, nil
+}
+
+// packageSliceKeys returns the keys of the slices of the package pkgName
+// in release which are not deprecated, sorted by name. The expanded slices
+// are printed to standard error, so that the selection is not a surprise.
+func packageSliceKeys(release *setup.Release, pkgName string) ([]setup.SliceKey, error) {
+	pkg, ok := release.Packages[pkgName]
+	if !ok {
+		return nil, fmt.Errorf("slices of package %q not found", pkgName)
+	}
+	var names []string
+	for name, slice := range pkg.Slices {
+		if slice.Deprecated == "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("package %q has no slices which are not deprecated", pkgName)
+	}
+	sort.Strings(names)
+	sliceKeys := make([]setup.SliceKey, len(names))
+	for i, name := range names {
+		sliceKeys[i] = setup.SliceKey{Package: pkgName, Slice: name}
+	}
+	fmt.Fprintf(Stderr, "Selected all slices of %s: %s\n", pkgName, strings.Join(names, ", "))
+	return sliceKeys, nil
+}
+
+// readSliceRefs reads slice references from the file at path, or from the
+// standard input if path is "-". References are listed one per line, and
+// empty lines or text following a "#" are ignored.
+func readSliceRefs(path string) ([]string, error) {
+	var r io.Reader
+	if path == "-" {
+		r = Stdin
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read slice list: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	var sliceRefs []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line != "" {
+			sliceRefs = append(sliceRefs, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read slice list: %w", err)
+	}
+	return sliceRefs, nil
+}
+
+// parseTimestamp parses the provided number of seconds since the Unix epoch,
+// falling back to the SOURCE_DATE_EPOCH environment variable if it is empty.
+// The zero time is returned if neither is set.
+func parseTimestamp(timestamp string) (time.Time, error) {
+	if timestamp == "" {
+		timestamp = os.Getenv("SOURCE_DATE_EPOCH")
+		if timestamp == "" {
+			return time.Time{}, nil
+		}
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || seconds < 0 {
+		return time.Time{}, &usageError{fmt.Errorf("invalid timestamp %q, must be a number of seconds since the epoch", timestamp)}
+	}
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
+// archiveLimits restricts the use of the network by all the archives
+// opened, according to --download-limit and --max-connections.
+var archiveLimits *archive.Limits
+
+// parseArchiveLimits returns the limits for the provided download rate and
+// maximum number of connections, or nil if neither is set. The rate is in
+// bytes per second, with an optional K, M or G suffix for binary multiples.
+func parseArchiveLimits(downloadLimit string, maxConnections int) (*archive.Limits, error) {
+	if maxConnections < 0 {
+		return nil, &usageError{fmt.Errorf("invalid maximum number of connections %d, must not be negative", maxConnections)}
+	}
+	var rate int64
+	if downloadLimit != "" {
+		var ok bool
+		rate, ok = parseBytes(downloadLimit)
+		if !ok {
+			return nil, &usageError{fmt.Errorf("invalid download limit %q, must be a positive number of bytes per second (e.g. 500K)", downloadLimit)}
+		}
+	}
+	if rate == 0 && maxConnections == 0 {
+		return nil, nil
+	}
+	return archive.NewLimits(rate, maxConnections), nil
+}
+
+// parseBytes parses a positive number of bytes, with an optional K, M or G
+// suffix for binary multiples.
+func parseBytes(value string) (n int64, ok bool) {
+	if value == "" {
+		return 0, false
+	}
+	digits, unit := value, int64(1)
+	if i := strings.IndexByte("KMG", value[len(value)-1]); i >= 0 {
+		digits = value[:len(value)-1]
+		unit <<= 10 * (i + 1)
+	}
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || n <= 0 || n > math.MaxInt64/unit {
+		return 0, false
+	}
+	return n * unit, true
+}
+
+// caCerts holds the certificates trusted with --ca-cert, in addition to the
+// ones trusted by the system and by each archive.
+var caCerts []byte
+
+// readCACerts returns the PEM-encoded certificates in the file at path, or
+// nil if path is empty.
+func readCACerts(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read CA certificates: %w", err)
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(data) {
+		return nil, &usageError{fmt.Errorf("no valid certificate found in %s", path)}
+	}
+	return data, nil
+}
+
+// invocationTempDir holds the temporary files of the running command, in
+// a single directory created on first use under base, or under the default
+// directory for temporary files if base is empty. It is removed once the
+// command completes or is interrupted.
+var invocationTempDir struct {
+	mu   sync.Mutex
+	base string
+	path string
+}
+
+// setTempDirBase makes the temporary directory of the running command be
+// created under base, which must be an existing directory if not empty.
+func setTempDirBase(base string) error {
+	if base != "" {
+		info, err := os.Stat(base)
+		if err != nil {
+			return &usageError{fmt.Errorf("invalid temporary directory: %w", err)}
+		}
+		if !info.IsDir() {
+			return &usageError{fmt.Errorf("invalid temporary directory %s: not a directory", base)}
+		}
+	}
+	invocationTempDir.mu.Lock()
+	defer invocationTempDir.mu.Unlock()
+	invocationTempDir.base = base
+	return nil
+}
+
+// tempDir returns the temporary directory of the running command, creating
+// it if needed. Files created in it are left for the caller to remove, but
+// anything left behind is removed with the directory.
+func tempDir() (string, error) {
+	invocationTempDir.mu.Lock()
+	defer invocationTempDir.mu.Unlock()
+	if invocationTempDir.path == "" {
+		dir, err := os.MkdirTemp(invocationTempDir.base, "chisel-")
+		if err != nil {
+			return "", fmt.Errorf("cannot create temporary directory: %w", err)
+		}
+		invocationTempDir.path = dir
+	}
+	return invocationTempDir.path, nil
+}
+
+// removeTempDir removes the temporary directory of the running command,
+// if it was created.
+func removeTempDir() error {
+	invocationTempDir.mu.Lock()
+	defer invocationTempDir.mu.Unlock()
+	if invocationTempDir.path == "" {
+		return nil
+	}
+	err := os.RemoveAll(invocationTempDir.path)
+	invocationTempDir.path = ""
+	if err != nil {
+		return fmt.Errorf("cannot remove temporary directory: %w", err)
+	}
+	return nil
+}
+
+// commandContext is done once the running command must stop, as it was
+// interrupted or ran for longer than allowed by --timeout.
+var commandContext = context.Background()
+
+// newCommandContext returns the context of the command about to run, which
+// is cancelled once it is interrupted or terminated, or once timeout elapses
+// if not zero. A second signal removes the temporary directory of the
+// command and exits right away, with the status conventionally used for
+// the signal. The returned function releases the context and stops
+// watching for signals.
+func newCommandContext(timeout time.Duration) (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+		cancelParent := cancel
+		cancel = func() {
+			cancelTimeout()
+			cancelParent()
+		}
+	}
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-signals:
+			logf("Interrupted, stopping...")
+			cancel()
+		case <-done:
+			return
+		}
+		select {
+		case sig := <-signals:
+			removeTempDir()
+			code := 1
+			if s, ok := sig.(syscall.Signal); ok {
+				code = 128 + int(s)
+			}
+			os.Exit(code)
+		case <-done:
+		}
+	}()
+	stop = func() {
+		signal.Stop(signals)
+		close(done)
+		cancel()
+	}
+	return ctx, stop
+}
+
+// cancelledError returns err marked as caused by the cancellation of ctx,
+// if it was indeed caused by it, so that it is reported as such. Errors
+// found before ctx was cancelled, or unrelated to it, are left alone.
+func cancelledError(ctx context.Context, err error, timeout time.Duration) error {
+	if err == nil || ctx.Err() == nil || !errors.Is(err, ctx.Err()) {
+		return err
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &cancelError{fmt.Errorf("timed out after %s: %w", timeout, err)}
+	}
+	return &cancelError{fmt.Errorf("interrupted: %w", err)}
+}
+
+// startProfiling starts writing a CPU profile and an execution trace into
+// the files at cpuPath and tracePath, when they are not empty. The returned
+// function stops both, and writes a heap profile into the file at memPath
+// if it is not empty either.
+func startProfiling(cpuPath, memPath, tracePath string) (stop func() error, err error) {
+	var cpuFile, traceFile *os.File
+	stopAll := func() error {
+		var firstErr error
+		if cpuFile != nil {
+			pprof.StopCPUProfile()
+			firstErr = cpuFile.Close()
+		}
+		if traceFile != nil {
+			trace.Stop()
+			if err := traceFile.Close(); firstErr == nil {
+				firstErr = err
+			}
+		}
+		return firstErr
+	}
+	defer func() {
+		if err != nil {
+			stopAll()
+		}
+	}()
+	if cpuPath != "" {
+		f, err := os.Create(cpuPath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot write CPU profile: %w", err)
+		}
+		err = pprof.StartCPUProfile(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("cannot write CPU profile: %w", err)
+		}
+		cpuFile = f
+	}
+	if tracePath != "" {
+		f, err := os.Create(tracePath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot write execution trace: %w", err)
+		}
+		err = trace.Start(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("cannot write execution trace: %w", err)
+		}
+		traceFile = f
+	}
+	stop = func() error {
+		err := stopAll()
+		if memPath != "" {
+			if memErr := writeHeapProfile(memPath); err == nil {
+				err = memErr
+			}
+		}
+		return err
+	}
+	return stop, nil
+}
+
+// writeHeapProfile writes a profile of the memory in use into the file at
+// path, after a garbage collection so that it is up to date.
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("cannot write memory profile: %w", err)
+	}
+	defer f.Close()
+	runtime.GC()
+	err = pprof.WriteHeapProfile(f)
+	if err != nil {
+		return fmt.Errorf("cannot write memory profile: %w", err)
+	}
+	return f.Close()
+}
+
+// parseKeyFingerprints returns the provided key fingerprints in upper case
+// and without spaces, or a usage error if any of them is not the hex
+// representation of a full fingerprint or of a long key ID.
+func parseKeyFingerprints(values []string) ([]string, error) {
+	fingerprints := make([]string, 0, len(values))
+	for _, value := range values {
+		fingerprint := strings.ToUpper(strings.ReplaceAll(value, " ", ""))
+		_, err := hex.DecodeString(fingerprint)
+		if err != nil || len(fingerprint) != 40 && len(fingerprint) != 16 {
+			return nil, &usageError{fmt.Errorf("invalid trusted key %q, must be a key fingerprint or long key ID", value)}
+		}
+		fingerprints = append(fingerprints, fingerprint)
+	}
+	return fingerprints, nil
+}
+
+// restrictArchiveKeys replaces the archives of release so that only the
+// public keys matching fingerprints, as returned by parseKeyFingerprints,
+// may validate their signatures. It fails if any fingerprint matches no key
+// of the release, or if any archive is left without keys. Nothing is
+// changed if fingerprints is empty.
+func restrictArchiveKeys(release *setup.Release, fingerprints []string) error {
+	if len(fingerprints) == 0 {
+		return nil
+	}
+	used := make(map[string]bool)
+	archiveNames := make([]string, 0, len(release.Archives))
+	for archiveName := range release.Archives {
+		archiveNames = append(archiveNames, archiveName)
+	}
+	sort.Strings(archiveNames)
+	for _, archiveName := range archiveNames {
+		archiveInfo := *release.Archives[archiveName]
+		var pubKeys []*packet.PublicKey
+		var keyIDs []string
+		for _, pubKey := range archiveInfo.PubKeys {
+			keyIDs = append(keyIDs, pubKey.KeyIdString())
+			fingerprint := fmt.Sprintf("%X", pubKey.Fingerprint)
+			for _, trusted := range fingerprints {
+				if trusted == fingerprint || trusted == pubKey.KeyIdString() {
+					used[trusted] = true
+					pubKeys = append(pubKeys, pubKey)
+					break
+				}
+			}
+		}
+		if len(pubKeys) == 0 {
+			return fmt.Errorf("archive %q has no trusted key, its keys are: %s", archiveName, strings.Join(keyIDs, ", "))
+		}
+		archiveInfo.PubKeys = pubKeys
+		release.Archives[archiveName] = &archiveInfo
+	}
+	for _, trusted := range fingerprints {
+		if !used[trusted] {
+			return fmt.Errorf("trusted key %s is not used by any archive of the release", trusted)
+		}
+	}
+	return nil
+}
+
+// progressLogInterval is the interval between progress reports when these
+// are sent to the log rather than to a terminal.
+const progressLogInterval = 5 * time.Second
+
+// progressReporter returns the reporter to be used for long running
+// operations: a progress line when stderr is a terminal, and periodic log
+// messages otherwise. Reports are suppressed entirely if quiet is true.
+func progressReporter(quiet bool) progress.Reporter {
+	switch {
+	case quiet:
+		return progress.Null
+	case isStderrTTY:
+		return progress.NewTerminal(Stderr)
+	default:
+		return progress.NewLog(progressLogInterval)
+	}
+}
+
+// defaultManifestPath is where the cut command writes a manifest with
+// --with-manifest when no directory is provided, relative to the root.
+const defaultManifestPath = "/var/lib/chisel/" + manifest.Filename
+
+// readRootManifest reads the manifest at manifestPath within rootDir, or at
+// defaultManifestPath if manifestPath is empty.
+func readRootManifest(rootDir, manifestPath string) (*manifest.Manifest, error) {
+	if manifestPath == "" {
+		manifestPath = defaultManifestPath
+	}
+	f, err := os.Open(filepath.Join(rootDir, manifestPath))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read manifest: %w", err)
+	}
+	defer f.Close()
+	return manifest.Read(f)
+}
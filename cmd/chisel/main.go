@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"strings"
+	"time"
 	"unicode"
 	"unicode/utf8"
 
@@ -14,9 +16,15 @@ import (
 
 	"github.com/canonical/chisel/internal/archive"
 	"github.com/canonical/chisel/internal/deb"
+	"github.com/canonical/chisel/internal/fsutil"
+	"github.com/canonical/chisel/internal/jsonwall"
+	"github.com/canonical/chisel/internal/logger"
+	"github.com/canonical/chisel/internal/pgputil"
+	"github.com/canonical/chisel/internal/progress"
+	"github.com/canonical/chisel/internal/scripts"
 	"github.com/canonical/chisel/internal/setup"
 	"github.com/canonical/chisel/internal/slicer"
-	//"github.com/canonical/chisel/internal/logger"
+	"github.com/canonical/chisel/internal/strdist"
 )
 
 var (
@@ -31,7 +39,24 @@ var (
 )
 
 type options struct {
-	Version func() `long:"version"`
+	Version   func() `long:"version"`
+	Verbose   bool   `long:"verbose" description:"Log debug messages as well"`
+	Debug     bool   `long:"debug" description:"Log debug messages with their source location"`
+	LogFormat string `long:"log-format" value-name:"<text|json>" description:"Format of log messages (default: text)"`
+	Errors    string `long:"errors" value-name:"<text|json>" description:"Format of the error reported on failure (default: text)"`
+
+	ConflictPolicy string        `long:"conflict-policy" value-name:"<strict|warn>" description:"Fail or warn on slices which may extract different content from different packages into the same path (default: strict)"`
+	ExtraSlices    []string      `long:"extra-slices" value-name:"<dir>" description:"Read additional slice definitions from the given directory, replacing slices of the same name in the release (can be repeated)"`
+	DownloadLimit  string        `long:"download-limit" value-name:"<rate>" description:"Limit downloads from archives to the given bytes per second, with an optional K, M or G suffix (e.g. 500K)"`
+	MaxConnections int           `long:"max-connections" value-name:"<n>" description:"Limit the number of connections to archives open at once"`
+	CACert         string        `long:"ca-cert" value-name:"<file>" description:"Trust the PEM-encoded certificates in the given file when talking to archives and the release repository"`
+	NoVerifyDigest bool          `long:"no-verify-digest" description:"Accept packages which do not match the digest listed in the archive index, such as from local experimental archives"`
+	Timeout        time.Duration `long:"timeout" value-name:"<duration>" description:"Cancel the command if not complete after the given duration (e.g. 10m)"`
+	TmpDir         string        `long:"tmp-dir" value-name:"<dir>" description:"Keep temporary files in a directory created under the given one, removed once the command completes (default: $TMPDIR or /tmp)"`
+
+	CPUProfile string `long:"cpuprofile" value-name:"<file>" hidden:"yes" description:"Write a CPU profile of the command to the given file"`
+	MemProfile string `long:"memprofile" value-name:"<file>" hidden:"yes" description:"Write a memory profile to the given file once the command completes"`
+	Trace      string `long:"trace" value-name:"<file>" hidden:"yes" description:"Write an execution trace of the command to the given file"`
 }
 
 type argDesc struct {
@@ -61,6 +86,10 @@ var commands []*cmdInfo
 // debugCommands holds information about all debug commands.
 var debugCommands []*cmdInfo
 
+// manifestCommands holds information about all sub-commands of the
+// manifest command.
+var manifestCommands []*cmdInfo
+
 // addCommand replaces parser.addCommand() in a way that is compatible with
 // re-constructing a pristine parser.
 func addCommand(name, shortHelp, longHelp string, builder func() flags.Commander, optDescs map[string]string, argDescs []argDesc) *cmdInfo {
@@ -92,6 +121,22 @@ func addDebugCommand(name, shortHelp, longHelp string, builder func() flags.Comm
 	return info
 }
 
+// addManifestCommand replaces parser.addCommand() in a way that is
+// compatible with re-constructing a pristine parser. It is meant for
+// adding sub-commands of the manifest command.
+func addManifestCommand(name, shortHelp, longHelp string, builder func() flags.Commander, optDescs map[string]string, argDescs []argDesc) *cmdInfo {
+	info := &cmdInfo{
+		name:      name,
+		shortHelp: shortHelp,
+		longHelp:  longHelp,
+		builder:   builder,
+		optDescs:  optDescs,
+		argDescs:  argDescs,
+	}
+	manifestCommands = append(manifestCommands, info)
+	return info
+}
+
 type parserSetter interface {
 	setParser(*flags.Parser)
 }
@@ -154,6 +199,7 @@ func fixupArg(optName string) string {
 // Since commands have local state a fresh parser is required to isolate tests
 // from each other.
 func Parser() *flags.Parser {
+	optionsData = options{}
 	optionsData.Version = func() {
 		err := printVersions()
 		if err != nil {
@@ -234,6 +280,12 @@ func Parser() *flags.Parser {
 			c.extra(cmd)
 		}
 	}
+	// Add the manifest command and its sub-commands
+	manifestCommand, err := parser.AddCommand("manifest", shortManifestHelp, longManifestHelp, &cmdManifest{})
+	if err != nil {
+		panicf("cannot add command %q: %v", "manifest", err)
+	}
+	addSubCommands(manifestCommand, manifestCommands)
 	// Add the debug command
 	debugCommand, err := parser.AddCommand("debug", shortDebugHelp, longDebugHelp, &cmdDebug{})
 	debugCommand.Hidden = true
@@ -241,14 +293,21 @@ func Parser() *flags.Parser {
 		panicf("cannot add command %q: %v", "debug", err)
 	}
 	// Add all the sub-commands of the debug command
-	for _, c := range debugCommands {
+	addSubCommands(debugCommand, debugCommands)
+	return parser
+}
+
+// addSubCommands adds the commands described by infos as sub-commands of
+// parent.
+func addSubCommands(parent *flags.Command, infos []*cmdInfo) {
+	for _, c := range infos {
 		obj := c.builder()
 		//if x, ok := obj.(clientSetter); ok {
 		//	x.setClient(cli)
 		//}
-		cmd, err := debugCommand.AddCommand(c.name, c.shortHelp, strings.TrimSpace(c.longHelp), obj)
+		cmd, err := parent.AddCommand(c.name, c.shortHelp, strings.TrimSpace(c.longHelp), obj)
 		if err != nil {
-			panicf("cannot add debug command %q: %v", c.name, err)
+			panicf("cannot add %s command %q: %v", parent.Name, c.name, err)
 		}
 		cmd.Hidden = c.hidden
 		opts := cmd.Options()
@@ -286,12 +345,12 @@ func Parser() *flags.Parser {
 			arg.Description = desc
 		}
 	}
-	return parser
 }
 
 var (
 	isStdinTTY  = term.IsTerminal(0)
 	isStdoutTTY = term.IsTerminal(1)
+	isStderrTTY = term.IsTerminal(2)
 )
 
 func main() {
@@ -305,8 +364,7 @@ func main() {
 	}()
 
 	if err := run(); err != nil {
-		fmt.Fprintf(Stderr, errorPrefix+"%v\n", err)
-		os.Exit(1)
+		os.Exit(printError(Stderr, err, optionsData.Errors))
 	}
 }
 
@@ -323,12 +381,65 @@ func (e *exitStatus) Error() string {
 }
 
 func run() error {
-	archive.SetLogger(log.Default())
-	deb.SetLogger(log.Default())
-	setup.SetLogger(log.Default())
-	slicer.SetLogger(log.Default())
+	setupLogging(log.Default(), false)
 
 	parser := Parser()
+	parser.CommandHandler = func(command flags.Commander, args []string) error {
+		switch optionsData.Errors {
+		case "", "text", "json":
+		default:
+			return &usageError{fmt.Errorf("invalid errors format %q, must be \"text\" or \"json\"", optionsData.Errors)}
+		}
+		switch setup.ConflictPolicy(optionsData.ConflictPolicy) {
+		case "", setup.ConflictStrict, setup.ConflictWarn:
+		default:
+			return &usageError{fmt.Errorf("invalid conflict policy %q, must be \"strict\" or \"warn\"", optionsData.ConflictPolicy)}
+		}
+		limits, err := parseArchiveLimits(optionsData.DownloadLimit, optionsData.MaxConnections)
+		if err != nil {
+			return err
+		}
+		archiveLimits = limits
+		caCerts, err = readCACerts(optionsData.CACert)
+		if err != nil {
+			return err
+		}
+		l, err := logger.New(Stderr, &logger.Options{
+			Format: logger.Format(optionsData.LogFormat),
+			Caller: optionsData.Debug,
+		})
+		if err != nil {
+			return &usageError{err}
+		}
+		setupLogging(l, optionsData.Verbose || optionsData.Debug)
+		err = setTempDirBase(optionsData.TmpDir)
+		if err != nil {
+			return err
+		}
+		if optionsData.Timeout < 0 {
+			return &usageError{fmt.Errorf("invalid timeout %s, must not be negative", optionsData.Timeout)}
+		}
+		if command == nil {
+			return nil
+		}
+		stopProfiling, err := startProfiling(optionsData.CPUProfile, optionsData.MemProfile, optionsData.Trace)
+		if err != nil {
+			return err
+		}
+		ctx, stopContext := newCommandContext(optionsData.Timeout)
+		commandContext = ctx
+		err = command.Execute(args)
+		err = cancelledError(ctx, err, optionsData.Timeout)
+		stopContext()
+		commandContext = context.Background()
+		if removeErr := removeTempDir(); err == nil {
+			err = removeErr
+		}
+		if stopErr := stopProfiling(); err == nil {
+			err = stopErr
+		}
+		return err
+	}
 	xtra, err := parser.Parse()
 	if err != nil {
 		if e, ok := err.(*flags.Error); ok {
@@ -348,7 +459,7 @@ func run() error {
 						sug = "chisel help " + x.Name
 					}
 				}
-				return fmt.Errorf("unknown command %q, see '%s'.", sub, sug)
+				return &usageError{fmt.Errorf("unknown command %q, see '%s'.", sub, sug)}
 			}
 		}
 		return err
@@ -358,3 +469,30 @@ func run() error {
 }
 
 var errorPrefix = "error: "
+
+// setupLogging plugs l into all the packages that log messages, enabling the
+// delivery of debug messages if debug is true.
+func setupLogging(l logger.Outputter, debug bool) {
+	SetLogger(l)
+	SetDebug(debug)
+	archive.SetLogger(l)
+	archive.SetDebug(debug)
+	deb.SetLogger(l)
+	deb.SetDebug(debug)
+	fsutil.SetLogger(l)
+	fsutil.SetDebug(debug)
+	jsonwall.SetLogger(l)
+	jsonwall.SetDebug(debug)
+	pgputil.SetLogger(l)
+	pgputil.SetDebug(debug)
+	progress.SetLogger(l)
+	progress.SetDebug(debug)
+	scripts.SetLogger(l)
+	scripts.SetDebug(debug)
+	setup.SetLogger(l)
+	setup.SetDebug(debug)
+	slicer.SetLogger(l)
+	slicer.SetDebug(debug)
+	strdist.SetLogger(l)
+	strdist.SetDebug(debug)
+}
@@ -2,8 +2,15 @@ package main_test
 
 import (
 	"bytes"
+	"context"
+	"encoding/pem"
+	"fmt"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"golang.org/x/term"
 	. "gopkg.in/check.v1"
@@ -80,3 +87,241 @@ type ChiselSuite struct {
 }
 
 var _ = Suite(&ChiselSuite{})
+
+func (s *ChiselSuite) TestLogFormat(c *C) {
+	restore := fakeVersion("4.56")
+	defer restore()
+	defer fakeArgs("chisel", "--log-format", "json", "version")()
+
+	err := chisel.RunMain()
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, "4.56\n")
+
+	s.ResetStdStreams()
+	defer fakeArgs("chisel", "--log-format", "xml", "version")()
+
+	err = chisel.RunMain()
+	c.Assert(err, ErrorMatches, `invalid log format "xml", must be "text" or "json"`)
+	c.Assert(s.Stdout(), Equals, "")
+}
+
+var archiveLimitsTests = []struct {
+	summary string
+	args    []string
+	error   string
+}{{
+	summary: "Download limit with suffix",
+	args:    []string{"--download-limit", "500K"},
+}, {
+	summary: "Download limit in bytes",
+	args:    []string{"--download-limit", "1000"},
+}, {
+	summary: "Maximum number of connections",
+	args:    []string{"--max-connections", "2", "--download-limit", "2M"},
+}, {
+	summary: "Invalid download limit suffix",
+	args:    []string{"--download-limit", "5T"},
+	error:   `invalid download limit "5T", must be a positive number of bytes per second \(e.g. 500K\)`,
+}, {
+	summary: "Zero download limit",
+	args:    []string{"--download-limit", "0"},
+	error:   `invalid download limit "0", .*`,
+}, {
+	summary: "Download limit too large",
+	args:    []string{"--download-limit", "9999999999999G"},
+	error:   `invalid download limit "9999999999999G", .*`,
+}, {
+	summary: "Negative number of connections",
+	args:    []string{"--max-connections", "-1"},
+	error:   `invalid maximum number of connections -1, must not be negative`,
+}}
+
+func (s *ChiselSuite) TestArchiveLimits(c *C) {
+	restore := fakeVersion("4.56")
+	defer restore()
+	for _, test := range archiveLimitsTests {
+		c.Logf("Summary: %s", test.summary)
+		s.ResetStdStreams()
+		restore := fakeArgs(append(append([]string{"chisel"}, test.args...), "version")...)
+		err := chisel.RunMain()
+		restore()
+		if test.error != "" {
+			c.Assert(err, ErrorMatches, test.error)
+			continue
+		}
+		c.Assert(err, IsNil)
+		c.Assert(s.Stdout(), Equals, "4.56\n")
+	}
+}
+
+func (s *ChiselSuite) TestTempDir(c *C) {
+	base := c.MkDir()
+	err := chisel.SetTempDirBase(base)
+	c.Assert(err, IsNil)
+	defer chisel.SetTempDirBase("")
+
+	dir, err := chisel.TempDir()
+	c.Assert(err, IsNil)
+	c.Assert(filepath.Dir(dir), Equals, base)
+	c.Assert(strings.HasPrefix(filepath.Base(dir), "chisel-"), Equals, true)
+	err = os.WriteFile(filepath.Join(dir, "file"), nil, 0644)
+	c.Assert(err, IsNil)
+
+	// The same directory is used for the whole invocation.
+	again, err := chisel.TempDir()
+	c.Assert(err, IsNil)
+	c.Assert(again, Equals, dir)
+
+	err = chisel.RemoveTempDir()
+	c.Assert(err, IsNil)
+	entries, err := os.ReadDir(base)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 0)
+
+	// Nothing to remove once removed.
+	err = chisel.RemoveTempDir()
+	c.Assert(err, IsNil)
+}
+
+func (s *ChiselSuite) TestTempDirOption(c *C) {
+	restore := fakeVersion("4.56")
+	defer restore()
+	defer chisel.SetTempDirBase("")
+
+	dir := c.MkDir()
+	defer fakeArgs("chisel", "--tmp-dir", dir, "version")()
+	err := chisel.RunMain()
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, "4.56\n")
+	entries, err := os.ReadDir(dir)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 0)
+
+	file := filepath.Join(dir, "file")
+	err = os.WriteFile(file, nil, 0644)
+	c.Assert(err, IsNil)
+	defer fakeArgs("chisel", "--tmp-dir", file, "version")()
+	err = chisel.RunMain()
+	c.Assert(err, ErrorMatches, `invalid temporary directory .*/file: not a directory`)
+
+	defer fakeArgs("chisel", "--tmp-dir", filepath.Join(dir, "missing"), "version")()
+	err = chisel.RunMain()
+	c.Assert(err, ErrorMatches, `invalid temporary directory: .*/missing: no such file or directory`)
+}
+
+func (s *ChiselSuite) TestCommandContextInterrupt(c *C) {
+	ctx, stop := chisel.NewCommandContext(0)
+	defer stop()
+	p, err := os.FindProcess(os.Getpid())
+	c.Assert(err, IsNil)
+	err = p.Signal(os.Interrupt)
+	if err != nil {
+		c.Skip("cannot interrupt the process: " + err.Error())
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		c.Fatalf("command context not cancelled on interrupt")
+	}
+	err = chisel.CancelledError(ctx, fmt.Errorf("boom: %w", context.Canceled), 0)
+	c.Assert(err, ErrorMatches, "interrupted: boom: context canceled")
+}
+
+func (s *ChiselSuite) TestCommandContext(c *C) {
+	ctx, stop := chisel.NewCommandContext(time.Millisecond)
+	defer stop()
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		c.Fatalf("command context not cancelled on timeout")
+	}
+	err := chisel.CancelledError(ctx, fmt.Errorf("boom: %w", context.DeadlineExceeded), time.Millisecond)
+	c.Assert(err, ErrorMatches, "timed out after 1ms: boom: context deadline exceeded")
+	c.Assert(chisel.CancelledError(ctx, nil, time.Millisecond), IsNil)
+
+	// Errors are left alone unless caused by the cancellation.
+	err = chisel.CancelledError(ctx, fmt.Errorf("boom"), time.Millisecond)
+	c.Assert(err, ErrorMatches, "boom")
+	err = chisel.CancelledError(context.Background(), fmt.Errorf("boom: %w", context.Canceled), 0)
+	c.Assert(err, ErrorMatches, "boom: context canceled")
+}
+
+func (s *ChiselSuite) TestTimeoutOption(c *C) {
+	restore := fakeVersion("4.56")
+	defer restore()
+
+	defer fakeArgs("chisel", "--timeout", "10m", "version")()
+	err := chisel.RunMain()
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, "4.56\n")
+
+	defer fakeArgs("chisel", "--timeout", "-1s", "version")()
+	err = chisel.RunMain()
+	c.Assert(err, ErrorMatches, `invalid timeout -1s, must not be negative`)
+}
+
+func (s *ChiselSuite) TestCACert(c *C) {
+	restore := fakeVersion("4.56")
+	defer restore()
+
+	server := httptest.NewTLSServer(nil)
+	server.Close()
+	dir := c.MkDir()
+	validPath := filepath.Join(dir, "valid.pem")
+	err := os.WriteFile(validPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644)
+	c.Assert(err, IsNil)
+	invalidPath := filepath.Join(dir, "invalid.pem")
+	err = os.WriteFile(invalidPath, []byte("invalid"), 0644)
+	c.Assert(err, IsNil)
+
+	defer fakeArgs("chisel", "--ca-cert", validPath, "version")()
+	err = chisel.RunMain()
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, "4.56\n")
+
+	defer fakeArgs("chisel", "--ca-cert", invalidPath, "version")()
+	err = chisel.RunMain()
+	c.Assert(err, ErrorMatches, `no valid certificate found in .*/invalid.pem`)
+
+	defer fakeArgs("chisel", "--ca-cert", filepath.Join(dir, "missing.pem"), "version")()
+	err = chisel.RunMain()
+	c.Assert(err, ErrorMatches, `cannot read CA certificates: open .*/missing.pem: no such file or directory`)
+}
+
+func (s *ChiselSuite) TestProfiling(c *C) {
+	restore := fakeVersion("4.56")
+	defer restore()
+
+	dir := c.MkDir()
+	paths := []string{
+		filepath.Join(dir, "cpu.prof"),
+		filepath.Join(dir, "mem.prof"),
+		filepath.Join(dir, "trace.out"),
+	}
+	defer fakeArgs("chisel", "--cpuprofile", paths[0], "--memprofile", paths[1], "--trace", paths[2], "version")()
+	err := chisel.RunMain()
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Equals, "4.56\n")
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		c.Assert(err, IsNil)
+		c.Assert(info.Size() > 0, Equals, true, Commentf("%s", path))
+	}
+
+	defer fakeArgs("chisel", "--cpuprofile", filepath.Join(dir, "missing/cpu.prof"), "version")()
+	err = chisel.RunMain()
+	c.Assert(err, ErrorMatches, `cannot write CPU profile: open .*/missing/cpu.prof: no such file or directory`)
+
+	// The options are not listed in the help.
+	s.ResetStdStreams()
+	defer fakeArgs("chisel", "--help")()
+	err = chisel.RunMain()
+	c.Assert(err, IsNil)
+	c.Assert(s.Stdout(), Not(Matches), `(?s).*profile.*`)
+}
+
+func fakeArgs(args ...string) (restore func()) {
+	old := os.Args
+	os.Args = args
+	return func() { os.Args = old }
+}
@@ -1,11 +1,21 @@
 package archive
 
 import (
+	"bufio"
 	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"path"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/crypto/openpgp/packet"
@@ -14,12 +24,65 @@ import (
 	"github.com/canonical/chisel/internal/control"
 	"github.com/canonical/chisel/internal/deb"
 	"github.com/canonical/chisel/internal/pgputil"
+	"github.com/canonical/chisel/internal/progress"
 )
 
 type Archive interface {
 	Options() *Options
 	Fetch(pkg string) (io.ReadCloser, error)
 	Exists(pkg string) bool
+	// Packages returns the names of all the packages in the archive for
+	// its architecture, sorted.
+	Packages() []string
+}
+
+// PackageInformer is implemented by archives which can tell the details of
+// their packages as listed in the archive index.
+type PackageInformer interface {
+	Info(pkg string) (*PackageInfo, error)
+}
+
+// Info returns the details of pkg as listed in the index of archive a, which
+// must implement PackageInformer.
+func Info(a Archive, pkg string) (*PackageInfo, error) {
+	informer, ok := a.(PackageInformer)
+	if !ok {
+		return nil, fmt.Errorf("archive %q cannot tell package details", a.Options().Label)
+	}
+	return informer.Info(pkg)
+}
+
+// PackageInfo holds the details of a package as listed in the archive index.
+type PackageInfo struct {
+	Name    string
+	Version string
+	Arch    string
+	SHA256  string
+	// Size is the size in bytes of the package file itself.
+	Size int64
+	// InstalledSize is the approximate size in bytes taken by the package
+	// contents once fully installed.
+	InstalledSize int64
+	// Suite and Component identify the index the package was selected
+	// from, according to the suite policy of the archive.
+	Suite     string
+	Component string
+	// Description is the description of the package, whose first line
+	// summarizes it.
+	Description string
+
+	// section holds every field of the package in the index, including
+	// the ones not exposed above.
+	section control.Section
+}
+
+// Get returns the value of field in the index entry of the package, such
+// as "Homepage" or "Built-Using", or "" if the field is not present.
+func (info *PackageInfo) Get(field string) string {
+	if info.section == nil {
+		return ""
+	}
+	return info.section.Get(field)
 }
 
 type Options struct {
@@ -30,8 +93,140 @@ type Options struct {
 	Components []string
 	CacheDir   string
 	PubKeys    []*packet.PublicKey
+	// SuitePolicy defines which suite a package is selected from when
+	// several of them provide it. It defaults to HighestVersion.
+	SuitePolicy string
+	// Progress, if set, is notified about the progress of downloads.
+	Progress progress.Reporter
+	// Stats, if set, accumulates statistics about the data fetched.
+	Stats *Stats
+	// Limits, if set, restricts the use of the network.
+	Limits *Limits
+	// CACerts holds PEM-encoded certificates trusted when talking to the
+	// archive over TLS, in addition to the ones trusted by the system.
+	CACerts []byte
+	// NoVerifyDigest accepts fetched packages which do not match the
+	// SHA256 digest listed for them in the archive index, or which have
+	// none, as may happen with local experimental repositories.
+	NoVerifyDigest bool
+	// Context, if set, cancels the requests in progress once done, and
+	// prevents new ones from being made.
+	Context context.Context
+}
+
+// Limits restricts the use of the network by the archives sharing it, so
+// that fetching does not saturate constrained or shared links.
+type Limits struct {
+	conns chan struct{}
+	rate  int64
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// NewLimits returns Limits allowing at most maxConnections requests at once
+// and downloading at most bytesPerSecond, across all the archives sharing
+// them. Zero disables either limit.
+func NewLimits(bytesPerSecond int64, maxConnections int) *Limits {
+	l := &Limits{rate: bytesPerSecond}
+	if maxConnections > 0 {
+		l.conns = make(chan struct{}, maxConnections)
+	}
+	return l
+}
+
+// acquire waits until a new connection is allowed, and returns the function
+// to call once it is closed. It fails if ctx is done first.
+func (l *Limits) acquire(ctx context.Context) (release func(), err error) {
+	if l == nil || l.conns == nil {
+		return func() {}, nil
+	}
+	select {
+	case l.conns <- struct{}{}:
+		return func() { <-l.conns }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// throttled returns whether downloads are limited to some rate.
+func (l *Limits) throttled() bool {
+	return l != nil && l.rate > 0
+}
+
+// throttle waits as long as needed to keep the rate of downloads within
+// the limit, once n more bytes were downloaded. It fails if ctx is done
+// before the wait is over.
+func (l *Limits) throttle(ctx context.Context, n int) error {
+	if l == nil || l.rate <= 0 || n <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	now := timeNow()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+	l.mu.Unlock()
+	if wait > 0 {
+		return sleep(ctx, wait)
+	}
+	return nil
+}
+
+var timeNow = time.Now
+
+var sleep = func(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats accumulates statistics about the data fetched by archives, and may
+// be shared by several of them. Data is only looked up in the cache when
+// its digest is known, so InRelease files are downloaded without counting
+// as cache misses.
+type Stats struct {
+	CacheHits   atomic.Int64
+	CacheMisses atomic.Int64
+	// Downloaded is the number of bytes downloaded, as transferred.
+	Downloaded atomic.Int64
+}
+
+func (s *Stats) addLookup(hit bool) {
+	if s == nil {
+		return
+	}
+	if hit {
+		s.CacheHits.Add(1)
+	} else {
+		s.CacheMisses.Add(1)
+	}
+}
+
+func (s *Stats) addDownloaded(n int64) {
+	if s != nil {
+		s.Downloaded.Add(n)
+	}
 }
 
+// Policies for selecting a package provided by several suites of an archive.
+const (
+	// HighestVersion selects the highest version of the package across all
+	// suites and components.
+	HighestVersion = "highest-version"
+	// FirstMatch selects the package from the first suite listing it, in
+	// the order the suites were provided, and from the first component
+	// within that suite.
+	FirstMatch = "first-match"
+)
+
 func Open(options *Options) (Archive, error) {
 	var err error
 	if options.Arch == "" {
@@ -45,6 +240,16 @@ func Open(options *Options) (Archive, error) {
 	return openUbuntu(options)
 }
 
+// VerifyError is returned when data obtained from the archive doesn't match
+// the expected signatures or digests.
+type VerifyError struct {
+	msg string
+	err error
+}
+
+func (e *VerifyError) Error() string { return e.msg }
+func (e *VerifyError) Unwrap() error { return e.err }
+
 type fetchFlags uint
 
 const (
@@ -53,22 +258,74 @@ const (
 )
 
 var httpClient = &http.Client{
-	Timeout: 30 * time.Second,
+	Timeout:   30 * time.Second,
+	Transport: newTransport(nil),
 }
 
 var httpDo = httpClient.Do
 
 var bulkClient = &http.Client{
-	Timeout: 5 * time.Minute,
+	Timeout:   5 * time.Minute,
+	Transport: newTransport(nil),
 }
 
 var bulkDo = bulkClient.Do
 
+// throttledClient is used instead of the other clients when downloads are
+// throttled, as they may then take any time to complete. Its requests are
+// only bounded by their context, by the time taken to receive the response
+// headers, and by idleTimeout while reading the response body.
+var throttledClient = &http.Client{
+	Transport: newTransport(nil),
+}
+
+var throttledDo = throttledClient.Do
+
+// headerTimeout is how long archives may take to send the headers of their
+// responses.
+const headerTimeout = 30 * time.Second
+
+// idleTimeout is how long throttled downloads may go without receiving any
+// data before they are abandoned.
+var idleTimeout = 30 * time.Second
+
+// newTransport returns the transport used to talk to archives, which goes
+// through the proxies set in the HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+// environment variables and trusts rootCAs, or the system certificates if
+// nil.
+func newTransport(rootCAs *x509.CertPool) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	transport.ResponseHeaderTimeout = headerTimeout
+	if rootCAs != nil {
+		transport.TLSClientConfig = &tls.Config{RootCAs: rootCAs}
+	}
+	return transport
+}
+
 type ubuntuArchive struct {
 	options Options
 	indexes []*ubuntuIndex
 	cache   *cache.Cache
 	pubKeys []*packet.PublicKey
+	// transport is used instead of the default one when the archive
+	// trusts additional certificates.
+	transport http.RoundTripper
+}
+
+// do sends req with the client appropriate for flags, or with the one for
+// throttled downloads if the archive has a download limit.
+func (a *ubuntuArchive) do(req *http.Request, flags fetchFlags) (*http.Response, error) {
+	client, do := httpClient, httpDo
+	if a.options.Limits.throttled() {
+		client, do = throttledClient, throttledDo
+	} else if flags&fetchBulk != 0 {
+		client, do = bulkClient, bulkDo
+	}
+	if a.transport == nil {
+		return do(req)
+	}
+	return (&http.Client{Timeout: client.Timeout, Transport: a.transport}).Do(req)
 }
 
 type ubuntuIndex struct {
@@ -79,7 +336,11 @@ type ubuntuIndex struct {
 	component string
 	release   control.Section
 	packages  control.File
-	archive   *ubuntuArchive
+	// allPackages holds the packages of architecture "all" when the archive
+	// lists them in a separate index rather than in the one of each
+	// architecture.
+	allPackages control.File
+	archive     *ubuntuArchive
 }
 
 func (a *ubuntuArchive) Options() *Options {
@@ -91,19 +352,93 @@ func (a *ubuntuArchive) Exists(pkg string) bool {
 	return err == nil
 }
 
+func (a *ubuntuArchive) Info(pkg string) (*PackageInfo, error) {
+	section, index, err := a.selectPackage(pkg)
+	if err != nil {
+		return nil, err
+	}
+	info, err := NewPackageInfo(section)
+	if err != nil {
+		return nil, err
+	}
+	info.Suite = index.suite
+	info.Component = index.component
+	return info, nil
+}
+
+// NewPackageInfo returns the information on a package from its entry in a
+// package index, leaving Suite and Component unset.
+func NewPackageInfo(section control.Section) (*PackageInfo, error) {
+	pkg := section.Get("Package")
+	info := &PackageInfo{
+		Name:        pkg,
+		Version:     section.Get("Version"),
+		Arch:        section.Get("Architecture"),
+		SHA256:      section.Get("SHA256"),
+		Description: section.Get("Description"),
+		section:     section,
+	}
+	if size := section.Get("Size"); size != "" {
+		var err error
+		info.Size, err = strconv.ParseInt(size, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size for package %q in archive: %q", pkg, size)
+		}
+	}
+	// Installed-Size is expressed in KiB, as documented in deb-control(5).
+	if size := section.Get("Installed-Size"); size != "" {
+		kib, err := strconv.ParseInt(size, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid installed size for package %q in archive: %q", pkg, size)
+		}
+		info.InstalledSize = kib * 1024
+	}
+	return info, nil
+}
+
+func (a *ubuntuArchive) Packages() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, index := range a.indexes {
+		for _, packages := range []control.File{index.packages, index.allPackages} {
+			if packages == nil {
+				continue
+			}
+			for _, name := range packages.Keys() {
+				if !seen[name] {
+					seen[name] = true
+					names = append(names, name)
+				}
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// selectPackage returns the section describing pkg and the index it was
+// found in, following the suite policy of the archive.
 func (a *ubuntuArchive) selectPackage(pkg string) (control.Section, *ubuntuIndex, error) {
 	var selectedVersion string
 	var selectedSection control.Section
 	var selectedIndex *ubuntuIndex
 	for _, index := range a.indexes {
-		section := index.packages.Section(pkg)
-		if section != nil && section.Get("Filename") != "" {
-			version := section.Get("Version")
-			if selectedVersion == "" || deb.CompareVersions(selectedVersion, version) < 0 {
-				selectedVersion = version
-				selectedSection = section
-				selectedIndex = index
+		for _, packages := range []control.File{index.packages, index.allPackages} {
+			if packages == nil {
+				continue
 			}
+			section := packages.Section(pkg)
+			if section != nil && section.Get("Filename") != "" {
+				version := section.Get("Version")
+				if selectedVersion == "" || deb.CompareVersions(selectedVersion, version) < 0 {
+					selectedVersion = version
+					selectedSection = section
+					selectedIndex = index
+				}
+			}
+		}
+		if selectedVersion != "" && a.options.SuitePolicy == FirstMatch {
+			break
 		}
 	}
 	if selectedVersion == "" {
@@ -117,17 +452,36 @@ func (a *ubuntuArchive) Fetch(pkg string) (io.ReadCloser, error) {
 	if err != nil {
 		return nil, err
 	}
-	suffix := section.Get("Filename")
-	logf("Fetching %s...", suffix)
-	reader, err := index.fetch("../../"+suffix, section.Get("SHA256"), fetchBulk)
+	suffix := "../../" + section.Get("Filename")
+	logf("Fetching %s...", section.Get("Filename"))
+	digest := section.Get("SHA256")
+	if a.options.NoVerifyDigest {
+		// Content cached under the listed digest is known to match it.
+		reader, err := a.cache.Open(digest)
+		if err == nil {
+			a.options.Stats.addLookup(true)
+			return reader, nil
+		}
+		logf("Warning: not verifying digest of package %q", pkg)
+		return index.fetch(suffix, "", fetchBulk)
+	}
+	if digest == "" {
+		return nil, &VerifyError{msg: fmt.Sprintf("cannot verify package %q fetched from %s: no sha256 digest in archive index", pkg, index.url(suffix))}
+	}
+	reader, err := index.fetch(suffix, digest, fetchBulk)
 	if err != nil {
+		var digestErr *cache.DigestError
+		if errors.As(err, &digestErr) {
+			msg := fmt.Sprintf("cannot verify package %q fetched from %s: expected sha256 %s, got %s", pkg, index.url(suffix), digestErr.Expected, digestErr.Got)
+			return nil, &VerifyError{msg: msg, err: digestErr}
+		}
 		return nil, err
 	}
 	return reader, nil
 }
 
-const ubuntuURL = "http://archive.ubuntu.com/ubuntu/"
-const ubuntuPortsURL = "http://ports.ubuntu.com/ubuntu-ports/"
+var ubuntuURL = "http://archive.ubuntu.com/ubuntu/"
+var ubuntuPortsURL = "http://ports.ubuntu.com/ubuntu-ports/"
 
 func openUbuntu(options *Options) (Archive, error) {
 	if len(options.Components) == 0 {
@@ -139,6 +493,13 @@ func openUbuntu(options *Options) (Archive, error) {
 	if len(options.Version) == 0 {
 		return nil, fmt.Errorf("archive options missing version")
 	}
+	switch options.SuitePolicy {
+	case "":
+		options.SuitePolicy = HighestVersion
+	case HighestVersion, FirstMatch:
+	default:
+		return nil, fmt.Errorf("archive options have invalid suite policy: %q", options.SuitePolicy)
+	}
 
 	archive := &ubuntuArchive{
 		options: *options,
@@ -147,6 +508,19 @@ func openUbuntu(options *Options) (Archive, error) {
 		},
 		pubKeys: options.PubKeys,
 	}
+	if archive.options.Context == nil {
+		archive.options.Context = context.Background()
+	}
+	if len(options.CACerts) > 0 {
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil {
+			rootCAs = x509.NewCertPool()
+		}
+		if !rootCAs.AppendCertsFromPEM(options.CACerts) {
+			return nil, fmt.Errorf("archive options have no valid CA certificates")
+		}
+		archive.transport = newTransport(rootCAs)
+	}
 
 	for _, suite := range options.Suites {
 		var release control.Section
@@ -170,6 +544,10 @@ func openUbuntu(options *Options) (Archive, error) {
 				if err != nil {
 					return nil, err
 				}
+				err = index.checkArch()
+				if err != nil {
+					return nil, err
+				}
 			}
 			err := index.fetchIndex()
 			if err != nil {
@@ -195,19 +573,9 @@ func (index *ubuntuIndex) fetchRelease() error {
 		return err
 	}
 
-	// Decode the signature(s) and verify the InRelease file. The InRelease
-	// file may have multiple signatures from different keys. Verify that at
-	// least one signature is valid against the archive's set of public keys.
-	// Unlike gpg --verify which ensures the verification of all signatures,
-	// this is in line with what apt does internally:
-	// https://salsa.debian.org/apt-team/apt/-/blob/4e344a4/methods/gpgv.cc#L553-557
-	sigs, canonicalBody, err := pgputil.DecodeClearSigned(data)
-	if err != nil {
-		return fmt.Errorf("cannot decode clearsigned InRelease file: %v", err)
-	}
-	err = pgputil.VerifyAnySignature(index.archive.pubKeys, sigs, canonicalBody)
+	canonicalBody, err := verifyInRelease(data, index.archive.pubKeys)
 	if err != nil {
-		return fmt.Errorf("cannot verify signature of the InRelease file")
+		return err
 	}
 
 	// canonicalBody has <CR><LF> line endings, reverting that to match the
@@ -230,28 +598,119 @@ func (index *ubuntuIndex) fetchRelease() error {
 	return nil
 }
 
+// verifyInRelease verifies the signature of the InRelease file in data and
+// returns its canonical body.
+func verifyInRelease(data []byte, pubKeys []*packet.PublicKey) ([]byte, error) {
+	// Decode the signature(s) and verify the InRelease file. The InRelease
+	// file may have multiple signatures from different keys. Verify that at
+	// least one signature is valid against the archive's set of public keys.
+	// Unlike gpg --verify which ensures the verification of all signatures,
+	// this is in line with what apt does internally:
+	// https://salsa.debian.org/apt-team/apt/-/blob/4e344a4/methods/gpgv.cc#L553-557
+	sigs, canonicalBody, err := pgputil.DecodeClearSigned(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode clearsigned InRelease file: %v", err)
+	}
+	err = pgputil.VerifyAnySignature(pubKeys, sigs, canonicalBody)
+	if err != nil {
+		return nil, &VerifyError{msg: "cannot verify signature of the InRelease file", err: err}
+	}
+	return canonicalBody, nil
+}
+
+// clearSignedHeader starts every InRelease file.
+const clearSignedHeader = "-----BEGIN PGP SIGNED MESSAGE-----"
+
+// VerifyCache checks the integrity of the archive data cached in cacheDir.
+// Cached packages and indexes whose content doesn't match their digest are
+// removed, as are the InRelease files whose signature cannot be verified
+// with any of pubKeys, so that they are fetched again when next needed.
+func VerifyCache(cacheDir string, pubKeys []*packet.PublicKey) (checked int, bad []cache.BadEntry, err error) {
+	c := &cache.Cache{Dir: cacheDir}
+	return c.Verify(func(digest string, r io.Reader) error {
+		br := bufio.NewReader(r)
+		header, _ := br.Peek(len(clearSignedHeader))
+		if string(header) != clearSignedHeader {
+			return nil
+		}
+		data, err := io.ReadAll(br)
+		if err != nil {
+			return err
+		}
+		_, err = verifyInRelease(data, pubKeys)
+		return err
+	})
+}
+
 func (index *ubuntuIndex) fetchIndex() error {
+	logf("Fetching index for %s %s %s %s component...", index.label, index.version, index.suite, index.component)
+	packages, err := index.fetchPackages(index.arch)
+	if err != nil {
+		return err
+	}
+	index.packages = packages
+
+	// Archives may either list packages of architecture "all" in the index
+	// of every architecture, or only in a separate one which is announced
+	// by the presence of a binary-all index in the release file.
+	if index.release.Get("No-Support-for-Architecture-all") == "Packages" {
+		return nil
+	}
+	allPath := fmt.Sprintf("%s/binary-all/Packages", index.component)
+	if digest, _, _ := control.ParsePathInfo(index.release.Get("SHA256"), allPath); digest == "" {
+		return nil
+	}
+	index.allPackages, err = index.fetchPackages("all")
+	return err
+}
+
+func (index *ubuntuIndex) fetchPackages(arch string) (control.File, error) {
 	digests := index.release.Get("SHA256")
-	packagesPath := fmt.Sprintf("%s/binary-%s/Packages", index.component, index.arch)
+	packagesPath := fmt.Sprintf("%s/binary-%s/Packages", index.component, arch)
 	digest, _, _ := control.ParsePathInfo(digests, packagesPath)
 	if digest == "" {
-		return fmt.Errorf("%s is missing from %s %s component digests", packagesPath, index.suite, index.component)
+		return nil, fmt.Errorf("%s is missing from %s %s component digests", packagesPath, index.suite, index.component)
 	}
 
-	logf("Fetching index for %s %s %s %s component...", index.label, index.version, index.suite, index.component)
 	reader, err := index.fetch(packagesPath+".gz", digest, fetchBulk)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	ctrl, err := control.ParseReader("Package", reader)
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
 	if err != nil {
-		return fmt.Errorf("parsing archive Package file: %v", err)
+		return nil, fmt.Errorf("cannot read archive Package file: %v", err)
 	}
-
-	index.packages = ctrl
-	return nil
+	content := string(data)
+
+	// Scanning large indexes takes a while, so the position of their
+	// sections is cached next to them for later runs.
+	cache := index.archive.cache
+	if ctrlIndex, err := cache.ReadDerived(digest, packagesIndexName); err == nil {
+		ctrl, err := control.ParseStringIndexed("Package", content, ctrlIndex)
+		if err == nil {
+			return ctrl, nil
+		}
+		debugf("Cannot use cached index of %s: %v", packagesPath, err)
+	}
+	ctrl, err := control.ParseString("Package", content)
+	if err != nil {
+		return nil, fmt.Errorf("parsing archive Package file: %v", err)
+	}
+	ctrlIndex, err := control.Index(ctrl)
+	if err == nil {
+		err = cache.WriteDerived(digest, packagesIndexName, ctrlIndex)
+	}
+	if err != nil {
+		debugf("Cannot cache index of %s: %v", packagesPath, err)
+	}
+	return ctrl, nil
 }
 
+// packagesIndexName is the name under which the index of the sections of
+// Packages files is cached.
+const packagesIndexName = "control-index"
+
 func (index *ubuntuIndex) checkComponents(components []string) error {
 	releaseComponents := strings.Fields(index.release.Get("Components"))
 	for _, c1 := range components {
@@ -269,38 +728,50 @@ func (index *ubuntuIndex) checkComponents(components []string) error {
 	return nil
 }
 
+// checkArch ensures the archive publishes packages for the architecture of
+// index, according to the Architectures field of the release file. Archives
+// which do not list their architectures are trusted to have it.
+func (index *ubuntuIndex) checkArch() error {
+	archs := strings.Fields(index.release.Get("Architectures"))
+	if len(archs) == 0 {
+		return nil
+	}
+	for _, arch := range archs {
+		if arch == index.arch {
+			return nil
+		}
+	}
+	return fmt.Errorf("archive has no packages for architecture %q, available: %s", index.arch, strings.Join(archs, ", "))
+}
+
 func (index *ubuntuIndex) fetch(suffix, digest string, flags fetchFlags) (io.ReadCloser, error) {
+	stats := index.archive.options.Stats
 	reader, err := index.archive.cache.Open(digest)
 	if err == nil {
+		stats.addLookup(true)
 		return reader, nil
 	} else if err != cache.MissErr {
 		return nil, err
 	}
-
-	baseURL := ubuntuURL
-	if index.arch != "amd64" && index.arch != "i386" {
-		baseURL = ubuntuPortsURL
+	if digest != "" {
+		stats.addLookup(false)
 	}
 
-	var url string
-	if strings.HasPrefix(suffix, "pool/") {
-		url = baseURL + suffix
-	} else {
-		url = baseURL + "dists/" + index.suite + "/" + suffix
-	}
-
-	req, err := http.NewRequest("GET", url, nil)
+	ctx, cancel := context.WithCancelCause(index.archive.options.Context)
+	defer cancel(nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", index.url(suffix), nil)
 	if err != nil {
 		return nil, fmt.Errorf("cannot create HTTP request: %v", err)
 	}
-	var resp *http.Response
-	if flags&fetchBulk != 0 {
-		resp, err = bulkDo(req)
-	} else {
-		resp, err = httpDo(req)
+	limits := index.archive.options.Limits
+	release, err := limits.acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot talk to archive: %w", err)
 	}
+	defer release()
+	resp, err := index.archive.do(req, flags)
 	if err != nil {
-		return nil, fmt.Errorf("cannot talk to archive: %v", err)
+		return nil, fmt.Errorf("cannot talk to archive: %w", err)
 	}
 	defer resp.Body.Close()
 
@@ -313,7 +784,20 @@ func (index *ubuntuIndex) fetch(suffix, digest string, flags fetchFlags) (io.Rea
 		return nil, fmt.Errorf("error from archive: %v", resp.Status)
 	}
 
-	body := resp.Body
+	reporter := index.archive.options.Progress
+	if reporter == nil {
+		reporter = progress.Null
+	}
+	task := reporter.Start("Fetching "+path.Base(suffix), resp.ContentLength)
+	defer task.Done()
+
+	sr := &statsReader{inner: resp.Body, stats: stats, limits: limits, ctx: ctx}
+	idleErr := fmt.Errorf("no data received for %s", idleTimeout)
+	if limits.throttled() {
+		sr.idle = time.AfterFunc(idleTimeout, func() { cancel(idleErr) })
+		defer sr.idle.Stop()
+	}
+	body := progress.Reader(sr, task)
 	if strings.HasSuffix(suffix, ".gz") {
 		reader, err := gzip.NewReader(body)
 		if err != nil {
@@ -330,9 +814,57 @@ func (index *ubuntuIndex) fetch(suffix, digest string, flags fetchFlags) (io.Rea
 	if err == nil {
 		err = writer.Close()
 	}
+	if err != nil && context.Cause(ctx) == idleErr {
+		err = idleErr
+	}
 	if err != nil {
-		return nil, fmt.Errorf("cannot fetch from archive: %v", err)
+		var digestErr *cache.DigestError
+		if errors.As(err, &digestErr) {
+			return nil, &VerifyError{msg: fmt.Sprintf("cannot fetch from archive: %v", err), err: err}
+		}
+		return nil, fmt.Errorf("cannot fetch from archive: %w", err)
 	}
 
 	return index.archive.cache.Open(writer.Digest())
 }
+
+// url returns the location of the archive data at suffix, relative to the
+// directory of the suite in the archive.
+func (index *ubuntuIndex) url(suffix string) string {
+	baseURL := ubuntuURL
+	if index.arch != "amd64" && index.arch != "i386" {
+		baseURL = ubuntuPortsURL
+	}
+	if strings.HasPrefix(suffix, "pool/") {
+		return baseURL + suffix
+	}
+	return baseURL + path.Clean("dists/"+index.suite+"/"+suffix)
+}
+
+// statsReader records in stats the number of bytes read from inner, and
+// throttles reading according to limits.
+type statsReader struct {
+	inner  io.Reader
+	stats  *Stats
+	limits *Limits
+	ctx    context.Context
+	// idle, if set, is reset after every read, but for the time spent
+	// throttling, so that it only fires once no data was received for
+	// idleTimeout.
+	idle *time.Timer
+}
+
+func (sr *statsReader) Read(p []byte) (int, error) {
+	n, err := sr.inner.Read(p)
+	sr.stats.addDownloaded(int64(n))
+	if sr.idle != nil {
+		sr.idle.Stop()
+	}
+	if throttleErr := sr.limits.throttle(sr.ctx, n); err == nil {
+		err = throttleErr
+	}
+	if sr.idle != nil {
+		sr.idle.Reset(idleTimeout)
+	}
+	return n, err
+}
@@ -18,7 +18,9 @@ import (
 
 	"github.com/canonical/chisel/internal/archive"
 	"github.com/canonical/chisel/internal/archive/testarchive"
+	"github.com/canonical/chisel/internal/control"
 	"github.com/canonical/chisel/internal/deb"
+	"github.com/canonical/chisel/internal/progress"
 	"github.com/canonical/chisel/internal/testutil"
 )
 
@@ -178,6 +180,16 @@ var optionErrorTests = []optionErrorTest{{
 		Components: []string{"main", "other"},
 	},
 	error: `invalid package architecture: foo`,
+}, {
+	options: archive.Options{
+		Label:       "ubuntu",
+		Version:     "22.04",
+		Arch:        "amd64",
+		Suites:      []string{"jammy"},
+		Components:  []string{"main"},
+		SuitePolicy: "newest",
+	},
+	error: `archive options have invalid suite policy: "newest"`,
 }}
 
 func (s *httpSuite) TestOptionErrors(c *C) {
@@ -219,6 +231,234 @@ func (s *httpSuite) TestFetchPackage(c *C) {
 	c.Assert(read(pkg), Equals, "mypkg4 1.4 data")
 }
 
+var fetchPackageDigestTests = []struct {
+	summary        string
+	content        string
+	noVerifyDigest bool
+	error          string
+}{{
+	summary: "Content matching the index",
+	content: "mypkg1 1.1 data",
+}, {
+	summary: "Content not matching the index",
+	content: "rotten",
+	error:   `cannot verify package "mypkg1" fetched from http://archive.ubuntu.com/ubuntu/pool/main/m/mypkg1/mypkg1_1.1ubuntu1_amd64.deb: expected sha256 [0-9a-f]{64}, got [0-9a-f]{64}`,
+}, {
+	summary:        "Content not matching the index without verification",
+	content:        "rotten",
+	noVerifyDigest: true,
+}}
+
+func (s *httpSuite) TestFetchPackageDigest(c *C) {
+	for _, test := range fetchPackageDigestTests {
+		c.Logf("Summary: %s", test.summary)
+
+		s.prepareArchive("jammy", "22.04", "amd64", []string{"main"})
+		s.responses["/ubuntu/pool/main/m/mypkg1/mypkg1_1.1ubuntu1_amd64.deb"] = []byte(test.content)
+
+		options := archive.Options{
+			Label:          "ubuntu",
+			Version:        "22.04",
+			Arch:           "amd64",
+			Suites:         []string{"jammy"},
+			Components:     []string{"main"},
+			CacheDir:       c.MkDir(),
+			PubKeys:        []*packet.PublicKey{s.pubKey},
+			NoVerifyDigest: test.noVerifyDigest,
+		}
+		testArchive, err := archive.Open(&options)
+		c.Assert(err, IsNil)
+
+		pkg, err := testArchive.Fetch("mypkg1")
+		if test.error != "" {
+			c.Assert(err, ErrorMatches, test.error)
+			c.Assert(err, FitsTypeOf, &archive.VerifyError{})
+			continue
+		}
+		c.Assert(err, IsNil)
+		c.Assert(read(pkg), Equals, test.content)
+		pkg.Close()
+	}
+}
+
+func (s *httpSuite) TestPackageInfo(c *C) {
+	s.prepareArchive("jammy", "22.04", "amd64", []string{"main", "universe"})
+
+	options := archive.Options{
+		Label:      "ubuntu",
+		Version:    "22.04",
+		Arch:       "amd64",
+		Suites:     []string{"jammy"},
+		Components: []string{"main", "universe"},
+		CacheDir:   c.MkDir(),
+		PubKeys:    []*packet.PublicKey{s.pubKey},
+	}
+
+	archive, err := archive.Open(&options)
+	c.Assert(err, IsNil)
+
+	info, err := archiveInfo(archive, "mypkg1")
+	c.Assert(err, IsNil)
+	c.Assert(info.Name, Equals, "mypkg1")
+	c.Assert(info.Version, Equals, "1.1")
+	c.Assert(info.Arch, Equals, "amd64")
+	c.Assert(info.SHA256, Equals, "1f08ef04cfe7a8087ee38a1ea35fa1810246648136c3c42d5a61ad6503d85e05")
+	c.Assert(info.Size, Equals, int64(len("mypkg1 1.1 data")))
+	c.Assert(info.InstalledSize, Equals, int64(10*1024))
+	c.Assert(info.Suite, Equals, "jammy")
+	c.Assert(info.Component, Equals, "main")
+
+	// Fields not otherwise exposed are preserved.
+	c.Assert(info.Get("Description"), Equals, "Description of mypkg1")
+	c.Assert(info.Get("Task"), Equals, "minimal")
+	c.Assert(info.Get("Homepage"), Equals, "")
+	c.Assert(info.Description, Equals, "Description of mypkg1")
+
+	info, err = archiveInfo(archive, "mypkg4")
+	c.Assert(err, IsNil)
+	c.Assert(info.Component, Equals, "universe")
+
+	_, err = archiveInfo(archive, "mypkg99")
+	c.Assert(err, ErrorMatches, `cannot find package "mypkg99" in archive`)
+
+	c.Assert(archive.Packages(), DeepEquals, []string{"mypkg1", "mypkg2", "mypkg3", "mypkg4"})
+}
+
+func (s *S) TestNewPackageInfo(c *C) {
+	file, err := control.ParseString("Package", "Package: mypkg\nVersion: 1.0\nSize: 12\nInstalled-Size: 2\nHomepage: https://example.com\n")
+	c.Assert(err, IsNil)
+	info, err := archive.NewPackageInfo(file.Section("mypkg"))
+	c.Assert(err, IsNil)
+	c.Assert(info.Name, Equals, "mypkg")
+	c.Assert(info.Version, Equals, "1.0")
+	c.Assert(info.Size, Equals, int64(12))
+	c.Assert(info.InstalledSize, Equals, int64(2048))
+	c.Assert(info.Get("Homepage"), Equals, "https://example.com")
+
+	file, err = control.ParseString("Package", "Package: mypkg\nSize: foo\n")
+	c.Assert(err, IsNil)
+	_, err = archive.NewPackageInfo(file.Section("mypkg"))
+	c.Assert(err, ErrorMatches, `invalid size for package "mypkg" in archive: "foo"`)
+}
+
+type recordingReporter struct {
+	labels []string
+	done   int
+}
+
+func (r *recordingReporter) Start(label string, total int64) progress.Task {
+	r.labels = append(r.labels, label)
+	return r
+}
+
+func (r *recordingReporter) Add(n int64) {}
+func (r *recordingReporter) Done()       { r.done++ }
+
+func (s *httpSuite) TestFetchProgress(c *C) {
+	s.prepareArchive("jammy", "22.04", "amd64", []string{"main"})
+
+	reporter := &recordingReporter{}
+	options := archive.Options{
+		Label:      "ubuntu",
+		Version:    "22.04",
+		Arch:       "amd64",
+		Suites:     []string{"jammy"},
+		Components: []string{"main"},
+		CacheDir:   c.MkDir(),
+		PubKeys:    []*packet.PublicKey{s.pubKey},
+		Progress:   reporter,
+	}
+
+	archive, err := archive.Open(&options)
+	c.Assert(err, IsNil)
+
+	pkg, err := archive.Fetch("mypkg1")
+	c.Assert(err, IsNil)
+	pkg.Close()
+
+	// Fetching from the cache does not report progress.
+	pkg, err = archive.Fetch("mypkg1")
+	c.Assert(err, IsNil)
+	pkg.Close()
+
+	c.Assert(reporter.labels, DeepEquals, []string{
+		"Fetching InRelease",
+		"Fetching Packages.gz",
+		"Fetching mypkg1_1.1ubuntu1_amd64.deb",
+	})
+	c.Assert(reporter.done, Equals, 3)
+}
+
+func (s *httpSuite) TestUnpublishedArch(c *C) {
+	s.base = "http://ports.ubuntu.com/ubuntu-ports/"
+	s.prepareArchiveAdjustRelease("jammy", "22.04", "arm64", []string{"main"}, func(r *testarchive.Release) {
+		r.Architectures = []string{"arm64", "armhf"}
+	})
+
+	options := archive.Options{
+		Label:      "ubuntu",
+		Version:    "22.04",
+		Arch:       "s390x",
+		Suites:     []string{"jammy"},
+		Components: []string{"main"},
+		CacheDir:   c.MkDir(),
+		PubKeys:    []*packet.PublicKey{s.pubKey},
+	}
+	_, err := archive.Open(&options)
+	c.Assert(err, ErrorMatches, `archive has no packages for architecture "s390x", available: arm64, armhf`)
+}
+
+func (s *httpSuite) TestArchAllIndex(c *C) {
+	s.base = "http://ports.ubuntu.com/ubuntu-ports/"
+	s.prepareArchiveAdjustRelease("jammy", "22.04", "arm64", []string{"main"}, func(r *testarchive.Release) {
+		index := &testarchive.PackageIndex{
+			Component: "main",
+			Arch:      "all",
+			Packages: []testarchive.Item{&testarchive.Package{
+				Name:      "mydata",
+				Version:   "2.0",
+				Arch:      "all",
+				Component: "main",
+			}, &testarchive.Package{
+				// Newer than the one in the arm64 index.
+				Name:      "mypkg2",
+				Version:   "1.3",
+				Arch:      "all",
+				Component: "main",
+			}},
+		}
+		r.Items = append(r.Items, index, &testarchive.Gzip{index})
+	})
+
+	options := archive.Options{
+		Label:      "ubuntu",
+		Version:    "22.04",
+		Arch:       "arm64",
+		Suites:     []string{"jammy"},
+		Components: []string{"main"},
+		CacheDir:   c.MkDir(),
+		PubKeys:    []*packet.PublicKey{s.pubKey},
+	}
+	archive, err := archive.Open(&options)
+	c.Assert(err, IsNil)
+
+	info, err := archiveInfo(archive, "mydata")
+	c.Assert(err, IsNil)
+	c.Assert(info.Arch, Equals, "all")
+	pkg, err := archive.Fetch("mydata")
+	c.Assert(err, IsNil)
+	c.Assert(read(pkg), Equals, "mydata 2.0 data")
+
+	info, err = archiveInfo(archive, "mypkg2")
+	c.Assert(err, IsNil)
+	c.Assert(info.Version, Equals, "1.3")
+	c.Assert(info.Arch, Equals, "all")
+
+	info, err = archiveInfo(archive, "mypkg1")
+	c.Assert(err, IsNil)
+	c.Assert(info.Arch, Equals, "arm64")
+}
+
 func (s *httpSuite) TestFetchPortsPackage(c *C) {
 
 	s.base = "http://ports.ubuntu.com/ubuntu-ports/"
@@ -280,11 +520,107 @@ func (s *httpSuite) TestFetchSecurityPackage(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(read(pkg), Equals, "package from jammy-security")
 
+	info, err := archiveInfo(archive, "mypkg1")
+	c.Assert(err, IsNil)
+	c.Assert(info.Suite, Equals, "jammy-security")
+
 	pkg, err = archive.Fetch("mypkg2")
 	c.Assert(err, IsNil)
 	c.Assert(read(pkg), Equals, "mypkg2 1.2 data")
 }
 
+func (s *httpSuite) TestFetchFirstMatchPackage(c *C) {
+	for i, suite := range []string{"jammy", "jammy-updates", "jammy-security"} {
+		release := s.prepareArchive(suite, "22.04", "amd64", []string{"main", "universe"})
+		release.Walk(func(item testarchive.Item) error {
+			if p, ok := item.(*testarchive.Package); ok && p.Name == "mypkg1" {
+				p.Version = fmt.Sprintf("%s.%d", p.Version, i)
+				p.Data = []byte("package from " + suite)
+			}
+			return nil
+		})
+		release.Render("/ubuntu", s.responses)
+	}
+
+	options := archive.Options{
+		Label:       "ubuntu",
+		Version:     "22.04",
+		CacheDir:    c.MkDir(),
+		Arch:        "amd64",
+		Suites:      []string{"jammy-updates", "jammy-security", "jammy"},
+		Components:  []string{"main", "universe"},
+		PubKeys:     []*packet.PublicKey{s.pubKey},
+		SuitePolicy: archive.FirstMatch,
+	}
+
+	archive, err := archive.Open(&options)
+	c.Assert(err, IsNil)
+
+	// The version in jammy-security is higher, but jammy-updates is listed first.
+	pkg, err := archive.Fetch("mypkg1")
+	c.Assert(err, IsNil)
+	c.Assert(read(pkg), Equals, "package from jammy-updates")
+
+	info, err := archiveInfo(archive, "mypkg1")
+	c.Assert(err, IsNil)
+	c.Assert(info.Suite, Equals, "jammy-updates")
+}
+
+func (s *httpSuite) TestVerifyCache(c *C) {
+	s.prepareArchive("jammy", "22.04", "amd64", []string{"main"})
+
+	cacheDir := c.MkDir()
+	options := archive.Options{
+		Label:      "ubuntu",
+		Version:    "22.04",
+		Arch:       "amd64",
+		Suites:     []string{"jammy"},
+		Components: []string{"main"},
+		CacheDir:   cacheDir,
+		PubKeys:    []*packet.PublicKey{s.pubKey},
+	}
+	testArchive, err := archive.Open(&options)
+	c.Assert(err, IsNil)
+	pkg, err := testArchive.Fetch("mypkg1")
+	c.Assert(err, IsNil)
+	pkg.Close()
+
+	// InRelease, Packages and the package itself.
+	checked, bad, err := archive.VerifyCache(cacheDir, []*packet.PublicKey{s.pubKey})
+	c.Assert(err, IsNil)
+	c.Assert(checked, Equals, 3)
+	c.Assert(bad, HasLen, 0)
+
+	info, err := archiveInfo(testArchive, "mypkg1")
+	c.Assert(err, IsNil)
+	pkgPath := filepath.Join(cacheDir, "sha256", info.SHA256)
+	c.Assert(os.WriteFile(pkgPath, []byte("rotten"), 0644), IsNil)
+
+	checked, bad, err = archive.VerifyCache(cacheDir, []*packet.PublicKey{s.pubKey})
+	c.Assert(err, IsNil)
+	c.Assert(checked, Equals, 3)
+	c.Assert(bad, HasLen, 1)
+	c.Assert(bad[0].Digest, Equals, info.SHA256)
+	c.Assert(bad[0].Err, ErrorMatches, "expected digest "+info.SHA256+", got .*")
+	_, err = os.Stat(pkgPath)
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	// The InRelease file is not signed by an unrelated key.
+	checked, bad, err = archive.VerifyCache(cacheDir, []*packet.PublicKey{key2.PubKey})
+	c.Assert(err, IsNil)
+	c.Assert(checked, Equals, 2)
+	c.Assert(bad, HasLen, 1)
+	c.Assert(bad[0].Err, ErrorMatches, "cannot verify signature of the InRelease file")
+
+	// The package is fetched again.
+	s.prepareArchive("jammy", "22.04", "amd64", []string{"main"})
+	testArchive, err = archive.Open(&options)
+	c.Assert(err, IsNil)
+	pkg, err = testArchive.Fetch("mypkg1")
+	c.Assert(err, IsNil)
+	c.Assert(read(pkg), Equals, "mypkg1 1.1 data")
+}
+
 func (s *httpSuite) TestArchiveLabels(c *C) {
 	setLabel := func(label string) func(*testarchive.Release) {
 		return func(r *testarchive.Release) {
@@ -393,6 +729,7 @@ func (s *httpSuite) TestVerifyArchiveRelease(c *C) {
 		_, err := archive.Open(&options)
 		if test.error != "" {
 			c.Assert(err, ErrorMatches, test.error)
+			c.Assert(err, FitsTypeOf, &archive.VerifyError{})
 		} else {
 			c.Assert(err, IsNil)
 		}
@@ -407,6 +744,9 @@ func read(r io.Reader) string {
 	return string(data)
 }
 
+// archiveInfo is archive.Info, which the tests shadow with their archives.
+var archiveInfo = archive.Info
+
 // ----------------------------------------------------------------------------------------
 // Real archive tests, only enabled via --real-archive.
 
@@ -1,20 +1,73 @@
 package archive
 
 import (
+	"context"
 	"net/http"
+	"time"
 )
 
 func FakeDo(do func(req *http.Request) (*http.Response, error)) (restore func()) {
 	_httpDo := httpDo
 	_bulkDo := bulkDo
+	_throttledDo := throttledDo
 	httpDo = do
 	bulkDo = do
+	throttledDo = do
 	return func() {
 		httpDo = _httpDo
 		bulkDo = _bulkDo
+		throttledDo = _throttledDo
 	}
 }
 
+// FakeTimeouts sets the overall timeout of the requests made by archives
+// which are not throttled, and the time throttled downloads may go without
+// receiving any data.
+func FakeTimeouts(request, idle time.Duration) (restore func()) {
+	_httpTimeout := httpClient.Timeout
+	_bulkTimeout := bulkClient.Timeout
+	_idleTimeout := idleTimeout
+	httpClient.Timeout = request
+	bulkClient.Timeout = request
+	idleTimeout = idle
+	return func() {
+		httpClient.Timeout = _httpTimeout
+		bulkClient.Timeout = _bulkTimeout
+		idleTimeout = _idleTimeout
+	}
+}
+
+// FakeBaseURL makes archives of every architecture be fetched from url.
+func FakeBaseURL(url string) (restore func()) {
+	_ubuntuURL := ubuntuURL
+	_ubuntuPortsURL := ubuntuPortsURL
+	ubuntuURL = url
+	ubuntuPortsURL = url
+	return func() {
+		ubuntuURL = _ubuntuURL
+		ubuntuPortsURL = _ubuntuPortsURL
+	}
+}
+
+func FakeTime(now func() time.Time, sleepFunc func(d time.Duration)) (restore func()) {
+	_timeNow := timeNow
+	_sleep := sleep
+	timeNow = now
+	sleep = func(ctx context.Context, d time.Duration) error {
+		sleepFunc(d)
+		return nil
+	}
+	return func() {
+		timeNow = _timeNow
+		sleep = _sleep
+	}
+}
+
+var (
+	LimitsAcquire  = (*Limits).acquire
+	LimitsThrottle = (*Limits).throttle
+)
+
 type Credentials = credentials
 
 var FindCredentials = findCredentials
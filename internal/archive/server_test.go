@@ -0,0 +1,370 @@
+package archive_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/openpgp/packet"
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/archive/testarchive"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+// serverSuite tests archives against a real HTTP server, rather than
+// replacing the HTTP client as httpSuite does.
+type serverSuite struct {
+	release *testarchive.Release
+	server  *testarchive.Server
+	restore func()
+}
+
+var _ = Suite(&serverSuite{})
+
+func (s *serverSuite) SetUpTest(c *C) {
+	release := &testarchive.Release{
+		Suite:   "jammy",
+		Version: "22.04",
+		Label:   "Ubuntu",
+		PrivKey: key1.PrivKey,
+	}
+	index := &testarchive.PackageIndex{
+		Component: "main",
+		Arch:      "amd64",
+		Packages: []testarchive.Item{&testarchive.Package{
+			Name:      "mypkg",
+			Version:   "1.0",
+			Arch:      "amd64",
+			Component: "main",
+			Data:      testutil.PackageData["test-package"],
+		}},
+	}
+	release.Items = append(release.Items, index, &testarchive.Gzip{index})
+	s.release = release
+	s.server = testarchive.NewServer(release)
+	s.restore = archive.FakeBaseURL(s.server.ArchiveURL())
+}
+
+func (s *serverSuite) TearDownTest(c *C) {
+	s.restore()
+	s.server.Close()
+}
+
+func (s *serverSuite) options(c *C, pubKey *packet.PublicKey) *archive.Options {
+	return &archive.Options{
+		Label:      "ubuntu",
+		Version:    "22.04",
+		Arch:       "amd64",
+		Suites:     []string{"jammy"},
+		Components: []string{"main"},
+		CacheDir:   c.MkDir(),
+		PubKeys:    []*packet.PublicKey{pubKey},
+	}
+}
+
+func (s *serverSuite) TestFetch(c *C) {
+	options := s.options(c, key1.PubKey)
+	archive, err := archive.Open(options)
+	c.Assert(err, IsNil)
+
+	pkg, err := archive.Fetch("mypkg")
+	c.Assert(err, IsNil)
+	c.Assert(read(pkg), Equals, string(testutil.PackageData["test-package"]))
+
+	// Packages are fetched from the cache once downloaded.
+	pkg, err = archive.Fetch("mypkg")
+	c.Assert(err, IsNil)
+	pkg.Close()
+
+	c.Assert(s.server.Requests(), DeepEquals, []string{
+		"dists/jammy/InRelease",
+		"dists/jammy/main/binary-amd64/Packages.gz",
+		"pool/main/m/mypkg/mypkg_1.0ubuntu1_amd64.deb",
+	})
+}
+
+func (s *serverSuite) TestFetchCancel(c *C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	options := s.options(c, key1.PubKey)
+	options.Context = ctx
+	archive, err := archive.Open(options)
+	c.Assert(err, IsNil)
+
+	cancel()
+	_, err = archive.Fetch("mypkg")
+	c.Assert(err, ErrorMatches, `cannot talk to archive: .*context canceled`)
+	c.Assert(errors.Is(err, context.Canceled), Equals, true)
+	c.Assert(s.server.Requests(), DeepEquals, []string{
+		"dists/jammy/InRelease",
+		"dists/jammy/main/binary-amd64/Packages.gz",
+	})
+}
+
+func (s *serverSuite) TestFetchThrottled(c *C) {
+	// Throttled downloads are not bounded by the timeout of requests.
+	restore := archive.FakeTimeouts(20*time.Millisecond, 5*time.Second)
+	defer restore()
+
+	data := bytes.Repeat([]byte("data"), 64*1024)
+	index := s.release.Items[0].(*testarchive.PackageIndex)
+	index.Packages = append(index.Packages, &testarchive.Package{
+		Name:      "bigpkg",
+		Version:   "1.0",
+		Arch:      "amd64",
+		Component: "main",
+		Data:      data,
+	})
+	s.server.Close()
+	s.server = testarchive.NewServer(s.release)
+	defer archive.FakeBaseURL(s.server.ArchiveURL())()
+
+	options := s.options(c, key1.PubKey)
+	options.Limits = archive.NewLimits(1<<20, 0)
+	archive, err := archive.Open(options)
+	c.Assert(err, IsNil)
+	started := time.Now()
+	pkg, err := archive.Fetch("bigpkg")
+	c.Assert(err, IsNil)
+	c.Assert(read(pkg) == string(data), Equals, true)
+	c.Assert(time.Since(started) > 20*time.Millisecond, Equals, true)
+}
+
+func (s *serverSuite) TestFetchThrottledIdle(c *C) {
+	restore := archive.FakeTimeouts(time.Minute, 50*time.Millisecond)
+	defer restore()
+
+	// The archive stops sending data after the headers.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1000")
+		w.WriteHeader(200)
+		w.Write([]byte("-----BEGIN"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	defer archive.FakeBaseURL(server.URL + "/")()
+
+	options := s.options(c, key1.PubKey)
+	options.Limits = archive.NewLimits(1000000, 0)
+	_, err := archive.Open(options)
+	c.Assert(err, ErrorMatches, `cannot fetch from archive: no data received for 50ms`)
+}
+
+func (s *serverSuite) TestPackagesIndexCache(c *C) {
+	options := s.options(c, key1.PubKey)
+	_, err := archive.Open(options)
+	c.Assert(err, IsNil)
+
+	// The position of the sections of the Packages file is cached.
+	indexPaths, err := filepath.Glob(filepath.Join(options.CacheDir, "derived", "*.control-index"))
+	c.Assert(err, IsNil)
+	c.Assert(indexPaths, HasLen, 1)
+
+	// The cached index is used by later runs, and ignored if invalid.
+	for _, data := range []string{"", "invalid"} {
+		if data != "" {
+			err = os.WriteFile(indexPaths[0], []byte(data), 0644)
+			c.Assert(err, IsNil)
+		}
+		archive, err := archive.Open(options)
+		c.Assert(err, IsNil)
+		info, err := archiveInfo(archive, "mypkg")
+		c.Assert(err, IsNil)
+		c.Assert(info.Version, Equals, "1.0")
+	}
+	data, err := os.ReadFile(indexPaths[0])
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Not(Equals), "invalid")
+}
+
+func (s *serverSuite) TestStats(c *C) {
+	stats := &archive.Stats{}
+	options := s.options(c, key1.PubKey)
+	options.Stats = stats
+	archive, err := archive.Open(options)
+	c.Assert(err, IsNil)
+	for i := 0; i < 2; i++ {
+		pkg, err := archive.Fetch("mypkg")
+		c.Assert(err, IsNil)
+		pkg.Close()
+	}
+
+	var downloaded int64
+	for _, itemPath := range s.server.Requests() {
+		downloaded += int64(len(s.server.Content(itemPath)))
+	}
+	c.Assert(stats.CacheHits.Load(), Equals, int64(1))
+	c.Assert(stats.CacheMisses.Load(), Equals, int64(2))
+	c.Assert(stats.Downloaded.Load(), Equals, downloaded)
+}
+
+func (s *serverSuite) TestCACerts(c *C) {
+	tlsServer := testarchive.NewTLSServer(s.release)
+	defer tlsServer.Close()
+	restore := archive.FakeBaseURL(tlsServer.ArchiveURL())
+	defer restore()
+
+	// The certificate of the server is not trusted by the system.
+	_, err := archive.Open(s.options(c, key1.PubKey))
+	c.Assert(err, ErrorMatches, `.*certificate signed by unknown authority.*`)
+
+	options := s.options(c, key1.PubKey)
+	options.CACerts = tlsServer.CACert()
+	archive, err := archive.Open(options)
+	c.Assert(err, IsNil)
+	pkg, err := archive.Fetch("mypkg")
+	c.Assert(err, IsNil)
+	c.Assert(read(pkg), Equals, string(testutil.PackageData["test-package"]))
+}
+
+func (s *serverSuite) TestInvalidCACerts(c *C) {
+	options := s.options(c, key1.PubKey)
+	options.CACerts = []byte("invalid")
+	_, err := archive.Open(options)
+	c.Assert(err, ErrorMatches, `archive options have no valid CA certificates`)
+}
+
+func (s *serverSuite) TestInvalidSignature(c *C) {
+	_, err := archive.Open(s.options(c, key2.PubKey))
+	c.Assert(err, ErrorMatches, `cannot verify signature of the InRelease file`)
+	c.Assert(err, FitsTypeOf, &archive.VerifyError{})
+}
+
+func (s *serverSuite) TestServerError(c *C) {
+	s.server.Fail("dists/jammy/main/binary-amd64/Packages.gz", 503)
+	_, err := archive.Open(s.options(c, key1.PubKey))
+	c.Assert(err, ErrorMatches, `error from archive: 503 Service Unavailable`)
+
+	// The failure is over.
+	_, err = archive.Open(s.options(c, key1.PubKey))
+	c.Assert(err, IsNil)
+}
+
+func (s *serverSuite) TestMissingPackage(c *C) {
+	s.server.Fail("pool/main/m/mypkg/mypkg_1.0ubuntu1_amd64.deb", 404)
+	archive, err := archive.Open(s.options(c, key1.PubKey))
+	c.Assert(err, IsNil)
+	_, err = archive.Fetch("mypkg")
+	c.Assert(err, ErrorMatches, `cannot find archive data`)
+}
+
+func (s *serverSuite) TestLimits(c *C) {
+	now := time.Unix(0, 0)
+	var slept time.Duration
+	restore := archive.FakeTime(func() time.Time { return now }, func(d time.Duration) {
+		slept += d
+		now = now.Add(d)
+	})
+	defer restore()
+
+	options := s.options(c, key1.PubKey)
+	options.Limits = archive.NewLimits(1000, 1)
+	archive, err := archive.Open(options)
+	c.Assert(err, IsNil)
+	pkg, err := archive.Fetch("mypkg")
+	c.Assert(err, IsNil)
+	c.Assert(read(pkg), Equals, string(testutil.PackageData["test-package"]))
+
+	// The last read is not waited for, as the rate is only enforced
+	// before the next one.
+	var downloaded int64
+	for _, itemPath := range s.server.Requests() {
+		downloaded += int64(len(s.server.Content(itemPath)))
+	}
+	c.Assert(slept > 0, Equals, true)
+	c.Assert(slept <= time.Duration(downloaded)*time.Millisecond, Equals, true)
+}
+
+var limitsThrottleTests = []struct {
+	summary string
+	rate    int64
+	reads   []int
+	slept   []time.Duration
+}{{
+	summary: "No limit",
+	rate:    0,
+	reads:   []int{1000, 1000},
+	slept:   nil,
+}, {
+	summary: "Reads are delayed to keep the rate",
+	rate:    1000,
+	reads:   []int{500, 500, 1000, 0, 1},
+	slept:   []time.Duration{500 * time.Millisecond, 500 * time.Millisecond, time.Second},
+}}
+
+func (s *S) TestLimitsThrottle(c *C) {
+	for _, test := range limitsThrottleTests {
+		c.Logf("Summary: %s", test.summary)
+		now := time.Unix(0, 0)
+		var slept []time.Duration
+		restore := archive.FakeTime(func() time.Time { return now }, func(d time.Duration) {
+			slept = append(slept, d)
+			now = now.Add(d)
+		})
+		limits := archive.NewLimits(test.rate, 0)
+		for _, n := range test.reads {
+			err := archive.LimitsThrottle(limits, context.Background(), n)
+			c.Assert(err, IsNil)
+		}
+		restore()
+		c.Assert(slept, DeepEquals, test.slept)
+	}
+}
+
+func (s *S) TestLimitsAcquire(c *C) {
+	ctx := context.Background()
+	limits := archive.NewLimits(0, 1)
+	release, err := archive.LimitsAcquire(limits, ctx)
+	c.Assert(err, IsNil)
+
+	acquired := make(chan func())
+	go func() {
+		release, _ := archive.LimitsAcquire(limits, ctx)
+		acquired <- release
+	}()
+	select {
+	case <-acquired:
+		c.Fatalf("connection acquired beyond the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	release()
+	select {
+	case release = <-acquired:
+		release()
+	case <-time.After(5 * time.Second):
+		c.Fatalf("connection not acquired after release")
+	}
+
+	// Unlimited connections never wait.
+	limits = archive.NewLimits(0, 0)
+	for i := 0; i < 10; i++ {
+		_, err := archive.LimitsAcquire(limits, ctx)
+		c.Assert(err, IsNil)
+	}
+}
+
+func (s *S) TestLimitsCancel(c *C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	limits := archive.NewLimits(1, 1)
+	release, err := archive.LimitsAcquire(limits, ctx)
+	c.Assert(err, IsNil)
+	defer release()
+
+	cancel()
+	_, err = archive.LimitsAcquire(limits, ctx)
+	c.Assert(err, Equals, context.Canceled)
+
+	// Waiting for the rate limit stops as well.
+	err = archive.LimitsThrottle(limits, ctx, 1000)
+	c.Assert(err, IsNil)
+	err = archive.LimitsThrottle(limits, ctx, 1000)
+	c.Assert(err, Equals, context.Canceled)
+}
@@ -0,0 +1,118 @@
+package testarchive
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Server is an HTTP server serving the content of releases as an archive
+// would, so that the code fetching from archives may be tested end-to-end.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	content  map[string][]byte
+	failures map[string][]int
+	requests []string
+}
+
+// serverPrefix is the path under which the archive is served.
+const serverPrefix = "/ubuntu/"
+
+// NewServer starts a Server serving the content of releases, as rendered
+// by Release.Render. The server must be closed once no longer needed.
+func NewServer(releases ...*Release) *Server {
+	return newServer(httptest.NewServer, releases)
+}
+
+// NewTLSServer is like NewServer but serves over TLS, with a certificate
+// signed by the authority returned by CACert.
+func NewTLSServer(releases ...*Release) *Server {
+	return newServer(httptest.NewTLSServer, releases)
+}
+
+func newServer(start func(handler http.Handler) *httptest.Server, releases []*Release) *Server {
+	s := &Server{
+		content:  make(map[string][]byte),
+		failures: make(map[string][]int),
+	}
+	for _, release := range releases {
+		err := release.Render(serverPrefix, s.content)
+		if err != nil {
+			panic(err)
+		}
+	}
+	s.Server = start(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// CACert returns the PEM-encoded certificate of the authority to trust for
+// talking to a server started with NewTLSServer.
+func (s *Server) CACert() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})
+}
+
+// ArchiveURL returns the base URL of the archive served.
+func (s *Server) ArchiveURL() string {
+	return s.URL + serverPrefix
+}
+
+// Fail makes the next requests for the file at itemPath, relative to the
+// base URL of the archive, respond with the provided statuses in order,
+// before the file is served again.
+func (s *Server) Fail(itemPath string, statuses ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	itemPath = path.Join(serverPrefix, itemPath)
+	s.failures[itemPath] = append(s.failures[itemPath], statuses...)
+}
+
+// Requests returns the paths requested so far, relative to the base URL of
+// the archive, in the order received.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// Content returns the content served for the file at itemPath, relative to
+// the base URL of the archive, or nil if there is no such file.
+func (s *Server) Content(itemPath string) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.content[path.Join(serverPrefix, itemPath)]
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	itemPath := path.Clean(r.URL.Path)
+	relPath, _ := strings.CutPrefix(itemPath, serverPrefix)
+	s.requests = append(s.requests, relPath)
+	var status int
+	if statuses := s.failures[itemPath]; len(statuses) > 0 {
+		status = statuses[0]
+		s.failures[itemPath] = statuses[1:]
+	}
+	data, ok := s.content[itemPath]
+	s.mu.Unlock()
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
+}
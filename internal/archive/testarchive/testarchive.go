@@ -107,6 +107,9 @@ type Release struct {
 	Label   string
 	Items   []Item
 	PrivKey *packet.PrivateKey
+	// Architectures overrides the default list of architectures announced
+	// by the release.
+	Architectures []string
 }
 
 func (r *Release) Walk(f func(Item) error) error {
@@ -122,6 +125,10 @@ func (r *Release) Section() []byte {
 }
 
 func (r *Release) Content() []byte {
+	archs := "amd64 arm64 armhf i386 ppc64el riscv64 s390x"
+	if r.Architectures != nil {
+		archs = strings.Join(r.Architectures, " ")
+	}
 	digests := bytes.Buffer{}
 	for _, item := range r.Items {
 		content := item.Content()
@@ -134,12 +141,12 @@ func (r *Release) Content() []byte {
 		Version: %s
 		Codename: codename
 		Date: Thu, 21 Apr 2022 17:16:08 UTC
-		Architectures: amd64 arm64 armhf i386 ppc64el riscv64 s390x
+		Architectures: %s
 		Components: main restricted universe multiverse
 		Description: Ubuntu %s
 		SHA256:
 		%s
-	`)), r.Label, r.Suite, r.Version, r.Version, digests.String())
+	`)), r.Label, r.Suite, r.Version, archs, r.Version, digests.String())
 
 	var buf bytes.Buffer
 	writer, err := clearsign.Encode(&buf, r.PrivKey, nil)
@@ -8,6 +8,8 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"time"
 )
 
@@ -74,7 +76,7 @@ func (cw *Writer) Close() error {
 	if cw.digest == "" {
 		cw.digest = digest
 	} else if digest != cw.digest {
-		return cw.fail(fmt.Errorf("expected digest %s, got %s", cw.digest, digest))
+		return cw.fail(&DigestError{Expected: cw.digest, Got: digest})
 	}
 	fname := cw.file.Name()
 	err = os.Rename(fname, filepath.Join(filepath.Dir(fname), cw.digest))
@@ -93,6 +95,17 @@ const digestKind = "sha256"
 
 var MissErr = fmt.Errorf("not cached")
 
+// DigestError is returned when the written content doesn't match the
+// expected digest.
+type DigestError struct {
+	Expected string
+	Got      string
+}
+
+func (e *DigestError) Error() string {
+	return fmt.Sprintf("expected digest %s, got %s", e.Expected, e.Got)
+}
+
 func (c *Cache) filePath(digest string) string {
 	return filepath.Join(c.Dir, digestKind, digest)
 }
@@ -164,6 +177,59 @@ func (c *Cache) Read(digest string) ([]byte, error) {
 	return data, nil
 }
 
+// derivedKind is the directory holding data derived from cache entries,
+// such as the parsed form of package indexes, which is not addressed by its
+// own digest.
+const derivedKind = "derived"
+
+func (c *Cache) derivedPath(digest, name string) string {
+	return filepath.Join(c.Dir, derivedKind, digest+"."+name)
+}
+
+// WriteDerived stores data derived from the entry with the provided digest
+// under name, replacing any data previously stored. Derived data is removed
+// along with the entry it was derived from.
+func (c *Cache) WriteDerived(digest, name string, data []byte) error {
+	if c.Dir == "" {
+		return fmt.Errorf("internal error: cache directory is unset")
+	}
+	err := os.MkdirAll(filepath.Join(c.Dir, derivedKind), 0755)
+	if err != nil {
+		return fmt.Errorf("cannot create cache directory: %v", err)
+	}
+	file, err := os.CreateTemp(filepath.Join(c.Dir, derivedKind), "tmp.*")
+	if err != nil {
+		return fmt.Errorf("cannot create cache file: %v", err)
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), c.derivedPath(digest, name))
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return fmt.Errorf("cannot write cache file: %v", err)
+	}
+	return nil
+}
+
+// ReadDerived returns the data stored under name by WriteDerived for the
+// entry with the provided digest, or MissErr if there is none.
+func (c *Cache) ReadDerived(digest, name string) ([]byte, error) {
+	if c.Dir == "" || digest == "" {
+		return nil, MissErr
+	}
+	data, err := os.ReadFile(c.derivedPath(digest, name))
+	if os.IsNotExist(err) {
+		return nil, MissErr
+	} else if err != nil {
+		return nil, fmt.Errorf("cannot read cache file: %v", err)
+	}
+	return data, nil
+}
+
 func (c *Cache) Expire(timeout time.Duration) error {
 	entries, err := os.ReadDir(filepath.Join(c.Dir, digestKind))
 	if err != nil {
@@ -183,5 +249,102 @@ func (c *Cache) Expire(timeout time.Duration) error {
 			return fmt.Errorf("cannot expire cache entry: %v", err)
 		}
 	}
+	return c.expireDerived()
+}
+
+// expireDerived removes the derived data of entries no longer cached.
+func (c *Cache) expireDerived() error {
+	entries, err := os.ReadDir(filepath.Join(c.Dir, derivedKind))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot list cache directory: %v", err)
+	}
+	for _, entry := range entries {
+		digest, _, _ := strings.Cut(entry.Name(), ".")
+		if digestExp.MatchString(digest) {
+			_, err := os.Stat(c.filePath(digest))
+			if err == nil || !os.IsNotExist(err) {
+				continue
+			}
+		}
+		err = os.Remove(filepath.Join(c.Dir, derivedKind, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("cannot expire cache entry: %v", err)
+		}
+	}
 	return nil
 }
+
+// BadEntry describes a cache entry removed by Verify.
+type BadEntry struct {
+	Digest string
+	Err    error
+}
+
+// Verify checks that the content of every cache entry matches its digest,
+// and calls check, if not nil, with the content of the entries that do.
+// Entries failing either check are removed from the cache and returned,
+// along with the number of entries checked. Temporary files of writes in
+// progress are left alone.
+func (c *Cache) Verify(check func(digest string, r io.Reader) error) (checked int, bad []BadEntry, err error) {
+	entries, err := os.ReadDir(filepath.Join(c.Dir, digestKind))
+	if os.IsNotExist(err) {
+		return 0, nil, nil
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("cannot list cache directory: %v", err)
+	}
+	for _, entry := range entries {
+		digest := entry.Name()
+		if !entry.Type().IsRegular() || !digestExp.MatchString(digest) {
+			continue
+		}
+		checked++
+		filePath := c.filePath(digest)
+		got, err := fileDigest(filePath)
+		if err != nil {
+			return checked, bad, fmt.Errorf("cannot read cache file: %v", err)
+		}
+		if got != digest {
+			err = &DigestError{Expected: digest, Got: got}
+		} else if check != nil {
+			err = checkFile(filePath, digest, check)
+		}
+		if err == nil {
+			continue
+		}
+		bad = append(bad, BadEntry{Digest: digest, Err: err})
+		err = os.Remove(filePath)
+		if err != nil {
+			return checked, bad, fmt.Errorf("cannot remove cache entry: %v", err)
+		}
+	}
+	return checked, bad, nil
+}
+
+var digestExp = regexp.MustCompile("^[0-9a-f]{64}$")
+
+func fileDigest(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, file)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func checkFile(filePath, digest string, check func(digest string, r io.Reader) error) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return check(digest, file)
+}
@@ -3,6 +3,7 @@ package cache_test
 import (
 	. "gopkg.in/check.v1"
 
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -95,6 +96,51 @@ func (s *S) TestCacheReadWrite(c *C) {
 	c.Assert(os.IsNotExist(err), Equals, true)
 }
 
+func (s *S) TestCacheDerived(c *C) {
+	cc := cache.Cache{Dir: c.MkDir()}
+
+	_, err := cc.ReadDerived(data1Digest, "index")
+	c.Assert(err, Equals, cache.MissErr)
+
+	err = cc.Write(data1Digest, []byte("data1"))
+	c.Assert(err, IsNil)
+	err = cc.Write(data2Digest, []byte("data2"))
+	c.Assert(err, IsNil)
+	err = cc.WriteDerived(data1Digest, "index", []byte("index1"))
+	c.Assert(err, IsNil)
+	err = cc.WriteDerived(data2Digest, "index", []byte("old"))
+	c.Assert(err, IsNil)
+	err = cc.WriteDerived(data2Digest, "index", []byte("index2"))
+	c.Assert(err, IsNil)
+
+	data, err := cc.ReadDerived(data1Digest, "index")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "index1")
+	data, err = cc.ReadDerived(data2Digest, "index")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "index2")
+	_, err = cc.ReadDerived(data2Digest, "other")
+	c.Assert(err, Equals, cache.MissErr)
+
+	// Derived data does not count as an entry of its own.
+	checked, bad, err := cc.Verify(nil)
+	c.Assert(err, IsNil)
+	c.Assert(checked, Equals, 2)
+	c.Assert(bad, HasLen, 0)
+
+	// Derived data expires along with its entry.
+	expired := time.Now().Add(-time.Hour - time.Second)
+	err = os.Chtimes(filepath.Join(cc.Dir, "sha256", data1Digest), expired, expired)
+	c.Assert(err, IsNil)
+	err = cc.Expire(time.Hour)
+	c.Assert(err, IsNil)
+	_, err = cc.ReadDerived(data1Digest, "index")
+	c.Assert(err, Equals, cache.MissErr)
+	data, err = cc.ReadDerived(data2Digest, "index")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "index2")
+}
+
 func (s *S) TestCacheCreate(c *C) {
 	cc := cache.Cache{Dir: c.MkDir()}
 
@@ -129,6 +175,7 @@ func (s *S) TestCacheWrongDigest(c *C) {
 	errClose := w.Close()
 	c.Assert(err, IsNil)
 	c.Assert(errClose, ErrorMatches, "expected digest "+data1Digest+", got "+data2Digest)
+	c.Assert(errClose, DeepEquals, &cache.DigestError{Expected: data1Digest, Got: data2Digest})
 
 	_, err = cc.Read(data1Digest)
 	c.Assert(err, Equals, cache.MissErr)
@@ -151,3 +198,52 @@ func (s *S) TestCacheOpen(c *C) {
 
 	c.Assert(string(data1), Equals, "data1")
 }
+
+func (s *S) TestCacheVerify(c *C) {
+	cc := cache.Cache{Dir: c.MkDir()}
+
+	// Nothing to verify before the cache is first written.
+	checked, bad, err := cc.Verify(nil)
+	c.Assert(err, IsNil)
+	c.Assert(checked, Equals, 0)
+	c.Assert(bad, HasLen, 0)
+
+	for _, data := range []string{"data1", "data2", "data3"} {
+		c.Assert(cc.Write("", []byte(data)), IsNil)
+	}
+	// Corrupt data1 and leave a write in progress behind.
+	data1Path := filepath.Join(cc.Dir, "sha256", data1Digest)
+	c.Assert(os.WriteFile(data1Path, []byte("data2"), 0644), IsNil)
+	tmpPath := filepath.Join(cc.Dir, "sha256", data3Digest+".tmp")
+	c.Assert(os.WriteFile(tmpPath, []byte("dat"), 0644), IsNil)
+
+	var seen []string
+	checked, bad, err = cc.Verify(func(digest string, r io.Reader) error {
+		seen = append(seen, digest)
+		data, err := io.ReadAll(r)
+		c.Assert(err, IsNil)
+		if string(data) == "data3" {
+			return fmt.Errorf("bad data")
+		}
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(checked, Equals, 3)
+	c.Assert(seen, DeepEquals, []string{data2Digest, data3Digest})
+	c.Assert(bad, DeepEquals, []cache.BadEntry{{
+		Digest: data1Digest,
+		Err:    &cache.DigestError{Expected: data1Digest, Got: data2Digest},
+	}, {
+		Digest: data3Digest,
+		Err:    fmt.Errorf("bad data"),
+	}})
+
+	_, err = cc.Read(data1Digest)
+	c.Assert(err, Equals, cache.MissErr)
+	_, err = cc.Read(data2Digest)
+	c.Assert(err, IsNil)
+	_, err = cc.Read(data3Digest)
+	c.Assert(err, Equals, cache.MissErr)
+	_, err = os.Stat(tmpPath)
+	c.Assert(err, IsNil)
+}
@@ -3,6 +3,7 @@ package control
 import (
 	"bytes"
 	"io"
+	"sort"
 	"strings"
 )
 
@@ -16,6 +17,8 @@ import (
 
 type File interface {
 	Section(key string) Section
+	// Keys returns the keys of all the sections in the file, sorted.
+	Keys() []string
 }
 
 type Section interface {
@@ -38,6 +41,15 @@ func (f *ctrlFile) Section(key string) Section {
 	return nil
 }
 
+func (f *ctrlFile) Keys() []string {
+	keys := make([]string, 0, len(f.sections))
+	for key := range f.sections {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 type ctrlSection struct {
 	content string
 }
@@ -142,3 +154,32 @@ func ParseString(sectionKey, content string) (File, error) {
 		sectionKey: sectionKey,
 	}, nil
 }
+
+// ParseSections returns all the sections in content in the order they are
+// found, for files such as debian/copyright where sections do not have a
+// unique key. Sections are separated by lines holding only whitespace.
+func ParseSections(content string) []Section {
+	var sections []Section
+	start := -1
+	pos := 0
+	for pos <= len(content) {
+		eol := strings.Index(content[pos:], "\n")
+		if eol < 0 {
+			eol = len(content)
+		} else {
+			eol += pos
+		}
+		blank := strings.TrimSpace(content[pos:eol]) == ""
+		if blank && start >= 0 {
+			sections = append(sections, &ctrlSection{content[start:pos]})
+			start = -1
+		} else if !blank && start < 0 {
+			start = pos
+		}
+		pos = eol + 1
+	}
+	if start >= 0 {
+		sections = append(sections, &ctrlSection{content[start:]})
+	}
+	return sections
+}
@@ -59,6 +59,7 @@ var testFileResults = map[string]map[string]string{
 func (s *S) TestParseString(c *C) {
 	file, err := control.ParseString("Section", testFile)
 	c.Assert(err, IsNil)
+	c.Assert(file.Keys(), DeepEquals, []string{"four", "one", "three", "two"})
 
 	for skey, svalues := range testFileResults {
 		section := file.Section(skey)
@@ -114,3 +115,18 @@ func BenchmarkSectionGet(b *testing.B) {
 		}
 	}
 }
+
+func (s *S) TestParseSections(c *C) {
+	sections := control.ParseSections("\n" + testFile + "  \n\t\nSection: five\nLine: last")
+	c.Assert(sections, HasLen, 5)
+	for i, skey := range []string{"one", "two", "three", "four"} {
+		for key, value := range testFileResults[skey] {
+			c.Assert(sections[i].Get(key), Equals, value, Commentf("Section %q / Key %q", skey, key))
+		}
+	}
+	c.Assert(sections[4].Get("Section"), Equals, "five")
+	c.Assert(sections[4].Get("Line"), Equals, "last")
+
+	c.Assert(control.ParseSections(""), HasLen, 0)
+	c.Assert(control.ParseSections("\n \n"), HasLen, 0)
+}
@@ -0,0 +1,137 @@
+package control
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// The index of a file is the list of positions of its sections, so that
+// large files such as Packages indexes may be loaded again without scanning
+// their content. It is laid out as:
+//
+//	magic | len(content) | len(sectionKey) sectionKey | count | entries
+//
+// with every entry being len(key) key | start | end - start, sorted by
+// start, and every number encoded as an unsigned varint.
+
+const indexMagic = "chisel-control-index-v1\n"
+
+// Index returns a compact binary representation of the positions of the
+// sections of f, which ParseStringIndexed uses to load the same content
+// again without scanning it. The file must have been returned by one of the
+// Parse functions in this package.
+func Index(f File) ([]byte, error) {
+	cf, ok := f.(*ctrlFile)
+	if !ok {
+		return nil, fmt.Errorf("internal error: cannot index control file of type %T", f)
+	}
+	keys := make([]string, 0, len(cf.sections))
+	size := len(indexMagic) + 3*binary.MaxVarintLen64 + len(cf.sectionKey)
+	for key := range cf.sections {
+		keys = append(keys, key)
+		size += 3*binary.MaxVarintLen64 + len(key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return cf.sections[keys[i]].start < cf.sections[keys[j]].start
+	})
+
+	data := make([]byte, 0, size)
+	data = append(data, indexMagic...)
+	data = binary.AppendUvarint(data, uint64(len(cf.content)))
+	data = binary.AppendUvarint(data, uint64(len(cf.sectionKey)))
+	data = append(data, cf.sectionKey...)
+	data = binary.AppendUvarint(data, uint64(len(keys)))
+	for _, key := range keys {
+		pos := cf.sections[key]
+		data = binary.AppendUvarint(data, uint64(len(key)))
+		data = append(data, key...)
+		data = binary.AppendUvarint(data, uint64(pos.start))
+		data = binary.AppendUvarint(data, uint64(pos.end-pos.start))
+	}
+	return data, nil
+}
+
+// ParseStringIndexed is like ParseString but obtains the position of the
+// sections from index, as returned by Index for the same content, rather
+// than scanning content. An error is returned if index does not match
+// sectionKey and content.
+func ParseStringIndexed(sectionKey, content string, index []byte) (File, error) {
+	r := &indexReader{data: index}
+	if !r.consume(indexMagic) {
+		return nil, fmt.Errorf("invalid control file index: unknown format")
+	}
+	if r.uvarint() != uint64(len(content)) {
+		return nil, fmt.Errorf("invalid control file index: content size mismatch")
+	}
+	if r.string() != sectionKey {
+		return nil, fmt.Errorf("invalid control file index: section key mismatch")
+	}
+	count := r.uvarint()
+	if r.err != nil || count > uint64(len(r.data)) {
+		return nil, fmt.Errorf("invalid control file index: truncated data")
+	}
+	sections := make(map[string]ctrlPos, count)
+	for i := uint64(0); i < count; i++ {
+		key := r.string()
+		start := r.uvarint()
+		size := r.uvarint()
+		if r.err != nil {
+			return nil, fmt.Errorf("invalid control file index: truncated data")
+		}
+		if start > uint64(len(content)) || size > uint64(len(content))-start {
+			return nil, fmt.Errorf("invalid control file index: section %q out of bounds", key)
+		}
+		sections[key] = ctrlPos{int(start), int(start + size)}
+	}
+	if len(r.data) > 0 {
+		return nil, fmt.Errorf("invalid control file index: trailing data")
+	}
+	return &ctrlFile{
+		content:    content,
+		sections:   sections,
+		sectionKey: sectionKey,
+	}, nil
+}
+
+// indexReader decodes the fields of an index, recording in err the first
+// failure, after which every field read is empty.
+type indexReader struct {
+	data []byte
+	err  error
+}
+
+func (r *indexReader) consume(prefix string) bool {
+	if len(r.data) < len(prefix) || string(r.data[:len(prefix)]) != prefix {
+		return false
+	}
+	r.data = r.data[len(prefix):]
+	return true
+}
+
+func (r *indexReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	value, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = fmt.Errorf("invalid varint")
+		return 0
+	}
+	r.data = r.data[n:]
+	return value
+}
+
+func (r *indexReader) string() string {
+	size := r.uvarint()
+	if r.err != nil {
+		return ""
+	}
+	if size > uint64(len(r.data)) {
+		r.err = fmt.Errorf("string out of bounds")
+		return ""
+	}
+	value := string(r.data[:size])
+	r.data = r.data[size:]
+	return value
+}
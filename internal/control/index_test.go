@@ -0,0 +1,95 @@
+package control_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/control"
+)
+
+func (s *S) TestParseStringIndexed(c *C) {
+	file, err := control.ParseString("Section", testFile)
+	c.Assert(err, IsNil)
+	index, err := control.Index(file)
+	c.Assert(err, IsNil)
+
+	file, err = control.ParseStringIndexed("Section", testFile, index)
+	c.Assert(err, IsNil)
+	for skey, svalues := range testFileResults {
+		section := file.Section(skey)
+		for key, value := range svalues {
+			c.Assert(section.Get(key), Equals, value, Commentf("Section %q / Key %q", skey, key))
+		}
+	}
+	c.Assert(file.Section("five"), IsNil)
+
+	// The index is deterministic.
+	again, err := control.Index(file)
+	c.Assert(err, IsNil)
+	c.Assert(again, DeepEquals, index)
+}
+
+var parseStringIndexedErrorTests = []struct {
+	summary    string
+	sectionKey string
+	content    string
+	index      func(index []byte) []byte
+	error      string
+}{{
+	summary: "Unknown format",
+	index:   func(index []byte) []byte { return []byte("foo") },
+	error:   `invalid control file index: unknown format`,
+}, {
+	summary: "Different content",
+	content: testFile + "\n",
+	error:   `invalid control file index: content size mismatch`,
+}, {
+	summary:    "Different section key",
+	sectionKey: "Line",
+	error:      `invalid control file index: section key mismatch`,
+}, {
+	summary: "Truncated index",
+	index:   func(index []byte) []byte { return index[:len(index)-1] },
+	error:   `invalid control file index: truncated data`,
+}, {
+	summary: "Trailing data",
+	index:   func(index []byte) []byte { return append(index, 0) },
+	error:   `invalid control file index: trailing data`,
+}}
+
+func (s *S) TestParseStringIndexedErrors(c *C) {
+	file, err := control.ParseString("Section", testFile)
+	c.Assert(err, IsNil)
+	index, err := control.Index(file)
+	c.Assert(err, IsNil)
+
+	for _, test := range parseStringIndexedErrorTests {
+		c.Logf("Summary: %s", test.summary)
+		sectionKey := test.sectionKey
+		if sectionKey == "" {
+			sectionKey = "Section"
+		}
+		content := test.content
+		if content == "" {
+			content = testFile
+		}
+		testIndex := append([]byte(nil), index...)
+		if test.index != nil {
+			testIndex = test.index(testIndex)
+		}
+		_, err := control.ParseStringIndexed(sectionKey, content, testIndex)
+		c.Assert(err, ErrorMatches, test.error)
+	}
+}
+
+func (s *S) TestIndexOutOfBounds(c *C) {
+	content := "Section: one\nLine: foo\n"
+	file, err := control.ParseString("Section", content)
+	c.Assert(err, IsNil)
+	index, err := control.Index(file)
+	c.Assert(err, IsNil)
+
+	// Make the only section extend past the end of the content.
+	index[len(index)-1] = 0x7f
+	_, err = control.ParseStringIndexed("Section", content, index)
+	c.Assert(err, ErrorMatches, `invalid control file index: section "one" out of bounds`)
+}
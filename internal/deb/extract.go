@@ -3,6 +3,7 @@ package deb
 import (
 	"archive/tar"
 	"bytes"
+	"compress/bzip2"
 	"compress/gzip"
 	"fmt"
 	"io"
@@ -11,11 +12,11 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
-	"syscall"
 
 	"github.com/blakesmith/ar"
 	"github.com/klauspost/compress/zstd"
 	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
 
 	"github.com/canonical/chisel/internal/fsutil"
 	"github.com/canonical/chisel/internal/strdist"
@@ -29,6 +30,9 @@ type ExtractOptions struct {
 	// extractInfos is set to the matching entries in Extract, and is nil in cases where
 	// the created entry is implicit and unlisted (for example, parent directories).
 	Create func(extractInfos []ExtractInfo, options *fsutil.CreateOptions) error
+	// KeepMTimes gives the extracted entries the modification time recorded
+	// in the package, rather than the time they are extracted at.
+	KeepMTimes bool
 }
 
 type ExtractInfo struct {
@@ -93,39 +97,107 @@ func Extract(pkgReader io.Reader, options *ExtractOptions) (err error) {
 		if err != nil {
 			return err
 		}
-		switch arHeader.Name {
-		case "data.tar.gz":
-			gzipReader, err := gzip.NewReader(arReader)
-			if err != nil {
-				return err
-			}
-			defer gzipReader.Close()
-			dataReader = gzipReader
-		case "data.tar.xz":
-			xzReader, err := xz.NewReader(arReader)
-			if err != nil {
-				return err
-			}
-			dataReader = xzReader
-		case "data.tar.zst":
-			zstdReader, err := zstd.NewReader(arReader)
-			if err != nil {
-				return err
-			}
-			defer zstdReader.Close()
-			dataReader = zstdReader
+		if !isTarMember(arHeader.Name, "data") {
+			continue
 		}
+		reader, closeReader, err := memberReader(arReader, arHeader.Name)
+		if err != nil {
+			return err
+		}
+		defer closeReader()
+		dataReader = reader
 	}
 	return extractData(dataReader, validOpts)
 }
 
-func extractData(dataReader io.Reader, options *ExtractOptions) error {
-
-	oldUmask := syscall.Umask(0)
+// ReadControl returns the content of the control file in the control
+// archive of the package.
+func ReadControl(pkgReader io.Reader) (data []byte, err error) {
 	defer func() {
-		syscall.Umask(oldUmask)
+		if err != nil {
+			err = fmt.Errorf("cannot read control file: %w", err)
+		}
 	}()
 
+	arReader := ar.NewReader(pkgReader)
+	var memberName string
+	for memberName == "" {
+		arHeader, err := arReader.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no control payload")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if isTarMember(arHeader.Name, "control") {
+			memberName = arHeader.Name
+		}
+	}
+	reader, closeReader, err := memberReader(arReader, memberName)
+	if err != nil {
+		return nil, err
+	}
+	defer closeReader()
+	tarReader := tar.NewReader(reader)
+	for {
+		tarHeader, err := tarReader.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no control file in control payload")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if filepath.Clean(tarHeader.Name) == "control" && tarHeader.Typeflag == tar.TypeReg {
+			return io.ReadAll(tarReader)
+		}
+	}
+}
+
+// isTarMember returns whether the ar member called name is the tarball
+// with the given base name, compressed or not.
+func isTarMember(name, base string) bool {
+	return name == base+".tar" || strings.HasPrefix(name, base+".tar.")
+}
+
+// memberReader returns a reader of the uncompressed content of the ar
+// member called name, according to its extension, which may be any of
+// those supported by dpkg or none. The returned function must be called
+// once done reading.
+func memberReader(r io.Reader, name string) (reader io.Reader, closeReader func(), err error) {
+	switch filepath.Ext(name) {
+	case ".tar":
+		return r, func() {}, nil
+	case ".gz":
+		gzipReader, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return gzipReader, func() { gzipReader.Close() }, nil
+	case ".xz":
+		xzReader, err := xz.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return xzReader, func() {}, nil
+	case ".zst":
+		zstdReader, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zstdReader, zstdReader.Close, nil
+	case ".bz2":
+		return bzip2.NewReader(r), func() {}, nil
+	case ".lzma":
+		lzmaReader, err := lzma.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return lzmaReader, func() {}, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported compression of %s", name)
+}
+
+func extractData(dataReader io.Reader, options *ExtractOptions) error {
 	pendingPaths := make(map[string]bool)
 	for extractPath, extractInfos := range options.Extract {
 		for _, extractInfo := range extractInfos {
@@ -143,6 +215,10 @@ func extractData(dataReader io.Reader, options *ExtractOptions) error {
 	// before the entry for the file itself. This is the case for .deb files but
 	// not for all tarballs.
 	tarDirMode := make(map[string]fs.FileMode)
+	rootDir, err := filepath.EvalSymlinks(options.TargetDir)
+	if err != nil {
+		return err
+	}
 	tarReader := tar.NewReader(dataReader)
 	for {
 		tarHeader, err := tarReader.Next()
@@ -157,10 +233,19 @@ func extractData(dataReader io.Reader, options *ExtractOptions) error {
 		if len(sourcePath) < 3 || sourcePath[0] != '.' || sourcePath[1] != '/' {
 			continue
 		}
+		// Long names held in PAX records or GNU headers may lack the
+		// trailing slash of directories.
+		if tarHeader.Typeflag == tar.TypeDir && !strings.HasSuffix(sourcePath, "/") {
+			sourcePath += "/"
+		}
 		sourcePath = sourcePath[1:]
 		if sourcePath == "" {
 			continue
 		}
+		err = checkTarEntry(sourcePath, tarHeader)
+		if err != nil {
+			return err
+		}
 
 		sourceIsDir := sourcePath[len(sourcePath)-1] == '/'
 		if sourceIsDir {
@@ -240,7 +325,11 @@ func extractData(dataReader io.Reader, options *ExtractOptions) error {
 					Mode:        mode,
 					MakeParents: true,
 				}
-				err := options.Create(nil, createOptions)
+				err := checkInsideRoot(rootDir, createOptions.Path)
+				if err != nil {
+					return err
+				}
+				err = options.Create(nil, createOptions)
 				if err != nil {
 					return err
 				}
@@ -252,8 +341,18 @@ func extractData(dataReader io.Reader, options *ExtractOptions) error {
 				Data:        pathReader,
 				Link:        tarHeader.Linkname,
 				MakeParents: true,
+				DevMajor:    uint32(tarHeader.Devmajor),
+				DevMinor:    uint32(tarHeader.Devminor),
+				Xattrs:      fsutil.TarXattrs(tarHeader),
+			}
+			if options.KeepMTimes {
+				createOptions.MTime = tarHeader.ModTime
 			}
-			err := options.Create(extractInfos, createOptions)
+			err := checkInsideRoot(rootDir, createOptions.Path)
+			if err != nil {
+				return err
+			}
+			err = options.Create(extractInfos, createOptions)
 			if err != nil {
 				return err
 			}
@@ -276,6 +375,68 @@ func extractData(dataReader io.Reader, options *ExtractOptions) error {
 	return nil
 }
 
+// checkTarEntry returns an error if the tar entry for sourcePath, relative to
+// the root of the package, could be used to escape the root directory it is
+// extracted into, either via its own path or via the path it links to.
+func checkTarEntry(sourcePath string, tarHeader *tar.Header) error {
+	if hasDotDot(sourcePath) {
+		return fmt.Errorf("invalid package entry %s: path escapes root", sourcePath)
+	}
+	switch tarHeader.Typeflag {
+	case tar.TypeLink:
+		// Hard link targets are relative to the root of the package.
+		if !strings.HasPrefix(tarHeader.Linkname, "./") || hasDotDot(tarHeader.Linkname) {
+			return fmt.Errorf("invalid package entry %s: hard link target %q escapes root", sourcePath, tarHeader.Linkname)
+		}
+	case tar.TypeSymlink:
+		// Absolute targets are resolved within the root once it's in use,
+		// so only relative targets may point outside of it. Writing into
+		// the root through absolute links is prevented separately.
+		link := tarHeader.Linkname
+		if !filepath.IsAbs(link) {
+			target := filepath.Join(filepath.Dir(strings.Trim(sourcePath, "/")), link)
+			if target == ".." || strings.HasPrefix(target, "../") {
+				return fmt.Errorf("invalid package entry %s: symlink target %q escapes root", sourcePath, link)
+			}
+		}
+	}
+	return nil
+}
+
+func hasDotDot(path string) bool {
+	for _, part := range strings.Split(path, "/") {
+		if part == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// checkInsideRoot returns an error if writing to path would end up outside
+// of rootDir, which must have no symlinks, because some existing parent of
+// path is a symlink pointing outside of it.
+func checkInsideRoot(rootDir, path string) error {
+	dir := filepath.Dir(path)
+	for {
+		_, err := os.Lstat(dir)
+		if err == nil {
+			break
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		dir = filepath.Dir(dir)
+	}
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	if resolved != rootDir && !strings.HasPrefix(resolved, strings.TrimSuffix(rootDir, "/")+"/") {
+		return fmt.Errorf("cannot create %s: parent directory resolves outside of root", path)
+	}
+	return nil
+}
+
 func parentDirs(path string) []string {
 	path = filepath.Clean(path)
 	parents := make([]string, strings.Count(path, "/"))
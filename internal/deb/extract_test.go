@@ -1,18 +1,25 @@
 package deb_test
 
 import (
+	"archive/tar"
 	"bytes"
+	"encoding/base64"
 	"path/filepath"
 	"sort"
 	"strings"
 
+	"github.com/blakesmith/ar"
 	. "gopkg.in/check.v1"
 
 	"github.com/canonical/chisel/internal/deb"
 	"github.com/canonical/chisel/internal/fsutil"
 	"github.com/canonical/chisel/internal/testutil"
+	"github.com/canonical/chisel/public/testdeb"
 )
 
+// longName does not fit in the name field of tar headers.
+var longName = strings.Repeat("long-name-", 12)
+
 type extractTest struct {
 	summary string
 	pkgdata []byte
@@ -337,6 +344,32 @@ var extractTests = []extractTest{{
 		"/日本/語": "file 0644 85738f8f",
 	},
 	notCreated: []string{},
+}, {
+	summary: "Extract long names from PAX records and GNU headers",
+	pkgdata: testutil.MustMakeDeb([]testutil.TarEntry{
+		testutil.Dir(0755, "./"),
+		{Header: tar.Header{Typeflag: tar.TypeDir, Name: "./" + longName, Mode: 0700, Format: tar.FormatPAX}},
+		{Header: tar.Header{Typeflag: tar.TypeReg, Name: "./" + longName + "/" + longName, Mode: 0644, Size: 4, Format: tar.FormatPAX}, Content: []byte("data")},
+		{Header: tar.Header{Typeflag: tar.TypeDir, Name: "./gnu/" + longName, Mode: 0700, Format: tar.FormatGNU}},
+		{Header: tar.Header{Typeflag: tar.TypeReg, Name: "./gnu/" + longName + "/file", Mode: 0644, Size: 4, Format: tar.FormatGNU}, Content: []byte("data")},
+	}),
+	options: deb.ExtractOptions{
+		Extract: map[string][]deb.ExtractInfo{
+			"/" + longName + "/" + longName: []deb.ExtractInfo{{
+				Path: "/" + longName + "/" + longName,
+			}},
+			"/gnu/" + longName + "/": []deb.ExtractInfo{{
+				Path: "/gnu/" + longName + "/",
+			}},
+		},
+	},
+	result: map[string]string{
+		"/" + longName + "/":            "dir 0700",
+		"/" + longName + "/" + longName: "file 0644 3a6eb079",
+		"/gnu/":                         "dir 0755",
+		"/gnu/" + longName + "/":        "dir 0700",
+	},
+	notCreated: []string{"/gnu/"},
 }, {
 	summary: "Entries for same destination must have the same mode",
 	pkgdata: testutil.PackageData["test-package"],
@@ -352,6 +385,115 @@ var extractTests = []extractTest{{
 		},
 	},
 	error: `cannot extract from package "test-package": path /dir/ requested twice with diverging mode: 0777 != 0000`,
+}, {
+	summary: "Entries cannot escape root via ..",
+	pkgdata: testutil.MustMakeDeb([]testutil.TarEntry{
+		testutil.Dir(0755, "./"),
+		testutil.Reg(0644, "./../evil", "whatever"),
+	}),
+	options: deb.ExtractOptions{
+		Extract: map[string][]deb.ExtractInfo{
+			"/**": []deb.ExtractInfo{{
+				Path: "/**",
+			}},
+		},
+	},
+	error: `cannot extract from package "test-package": invalid package entry /../evil: path escapes root`,
+}, {
+	summary: "Entries cannot escape root via nested ..",
+	pkgdata: testutil.MustMakeDeb([]testutil.TarEntry{
+		testutil.Dir(0755, "./"),
+		testutil.Dir(0755, "./dir/"),
+		testutil.Reg(0644, "./dir/../../evil", "whatever"),
+	}),
+	options: deb.ExtractOptions{
+		Extract: map[string][]deb.ExtractInfo{
+			"/dir/file": []deb.ExtractInfo{{
+				Path: "/dir/file",
+			}},
+		},
+	},
+	error: `cannot extract from package "test-package": invalid package entry /dir/../../evil: path escapes root`,
+}, {
+	summary: "Hard links cannot target absolute paths",
+	pkgdata: testutil.MustMakeDeb([]testutil.TarEntry{
+		testutil.Dir(0755, "./"),
+		{Header: tar.Header{Name: "./link", Typeflag: tar.TypeLink, Linkname: "/etc/passwd"}},
+	}),
+	options: deb.ExtractOptions{
+		Extract: map[string][]deb.ExtractInfo{
+			"/**": []deb.ExtractInfo{{
+				Path: "/**",
+			}},
+		},
+	},
+	error: `cannot extract from package "test-package": invalid package entry /link: hard link target "/etc/passwd" escapes root`,
+}, {
+	summary: "Hard links cannot target paths outside of root",
+	pkgdata: testutil.MustMakeDeb([]testutil.TarEntry{
+		testutil.Dir(0755, "./"),
+		{Header: tar.Header{Name: "./link", Typeflag: tar.TypeLink, Linkname: "./../evil"}},
+	}),
+	options: deb.ExtractOptions{
+		Extract: map[string][]deb.ExtractInfo{
+			"/**": []deb.ExtractInfo{{
+				Path: "/**",
+			}},
+		},
+	},
+	error: `cannot extract from package "test-package": invalid package entry /link: hard link target "./../evil" escapes root`,
+}, {
+	summary: "Relative symlinks cannot target paths outside of root",
+	pkgdata: testutil.MustMakeDeb([]testutil.TarEntry{
+		testutil.Dir(0755, "./"),
+		testutil.Dir(0755, "./dir/"),
+		testutil.Lnk(0777, "./dir/link", "../../etc"),
+	}),
+	options: deb.ExtractOptions{
+		Extract: map[string][]deb.ExtractInfo{
+			"/**": []deb.ExtractInfo{{
+				Path: "/**",
+			}},
+		},
+	},
+	error: `cannot extract from package "test-package": invalid package entry /dir/link: symlink target "../../etc" escapes root`,
+}, {
+	summary: "Entries cannot be written through symlinks pointing outside of root",
+	pkgdata: testutil.MustMakeDeb([]testutil.TarEntry{
+		testutil.Dir(0755, "./"),
+		testutil.Lnk(0777, "./link", "/"),
+		testutil.Reg(0644, "./link/evil", "whatever"),
+	}),
+	options: deb.ExtractOptions{
+		Extract: map[string][]deb.ExtractInfo{
+			"/**": []deb.ExtractInfo{{
+				Path: "/**",
+			}},
+		},
+	},
+	error: `cannot extract from package "test-package": cannot create .*/link/evil: parent directory resolves outside of root`,
+}, {
+	summary: "Entries may be written through symlinks pointing inside root",
+	pkgdata: testutil.MustMakeDeb([]testutil.TarEntry{
+		testutil.Dir(0755, "./"),
+		testutil.Dir(0755, "./usr/"),
+		testutil.Dir(0755, "./usr/lib/"),
+		testutil.Lnk(0777, "./lib", "usr/lib"),
+		testutil.Reg(0644, "./lib/file", "whatever"),
+	}),
+	options: deb.ExtractOptions{
+		Extract: map[string][]deb.ExtractInfo{
+			"/**": []deb.ExtractInfo{{
+				Path: "/**",
+			}},
+		},
+	},
+	result: map[string]string{
+		"/lib":          "symlink usr/lib",
+		"/usr/":         "dir 0755",
+		"/usr/lib/":     "dir 0755",
+		"/usr/lib/file": "file 0644 85738f8f",
+	},
 }}
 
 func (s *S) TestExtract(c *C) {
@@ -494,3 +636,120 @@ func (s *S) TestExtractCreateCallback(c *C) {
 		c.Assert(createExtractInfos, DeepEquals, test.calls)
 	}
 }
+
+func (s *S) TestExtractXattrs(c *C) {
+	pkgdata := testutil.MustMakeDeb([]testutil.TarEntry{
+		testutil.Dir(0755, "./"),
+		{
+			Header: tar.Header{
+				Name:   "./ping",
+				Mode:   0755,
+				Format: tar.FormatPAX,
+				PAXRecords: map[string]string{
+					"SCHILY.xattr.security.capability": "\x01\x00\x00\x02",
+				},
+			},
+			Content: []byte("data"),
+		},
+		testutil.Reg(0644, "./file", "data"),
+	})
+	xattrs := make(map[string]map[string]string)
+	options := deb.ExtractOptions{
+		Package:   "test-package",
+		TargetDir: c.MkDir(),
+		Extract: map[string][]deb.ExtractInfo{
+			"/**": []deb.ExtractInfo{{
+				Path: "/**",
+			}},
+		},
+		Create: func(_ []deb.ExtractInfo, o *fsutil.CreateOptions) error {
+			xattrs[filepath.Base(o.Path)] = o.Xattrs
+			return nil
+		},
+	}
+	err := deb.Extract(bytes.NewBuffer(pkgdata), &options)
+	c.Assert(err, IsNil)
+	c.Assert(xattrs["ping"], DeepEquals, map[string]string{"security.capability": "\x01\x00\x00\x02"})
+	c.Assert(xattrs["file"], IsNil)
+}
+
+// The tarballs of a package whose control file holds "Package: bzip2-package"
+// and whose data holds ./file, compressed with bzip2, for which there is no
+// compressor in Go.
+const (
+	bzip2ControlTar = "QlpoOTFBWSZTWdcUvIEAACp7kEqQAEBAA/eQQIF6rd4QBAAgAHQSo1Rmp6EYCNkmmaD1QSo1DTQDQAAAOw2kdMwYApznhIECOpZS0aFAsQFZoDx0IY1WriOeMGGUHnsaMPLJi3tx3+NwhS6vB6NvsQKYyIlGhHYQWRyfUaCBxKcogVijgUXoWc/i7kinChIa4peQIA=="
+	bzip2DataTar    = "QlpoOTFBWSZTWS0biv4AACj7kEmAAEBAAfeAABFnLp4ABAAgAHUJSTTEA0G1AZAKqm1E9EY1Mho0aMn4050vmmKsMa1JwX5STuXFEkFUiH1Cf2o54ghJYEhghlejxjYra+I5YVIKU7p7y2dNN9TU3eVEcLDBw5P4u5IpwoSBaNxX8A=="
+)
+
+// makeArchive returns an ar archive with the provided members, in order.
+func makeArchive(c *C, members ...[2]string) []byte {
+	var buf bytes.Buffer
+	writer := ar.NewWriter(&buf)
+	c.Assert(writer.WriteGlobalHeader(), IsNil)
+	for _, member := range members {
+		data := []byte(member[1])
+		c.Assert(writer.WriteHeader(&ar.Header{Name: member[0], Mode: 0644, Size: int64(len(data))}), IsNil)
+		_, err := writer.Write(data)
+		c.Assert(err, IsNil)
+	}
+	return buf.Bytes()
+}
+
+func bzip2Package(c *C) []byte {
+	control, err := base64.StdEncoding.DecodeString(bzip2ControlTar)
+	c.Assert(err, IsNil)
+	data, err := base64.StdEncoding.DecodeString(bzip2DataTar)
+	c.Assert(err, IsNil)
+	return makeArchive(c,
+		[2]string{"debian-binary", "2.0\n"},
+		[2]string{"control.tar.bz2", string(control)},
+		[2]string{"data.tar.bz2", string(data)},
+	)
+}
+
+func (s *S) TestExtractCompressions(c *C) {
+	packages := map[string][]byte{"bz2": bzip2Package(c)}
+	for _, compression := range []testdeb.Compression{testdeb.Zstd, testdeb.Gzip, testdeb.Xz, testdeb.Lzma, testdeb.None} {
+		packages[string(compression)] = testdeb.MustMake(testutil.OtherPackageEntries, &testdeb.Options{Compression: compression})
+	}
+	for compression, pkgdata := range packages {
+		c.Logf("Compression: %s", compression)
+		dir := c.MkDir()
+		err := deb.Extract(bytes.NewReader(pkgdata), &deb.ExtractOptions{
+			Package:   "test-package",
+			TargetDir: dir,
+			Extract: map[string][]deb.ExtractInfo{
+				"/file": {{Path: "/file"}},
+			},
+		})
+		c.Assert(err, IsNil)
+		c.Assert(testutil.TreeDump(dir), DeepEquals, map[string]string{
+			"/file": "file 0644 fc02ca0e",
+		})
+	}
+
+	pkgdata := makeArchive(c, [2]string{"debian-binary", "2.0\n"}, [2]string{"data.tar.lz4", "data"})
+	err := deb.Extract(bytes.NewReader(pkgdata), &deb.ExtractOptions{
+		Package:   "test-package",
+		TargetDir: c.MkDir(),
+	})
+	c.Assert(err, ErrorMatches, `cannot extract from package "test-package": unsupported compression of data.tar.lz4`)
+}
+
+func (s *S) TestReadControl(c *C) {
+	for _, compression := range []testdeb.Compression{testdeb.Zstd, testdeb.Gzip, testdeb.Xz, testdeb.Lzma, testdeb.None} {
+		c.Logf("Compression: %s", compression)
+		pkgdata := testdeb.MustMake(nil, &testdeb.Options{Package: "foo", Compression: compression})
+		control, err := deb.ReadControl(bytes.NewReader(pkgdata))
+		c.Assert(err, IsNil)
+		c.Assert(strings.HasPrefix(string(control), "Package: foo\n"), Equals, true)
+	}
+
+	control, err := deb.ReadControl(bytes.NewReader(bzip2Package(c)))
+	c.Assert(err, IsNil)
+	c.Assert(string(control), Equals, "Package: bzip2-package\n")
+
+	pkgdata := makeArchive(c, [2]string{"debian-binary", "2.0\n"})
+	_, err = deb.ReadControl(bytes.NewReader(pkgdata))
+	c.Assert(err, ErrorMatches, `cannot read control file: no control payload`)
+}
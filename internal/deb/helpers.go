@@ -3,6 +3,7 @@ package deb
 import (
 	"fmt"
 	"runtime"
+	"sort"
 )
 
 type archPair struct {
@@ -31,6 +32,16 @@ func InferArch() (string, error) {
 	return "", fmt.Errorf("cannot infer package architecture from current platform architecture: %s", platformGoArch)
 }
 
+// KnownArchs returns the package architectures supported, sorted by name.
+func KnownArchs() []string {
+	archs := make([]string, 0, len(knownArchs))
+	for _, arch := range knownArchs {
+		archs = append(archs, arch.debArch)
+	}
+	sort.Strings(archs)
+	return archs
+}
+
 func ValidateArch(debArch string) error {
 	for _, arch := range knownArchs {
 		if arch.debArch == debArch {
@@ -42,3 +42,11 @@ func (s *S) TestValidateArch(c *C) {
 	c.Assert(deb.ValidateArch("i3866"), Not(IsNil))
 	c.Assert(deb.ValidateArch(""), Not(IsNil))
 }
+
+func (s *S) TestKnownArchs(c *C) {
+	archs := deb.KnownArchs()
+	c.Assert(archs, DeepEquals, []string{"amd64", "arm64", "armhf", "i386", "ppc64el", "riscv64", "s390x"})
+	for _, arch := range archs {
+		c.Assert(deb.ValidateArch(arch), IsNil)
+	}
+}
@@ -2,6 +2,7 @@ package fsutil
 
 import (
 	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/hex"
 	"fmt"
 	"hash"
@@ -9,6 +10,9 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"runtime"
+	"syscall"
+	"time"
 )
 
 type CreateOptions struct {
@@ -17,8 +21,18 @@ type CreateOptions struct {
 	Data io.Reader
 	Link string
 	// If MakeParents is true, missing parent directories of Path are
-	// created with permissions 0755.
+	// created with permissions 0755, regardless of the umask.
 	MakeParents bool
+	// If MTime is not zero, it is set as the modification time of the
+	// created entry.
+	MTime time.Time
+	// DevMajor and DevMinor identify the device of device nodes.
+	DevMajor uint32
+	DevMinor uint32
+	// Xattrs holds extended attributes, such as security.capability, to
+	// set on the created entry, mapping their names to their raw values.
+	// Attributes which cannot be set are logged as warnings.
+	Xattrs map[string]string
 }
 
 type Entry struct {
@@ -27,12 +41,47 @@ type Entry struct {
 	Hash string
 	Size int
 	Link string
+	// Xattrs holds the extended attributes requested for the entry, even
+	// if setting them failed.
+	Xattrs map[string]string
+	// SHA512 holds the SHA512 digest of regular files, only computed by
+	// creators asked to do so.
+	SHA512 string
 }
 
-// Create creates a filesystem entry according to the provided options and returns
-// the information about the created entry.
+// Creator creates filesystem entries according to the provided options and
+// returns the information about the created entries. The content of regular
+// files is hashed the same way by every Creator.
+type Creator interface {
+	Create(options *CreateOptions) (*Entry, error)
+}
+
+// Create creates a filesystem entry on disk according to the provided options
+// and returns the information about the created entry.
 func Create(options *CreateOptions) (*Entry, error) {
-	rp := &readerProxy{inner: options.Data, h: sha256.New()}
+	return (&DiskCreator{}).Create(options)
+}
+
+// DiskCreator creates entries on disk.
+type DiskCreator struct {
+	// If Sync is true, the content of regular files is flushed to stable
+	// storage before they are closed, so that it survives a crash.
+	Sync bool
+	// If SHA512 is true, the SHA512 digest of regular files is computed
+	// as well.
+	SHA512 bool
+	// If Placeholders is true, symlinks are created as empty regular files
+	// but reported as requested. This allows building trees which are only
+	// meant to be archived, such as with AppendTar, on systems which may
+	// not be able to create symlinks.
+	Placeholders bool
+}
+
+var _ Creator = (*DiskCreator)(nil)
+
+func (dc *DiskCreator) Create(options *CreateOptions) (*Entry, error) {
+	rp := newReaderProxy(options.Data, dc.SHA512)
+	defer rp.finish()
 	// Use the proxy instead of the raw Reader.
 	optsCopy := *options
 	optsCopy.Data = rp
@@ -41,37 +90,98 @@ func Create(options *CreateOptions) (*Entry, error) {
 	var err error
 	var hash string
 	if o.MakeParents {
-		if err := os.MkdirAll(filepath.Dir(o.Path), 0755); err != nil {
+		if err := mkdirParents(filepath.Dir(o.Path)); err != nil {
 			return nil, err
 		}
 	}
 
 	switch o.Mode & fs.ModeType {
 	case 0:
-		err = createFile(o)
-		hash = hex.EncodeToString(rp.h.Sum(nil))
+		err = createFile(o, dc.Sync)
+		hash = rp.sha256()
 	case fs.ModeDir:
 		err = createDir(o)
 	case fs.ModeSymlink:
-		err = createSymlink(o)
+		if dc.Placeholders {
+			err = createPlaceholder(o)
+		} else {
+			err = createSymlink(o)
+		}
+	case fs.ModeDevice, fs.ModeDevice | fs.ModeCharDevice, fs.ModeNamedPipe:
+		err = createNode(o)
 	default:
 		err = fmt.Errorf("unsupported file type: %s", o.Path)
 	}
 	if err != nil {
 		return nil, err
 	}
+	if len(o.Xattrs) > 0 && o.Mode&fs.ModeSymlink == 0 {
+		setXattrs(o.Path, o.Xattrs)
+	}
+	if !o.MTime.IsZero() {
+		err = SetMTime(o.Path, o.MTime)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	s, err := os.Lstat(o.Path)
 	if err != nil {
 		return nil, err
 	}
+	mode := s.Mode()
+	if dc.Placeholders && o.Mode&fs.ModeSymlink != 0 {
+		mode = o.Mode
+	}
 	entry := &Entry{
 		Path: o.Path,
-		Mode: s.Mode(),
+		Mode: mode,
 		Hash: hash,
 		Size: rp.size,
 		Link: o.Link,
 	}
+	if len(o.Xattrs) > 0 {
+		entry.Xattrs = o.Xattrs
+	}
+	if o.Mode&fs.ModeType == 0 {
+		entry.SHA512 = rp.sha512()
+	}
+	return entry, nil
+}
+
+// DryRunCreator creates nothing, and only reports the entries that would be
+// created as requested. The content of regular files is read and hashed, so
+// the information returned is the same as when creating them on disk, except
+// for the mode of existing entries, which is not changed by DiskCreator.
+type DryRunCreator struct {
+	// If SHA512 is true, the SHA512 digest of regular files is computed
+	// as well.
+	SHA512 bool
+}
+
+var _ Creator = (*DryRunCreator)(nil)
+
+func (dc *DryRunCreator) Create(options *CreateOptions) (*Entry, error) {
+	debugf("Not creating (dry run): %s (mode %#o)", options.Path, options.Mode)
+	entry := &Entry{
+		Path: options.Path,
+		Mode: options.Mode,
+		Link: options.Link,
+	}
+	if options.Mode&fs.ModeType == 0 {
+		rp := newReaderProxy(options.Data, dc.SHA512)
+		defer rp.finish()
+		_, err := io.Copy(io.Discard, rp)
+		if err != nil {
+			return nil, err
+		}
+		entry.Hash = rp.sha256()
+		entry.SHA512 = rp.sha512()
+		entry.Size = rp.size
+	}
+	if len(options.Xattrs) > 0 {
+		entry.Xattrs = options.Xattrs
+	}
 	return entry, nil
 }
 
@@ -81,21 +191,89 @@ func createDir(o *CreateOptions) error {
 	if os.IsExist(err) {
 		return nil
 	}
-	return err
+	if err != nil {
+		return err
+	}
+	// The mode given to mkdir is masked by the umask of the process, so
+	// set it explicitly for the result not to depend on the environment.
+	return os.Chmod(o.Path, o.Mode)
 }
 
-func createFile(o *CreateOptions) error {
+// mkdirParents creates the directory at path and its missing parents with
+// permissions 0755, regardless of the umask. Existing directories are left
+// untouched.
+func mkdirParents(path string) error {
+	info, err := os.Stat(path)
+	if err == nil {
+		if !info.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: path, Err: syscall.ENOTDIR}
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	if parent := filepath.Dir(path); parent != path {
+		if err := mkdirParents(parent); err != nil {
+			return err
+		}
+	}
+	err = os.Mkdir(path, 0755)
+	if os.IsExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return os.Chmod(path, 0755)
+}
+
+func createFile(o *CreateOptions, sync bool) error {
 	debugf("Writing file: %s (mode %#o)", o.Path, o.Mode)
+	// Replace existing symlinks rather than writing to wherever they point.
+	fileinfo, err := os.Lstat(o.Path)
+	created := err != nil
+	if err == nil && fileinfo.Mode()&os.ModeSymlink != 0 {
+		err = os.Remove(o.Path)
+		if err != nil {
+			return err
+		}
+		created = true
+	}
 	file, err := os.OpenFile(o.Path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, o.Mode)
 	if err != nil {
 		return err
 	}
 	_, copyErr := io.Copy(file, o.Data)
+	if copyErr == nil && sync {
+		copyErr = file.Sync()
+	}
 	err = file.Close()
 	if copyErr != nil {
 		return copyErr
 	}
-	return err
+	if err != nil || !created {
+		return err
+	}
+	// As with directories, the mode of new files is set explicitly rather
+	// than relying on the umask of the process, which is shared by
+	// concurrent runs.
+	return os.Chmod(o.Path, o.Mode)
+}
+
+// createPlaceholder creates an empty regular file at the path of the entry
+// requested by o, replacing any existing one.
+func createPlaceholder(o *CreateOptions) error {
+	debugf("Creating placeholder: %s (mode %#o)", o.Path, o.Mode)
+	file, err := os.OpenFile(o.Path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+	return os.Chmod(o.Path, 0644)
 }
 
 func createSymlink(o *CreateOptions) error {
@@ -121,19 +299,117 @@ func createSymlink(o *CreateOptions) error {
 	return os.Symlink(o.Link, o.Path)
 }
 
+// concurrentHashSize is the amount of data read by a readerProxy after
+// which the rest is hashed concurrently with the reading and writing of
+// the content, as starting goroutines only pays off for large files and
+// with more than one CPU to run them.
+const concurrentHashSize = 1 << 20
+
 // readerProxy implements the io.Reader interface proxying the calls to its
-// inner io.Reader. On each read, the proxy keeps track of the file size and hash.
+// inner io.Reader. On each read, the proxy keeps track of the file size and hash,
+// and of the SHA512 digest if h512 is set. Past concurrentHashSize, every
+// digest is computed by its own hashWorker, so that hashing does not hold
+// back the disk writes. The digests are only available after finish.
 type readerProxy struct {
-	inner io.Reader
-	h     hash.Hash
-	size  int
+	inner   io.Reader
+	h       hash.Hash
+	h512    hash.Hash
+	size    int
+	workers []*hashWorker
 }
 
 var _ io.Reader = (*readerProxy)(nil)
 
+func newReaderProxy(inner io.Reader, withSHA512 bool) *readerProxy {
+	rp := &readerProxy{inner: inner, h: sha256.New()}
+	if withSHA512 {
+		rp.h512 = sha512.New()
+	}
+	return rp
+}
+
 func (rp *readerProxy) Read(p []byte) (n int, err error) {
 	n, err = rp.inner.Read(p)
-	rp.h.Write(p[:n])
+	if n > 0 {
+		rp.hash(p[:n])
+	}
 	rp.size += n
 	return n, err
 }
+
+func (rp *readerProxy) hash(data []byte) {
+	if rp.workers == nil && rp.size+len(data) > concurrentHashSize && runtime.GOMAXPROCS(0) > 1 {
+		rp.workers = append(rp.workers, newHashWorker(rp.h))
+		if rp.h512 != nil {
+			rp.workers = append(rp.workers, newHashWorker(rp.h512))
+		}
+	}
+	if rp.workers == nil {
+		rp.h.Write(data)
+		if rp.h512 != nil {
+			rp.h512.Write(data)
+		}
+		return
+	}
+	// The caller may reuse data once Read returns, and the workers only
+	// read their copy.
+	chunk := make([]byte, len(data))
+	copy(chunk, data)
+	for _, worker := range rp.workers {
+		worker.chunks <- chunk
+	}
+}
+
+// finish waits for every worker to hash the data read so far. It must be
+// called before the digests are obtained, and once it is no longer read
+// from, even on errors, so that the workers are stopped.
+func (rp *readerProxy) finish() {
+	for _, worker := range rp.workers {
+		worker.stop()
+	}
+	rp.workers = nil
+}
+
+// sha256 returns the SHA256 digest of the data read.
+func (rp *readerProxy) sha256() string {
+	rp.finish()
+	return hex.EncodeToString(rp.h.Sum(nil))
+}
+
+// sha512 returns the SHA512 digest of the data read, or an empty string if
+// it was not computed.
+func (rp *readerProxy) sha512() string {
+	rp.finish()
+	if rp.h512 == nil {
+		return ""
+	}
+	return hex.EncodeToString(rp.h512.Sum(nil))
+}
+
+// hashWorker writes the chunks of data sent to it into a hash from its own
+// goroutine.
+type hashWorker struct {
+	chunks chan []byte
+	done   chan struct{}
+}
+
+func newHashWorker(h hash.Hash) *hashWorker {
+	w := &hashWorker{
+		// Bound the memory held by chunks waiting to be hashed.
+		chunks: make(chan []byte, 16),
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(w.done)
+		for chunk := range w.chunks {
+			h.Write(chunk)
+		}
+	}()
+	return w
+}
+
+// stop waits for the chunks sent so far to be hashed.
+func (w *hashWorker) stop() {
+	close(w.chunks)
+	<-w.done
+}
@@ -0,0 +1,26 @@
+//go:build !linux && !darwin
+
+package fsutil
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// setXattrs logs that the extended attributes of the entry at path cannot be
+// set, as the host does not support them. As on other systems, failing to
+// set them does not prevent the entry from being used.
+func setXattrs(path string, xattrs map[string]string) {
+	logf("Warning: cannot set extended attributes of %s on this system", path)
+}
+
+func createNode(o *CreateOptions) error {
+	return fmt.Errorf("cannot create special file on this system: %s", o.Path)
+}
+
+// SetMTime sets both the access and modification times of the entry at path
+// to mtime.
+func SetMTime(path string, mtime time.Time) error {
+	return os.Chtimes(path, mtime, mtime)
+}
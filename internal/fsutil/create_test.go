@@ -2,11 +2,18 @@ package fsutil_test
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
-	"strings"
-	"syscall"
+	"runtime"
+	"testing"
+	"testing/iotest"
+	"time"
 
 	. "gopkg.in/check.v1"
 
@@ -14,122 +21,159 @@ import (
 	"github.com/canonical/chisel/internal/testutil"
 )
 
-type createTest struct {
-	options fsutil.CreateOptions
-	hackdir func(c *C, dir string)
-	result  map[string]string
-	error   string
+func (s *S) TestCreateMTime(c *C) {
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	dir := c.MkDir()
+	for _, options := range []fsutil.CreateOptions{{
+		Path: filepath.Join(dir, "file"),
+		Mode: 0644,
+		Data: bytes.NewBufferString("data"),
+	}, {
+		Path: filepath.Join(dir, "dir"),
+		Mode: fs.ModeDir | 0755,
+	}, {
+		Path: filepath.Join(dir, "link"),
+		Mode: fs.ModeSymlink,
+		// The target is missing, so the link itself must be changed.
+		Link: "missing",
+	}} {
+		options.MTime = mtime
+		_, err := fsutil.Create(&options)
+		c.Assert(err, IsNil)
+		info, err := os.Lstat(options.Path)
+		c.Assert(err, IsNil)
+		c.Assert(info.ModTime().Equal(mtime), Equals, true, Commentf("%s", options.Path))
+	}
 }
 
-var createTests = []createTest{{
-	options: fsutil.CreateOptions{
-		Path:        "foo/bar",
+func (s *S) TestDiskCreatorSync(c *C) {
+	dir := c.MkDir()
+	creator := &fsutil.DiskCreator{Sync: true}
+	entry, err := creator.Create(&fsutil.CreateOptions{
+		Path:        filepath.Join(dir, "foo/bar"),
+		Mode:        0644,
 		Data:        bytes.NewBufferString("data1"),
-		Mode:        0444,
 		MakeParents: true,
-	},
-	result: map[string]string{
+	})
+	c.Assert(err, IsNil)
+	c.Assert(testutil.TreeDumpEntry(entry), Equals, "file 0644 5b41362b")
+	c.Assert(testutil.TreeDump(dir), DeepEquals, map[string]string{
 		"/foo/":    "dir 0755",
-		"/foo/bar": "file 0444 5b41362b",
-	},
-}, {
-	options: fsutil.CreateOptions{
-		Path:        "foo/bar",
-		Link:        "../baz",
-		Mode:        fs.ModeSymlink,
-		MakeParents: true,
-	},
-	result: map[string]string{
-		"/foo/":    "dir 0755",
-		"/foo/bar": "symlink ../baz",
-	},
-}, {
-	options: fsutil.CreateOptions{
-		Path:        "foo/bar",
-		Mode:        fs.ModeDir | 0444,
-		MakeParents: true,
-	},
-	result: map[string]string{
-		"/foo/":     "dir 0755",
-		"/foo/bar/": "dir 0444",
-	},
-}, {
-	options: fsutil.CreateOptions{
-		Path: "tmp",
-		Mode: fs.ModeDir | fs.ModeSticky | 0775,
-	},
-	result: map[string]string{
-		"/tmp/": "dir 01775",
-	},
-}, {
-	options: fsutil.CreateOptions{
-		Path: "foo/bar",
-		Mode: fs.ModeDir | 0775,
-	},
-	error: `.*: no such file or directory`,
-}, {
-	options: fsutil.CreateOptions{
-		Path: "foo",
-		Mode: fs.ModeDir | 0775,
-	},
-	hackdir: func(c *C, dir string) {
-		c.Assert(os.Mkdir(filepath.Join(dir, "foo/"), fs.ModeDir|0765), IsNil)
-	},
-	result: map[string]string{
-		// mode is not updated.
-		"/foo/": "dir 0765",
-	},
-}, {
-	options: fsutil.CreateOptions{
-		Path: "foo",
-		// Mode should be ignored for existing entry.
+		"/foo/bar": "file 0644 5b41362b",
+	})
+}
+
+func (s *S) TestCreatorSHA512(c *C) {
+	const data1SHA512 = "9731b541b22c1d7042646ab2ee17685bbb664bced666d8ecf3593f3ef46493deef651b0f31b6cff8c4df8dcb425a1035e86ddb9877a8685647f39847be0d7c01"
+	dir := c.MkDir()
+	for _, creator := range []fsutil.Creator{
+		&fsutil.DiskCreator{SHA512: true},
+		&fsutil.DryRunCreator{SHA512: true},
+	} {
+		entry, err := creator.Create(&fsutil.CreateOptions{
+			Path: filepath.Join(dir, "file"),
+			Mode: 0644,
+			Data: bytes.NewBufferString("data1"),
+		})
+		c.Assert(err, IsNil)
+		c.Assert(entry.Hash, Equals, "5b41362bc82b7f3d56edc5a306db22105707d01ff4819e26faef9724a2d406c9")
+		c.Assert(entry.SHA512, Equals, data1SHA512)
+
+		entry, err = creator.Create(&fsutil.CreateOptions{
+			Path: filepath.Join(dir, "dir"),
+			Mode: fs.ModeDir | 0755,
+		})
+		c.Assert(err, IsNil)
+		c.Assert(entry.SHA512, Equals, "")
+	}
+
+	// The digest is only computed when requested.
+	entry, err := fsutil.Create(&fsutil.CreateOptions{
+		Path: filepath.Join(dir, "other"),
 		Mode: 0644,
-		Data: bytes.NewBufferString("changed"),
-	},
-	hackdir: func(c *C, dir string) {
-		c.Assert(os.WriteFile(filepath.Join(dir, "foo"), []byte("data"), 0666), IsNil)
-	},
-	result: map[string]string{
-		// mode is not updated.
-		"/foo": "file 0666 d67e2e94",
-	},
-}}
+		Data: bytes.NewBufferString("data1"),
+	})
+	c.Assert(err, IsNil)
+	c.Assert(entry.SHA512, Equals, "")
+}
 
-func (s *S) TestCreate(c *C) {
-	oldUmask := syscall.Umask(0)
-	defer func() {
-		syscall.Umask(oldUmask)
-	}()
+func (s *S) TestCreatorLargeFile(c *C) {
+	// Large files are hashed concurrently with being written, in chunks
+	// of varying sizes, when there are CPUs to spare.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(2))
+	data := bytes.Repeat([]byte("0123456789abcdef"), 300000)
+	sum256 := sha256.Sum256(data)
+	sum512 := sha512.Sum512(data)
+	dir := c.MkDir()
+	for _, creator := range []fsutil.Creator{
+		&fsutil.DiskCreator{SHA512: true},
+		&fsutil.DryRunCreator{SHA512: true},
+	} {
+		entry, err := creator.Create(&fsutil.CreateOptions{
+			Path: filepath.Join(dir, "file"),
+			Mode: 0644,
+			Data: iotest.HalfReader(bytes.NewReader(data)),
+		})
+		c.Assert(err, IsNil)
+		c.Assert(entry.Size, Equals, len(data))
+		c.Assert(entry.Hash, Equals, hex.EncodeToString(sum256[:]))
+		c.Assert(entry.SHA512, Equals, hex.EncodeToString(sum512[:]))
+	}
+	written, err := os.ReadFile(filepath.Join(dir, "file"))
+	c.Assert(err, IsNil)
+	c.Assert(bytes.Equal(written, data), Equals, true)
 
-	for _, test := range createTests {
-		if test.result == nil {
-			// Empty map for no files created.
-			test.result = make(map[string]string)
-		}
-		c.Logf("Options: %v", test.options)
-		dir := c.MkDir()
-		if test.hackdir != nil {
-			test.hackdir(c, dir)
-		}
-		options := test.options
-		options.Path = filepath.Join(dir, options.Path)
-		entry, err := fsutil.Create(&options)
+	// Failures while reading stop the hashing as well.
+	_, err = fsutil.Create(&fsutil.CreateOptions{
+		Path: filepath.Join(dir, "failed"),
+		Mode: 0644,
+		Data: io.MultiReader(bytes.NewReader(data), iotest.ErrReader(errors.New("BAM"))),
+	})
+	c.Assert(err, ErrorMatches, "BAM")
+}
 
-		if test.error != "" {
-			c.Assert(err, ErrorMatches, test.error)
-			continue
+func BenchmarkCreateLargeFile(b *testing.B) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 4<<20)
+	path := filepath.Join(b.TempDir(), "file")
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		_, err := fsutil.Create(&fsutil.CreateOptions{
+			Path: path,
+			Mode: 0644,
+			Data: bytes.NewReader(data),
+		})
+		if err != nil {
+			b.Fatal(err)
 		}
+	}
+}
 
+func (s *S) TestDryRunCreator(c *C) {
+	dir := c.MkDir()
+	creator := &fsutil.DryRunCreator{}
+	for _, options := range []fsutil.CreateOptions{{
+		Path:        filepath.Join(dir, "foo/bar"),
+		Mode:        0644,
+		Data:        bytes.NewBufferString("data1"),
+		MakeParents: true,
+	}, {
+		Path: filepath.Join(dir, "dir"),
+		Mode: fs.ModeDir | 0755,
+	}, {
+		Path: filepath.Join(dir, "link"),
+		Mode: fs.ModeSymlink | 0777,
+		Link: "foo/bar",
+	}} {
+		entry, err := creator.Create(&options)
 		c.Assert(err, IsNil)
-		c.Assert(testutil.TreeDump(dir), DeepEquals, test.result)
-		// [fsutil.Create] does not return information about parent directories
-		// created implicitly. We only check for the requested path.
-		entry.Path = strings.TrimPrefix(entry.Path, dir)
-		// Add the slashes that TreeDump adds to the path.
-		slashPath := "/" + test.options.Path
-		if test.options.Mode.IsDir() {
-			slashPath = slashPath + "/"
+		c.Assert(entry.Path, Equals, options.Path)
+		c.Assert(entry.Mode, Equals, options.Mode)
+		c.Assert(entry.Link, Equals, options.Link)
+		if options.Mode.IsRegular() {
+			c.Assert(entry.Size, Equals, 5)
+			c.Assert(entry.Hash, Equals, "5b41362bc82b7f3d56edc5a306db22105707d01ff4819e26faef9724a2d406c9")
 		}
-		c.Assert(testutil.TreeDumpEntry(entry), DeepEquals, test.result[slashPath])
 	}
+	// Nothing was created.
+	c.Assert(testutil.TreeDump(dir), DeepEquals, map[string]string{})
 }
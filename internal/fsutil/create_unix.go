@@ -0,0 +1,68 @@
+//go:build linux || darwin
+
+package fsutil
+
+import (
+	"io/fs"
+	"os"
+	"sort"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// setXattrs sets the extended attributes of the entry at path, logging the
+// ones that could not be set. Setting some attributes requires privileges
+// or support from the underlying filesystem, and failing to set them does
+// not prevent the entry from being used.
+func setXattrs(path string, xattrs map[string]string) {
+	names := make([]string, 0, len(xattrs))
+	for name := range xattrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		err := unix.Lsetxattr(path, name, []byte(xattrs[name]), 0)
+		if err != nil {
+			logf("Warning: cannot set extended attribute %s of %s: %v", name, path, err)
+		}
+	}
+}
+
+func createNode(o *CreateOptions) error {
+	debugf("Creating special file: %s (mode %#o)", o.Path, o.Mode)
+	mode := uint32(o.Mode.Perm())
+	switch o.Mode & fs.ModeType {
+	case fs.ModeDevice:
+		mode |= unix.S_IFBLK
+	case fs.ModeDevice | fs.ModeCharDevice:
+		mode |= unix.S_IFCHR
+	case fs.ModeNamedPipe:
+		mode |= unix.S_IFIFO
+	}
+	if o.Mode&fs.ModeSetuid != 0 {
+		mode |= unix.S_ISUID
+	}
+	if o.Mode&fs.ModeSetgid != 0 {
+		mode |= unix.S_ISGID
+	}
+	if o.Mode&fs.ModeSticky != 0 {
+		mode |= unix.S_ISVTX
+	}
+	err := unix.Mknod(o.Path, mode, int(unix.Mkdev(o.DevMajor, o.DevMinor)))
+	if err != nil {
+		return &os.PathError{Op: "mknod", Path: o.Path, Err: err}
+	}
+	return os.Chmod(o.Path, o.Mode)
+}
+
+// SetMTime sets both the access and modification times of the entry at path
+// to mtime. Symlinks are not followed, so their own times are changed.
+func SetMTime(path string, mtime time.Time) error {
+	ts := unix.NsecToTimespec(mtime.UnixNano())
+	err := unix.UtimesNanoAt(unix.AT_FDCWD, path, []unix.Timespec{ts, ts}, unix.AT_SYMLINK_NOFOLLOW)
+	if err != nil {
+		return &os.PathError{Op: "utimensat", Path: path, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+//go:build linux || darwin
+
+package fsutil_test
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/fsutil"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+type createTest struct {
+	options fsutil.CreateOptions
+	hackdir func(c *C, dir string)
+	result  map[string]string
+	error   string
+}
+
+var createTests = []createTest{{
+	options: fsutil.CreateOptions{
+		Path:        "foo/bar",
+		Data:        bytes.NewBufferString("data1"),
+		Mode:        0444,
+		MakeParents: true,
+	},
+	result: map[string]string{
+		"/foo/":    "dir 0755",
+		"/foo/bar": "file 0444 5b41362b",
+	},
+}, {
+	options: fsutil.CreateOptions{
+		Path:        "foo/bar",
+		Link:        "../baz",
+		Mode:        fs.ModeSymlink,
+		MakeParents: true,
+	},
+	result: map[string]string{
+		"/foo/":    "dir 0755",
+		"/foo/bar": "symlink ../baz",
+	},
+}, {
+	options: fsutil.CreateOptions{
+		Path:        "foo/bar",
+		Mode:        fs.ModeDir | 0444,
+		MakeParents: true,
+	},
+	result: map[string]string{
+		"/foo/":     "dir 0755",
+		"/foo/bar/": "dir 0444",
+	},
+}, {
+	options: fsutil.CreateOptions{
+		Path: "tmp",
+		Mode: fs.ModeDir | fs.ModeSticky | 0775,
+	},
+	result: map[string]string{
+		"/tmp/": "dir 01775",
+	},
+}, {
+	options: fsutil.CreateOptions{
+		Path: "foo/bar",
+		Mode: fs.ModeDir | 0775,
+	},
+	error: `.*: no such file or directory`,
+}, {
+	options: fsutil.CreateOptions{
+		Path: "foo",
+		Mode: fs.ModeDir | 0775,
+	},
+	hackdir: func(c *C, dir string) {
+		c.Assert(os.Mkdir(filepath.Join(dir, "foo/"), fs.ModeDir|0765), IsNil)
+	},
+	result: map[string]string{
+		// mode is not updated.
+		"/foo/": "dir 0765",
+	},
+}, {
+	options: fsutil.CreateOptions{
+		Path: "foo",
+		// Mode should be ignored for existing entry.
+		Mode: 0644,
+		Data: bytes.NewBufferString("changed"),
+	},
+	hackdir: func(c *C, dir string) {
+		c.Assert(os.WriteFile(filepath.Join(dir, "foo"), []byte("data"), 0666), IsNil)
+	},
+	result: map[string]string{
+		// mode is not updated.
+		"/foo": "file 0666 d67e2e94",
+	},
+}, {
+	options: fsutil.CreateOptions{
+		Path: "foo",
+		Mode: 0644,
+		Data: bytes.NewBufferString("data"),
+	},
+	hackdir: func(c *C, dir string) {
+		c.Assert(os.WriteFile(filepath.Join(dir, "target"), []byte("target"), 0644), IsNil)
+		c.Assert(os.Symlink("target", filepath.Join(dir, "foo")), IsNil)
+	},
+	result: map[string]string{
+		// The symlink is replaced and its target is left untouched.
+		"/foo":    "file 0644 3a6eb079",
+		"/target": "file 0644 34a04005",
+	},
+}, {
+	options: fsutil.CreateOptions{
+		Path: "fifo",
+		Mode: fs.ModeNamedPipe | 0640,
+	},
+	result: map[string]string{
+		"/fifo": "fifo 0640",
+	},
+}}
+
+func (s *S) TestCreate(c *C) {
+	oldUmask := syscall.Umask(0)
+	defer func() {
+		syscall.Umask(oldUmask)
+	}()
+
+	for _, test := range createTests {
+		if test.result == nil {
+			// Empty map for no files created.
+			test.result = make(map[string]string)
+		}
+		c.Logf("Options: %v", test.options)
+		dir := c.MkDir()
+		if test.hackdir != nil {
+			test.hackdir(c, dir)
+		}
+		options := test.options
+		options.Path = filepath.Join(dir, options.Path)
+		entry, err := fsutil.Create(&options)
+
+		if test.error != "" {
+			c.Assert(err, ErrorMatches, test.error)
+			continue
+		}
+
+		c.Assert(err, IsNil)
+		c.Assert(testutil.TreeDump(dir), DeepEquals, test.result)
+		// [fsutil.Create] does not return information about parent directories
+		// created implicitly. We only check for the requested path.
+		entry.Path = strings.TrimPrefix(entry.Path, dir)
+		// Add the slashes that TreeDump adds to the path.
+		slashPath := "/" + test.options.Path
+		if test.options.Mode.IsDir() {
+			slashPath = slashPath + "/"
+		}
+		c.Assert(testutil.TreeDumpEntry(entry), DeepEquals, test.result[slashPath])
+	}
+}
+
+func (s *S) TestCreateUmask(c *C) {
+	for _, umask := range []int{0, 0022, 0077, 0777} {
+		c.Logf("Umask: %#o", umask)
+		oldUmask := syscall.Umask(umask)
+		dir := c.MkDir()
+		_, err := fsutil.Create(&fsutil.CreateOptions{
+			Path:        filepath.Join(dir, "parent/dir"),
+			Mode:        fs.ModeDir | fs.ModeSticky | 0775,
+			MakeParents: true,
+		})
+		if err == nil {
+			_, err = fsutil.Create(&fsutil.CreateOptions{
+				Path:        filepath.Join(dir, "other/parent/dir"),
+				Mode:        fs.ModeDir | 0700,
+				MakeParents: true,
+			})
+		}
+		if err == nil {
+			_, err = fsutil.Create(&fsutil.CreateOptions{
+				Path: filepath.Join(dir, "parent/file"),
+				Mode: 0664,
+				Data: bytes.NewBufferString("data"),
+			})
+		}
+		syscall.Umask(oldUmask)
+		c.Assert(err, IsNil)
+		// Implicit parents use the documented default, and explicit
+		// entries the requested mode.
+		c.Assert(testutil.TreeDump(dir), DeepEquals, map[string]string{
+			"/parent/":           "dir 0755",
+			"/parent/dir/":       "dir 01775",
+			"/parent/file":       "file 0664 3a6eb079",
+			"/other/":            "dir 0755",
+			"/other/parent/":     "dir 0755",
+			"/other/parent/dir/": "dir 0700",
+		})
+	}
+}
+
+func (s *S) TestCreateXattrs(c *C) {
+	path := filepath.Join(c.MkDir(), "file")
+	xattrs := map[string]string{"user.chisel-test": "value"}
+	entry, err := fsutil.Create(&fsutil.CreateOptions{
+		Path:   path,
+		Mode:   0644,
+		Data:   bytes.NewBufferString("data"),
+		Xattrs: xattrs,
+	})
+	c.Assert(err, IsNil)
+	// The attributes are reported even if the filesystem can't hold them.
+	c.Assert(entry.Xattrs, DeepEquals, xattrs)
+
+	buf := make([]byte, 64)
+	n, err := unix.Lgetxattr(path, "user.chisel-test", buf)
+	if err == unix.ENOTSUP {
+		c.Skip("filesystem does not support user extended attributes")
+	}
+	c.Assert(err, IsNil)
+	c.Assert(string(buf[:n]), Equals, "value")
+}
@@ -0,0 +1,387 @@
+package fsutil
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ReadTree walks the tree under root and returns the information of every
+// entry found in it, indexed by their path relative to root. Paths start
+// with "/" and directories end with "/", as in slice definitions. Regular
+// files are hashed the same way as by Create.
+func ReadTree(root string) (map[string]*Entry, error) {
+	entries := make(map[string]*Entry)
+	err := filepath.WalkDir(root, func(fpath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(root, fpath)
+		if err != nil {
+			return err
+		}
+		if relPath == "." {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entry := &Entry{Path: "/" + filepath.ToSlash(relPath), Mode: info.Mode()}
+		switch info.Mode().Type() {
+		case fs.ModeDir:
+			entry.Path += "/"
+		case fs.ModeSymlink:
+			entry.Link, err = os.Readlink(fpath)
+			if err != nil {
+				return err
+			}
+		case 0:
+			f, err := os.Open(fpath)
+			if err != nil {
+				return err
+			}
+			entry.Hash, entry.Size, err = hashReader(f)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("cannot read %s: %w", fpath, err)
+			}
+		}
+		entries[entry.Path] = entry
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// ReadTarTree returns the information of every entry in the tar archive read
+// from r, which may be compressed with gzip, in the same form as ReadTree.
+// Hard links are reported as the regular files they point to.
+func ReadTarTree(r io.Reader) (map[string]*Entry, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err == nil && bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gzipReader, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decompress tarball: %w", err)
+		}
+		defer gzipReader.Close()
+		r = gzipReader
+	} else {
+		r = br
+	}
+
+	entries := make(map[string]*Entry)
+	tarReader := tar.NewReader(r)
+	for {
+		hdr, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot read tarball: %w", err)
+		}
+		relPath := tarPath(hdr.Name)
+		if relPath == "/" {
+			continue
+		}
+		entry := &Entry{Path: relPath, Mode: hdr.FileInfo().Mode(), Xattrs: TarXattrs(hdr)}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			entry.Path = strings.TrimSuffix(relPath, "/") + "/"
+		case tar.TypeSymlink:
+			entry.Link = hdr.Linkname
+		case tar.TypeLink:
+			target, ok := entries[tarPath(hdr.Linkname)]
+			if !ok {
+				return nil, fmt.Errorf("cannot read tarball: hard link %s to unknown path %s", relPath, hdr.Linkname)
+			}
+			entry.Mode = target.Mode
+			entry.Hash = target.Hash
+			entry.Size = target.Size
+		case tar.TypeReg:
+			entry.Hash, entry.Size, err = hashReader(tarReader)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read tarball: %w", err)
+			}
+		}
+		entries[entry.Path] = entry
+	}
+	return entries, nil
+}
+
+// tarXattrPrefix prefixes the names of extended attributes in the PAX
+// records of tar entries.
+const tarXattrPrefix = "SCHILY.xattr."
+
+// TarXattrs returns the extended attributes recorded in the PAX records of
+// hdr, or nil if there are none.
+func TarXattrs(hdr *tar.Header) map[string]string {
+	var xattrs map[string]string
+	for key, value := range hdr.PAXRecords {
+		if name, ok := strings.CutPrefix(key, tarXattrPrefix); ok {
+			if xattrs == nil {
+				xattrs = make(map[string]string)
+			}
+			xattrs[name] = value
+		}
+	}
+	return xattrs
+}
+
+// tarPath returns the path of a tar entry in the form used by ReadTree,
+// without the trailing "/" of directories.
+func tarPath(name string) string {
+	return path.Clean("/" + strings.TrimPrefix(name, "./"))
+}
+
+func hashReader(r io.Reader) (hash string, size int, err error) {
+	rp := newReaderProxy(r, false)
+	defer rp.finish()
+	_, err = io.Copy(io.Discard, rp)
+	if err != nil {
+		return "", 0, err
+	}
+	return rp.sha256(), rp.size, nil
+}
+
+// AppendTar appends every entry in the tree under root to the uncompressed
+// tar archive at tarPath, so that both form a single layer. Directories
+// already in the archive are kept as they are, and any other path of the
+// tree which is already in the archive is a collision reported as an error
+// before anything is written. Entries are appended owned by root.
+//
+// If entries is not nil, the mode, link and extended attributes of the paths
+// it holds, indexed as by ReadTree, are taken from it rather than from the
+// tree, so that the archive does not depend on what the filesystem of the
+// host supports.
+func AppendTar(tarPath string, root string, entries map[string]*Entry) error {
+	f, err := os.OpenFile(tarPath, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	existing, end, err := scanTar(f)
+	if err != nil {
+		return fmt.Errorf("cannot append to %s: %w", tarPath, err)
+	}
+
+	headers, fpaths, err := treeHeaders(root, entries, func(relPath string, info fs.FileInfo) (bool, error) {
+		isDir, ok := existing[relPath]
+		if ok && isDir && info.IsDir() {
+			return false, nil
+		}
+		if ok {
+			return false, fmt.Errorf("cannot append to %s: %s already exists", tarPath, relPath)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Seek(end, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	err = writeHeaders(f, headers, fpaths)
+	if err != nil {
+		return fmt.Errorf("cannot append to %s: %w", tarPath, err)
+	}
+	// Drop whatever followed the previous end of the archive.
+	end, err = f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	err = f.Truncate(end)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// WriteTar writes every entry in the tree under root to w as an uncompressed
+// tar archive, with the same layout used by AppendTar.
+func WriteTar(w io.Writer, root string) error {
+	headers, fpaths, err := treeHeaders(root, nil, nil)
+	if err != nil {
+		return err
+	}
+	return writeHeaders(w, headers, fpaths)
+}
+
+// treeHeaders returns the tar headers of the entries in the tree under root
+// for which keep, if not nil, returns true, along with the paths of their
+// content on disk. Entries are named as "./path" and owned by root, and
+// their mode, link and extended attributes are taken from entries when they
+// are listed there.
+func treeHeaders(root string, entries map[string]*Entry, keep func(relPath string, info fs.FileInfo) (bool, error)) (headers []*tar.Header, fpaths []string, err error) {
+	err = filepath.WalkDir(root, func(fpath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(root, fpath)
+		if err != nil {
+			return err
+		}
+		if relPath == "." {
+			return nil
+		}
+		relPath = "/" + filepath.ToSlash(relPath)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if keep != nil {
+			ok, err := keep(relPath, info)
+			if !ok || err != nil {
+				return err
+			}
+		}
+		entryPath := relPath
+		if info.IsDir() {
+			entryPath += "/"
+		}
+		var link string
+		var xattrs map[string]string
+		// Symlinks may be held by placeholders, as created by DiskCreator.
+		entry, ok := entries[entryPath]
+		if ok && (entry.Mode.Type() == info.Mode().Type() || entry.Mode.Type() == fs.ModeSymlink) {
+			info = &entryInfo{FileInfo: info, mode: entry.Mode}
+			link = entry.Link
+			xattrs = entry.Xattrs
+		} else if info.Mode().Type() == fs.ModeSymlink {
+			link, err = os.Readlink(fpath)
+			if err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = "." + relPath
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+		if len(xattrs) > 0 {
+			hdr.Format = tar.FormatPAX
+			hdr.PAXRecords = make(map[string]string, len(xattrs))
+			for name, value := range xattrs {
+				hdr.PAXRecords[tarXattrPrefix+name] = value
+			}
+		}
+		headers = append(headers, hdr)
+		fpaths = append(fpaths, fpath)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return headers, fpaths, nil
+}
+
+// entryInfo overrides the mode of the file described by FileInfo.
+type entryInfo struct {
+	fs.FileInfo
+	mode fs.FileMode
+}
+
+func (ei *entryInfo) Mode() fs.FileMode {
+	return ei.mode
+}
+
+func (ei *entryInfo) IsDir() bool {
+	return ei.mode.IsDir()
+}
+
+// writeHeaders writes the entries with the provided headers to w, taking
+// the content of regular files from fpaths, and closes the archive.
+func writeHeaders(w io.Writer, headers []*tar.Header, fpaths []string) error {
+	tarWriter := tar.NewWriter(w)
+	for i, hdr := range headers {
+		err := tarWriter.WriteHeader(hdr)
+		if err == nil && hdr.Typeflag == tar.TypeReg {
+			err = copyFile(tarWriter, fpaths[i])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tarWriter.Close()
+}
+
+// scanTar returns the paths in the tar archive read from r, in the form used
+// by ReadTree but without the trailing "/" of directories, mapped to whether
+// they are directories. It also returns the offset where the end-of-archive
+// marker starts, at which new entries may be written.
+func scanTar(r io.Reader) (paths map[string]bool, end int64, err error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err == nil && bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		return nil, 0, fmt.Errorf("tarball is compressed")
+	}
+	cr := &countingReader{inner: br}
+	paths = make(map[string]bool)
+	tarReader := tar.NewReader(cr)
+	for {
+		hdr, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("cannot read tarball: %w", err)
+		}
+		paths[tarPath(hdr.Name)] = hdr.Typeflag == tar.TypeDir
+	}
+	// The marker is made of two zero blocks, which were consumed unless
+	// the archive was missing it altogether.
+	end = cr.count
+	if cr.zeros >= 1024 {
+		end -= 1024
+	}
+	return paths, end, nil
+}
+
+// countingReader counts the bytes read from inner, and how many of the
+// trailing ones are zero.
+type countingReader struct {
+	inner io.Reader
+	count int64
+	zeros int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.inner.Read(p)
+	cr.count += int64(n)
+	for _, b := range p[:n] {
+		if b == 0 {
+			cr.zeros++
+		} else {
+			cr.zeros = 0
+		}
+	}
+	return n, err
+}
+
+func copyFile(w io.Writer, fpath string) error {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -0,0 +1,237 @@
+package fsutil_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/fsutil"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+func treeDump(entries map[string]*fsutil.Entry) map[string]string {
+	result := make(map[string]string)
+	for path, entry := range entries {
+		result[path] = testutil.TreeDumpEntry(entry)
+	}
+	return result
+}
+
+func (s *S) TestReadTree(c *C) {
+	dir := c.MkDir()
+	c.Assert(os.MkdirAll(filepath.Join(dir, "usr/bin"), 0755), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, "usr/bin/foo"), []byte("data1"), 0755), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, "usr/bin/empty"), nil, 0644), IsNil)
+	c.Assert(os.Symlink("foo", filepath.Join(dir, "usr/bin/bar")), IsNil)
+
+	entries, err := fsutil.ReadTree(dir)
+	c.Assert(err, IsNil)
+	c.Assert(treeDump(entries), DeepEquals, map[string]string{
+		"/usr/":          "dir 0755",
+		"/usr/bin/":      "dir 0755",
+		"/usr/bin/foo":   "file 0755 5b41362b",
+		"/usr/bin/empty": "file 0644 empty",
+		"/usr/bin/bar":   "symlink foo",
+	})
+	c.Assert(entries["/usr/bin/foo"].Path, Equals, "/usr/bin/foo")
+	c.Assert(entries["/usr/bin/foo"].Size, Equals, 5)
+	c.Assert(entries["/usr/bin/foo"].Hash, Equals, "5b41362bc82b7f3d56edc5a306db22105707d01ff4819e26faef9724a2d406c9")
+}
+
+func (s *S) TestReadTarTree(c *C) {
+	data := testutil.MustMakeTar([]testutil.TarEntry{
+		testutil.Dir(0755, "./"),
+		testutil.Dir(0755, "./usr/"),
+		testutil.Dir(0755, "./usr/bin/"),
+		testutil.Reg(0755, "./usr/bin/foo", "data1"),
+		testutil.Lnk(0777, "./usr/bin/bar", "foo"),
+		{Header: tar.Header{Typeflag: tar.TypeLink, Name: "./usr/bin/baz", Linkname: "./usr/bin/foo"}},
+	})
+	expected := map[string]string{
+		"/usr/":        "dir 0755",
+		"/usr/bin/":    "dir 0755",
+		"/usr/bin/foo": "file 0755 5b41362b",
+		"/usr/bin/bar": "symlink foo",
+		"/usr/bin/baz": "file 0755 5b41362b",
+	}
+
+	entries, err := fsutil.ReadTarTree(bytes.NewReader(data))
+	c.Assert(err, IsNil)
+	c.Assert(treeDump(entries), DeepEquals, expected)
+	c.Assert(entries["/usr/bin/"].Mode, Equals, fs.ModeDir|0755)
+
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	_, err = gzipWriter.Write(data)
+	c.Assert(err, IsNil)
+	c.Assert(gzipWriter.Close(), IsNil)
+	entries, err = fsutil.ReadTarTree(&compressed)
+	c.Assert(err, IsNil)
+	c.Assert(treeDump(entries), DeepEquals, expected)
+}
+
+func (s *S) TestReadTarTreeLongNames(c *C) {
+	longName := strings.Repeat("long-name-", 12)
+	data := testutil.MustMakeTar([]testutil.TarEntry{
+		{Header: tar.Header{Typeflag: tar.TypeDir, Name: "./" + longName, Mode: 0755, Format: tar.FormatPAX}},
+		{Header: tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     "./" + longName + "/" + longName,
+			Mode:     0755,
+			Size:     5,
+			Format:   tar.FormatPAX,
+			PAXRecords: map[string]string{
+				"SCHILY.xattr.security.capability": "\x01\x00\x00\x02",
+			},
+		}, Content: []byte("data1")},
+		{Header: tar.Header{Typeflag: tar.TypeSymlink, Name: "./gnu/" + longName, Linkname: "../" + longName, Format: tar.FormatGNU}},
+	})
+	entries, err := fsutil.ReadTarTree(bytes.NewReader(data))
+	c.Assert(err, IsNil)
+	c.Assert(treeDump(entries), DeepEquals, map[string]string{
+		"/" + longName + "/":            "dir 0755",
+		"/" + longName + "/" + longName: "file 0755 5b41362b",
+		"/gnu/" + longName:              "symlink ../" + longName,
+	})
+	c.Assert(entries["/"+longName+"/"+longName].Xattrs, DeepEquals, map[string]string{"security.capability": "\x01\x00\x00\x02"})
+	c.Assert(entries["/"+longName+"/"].Xattrs, IsNil)
+}
+
+func (s *S) TestReadTarTreeUnknownHardLink(c *C) {
+	data := testutil.MustMakeTar([]testutil.TarEntry{
+		{Header: tar.Header{Typeflag: tar.TypeLink, Name: "./foo", Linkname: "./bar"}},
+	})
+	_, err := fsutil.ReadTarTree(bytes.NewReader(data))
+	c.Assert(err, ErrorMatches, `cannot read tarball: hard link /foo to unknown path ./bar`)
+}
+
+func (s *S) TestAppendTar(c *C) {
+	tarPath := filepath.Join(c.MkDir(), "base.tar")
+	data := testutil.MustMakeTar([]testutil.TarEntry{
+		testutil.Dir(0755, "./"),
+		testutil.Dir(0755, "./usr/"),
+		testutil.Reg(0644, "./usr/base", "data0"),
+	})
+	// Archives are often padded beyond the end-of-archive marker.
+	data = append(data, make([]byte, 4096)...)
+	c.Assert(os.WriteFile(tarPath, data, 0644), IsNil)
+
+	dir := c.MkDir()
+	c.Assert(os.MkdirAll(filepath.Join(dir, "usr/bin"), 0755), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, "usr/bin/foo"), []byte("data1"), 0755), IsNil)
+	c.Assert(os.Symlink("foo", filepath.Join(dir, "usr/bin/bar")), IsNil)
+
+	err := fsutil.AppendTar(tarPath, dir, nil)
+	c.Assert(err, IsNil)
+
+	f, err := os.Open(tarPath)
+	c.Assert(err, IsNil)
+	defer f.Close()
+	entries, err := fsutil.ReadTarTree(f)
+	c.Assert(err, IsNil)
+	c.Assert(treeDump(entries), DeepEquals, map[string]string{
+		"/usr/":        "dir 0755",
+		"/usr/base":    "file 0644 4285d108",
+		"/usr/bin/":    "dir 0755",
+		"/usr/bin/foo": "file 0755 5b41362b",
+		"/usr/bin/bar": "symlink foo",
+	})
+}
+
+func (s *S) TestAppendTarEntries(c *C) {
+	tarPath := filepath.Join(c.MkDir(), "base.tar")
+	c.Assert(os.WriteFile(tarPath, testutil.MustMakeTar(nil), 0644), IsNil)
+
+	// The tree was built on a host which does not keep modes nor create
+	// symlinks, with DiskCreator using placeholders.
+	dir := c.MkDir()
+	creator := &fsutil.DiskCreator{Placeholders: true}
+	for _, options := range []fsutil.CreateOptions{{
+		Path:        filepath.Join(dir, "usr/bin/foo"),
+		Mode:        0644,
+		Data:        bytes.NewBufferString("data1"),
+		MakeParents: true,
+	}, {
+		Path: filepath.Join(dir, "usr/bin/bar"),
+		Mode: fs.ModeSymlink | 0777,
+		Link: "foo",
+	}} {
+		entry, err := creator.Create(&options)
+		c.Assert(err, IsNil)
+		c.Assert(entry.Mode, Equals, options.Mode)
+	}
+
+	err := fsutil.AppendTar(tarPath, dir, map[string]*fsutil.Entry{
+		"/usr/bin/":    {Mode: fs.ModeDir | 0700},
+		"/usr/bin/foo": {Mode: 0755, Xattrs: map[string]string{"security.capability": "\x01\x00\x00\x02"}},
+		"/usr/bin/bar": {Mode: fs.ModeSymlink | 0777, Link: "foo"},
+	})
+	c.Assert(err, IsNil)
+
+	f, err := os.Open(tarPath)
+	c.Assert(err, IsNil)
+	defer f.Close()
+	entries, err := fsutil.ReadTarTree(f)
+	c.Assert(err, IsNil)
+	c.Assert(treeDump(entries), DeepEquals, map[string]string{
+		"/usr/":        "dir 0755",
+		"/usr/bin/":    "dir 0700",
+		"/usr/bin/foo": "file 0755 5b41362b",
+		"/usr/bin/bar": "symlink foo",
+	})
+	c.Assert(entries["/usr/bin/foo"].Xattrs, DeepEquals, map[string]string{"security.capability": "\x01\x00\x00\x02"})
+	c.Assert(entries["/usr/bin/bar"].Xattrs, IsNil)
+}
+
+func (s *S) TestAppendTarCollision(c *C) {
+	tarPath := filepath.Join(c.MkDir(), "base.tar")
+	data := testutil.MustMakeTar([]testutil.TarEntry{
+		testutil.Dir(0755, "./usr/"),
+		testutil.Reg(0644, "./usr/foo", "data0"),
+	})
+	c.Assert(os.WriteFile(tarPath, data, 0644), IsNil)
+
+	dir := c.MkDir()
+	c.Assert(os.MkdirAll(filepath.Join(dir, "usr/foo"), 0755), IsNil)
+
+	err := fsutil.AppendTar(tarPath, dir, nil)
+	c.Assert(err, ErrorMatches, `cannot append to .*/base.tar: /usr/foo already exists`)
+
+	// Nothing was written.
+	newData, err := os.ReadFile(tarPath)
+	c.Assert(err, IsNil)
+	c.Assert(newData, DeepEquals, data)
+}
+
+func (s *S) TestWriteTar(c *C) {
+	dir := c.MkDir()
+	c.Assert(os.MkdirAll(filepath.Join(dir, "usr/bin"), 0755), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, "usr/bin/foo"), []byte("data1"), 0755), IsNil)
+	c.Assert(os.Symlink("foo", filepath.Join(dir, "usr/bin/bar")), IsNil)
+
+	var buf bytes.Buffer
+	err := fsutil.WriteTar(&buf, dir)
+	c.Assert(err, IsNil)
+
+	entries, err := fsutil.ReadTarTree(&buf)
+	c.Assert(err, IsNil)
+	c.Assert(treeDump(entries), DeepEquals, map[string]string{
+		"/usr/":        "dir 0755",
+		"/usr/bin/":    "dir 0755",
+		"/usr/bin/foo": "file 0755 5b41362b",
+		"/usr/bin/bar": "symlink foo",
+	})
+}
+
+func (s *S) TestAppendTarCompressed(c *C) {
+	tarPath := filepath.Join(c.MkDir(), "base.tar.gz")
+	c.Assert(os.WriteFile(tarPath, []byte{0x1f, 0x8b, 0}, 0644), IsNil)
+	err := fsutil.AppendTar(tarPath, c.MkDir(), nil)
+	c.Assert(err, ErrorMatches, `cannot append to .*/base.tar.gz: tarball is compressed`)
+}
@@ -0,0 +1,88 @@
+// Package jsonschema generates JSON Schemas describing the documents which
+// Go values are decoded from, so that those documents may be validated by
+// other tools before they are ever read.
+package jsonschema
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Draft identifies the version of the JSON Schema specification followed by
+// the generated schemas.
+const Draft = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is a JSON Schema, restricted to the keywords used by Generate.
+type Schema struct {
+	Schema      string `json:"$schema,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	// Type is either a single type name or a list of them.
+	Type                 any                `json:"type,omitempty"`
+	Const                string             `json:"const,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Minimum              *int               `json:"minimum,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	OneOf                []*Schema          `json:"oneOf,omitempty"`
+}
+
+// Schemer is implemented by types whose schema cannot be derived from their
+// Go type, such as those decoded by custom unmarshalers.
+type Schemer interface {
+	JSONSchema() *Schema
+}
+
+var schemerType = reflect.TypeOf((*Schemer)(nil)).Elem()
+
+// Generate returns the schema of the documents decoded into values of type
+// t. The property names of structs are taken from the tag of their fields
+// with the provided key, such as "yaml" or "json", and fields without that
+// tag are not part of the schema. Pointers may also be null.
+//
+// Recursive types are not supported.
+func Generate(t reflect.Type, tagKey string) *Schema {
+	if reflect.PointerTo(t).Implements(schemerType) {
+		return reflect.New(t).Interface().(Schemer).JSONSchema()
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := Generate(t.Elem(), tagKey)
+		if name, ok := schema.Type.(string); ok {
+			schema.Type = []string{name, "null"}
+			return schema
+		}
+		return &Schema{AnyOf: []*Schema{schema, {Type: "null"}}}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Schema{Type: "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		minimum := 0
+		return &Schema{Type: "integer", Minimum: &minimum}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: Generate(t.Elem(), tagKey)}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: Generate(t.Elem(), tagKey)}
+	case reflect.Struct:
+		schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get(tagKey), ",")
+			if !field.IsExported() || name == "" || name == "-" {
+				continue
+			}
+			schema.Properties[name] = Generate(field.Type, tagKey)
+		}
+		return schema
+	}
+	// Interfaces and other kinds accept any value.
+	return &Schema{}
+}
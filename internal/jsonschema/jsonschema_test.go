@@ -0,0 +1,99 @@
+package jsonschema_test
+
+import (
+	"encoding/json"
+	"reflect"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/jsonschema"
+)
+
+type color string
+
+func (color) JSONSchema() *jsonschema.Schema {
+	return &jsonschema.Schema{Type: "string", Enum: []string{"red", "blue"}}
+}
+
+type item struct {
+	Name   string  `yaml:"name" json:"item-name"`
+	Count  uint    `yaml:"count,omitempty"`
+	Weight float64 `yaml:"weight"`
+	Color  *color  `yaml:"color"`
+	Hidden string  `yaml:"-"`
+	NoTag  bool
+	secret string `yaml:"secret"`
+}
+
+type document struct {
+	Title string           `yaml:"title"`
+	Draft *bool            `yaml:"draft"`
+	Tags  []string         `yaml:"tags"`
+	Items map[string]*item `yaml:"items"`
+	Extra any              `yaml:"extra"`
+}
+
+var generateTests = []struct {
+	summary string
+	value   any
+	tagKey  string
+	schema  string
+}{{
+	summary: "Basic types",
+	value:   "",
+	tagKey:  "yaml",
+	schema:  `{"type":"string"}`,
+}, {
+	summary: "Unsigned integers cannot be negative",
+	value:   uint32(0),
+	tagKey:  "yaml",
+	schema:  `{"type":"integer","minimum":0}`,
+}, {
+	summary: "Slices are arrays",
+	value:   []int{},
+	tagKey:  "yaml",
+	schema:  `{"type":"array","items":{"type":"integer"}}`,
+}, {
+	summary: "Structs using the provided tag",
+	value:   item{},
+	tagKey:  "json",
+	schema:  `{"type":"object","properties":{"item-name":{"type":"string"}}}`,
+}, {
+	summary: "Nested structs, maps and pointers",
+	value:   document{},
+	tagKey:  "yaml",
+	schema: `{
+		"type": "object",
+		"properties": {
+			"draft": {"type": ["boolean", "null"]},
+			"extra": {},
+			"items": {
+				"type": "object",
+				"additionalProperties": {
+					"type": ["object", "null"],
+					"properties": {
+						"color": {"type": ["string", "null"], "enum": ["red", "blue"]},
+						"count": {"type": "integer", "minimum": 0},
+						"name": {"type": "string"},
+						"weight": {"type": "number"}
+					}
+				}
+			},
+			"tags": {"type": "array", "items": {"type": "string"}},
+			"title": {"type": "string"}
+		}
+	}`,
+}}
+
+func (s *S) TestGenerate(c *C) {
+	for _, test := range generateTests {
+		c.Logf("Summary: %s", test.summary)
+		schema := jsonschema.Generate(reflect.TypeOf(test.value), test.tagKey)
+		data, err := json.Marshal(schema)
+		c.Assert(err, IsNil)
+		var obtained, expected any
+		c.Assert(json.Unmarshal(data, &obtained), IsNil)
+		c.Assert(json.Unmarshal([]byte(test.schema), &expected), IsNil)
+		c.Assert(obtained, DeepEquals, expected)
+	}
+}
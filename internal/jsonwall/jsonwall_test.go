@@ -233,3 +233,28 @@ func (s *S) TestDataTypeTable(c *C) {
 		}
 	}
 }
+
+func (s *S) TestWriteOrderIndependence(c *C) {
+	values := []any{
+		DataType{A: "foo", B: "1"},
+		DataType{A: "bar", C: "2"},
+		DataType{C: "3", D: "baz"},
+		map[string]string{"a": "foo", "b": "0"},
+	}
+	write := func(order []int) string {
+		dbw := jsonwall.NewDBWriter(&jsonwall.DBWriterOptions{Schema: "1.0"})
+		for _, i := range order {
+			c.Assert(dbw.Add(values[i]), IsNil)
+		}
+		buf := &bytes.Buffer{}
+		_, err := dbw.WriteTo(buf)
+		c.Assert(err, IsNil)
+		return buf.String()
+	}
+	// The same entries must always produce the same bytes, regardless of the
+	// order in which they were added, and of how many times it is written.
+	expected := write([]int{0, 1, 2, 3})
+	c.Assert(write([]int{3, 2, 1, 0}), Equals, expected)
+	c.Assert(write([]int{2, 0, 3, 1}), Equals, expected)
+	c.Assert(write([]int{0, 1, 2, 3}), Equals, expected)
+}
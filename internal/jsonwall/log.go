@@ -3,6 +3,8 @@ package jsonwall
 import (
 	"fmt"
 	"sync"
+
+	"github.com/canonical/chisel/internal/logger"
 )
 
 // Avoid importing the log type information unnecessarily.  There's a small cost
@@ -48,6 +50,6 @@ func debugf(format string, args ...interface{}) {
 	globalLoggerLock.Lock()
 	defer globalLoggerLock.Unlock()
 	if globalDebug && globalLogger != nil {
-		globalLogger.Output(2, fmt.Sprintf(format, args...))
+		logger.Debug(globalLogger, 2, fmt.Sprintf(format, args...))
 	}
 }
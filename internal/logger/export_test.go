@@ -0,0 +1,13 @@
+package logger
+
+import (
+	"time"
+)
+
+func FakeTimeNow(now func() time.Time) (restore func()) {
+	old := timeNow
+	timeNow = now
+	return func() {
+		timeNow = old
+	}
+}
@@ -0,0 +1,146 @@
+// Package logger implements the logger plugged into the internal packages via
+// their SetLogger functions, supporting different output formats and levels.
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+type Format string
+
+const (
+	TextFormat Format = "text"
+	JSONFormat Format = "json"
+)
+
+type Level string
+
+const (
+	InfoLevel  Level = "info"
+	DebugLevel Level = "debug"
+)
+
+type Options struct {
+	// Format defines how messages are written out, and defaults to
+	// TextFormat.
+	Format Format
+	// Caller includes in every message the source location from where it
+	// was logged.
+	Caller bool
+}
+
+// Logger writes log messages to an io.Writer. It implements the interface
+// expected by SetLogger in the internal packages, and the optional method
+// used by them to deliver debug messages.
+type Logger struct {
+	mu      sync.Mutex
+	w       io.Writer
+	options Options
+}
+
+var timeNow = time.Now
+
+// New returns a logger that writes messages to w according to options.
+func New(w io.Writer, options *Options) (*Logger, error) {
+	l := &Logger{w: w}
+	if options != nil {
+		l.options = *options
+	}
+	switch l.options.Format {
+	case "":
+		l.options.Format = TextFormat
+	case TextFormat, JSONFormat:
+	default:
+		return nil, fmt.Errorf("invalid log format %q, must be %q or %q", l.options.Format, TextFormat, JSONFormat)
+	}
+	return l, nil
+}
+
+// Output logs s as an informational message. The calldepth argument is the
+// count of stack frames to skip when computing the caller location, with 1
+// identifying the caller of Output, like in the standard log package.
+func (l *Logger) Output(calldepth int, s string) error {
+	return l.output(calldepth+1, InfoLevel, s)
+}
+
+// DebugOutput works like Output but logs s as a debug message.
+func (l *Logger) DebugOutput(calldepth int, s string) error {
+	return l.output(calldepth+1, DebugLevel, s)
+}
+
+// Outputter is the interface expected by SetLogger in the internal packages.
+type Outputter interface {
+	Output(calldepth int, s string) error
+}
+
+// DebugOutputter is optionally implemented by loggers that deliver debug
+// messages differently from regular ones.
+type DebugOutputter interface {
+	DebugOutput(calldepth int, s string) error
+}
+
+// Debug delivers s to l as a debug message, if l implements DebugOutputter,
+// or as a regular one otherwise. The calldepth argument is the one the
+// caller of Debug would pass to Output.
+func Debug(l Outputter, calldepth int, s string) error {
+	if debugLogger, ok := l.(DebugOutputter); ok {
+		return debugLogger.DebugOutput(calldepth+1, s)
+	}
+	return l.Output(calldepth+1, s)
+}
+
+type jsonMessage struct {
+	Time    string `json:"time"`
+	Level   Level  `json:"level"`
+	Message string `json:"msg"`
+	Caller  string `json:"caller,omitempty"`
+}
+
+func (l *Logger) output(calldepth int, level Level, s string) error {
+	now := timeNow()
+	var caller string
+	if l.options.Caller {
+		_, file, line, ok := runtime.Caller(calldepth)
+		if ok {
+			caller = fmt.Sprintf("%s:%d", filepath.Base(file), line)
+		} else {
+			caller = "???:0"
+		}
+	}
+
+	var buf []byte
+	switch l.options.Format {
+	case JSONFormat:
+		data, err := json.Marshal(&jsonMessage{
+			Time:    now.UTC().Format(time.RFC3339Nano),
+			Level:   level,
+			Message: s,
+			Caller:  caller,
+		})
+		if err != nil {
+			return err
+		}
+		buf = append(data, '\n')
+	default:
+		buf = now.AppendFormat(buf, "2006/01/02 15:04:05 ")
+		if caller != "" {
+			buf = append(buf, caller...)
+			buf = append(buf, ": "...)
+		}
+		buf = append(buf, s...)
+		if len(s) == 0 || s[len(s)-1] != '\n' {
+			buf = append(buf, '\n')
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err := l.w.Write(buf)
+	return err
+}
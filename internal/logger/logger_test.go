@@ -0,0 +1,84 @@
+package logger_test
+
+import (
+	"bytes"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/logger"
+)
+
+type loggerTest struct {
+	summary string
+	options *logger.Options
+	output  string
+	error   string
+}
+
+var loggerTests = []loggerTest{{
+	summary: "Default text format",
+	output: "" +
+		"2024/03/01 10:20:30 Fetching foo...\n" +
+		"2024/03/01 10:20:30 Writing file: /foo\n",
+}, {
+	summary: "Text format with caller",
+	options: &logger.Options{Format: logger.TextFormat, Caller: true},
+	output: "" +
+		"2024/03/01 10:20:30 logger_test.go:LINE: Fetching foo...\n" +
+		"2024/03/01 10:20:30 logger_test.go:LINE: Writing file: /foo\n",
+}, {
+	summary: "JSON format",
+	options: &logger.Options{Format: logger.JSONFormat},
+	output: "" +
+		`{"time":"2024-03-01T10:20:30Z","level":"info","msg":"Fetching foo..."}` + "\n" +
+		`{"time":"2024-03-01T10:20:30Z","level":"debug","msg":"Writing file: /foo"}` + "\n",
+}, {
+	summary: "JSON format with caller",
+	options: &logger.Options{Format: logger.JSONFormat, Caller: true},
+	output: "" +
+		`{"time":"2024-03-01T10:20:30Z","level":"info","msg":"Fetching foo...","caller":"logger_test.go:LINE"}` + "\n" +
+		`{"time":"2024-03-01T10:20:30Z","level":"debug","msg":"Writing file: /foo","caller":"logger_test.go:LINE"}` + "\n",
+}, {
+	summary: "Invalid format",
+	options: &logger.Options{Format: "xml"},
+	error:   `invalid log format "xml", must be "text" or "json"`,
+}}
+
+func (s *S) TestLogger(c *C) {
+	restore := logger.FakeTimeNow(func() time.Time {
+		return time.Date(2024, 3, 1, 10, 20, 30, 0, time.UTC)
+	})
+	defer restore()
+
+	for _, test := range loggerTests {
+		c.Logf("Summary: %s", test.summary)
+
+		var buf bytes.Buffer
+		l, err := logger.New(&buf, test.options)
+		if test.error != "" {
+			c.Assert(err, ErrorMatches, test.error)
+			continue
+		}
+		c.Assert(err, IsNil)
+
+		c.Assert(l.Output(1, "Fetching foo..."), IsNil)
+		c.Assert(logger.Debug(l, 1, "Writing file: /foo"), IsNil)
+		// Caller locations are checked loosely so the test is not
+		// sensitive to unrelated changes in this file.
+		pattern := regexp.QuoteMeta(test.output)
+		pattern = strings.ReplaceAll(pattern, "LINE", "[0-9]+")
+		c.Assert(buf.String(), Matches, pattern)
+	}
+}
+
+func (s *S) TestDebugPlainLogger(c *C) {
+	// Loggers without DebugOutput get debug messages as regular ones.
+	var buf bytes.Buffer
+	l := log.New(&buf, "", log.Lshortfile)
+	c.Assert(logger.Debug(l, 1, "Writing file: /foo"), IsNil)
+	c.Assert(buf.String(), Matches, `logger_test.go:[0-9]+: Writing file: /foo\n`)
+}
@@ -0,0 +1,331 @@
+// Package manifest implements reading and writing of the manifest generated
+// by chisel, which records the packages, slices and paths that made up a cut.
+//
+// The manifest is a zstd-compressed jsonwall database, where every entry has
+// a "kind" field identifying its type:
+//
+//	{"kind":"package","name":"hello","version":"2.10-2","sha256":"...","arch":"amd64","suite":"jammy"}
+//	{"kind":"slice","name":"hello_bins","summary":"The hello executable"}
+//	{"kind":"path","path":"/usr/bin/hello","mode":"0755","slices":["hello_bins"],"sha256":"...","size":26856}
+//	{"kind":"content","slice":"hello_bins","path":"/usr/bin/hello"}
+//	{"kind":"generator","name":"chisel","version":"v1.0.0"}
+//
+// The arch of packages is the one they were published for in the archive,
+// which is "all" for architecture-independent packages regardless of the
+// architecture of the cut. The suite of packages is the one of the archive
+// they were selected from, as decided by the suite policy of the archive.
+//
+// Paths with extended attributes, such as the security.capability of
+// executables, list them under "xattrs" with their values in base64. Paths
+// that were selected but removed once cut, such as documentation pruned on
+// request, are still listed with "pruned" set to true.
+//
+// Manifests of large trees may leave out the content entries, as the path
+// entries already list the slices they belong to. Such manifests declare
+// PathsOnlySchema rather than Schema in their header, and their content
+// entries are derived from the paths when read.
+package manifest
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/canonical/chisel/internal/jsonschema"
+	"github.com/canonical/chisel/internal/jsonwall"
+)
+
+// Schema is the version of the manifest format written by this package.
+const Schema = "1.0"
+
+// PathsOnlySchema is the version of the manifest format written without
+// content entries.
+const PathsOnlySchema = "1.0-paths-only"
+
+// Filename is the name of the manifest file written into every directory
+// listed as a "generate: manifest" path.
+const Filename = "manifest.wall"
+
+type Package struct {
+	Kind    string `json:"kind"`
+	Name    string `json:"name,omitempty"`
+	Version string `json:"version,omitempty"`
+	Digest  string `json:"sha256,omitempty"`
+	Arch    string `json:"arch,omitempty"`
+	Suite   string `json:"suite,omitempty"`
+	// SHA512 is only recorded when additional digests were requested.
+	SHA512 string `json:"sha512,omitempty"`
+	// Size is the size in bytes of the files cut from the package which
+	// are in the tree, with files shared by several slices counted once.
+	Size uint64 `json:"size,omitempty"`
+}
+
+type Slice struct {
+	Kind    string `json:"kind"`
+	Name    string `json:"name,omitempty"`
+	Summary string `json:"summary,omitempty"`
+	// Size is the size in bytes of the files of the slice which are in
+	// the tree, including those shared with other slices.
+	Size uint64 `json:"size,omitempty"`
+}
+
+type Path struct {
+	Kind        string            `json:"kind"`
+	Path        string            `json:"path,omitempty"`
+	Mode        string            `json:"mode,omitempty"`
+	Slices      []string          `json:"slices,omitempty"`
+	SHA256      string            `json:"sha256,omitempty"`
+	FinalSHA256 string            `json:"final_sha256,omitempty"`
+	Size        uint64            `json:"size,omitempty"`
+	Link        string            `json:"link,omitempty"`
+	Xattrs      map[string]string `json:"xattrs,omitempty"`
+	// Implicit is true for parent directories which were not selected
+	// themselves, but were created to hold the paths of Slices.
+	Implicit bool `json:"implicit,omitempty"`
+	// Pruned is true for paths which were removed from the tree once cut.
+	Pruned bool `json:"pruned,omitempty"`
+	// HardLink is the path of the file this one was hard linked to, when
+	// deduplicating files with the same content.
+	HardLink string `json:"hardlink,omitempty"`
+	// SHA512 and FinalSHA512 are only recorded when additional digests
+	// were requested.
+	SHA512      string `json:"sha512,omitempty"`
+	FinalSHA512 string `json:"final_sha512,omitempty"`
+}
+
+type Content struct {
+	Kind  string `json:"kind"`
+	Slice string `json:"slice,omitempty"`
+	Path  string `json:"path,omitempty"`
+}
+
+// Generator identifies the tool that wrote the manifest.
+type Generator struct {
+	Kind    string `json:"kind"`
+	Name    string `json:"name,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+// Manifest provides access to the entries of a manifest.
+type Manifest struct {
+	db        *jsonwall.DB
+	pathsOnly bool
+}
+
+// Read loads into memory the manifest from the compressed data in r.
+func Read(r io.Reader) (*Manifest, error) {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read manifest: %w", err)
+	}
+	defer zr.Close()
+	db, err := jsonwall.ReadDB(zr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read manifest: %w", err)
+	}
+	schema := db.Schema()
+	if schema != Schema && schema != PathsOnlySchema {
+		return nil, fmt.Errorf("unknown manifest schema version %q", schema)
+	}
+	return &Manifest{db: db, pathsOnly: schema == PathsOnlySchema}, nil
+}
+
+// PathsOnly returns whether the manifest was written without content
+// entries.
+func (m *Manifest) PathsOnly() bool {
+	return m.pathsOnly
+}
+
+// Generator returns the tool that wrote the manifest, or nil if that was
+// not recorded.
+func (m *Manifest) Generator() (*Generator, error) {
+	generator := &Generator{Kind: "generator"}
+	err := m.db.Get(generator)
+	if err == jsonwall.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("cannot read manifest: %w", err)
+	}
+	return generator, nil
+}
+
+// IteratePackages calls onMatch for every package in the manifest.
+func (m *Manifest) IteratePackages(onMatch func(*Package) error) error {
+	return iterate(m, &Package{Kind: "package"}, false, onMatch)
+}
+
+// IterateSlices calls onMatch for every slice in the manifest whose name
+// starts with pkgName followed by an underscore, or for every slice if
+// pkgName is empty.
+func (m *Manifest) IterateSlices(pkgName string, onMatch func(*Slice) error) error {
+	prefix := ""
+	if pkgName != "" {
+		prefix = pkgName + "_"
+	}
+	return iterate(m, &Slice{Kind: "slice", Name: prefix}, true, onMatch)
+}
+
+// IteratePaths calls onMatch for every path in the manifest starting with
+// pathPrefix.
+func (m *Manifest) IteratePaths(pathPrefix string, onMatch func(*Path) error) error {
+	return iterate(m, &Path{Kind: "path", Path: pathPrefix}, true, onMatch)
+}
+
+// IterateContents calls onMatch for every path recorded as content of the
+// provided slice, or of every slice if slice is empty. For manifests
+// without content entries, the contents are derived from the slices of
+// every path, and are sorted by path rather than by slice.
+func (m *Manifest) IterateContents(slice string, onMatch func(*Content) error) error {
+	if !m.pathsOnly {
+		return iterate(m, &Content{Kind: "content", Slice: slice}, false, onMatch)
+	}
+	return m.IteratePaths("", func(path *Path) error {
+		for _, pathSlice := range path.Slices {
+			if slice != "" && pathSlice != slice {
+				continue
+			}
+			err := onMatch(&Content{Kind: "content", Slice: pathSlice, Path: path.Path})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// iterate calls onMatch for every entry matching value, where the last field
+// of value is matched as a prefix if prefix is true.
+func iterate[T any](m *Manifest, value *T, prefix bool, onMatch func(*T) error) error {
+	var iter *jsonwall.Iterator
+	var err error
+	if prefix {
+		iter, err = m.db.IteratePrefix(value)
+	} else {
+		iter, err = m.db.Iterate(value)
+	}
+	if err != nil {
+		return err
+	}
+	for iter.Next() {
+		var val T
+		err := iter.Get(&val)
+		if err != nil {
+			return fmt.Errorf("cannot read manifest: %w", err)
+		}
+		err = onMatch(&val)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Writer assembles a new manifest.
+type Writer struct {
+	dbw       *jsonwall.DBWriter
+	pathsOnly bool
+}
+
+// WriterOptions holds the options of NewWriterWithOptions.
+type WriterOptions struct {
+	// PathsOnly leaves content entries out of the manifest, which is
+	// then written with PathsOnlySchema.
+	PathsOnly bool
+}
+
+// NewWriter returns a writer for a new empty manifest.
+func NewWriter() *Writer {
+	return NewWriterWithOptions(&WriterOptions{})
+}
+
+// NewWriterWithOptions returns a writer for a new empty manifest with the
+// provided options.
+func NewWriterWithOptions(options *WriterOptions) *Writer {
+	schema := Schema
+	if options.PathsOnly {
+		schema = PathsOnlySchema
+	}
+	return &Writer{
+		dbw:       jsonwall.NewDBWriter(&jsonwall.DBWriterOptions{Schema: schema}),
+		pathsOnly: options.PathsOnly,
+	}
+}
+
+// AddPackage adds pkg to the manifest, setting its kind.
+func (w *Writer) AddPackage(pkg *Package) error {
+	pkg.Kind = "package"
+	return w.dbw.Add(pkg)
+}
+
+// AddSlice adds slice to the manifest, setting its kind.
+func (w *Writer) AddSlice(slice *Slice) error {
+	slice.Kind = "slice"
+	return w.dbw.Add(slice)
+}
+
+// AddPath adds path to the manifest, setting its kind.
+func (w *Writer) AddPath(path *Path) error {
+	path.Kind = "path"
+	return w.dbw.Add(path)
+}
+
+// AddContent adds content to the manifest, setting its kind. It does nothing
+// if the manifest is written without content entries.
+func (w *Writer) AddContent(content *Content) error {
+	content.Kind = "content"
+	if w.pathsOnly {
+		return nil
+	}
+	return w.dbw.Add(content)
+}
+
+// SetGenerator records in the manifest the tool that is writing it, setting
+// its kind. It must be called at most once.
+func (w *Writer) SetGenerator(generator *Generator) error {
+	generator.Kind = "generator"
+	return w.dbw.Add(generator)
+}
+
+// Write writes the compressed manifest to out. The data written only depends
+// on the entries added, so the same entries always produce the same bytes
+// regardless of the order in which they were added.
+func (w *Writer) Write(out io.Writer) error {
+	zw, err := zstd.NewWriter(out, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return err
+	}
+	_, err = w.dbw.WriteTo(zw)
+	if err != nil {
+		zw.Close()
+		return err
+	}
+	return zw.Close()
+}
+
+// EntrySchema returns the JSON Schema of the entries of manifests, as found
+// in every line of the uncompressed database after its jsonwall header.
+func EntrySchema() *jsonschema.Schema {
+	entries := []struct {
+		kind  string
+		value any
+	}{
+		{"package", Package{}},
+		{"slice", Slice{}},
+		{"path", Path{}},
+		{"content", Content{}},
+		{"generator", Generator{}},
+	}
+	schema := &jsonschema.Schema{
+		Schema: jsonschema.Draft,
+		Title:  "Chisel manifest entry",
+	}
+	for _, entry := range entries {
+		entrySchema := jsonschema.Generate(reflect.TypeOf(entry.value), "json")
+		entrySchema.Properties["kind"].Const = entry.kind
+		entrySchema.Required = []string{"kind"}
+		schema.OneOf = append(schema.OneOf, entrySchema)
+	}
+	return schema
+}
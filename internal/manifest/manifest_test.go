@@ -0,0 +1,162 @@
+package manifest_test
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/klauspost/compress/zstd"
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/jsonwall"
+	"github.com/canonical/chisel/internal/manifest"
+)
+
+func writeSample(c *C, reverse bool, options *manifest.WriterOptions) []byte {
+	mw := manifest.NewWriterWithOptions(options)
+	adds := []func() error{
+		func() error {
+			return mw.AddPackage(&manifest.Package{Name: "pkg1", Version: "1.0", Digest: "abcd", Arch: "amd64", Suite: "jammy-updates"})
+		},
+		func() error {
+			return mw.AddPackage(&manifest.Package{Name: "pkg2", Version: "2.0", Digest: "efgh", Arch: "all"})
+		},
+		func() error { return mw.AddSlice(&manifest.Slice{Name: "pkg1_bins", Summary: "Binaries"}) },
+		func() error { return mw.AddSlice(&manifest.Slice{Name: "pkg1_bins2"}) },
+		func() error { return mw.AddSlice(&manifest.Slice{Name: "pkg2_libs"}) },
+		func() error {
+			return mw.AddPath(&manifest.Path{Path: "/usr/bin/foo", Mode: "0755", Slices: []string{"pkg1_bins"}, SHA256: "1234", Size: 4})
+		},
+		func() error {
+			return mw.AddPath(&manifest.Path{Path: "/usr/lib/", Mode: "0755", Slices: []string{"pkg2_libs"}})
+		},
+		func() error {
+			return mw.AddPath(&manifest.Path{Path: "/usr/bin/bar", Mode: "0755", Slices: []string{"pkg1_bins", "pkg1_bins2"}})
+		},
+		func() error { return mw.AddContent(&manifest.Content{Slice: "pkg1_bins", Path: "/usr/bin/foo"}) },
+		func() error { return mw.AddContent(&manifest.Content{Slice: "pkg1_bins", Path: "/usr/bin/bar"}) },
+		func() error { return mw.AddContent(&manifest.Content{Slice: "pkg1_bins2", Path: "/usr/bin/bar"}) },
+		func() error { return mw.AddContent(&manifest.Content{Slice: "pkg2_libs", Path: "/usr/lib/"}) },
+	}
+	for i := range adds {
+		if reverse {
+			i = len(adds) - 1 - i
+		}
+		c.Assert(adds[i](), IsNil)
+	}
+	c.Assert(mw.SetGenerator(&manifest.Generator{Name: "chisel", Version: "1.0"}), IsNil)
+	var buf bytes.Buffer
+	c.Assert(mw.Write(&buf), IsNil)
+	return buf.Bytes()
+}
+
+func (s *S) TestWriteRead(c *C) {
+	data := writeSample(c, false, &manifest.WriterOptions{})
+	c.Assert(writeSample(c, true, &manifest.WriterOptions{}), DeepEquals, data)
+
+	m, err := manifest.Read(bytes.NewReader(data))
+	c.Assert(err, IsNil)
+	c.Assert(m.PathsOnly(), Equals, false)
+
+	generator, err := m.Generator()
+	c.Assert(err, IsNil)
+	c.Assert(generator, DeepEquals, &manifest.Generator{Kind: "generator", Name: "chisel", Version: "1.0"})
+
+	var pkgs []string
+	err = m.IteratePackages(func(pkg *manifest.Package) error {
+		pkgs = append(pkgs, pkg.Name+" "+pkg.Version+" "+pkg.Digest+" "+pkg.Arch+" "+pkg.Suite)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(pkgs, DeepEquals, []string{"pkg1 1.0 abcd amd64 jammy-updates", "pkg2 2.0 efgh all "})
+
+	var slices []string
+	err = m.IterateSlices("pkg1", func(slice *manifest.Slice) error {
+		slices = append(slices, slice.Name+" "+slice.Summary)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(slices, DeepEquals, []string{"pkg1_bins Binaries", "pkg1_bins2 "})
+
+	var paths []*manifest.Path
+	err = m.IteratePaths("/usr/bin/f", func(path *manifest.Path) error {
+		paths = append(paths, path)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(paths, DeepEquals, []*manifest.Path{{
+		Kind:   "path",
+		Path:   "/usr/bin/foo",
+		Mode:   "0755",
+		Slices: []string{"pkg1_bins"},
+		SHA256: "1234",
+		Size:   4,
+	}})
+
+	// Slice names are matched exactly.
+	var contents []string
+	err = m.IterateContents("pkg1_bins", func(content *manifest.Content) error {
+		contents = append(contents, content.Path)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(contents, DeepEquals, []string{"/usr/bin/bar", "/usr/bin/foo"})
+}
+
+func (s *S) TestWriteReadPathsOnly(c *C) {
+	full := writeSample(c, false, &manifest.WriterOptions{})
+	data := writeSample(c, false, &manifest.WriterOptions{PathsOnly: true})
+	c.Assert(writeSample(c, true, &manifest.WriterOptions{PathsOnly: true}), DeepEquals, data)
+
+	m, err := manifest.Read(bytes.NewReader(data))
+	c.Assert(err, IsNil)
+	c.Assert(m.PathsOnly(), Equals, true)
+
+	// Contents are derived from the paths, and match those recorded in
+	// a full manifest.
+	fullManifest, err := manifest.Read(bytes.NewReader(full))
+	c.Assert(err, IsNil)
+	for _, slice := range []string{"", "pkg1_bins", "pkg1_bins2", "pkg2_libs", "pkg3_none"} {
+		var contents, fullContents []string
+		err = m.IterateContents(slice, func(content *manifest.Content) error {
+			contents = append(contents, content.Slice+" "+content.Path)
+			return nil
+		})
+		c.Assert(err, IsNil)
+		err = fullManifest.IterateContents(slice, func(content *manifest.Content) error {
+			fullContents = append(fullContents, content.Slice+" "+content.Path)
+			return nil
+		})
+		c.Assert(err, IsNil)
+		sort.Strings(contents)
+		sort.Strings(fullContents)
+		c.Assert(contents, DeepEquals, fullContents, Commentf("Slice %q", slice))
+	}
+}
+
+func (s *S) TestReadInvalid(c *C) {
+	_, err := manifest.Read(bytes.NewReader([]byte("foo")))
+	c.Assert(err, ErrorMatches, "cannot read manifest: .*")
+
+	dbw := jsonwall.NewDBWriter(&jsonwall.DBWriterOptions{Schema: "0.1"})
+	var buf bytes.Buffer
+	zw, err := zstd.NewWriter(&buf)
+	c.Assert(err, IsNil)
+	_, err = dbw.WriteTo(zw)
+	c.Assert(err, IsNil)
+	c.Assert(zw.Close(), IsNil)
+	_, err = manifest.Read(&buf)
+	c.Assert(err, ErrorMatches, `unknown manifest schema version "0.1"`)
+}
+
+func (s *S) TestEntrySchema(c *C) {
+	schema := manifest.EntrySchema()
+	var kinds []string
+	for _, entry := range schema.OneOf {
+		c.Assert(entry.Required, DeepEquals, []string{"kind"})
+		kinds = append(kinds, entry.Properties["kind"].Const)
+	}
+	c.Assert(kinds, DeepEquals, []string{"package", "slice", "path", "content", "generator"})
+	path := schema.OneOf[2]
+	c.Assert(path.Properties["xattrs"].AdditionalProperties.Type, Equals, "string")
+	c.Assert(path.Properties["size"].Type, Equals, "integer")
+}
@@ -0,0 +1,246 @@
+// Package progress provides the means to report the progress of long running
+// operations, such as downloading or extracting packages.
+package progress
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Reporter is notified about the progress of long running operations.
+type Reporter interface {
+	// Start announces a new operation described by label, which is expected
+	// to process total units of work, or an unknown amount if total is zero.
+	Start(label string, total int64) Task
+}
+
+// Task tracks the progress of a single operation.
+type Task interface {
+	// Add records that n more units of work were processed.
+	Add(n int64)
+	// Done records that the operation has finished.
+	Done()
+}
+
+// Null is a Reporter that discards all progress information.
+var Null Reporter = nullReporter{}
+
+type nullReporter struct{}
+
+func (nullReporter) Start(label string, total int64) Task { return nullTask{} }
+
+type nullTask struct{}
+
+func (nullTask) Add(n int64) {}
+func (nullTask) Done()       {}
+
+// Reader returns a reader that reads from r and records in task the number
+// of bytes read.
+func Reader(r io.Reader, task Task) io.Reader {
+	return &taskReader{inner: r, task: task}
+}
+
+type taskReader struct {
+	inner io.Reader
+	task  Task
+}
+
+func (tr *taskReader) Read(p []byte) (n int, err error) {
+	n, err = tr.inner.Read(p)
+	tr.task.Add(int64(n))
+	return n, err
+}
+
+var timeNow = time.Now
+
+// terminalRedraw is the minimum interval between updates of the progress
+// line on a terminal.
+const terminalRedraw = 100 * time.Millisecond
+
+// NewTerminal returns a Reporter that keeps a single line updated on w with
+// the state of the current operation. The line is cleared once the operation
+// is done, so w is expected to be a terminal.
+func NewTerminal(w io.Writer) Reporter {
+	return &terminalReporter{w: w}
+}
+
+type terminalReporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (r *terminalReporter) Start(label string, total int64) Task {
+	return &terminalTask{reporter: r, label: label, total: total}
+}
+
+type terminalTask struct {
+	reporter *terminalReporter
+	label    string
+	total    int64
+	current  int64
+	drawn    time.Time
+}
+
+func (t *terminalTask) Add(n int64) {
+	r := t.reporter
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t.current += n
+	now := timeNow()
+	if now.Sub(t.drawn) < terminalRedraw {
+		return
+	}
+	t.drawn = now
+	fmt.Fprintf(r.w, "\r\033[K%s %s", t.label, formatAmount(t.current, t.total))
+}
+
+func (t *terminalTask) Done() {
+	r := t.reporter
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !t.drawn.IsZero() {
+		fmt.Fprint(r.w, "\r\033[K")
+	}
+}
+
+// NewLog returns a Reporter that sends to the logger registered via SetLogger
+// the state of ongoing operations, at most once per interval for each of them.
+func NewLog(interval time.Duration) Reporter {
+	return &logReporter{interval: interval}
+}
+
+type logReporter struct {
+	interval time.Duration
+}
+
+func (r *logReporter) Start(label string, total int64) Task {
+	return &logTask{reporter: r, label: label, total: total, logged: timeNow()}
+}
+
+type logTask struct {
+	mu       sync.Mutex
+	reporter *logReporter
+	label    string
+	total    int64
+	current  int64
+	logged   time.Time
+}
+
+func (t *logTask) Add(n int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.current += n
+	now := timeNow()
+	if now.Sub(t.logged) < t.reporter.interval {
+		return
+	}
+	t.logged = now
+	logf("%s %s", t.label, formatAmount(t.current, t.total))
+}
+
+func (t *logTask) Done() {}
+
+func formatAmount(current, total int64) string {
+	if total <= 0 {
+		return FormatSize(current)
+	}
+	return fmt.Sprintf("%d%% (%s of %s)", current*100/total, FormatSize(current), FormatSize(total))
+}
+
+// FormatSize returns a human readable representation of size in bytes,
+// using binary multiples.
+func FormatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// Operation describes an operation which was reported to a Recorder.
+type Operation struct {
+	// Label is the description provided when the operation started.
+	Label string
+	// Total is the amount of work expected when the operation started,
+	// or zero if unknown.
+	Total int64
+	// Processed is the amount of work recorded as done.
+	Processed int64
+	// Elapsed is the time between the start of the operation and its end,
+	// or until now if it has not finished yet.
+	Elapsed time.Duration
+}
+
+// Recorder is a Reporter which keeps track of every operation it is notified
+// about, so that statistics may be obtained once they are done.
+type Recorder struct {
+	mu    sync.Mutex
+	inner Reporter
+	tasks []*recorderTask
+}
+
+// NewRecorder returns a Recorder which also forwards all progress
+// information to inner.
+func NewRecorder(inner Reporter) *Recorder {
+	return &Recorder{inner: inner}
+}
+
+func (r *Recorder) Start(label string, total int64) Task {
+	task := &recorderTask{
+		recorder: r,
+		inner:    r.inner.Start(label, total),
+		op:       Operation{Label: label, Total: total},
+		started:  timeNow(),
+	}
+	r.mu.Lock()
+	r.tasks = append(r.tasks, task)
+	r.mu.Unlock()
+	return task
+}
+
+// Operations returns the operations recorded so far, in the order they were
+// started.
+func (r *Recorder) Operations() []Operation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ops := make([]Operation, len(r.tasks))
+	for i, task := range r.tasks {
+		ops[i] = task.op
+		if !task.done {
+			ops[i].Elapsed = timeNow().Sub(task.started)
+		}
+	}
+	return ops
+}
+
+type recorderTask struct {
+	recorder *Recorder
+	inner    Task
+	op       Operation
+	started  time.Time
+	done     bool
+}
+
+func (t *recorderTask) Add(n int64) {
+	t.recorder.mu.Lock()
+	t.op.Processed += n
+	t.recorder.mu.Unlock()
+	t.inner.Add(n)
+}
+
+func (t *recorderTask) Done() {
+	t.recorder.mu.Lock()
+	if !t.done {
+		t.done = true
+		t.op.Elapsed = timeNow().Sub(t.started)
+	}
+	t.recorder.mu.Unlock()
+	t.inner.Done()
+}
@@ -0,0 +1,192 @@
+package progress_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/progress"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (fc *fakeClock) Now() time.Time {
+	return fc.now
+}
+
+func (fc *fakeClock) Advance(d time.Duration) {
+	fc.now = fc.now.Add(d)
+}
+
+type recordingTask struct {
+	added []int64
+	done  bool
+}
+
+func (t *recordingTask) Add(n int64) { t.added = append(t.added, n) }
+func (t *recordingTask) Done()       { t.done = true }
+
+func (s *S) TestReader(c *C) {
+	task := &recordingTask{}
+	reader := progress.Reader(strings.NewReader("0123456789"), task)
+	buf := make([]byte, 4)
+	for {
+		_, err := reader.Read(buf)
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, IsNil)
+	}
+	c.Assert(task.added, DeepEquals, []int64{4, 4, 2, 0})
+}
+
+func (s *S) TestNull(c *C) {
+	task := progress.Null.Start("Label", 10)
+	task.Add(5)
+	task.Done()
+}
+
+func (s *S) TestTerminal(c *C) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	defer progress.FakeTimeNow(clock.Now)()
+
+	var buf bytes.Buffer
+	reporter := progress.NewTerminal(&buf)
+	task := reporter.Start("Fetching foo.deb", 4096)
+	task.Add(1024)
+	c.Assert(buf.String(), Equals, "\r\033[KFetching foo.deb 25% (1.0KiB of 4.0KiB)")
+
+	// Updates are rate limited.
+	buf.Reset()
+	task.Add(1024)
+	c.Assert(buf.String(), Equals, "")
+	clock.Advance(time.Second)
+	task.Add(1024)
+	c.Assert(buf.String(), Equals, "\r\033[KFetching foo.deb 75% (3.0KiB of 4.0KiB)")
+
+	buf.Reset()
+	task.Done()
+	c.Assert(buf.String(), Equals, "\r\033[K")
+
+	// Unknown totals report the amount processed only.
+	buf.Reset()
+	clock.Advance(time.Second)
+	task = reporter.Start("Extracting foo", 0)
+	task.Add(2048)
+	c.Assert(buf.String(), Equals, "\r\033[KExtracting foo 2.0KiB")
+}
+
+func (s *S) TestTerminalNothingDrawn(c *C) {
+	var buf bytes.Buffer
+	reporter := progress.NewTerminal(&buf)
+	task := reporter.Start("Fetching foo.deb", 4096)
+	task.Done()
+	c.Assert(buf.String(), Equals, "")
+}
+
+type logRecorder struct {
+	lines []string
+}
+
+func (l *logRecorder) Output(calldepth int, s string) error {
+	l.lines = append(l.lines, s)
+	return nil
+}
+
+func (s *S) TestLog(c *C) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	defer progress.FakeTimeNow(clock.Now)()
+	logger := &logRecorder{}
+	progress.SetLogger(logger)
+
+	reporter := progress.NewLog(5 * time.Second)
+	task := reporter.Start("Fetching foo.deb", 2048)
+	task.Add(512)
+	c.Assert(logger.lines, HasLen, 0)
+	clock.Advance(5 * time.Second)
+	task.Add(512)
+	clock.Advance(time.Second)
+	task.Add(512)
+	clock.Advance(5 * time.Second)
+	task.Add(512)
+	task.Done()
+	c.Assert(logger.lines, DeepEquals, []string{
+		"Fetching foo.deb 50% (1.0KiB of 2.0KiB)",
+		"Fetching foo.deb 100% (2.0KiB of 2.0KiB)",
+	})
+}
+
+type recordingReporter struct {
+	labels []string
+	tasks  []*recordingTask
+}
+
+func (r *recordingReporter) Start(label string, total int64) progress.Task {
+	task := &recordingTask{}
+	r.labels = append(r.labels, label)
+	r.tasks = append(r.tasks, task)
+	return task
+}
+
+func (s *S) TestRecorder(c *C) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	defer progress.FakeTimeNow(clock.Now)()
+
+	inner := &recordingReporter{}
+	recorder := progress.NewRecorder(inner)
+	fetch := recorder.Start("Fetching foo.deb", 2048)
+	fetch.Add(1024)
+	clock.Advance(time.Second)
+	fetch.Add(1024)
+	clock.Advance(time.Second)
+	fetch.Done()
+	extract := recorder.Start("Extracting foo", 0)
+	extract.Add(512)
+	clock.Advance(3 * time.Second)
+
+	c.Assert(recorder.Operations(), DeepEquals, []progress.Operation{{
+		Label:     "Fetching foo.deb",
+		Total:     2048,
+		Processed: 2048,
+		Elapsed:   2 * time.Second,
+	}, {
+		Label:     "Extracting foo",
+		Processed: 512,
+		Elapsed:   3 * time.Second,
+	}})
+
+	// Finished operations keep their elapsed time.
+	extract.Done()
+	clock.Advance(time.Minute)
+	c.Assert(recorder.Operations()[1].Elapsed, Equals, 3*time.Second)
+
+	// Progress is forwarded to the inner reporter.
+	c.Assert(inner.labels, DeepEquals, []string{"Fetching foo.deb", "Extracting foo"})
+	c.Assert(inner.tasks[0].added, DeepEquals, []int64{1024, 1024})
+	c.Assert(inner.tasks[0].done, Equals, true)
+	c.Assert(inner.tasks[1].added, DeepEquals, []int64{512})
+	c.Assert(inner.tasks[1].done, Equals, true)
+}
+
+var formatSizeTests = []struct {
+	size   int64
+	result string
+}{
+	{0, "0B"},
+	{1023, "1023B"},
+	{1024, "1.0KiB"},
+	{1536, "1.5KiB"},
+	{10 * 1024 * 1024, "10.0MiB"},
+	{3 * 1024 * 1024 * 1024, "3.0GiB"},
+}
+
+func (s *S) TestFormatSize(c *C) {
+	for _, test := range formatSizeTests {
+		c.Assert(progress.FormatSize(test.size), Equals, test.result)
+	}
+}
@@ -0,0 +1,25 @@
+package progress_test
+
+import (
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/progress"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type S struct{}
+
+var _ = Suite(&S{})
+
+func (s *S) SetUpTest(c *C) {
+	progress.SetDebug(true)
+	progress.SetLogger(c)
+}
+
+func (s *S) TearDownTest(c *C) {
+	progress.SetDebug(false)
+	progress.SetLogger(nil)
+}
@@ -39,6 +39,8 @@ type ContentValue struct {
 	// OnWrite has to be called after a successful write with the entry resulting
 	// from the write.
 	OnWrite func(entry *fsutil.Entry) error
+	// Creator writes the files, or fsutil.Create is used if nil.
+	Creator fsutil.Creator
 }
 
 // Content starlark.Value interface
@@ -175,9 +177,13 @@ func (c *ContentValue) Write(thread *starlark.Thread, fn *starlark.Builtin, args
 	}
 	fdata := []byte(data.GoString())
 
+	creator := c.Creator
+	if creator == nil {
+		creator = &fsutil.DiskCreator{}
+	}
 	// No mode parameter for now as slices are supposed to list files
 	// explicitly instead.
-	entry, err := fsutil.Create(&fsutil.CreateOptions{
+	entry, err := creator.Create(&fsutil.CreateOptions{
 		Path: fpath,
 		Data: bytes.NewReader(fdata),
 		Mode: 0644,
@@ -3,6 +3,9 @@ package setup
 import (
 	"archive/tar"
 	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io"
 	"net/http"
@@ -21,6 +24,19 @@ type FetchOptions struct {
 	Label    string
 	Version  string
 	CacheDir string
+	// ConflictPolicy is used when reading the fetched release.
+	ConflictPolicy ConflictPolicy
+	// ExtraSlices is used when reading the fetched release.
+	ExtraSlices []string
+	// Packages and Sets are used when reading the fetched release.
+	Packages []string
+	Sets     []string
+	// CACerts holds PEM-encoded certificates trusted when talking to the
+	// release repository, in addition to the ones trusted by the system.
+	CACerts []byte
+	// Context, if set, cancels the request to the release repository once
+	// done.
+	Context context.Context
 }
 
 var bulkClient = &http.Client{
@@ -56,13 +72,30 @@ func FetchRelease(options *FetchOptions) (*Release, error) {
 		return nil, err
 	}
 
-	req, err := http.NewRequest("GET", baseURL+options.Label+"-"+options.Version, nil)
+	ctx := options.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", baseURL+options.Label+"-"+options.Version, nil)
 	if err != nil {
 		return nil, fmt.Errorf("cannot create request for release information: %w", err)
 	}
 	req.Header.Add("If-None-Match", string(tagData))
 
-	resp, err := bulkClient.Do(req)
+	do := bulkClient.Do
+	if len(options.CACerts) > 0 {
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil {
+			rootCAs = x509.NewCertPool()
+		}
+		if !rootCAs.AppendCertsFromPEM(options.CACerts) {
+			return nil, fmt.Errorf("cannot use CA certificates: no valid certificate found")
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: rootCAs}
+		do = (&http.Client{Timeout: bulkClient.Timeout, Transport: transport}).Do
+	}
+	resp, err := do(req)
 	if err != nil {
 		return nil, fmt.Errorf("cannot talk to release repository: %w", err)
 	}
@@ -105,7 +138,22 @@ func FetchRelease(options *FetchOptions) (*Release, error) {
 		}
 	}
 
-	return ReadRelease(dirName)
+	release, err := ReadReleaseWithOptions(dirName, &ReadOptions{
+		ConflictPolicy: options.ConflictPolicy,
+		ExtraSlices:    options.ExtraSlices,
+		Packages:       options.Packages,
+		Sets:           options.Sets,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// The tag of archives served by the release repository is the
+	// revision they were built from.
+	tagData, err = os.ReadFile(tagName)
+	if err == nil {
+		release.Commit = strings.Trim(strings.TrimPrefix(string(tagData), "W/"), `"`)
+	}
+	return release, nil
 }
 
 func extractTarGz(dataReader io.Reader, targetDir string) error {
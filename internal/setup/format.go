@@ -0,0 +1,127 @@
+package setup
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The order of the fields of packages, slices and paths in formatted slice
+// definitions. Unknown fields go last, in their original order.
+var (
+	packageFieldOrder = []string{"package", "archive", "deprecated", "essential", "slices"}
+	sliceFieldOrder   = []string{"summary", "description", "deprecated", "essential", "mutate-after", "contents", "mutate"}
+	pathFieldOrder    = []string{"make", "mode", "copy", "text", "symlink", "mutable", "until", "arch", "generate", "detail"}
+)
+
+// FormatSliceDefinition returns the content of the slice definition file at
+// path in canonical form: fields are in a fixed order, paths are sorted, and
+// the indentation is of two spaces. Comments, the order of slices and the
+// style of values are preserved, and the result is verified to define the
+// same slices as data.
+func FormatSliceDefinition(path string, data []byte) ([]byte, error) {
+	match := fnameExp.FindStringSubmatch(filepath.Base(path))
+	if match == nil {
+		return nil, fmt.Errorf("invalid slice definition filename: %q", filepath.Base(path))
+	}
+	pkgName := match[1]
+	pkg, err := parsePackage("", pkgName, path, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc yaml.Node
+	err = yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse package %q slice definitions: %v", pkgName, err)
+	}
+	if len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("cannot format package %q slice definitions: not a mapping", pkgName)
+	}
+	root := doc.Content[0]
+	sortFields(root, packageFieldOrder)
+	if slices := mappingValue(root, "slices"); slices != nil && slices.Kind == yaml.MappingNode {
+		for i := 1; i < len(slices.Content); i += 2 {
+			slice := slices.Content[i]
+			if slice.Kind != yaml.MappingNode {
+				continue
+			}
+			sortFields(slice, sliceFieldOrder)
+			contents := mappingValue(slice, "contents")
+			if contents == nil || contents.Kind != yaml.MappingNode {
+				continue
+			}
+			sortMapping(contents, func(a, b *yaml.Node) bool {
+				return a.Value < b.Value
+			})
+			for j := 1; j < len(contents.Content); j += 2 {
+				if contents.Content[j].Kind == yaml.MappingNode {
+					sortFields(contents.Content[j], pathFieldOrder)
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	err = enc.Encode(&doc)
+	if err == nil {
+		err = enc.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot format package %q slice definitions: %v", pkgName, err)
+	}
+	formatted := buf.Bytes()
+
+	fpkg, err := parsePackage("", pkgName, path, formatted)
+	if err != nil || !reflect.DeepEqual(fpkg, pkg) {
+		return nil, fmt.Errorf("internal error: formatting changes package %q slice definitions", pkgName)
+	}
+	return formatted, nil
+}
+
+// mappingValue returns the value of key in the mapping node, or nil.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// sortFields sorts the keys of the mapping node as listed in order.
+func sortFields(node *yaml.Node, order []string) {
+	rank := func(key *yaml.Node) int {
+		for i, name := range order {
+			if key.Value == name {
+				return i
+			}
+		}
+		return len(order)
+	}
+	sortMapping(node, func(a, b *yaml.Node) bool {
+		return rank(a) < rank(b)
+	})
+}
+
+// sortMapping stably sorts the key and value pairs of the mapping node by
+// their keys, as compared by less.
+func sortMapping(node *yaml.Node, less func(a, b *yaml.Node) bool) {
+	pairs := make([][2]*yaml.Node, 0, len(node.Content)/2)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		pairs = append(pairs, [2]*yaml.Node{node.Content[i], node.Content[i+1]})
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return less(pairs[i][0], pairs[j][0])
+	})
+	node.Content = node.Content[:0]
+	for _, pair := range pairs {
+		node.Content = append(node.Content, pair[0], pair[1])
+	}
+}
@@ -0,0 +1,130 @@
+package setup_test
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+var formatTests = []struct {
+	summary string
+	path    string
+	input   string
+	output  string
+	error   string
+}{{
+	summary: "Fields and paths are sorted",
+	path:    "slices/mypkg.yaml",
+	input: `
+		slices:
+			bins:
+				mutate: |
+					content.list("/")
+				contents:
+					/usr/bin/foo: {mode: 0755, copy: /usr/bin/bar}
+					/etc/foo.conf: {mutable: true, text: data1}
+					/usr/bin/baz:
+				essential:
+					- mypkg_config
+				summary: Binaries
+			config:
+				contents:
+					/etc/bar.conf:
+		package: mypkg
+	`,
+	output: "" +
+		"package: mypkg\n" +
+		"slices:\n" +
+		"  bins:\n" +
+		"    summary: Binaries\n" +
+		"    essential:\n" +
+		"      - mypkg_config\n" +
+		"    contents:\n" +
+		"      /etc/foo.conf: {text: data1, mutable: true}\n" +
+		"      /usr/bin/baz:\n" +
+		"      /usr/bin/foo: {mode: 0755, copy: /usr/bin/bar}\n" +
+		"    mutate: |\n" +
+		"      content.list(\"/\")\n" +
+		"  config:\n" +
+		"    contents:\n" +
+		"      /etc/bar.conf:\n",
+}, {
+	summary: "Comments are preserved",
+	path:    "slices/mydir/mypkg.yaml",
+	input: `
+		# Header.
+		package: mypkg
+		slices:
+			# The binaries.
+			bins:
+				contents:
+					/usr/bin/foo: # Trailing.
+					# Before bar.
+					/usr/bin/bar:
+	`,
+	output: "" +
+		"# Header.\n" +
+		"package: mypkg\n" +
+		"slices:\n" +
+		"  # The binaries.\n" +
+		"  bins:\n" +
+		"    contents:\n" +
+		"      # Before bar.\n" +
+		"      /usr/bin/bar:\n" +
+		"      /usr/bin/foo: # Trailing.\n",
+}, {
+	summary: "Formatted definitions are unchanged",
+	path:    "mypkg.yaml",
+	input: `
+		package: mypkg
+		slices:
+		  bins:
+		    contents:
+		      /usr/bin/foo:
+	`,
+	output: "" +
+		"package: mypkg\n" +
+		"slices:\n" +
+		"  bins:\n" +
+		"    contents:\n" +
+		"      /usr/bin/foo:\n",
+}, {
+	summary: "Invalid definitions are not formatted",
+	path:    "slices/mypkg.yaml",
+	input: `
+		package: mypkg
+		slices:
+			bins:
+				contents:
+					foo:
+	`,
+	error: `slice mypkg_bins has invalid content path: foo`,
+}, {
+	summary: "Filename must match package",
+	path:    "slices/mypkg.yaml",
+	input: `
+		package: otherpkg
+	`,
+	error: `slices/mypkg.yaml: filename and 'package' field \("otherpkg"\) disagree`,
+}, {
+	summary: "Invalid filename",
+	path:    "slices/MyPkg.yaml",
+	input: `
+		package: mypkg
+	`,
+	error: `invalid slice definition filename: "MyPkg.yaml"`,
+}}
+
+func (s *S) TestFormatSliceDefinition(c *C) {
+	for _, test := range formatTests {
+		c.Logf("Summary: %s", test.summary)
+		output, err := setup.FormatSliceDefinition(test.path, testutil.Reindent(test.input))
+		if test.error != "" {
+			c.Assert(err, ErrorMatches, test.error)
+			continue
+		}
+		c.Assert(err, IsNil)
+		c.Assert(string(output), Equals, test.output)
+	}
+}
@@ -0,0 +1,209 @@
+package setup
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MarshalPackage returns the content of a slice definition file defining pkg
+// and its slices, in the canonical form produced by FormatSliceDefinition.
+// Essential slices shared by every slice are listed in each of them, and
+// slices only list deprecation messages other than the one of pkg. The
+// archive is only listed when it was selected explicitly.
+func MarshalPackage(pkg *Package) ([]byte, error) {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	addField(root, "package", stringNode(pkg.Name))
+	if pkg.ExplicitArchive {
+		addField(root, "archive", stringNode(pkg.Archive))
+	}
+	if pkg.Deprecated != "" {
+		addField(root, "deprecated", stringNode(pkg.Deprecated))
+	}
+
+	sliceNames := make([]string, 0, len(pkg.Slices))
+	for name := range pkg.Slices {
+		sliceNames = append(sliceNames, name)
+	}
+	sort.Strings(sliceNames)
+	slices := &yaml.Node{Kind: yaml.MappingNode}
+	for _, name := range sliceNames {
+		slice := pkg.Slices[name]
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		if slice.Summary != "" {
+			addField(node, "summary", stringNode(slice.Summary))
+		}
+		if slice.Description != "" {
+			addField(node, "description", stringNode(slice.Description))
+		}
+		if slice.Deprecated != "" && slice.Deprecated != pkg.Deprecated {
+			addField(node, "deprecated", stringNode(slice.Deprecated))
+		}
+		if len(slice.Essential) > 0 {
+			addField(node, "essential", sliceKeysNode(slice.Essential))
+		}
+		if len(slice.Scripts.MutateAfter) > 0 {
+			addField(node, "mutate-after", sliceKeysNode(slice.Scripts.MutateAfter))
+		}
+		if len(slice.Contents) > 0 {
+			paths := make([]string, 0, len(slice.Contents))
+			for path := range slice.Contents {
+				paths = append(paths, path)
+			}
+			sort.Strings(paths)
+			contents := &yaml.Node{Kind: yaml.MappingNode}
+			for _, path := range paths {
+				addField(contents, path, pathInfoNode(path, slice.Contents[path]))
+			}
+			addField(node, "contents", contents)
+		}
+		if slice.Scripts.Mutate != "" {
+			addField(node, "mutate", stringNode(slice.Scripts.Mutate))
+		}
+		addField(slices, name, node)
+	}
+	addField(root, "slices", slices)
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	err := enc.Encode(root)
+	if err == nil {
+		err = enc.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal package %q slice definitions: %v", pkg.Name, err)
+	}
+	data := buf.Bytes()
+
+	// The definitions must be read back as they were provided.
+	mpkg, err := parsePackage("", pkg.Name, pkg.Path, data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal package %q slice definitions: %w", pkg.Name, err)
+	}
+	if !mpkg.ExplicitArchive {
+		mpkg.Archive = pkg.Archive
+	}
+	for _, slice := range mpkg.Slices {
+		if slice.Deprecated == "" {
+			slice.Deprecated = mpkg.Deprecated
+		}
+	}
+	if !reflect.DeepEqual(mpkg, pkg) {
+		return nil, fmt.Errorf("cannot marshal package %q slice definitions: cannot be read back as provided", pkg.Name)
+	}
+	return data, nil
+}
+
+// WriteSliceDefinitions writes a slice definition file for every package of
+// the release into the "slices" directory under dir, named after the
+// package, as done by MarshalPackage. Any other slice definition file of
+// those packages under that directory, such as the parts a package was
+// split across, is removed so the package is only defined once.
+func WriteSliceDefinitions(release *Release, dir string) error {
+	slicesDir := filepath.Join(dir, "slices")
+	err := os.MkdirAll(slicesDir, 0755)
+	if err != nil {
+		return err
+	}
+	err = filepath.WalkDir(slicesDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		match := fnameExp.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil
+		}
+		if _, ok := release.Packages[match[1]]; !ok {
+			return nil
+		}
+		return os.Remove(path)
+	})
+	if err != nil {
+		return err
+	}
+	for _, pkg := range release.Packages {
+		data, err := MarshalPackage(pkg)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(filepath.Join(slicesDir, pkg.Name+".yaml"), data, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addField(node *yaml.Node, key string, value *yaml.Node) {
+	node.Content = append(node.Content, stringNode(key), value)
+}
+
+func stringNode(value string) *yaml.Node {
+	node := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+	if strings.Contains(strings.TrimSuffix(value, "\n"), "\n") {
+		node.Style = yaml.LiteralStyle
+	}
+	return node
+}
+
+func sliceKeysNode(keys []SliceKey) *yaml.Node {
+	node := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, key := range keys {
+		node.Content = append(node.Content, stringNode(key.String()))
+	}
+	return node
+}
+
+// pathInfoNode returns the node with the details of a path in the contents
+// of a slice, which is empty when the path is copied as is from the package.
+func pathInfoNode(path string, info PathInfo) *yaml.Node {
+	node := &yaml.Node{Kind: yaml.MappingNode, Style: yaml.FlowStyle}
+	switch info.Kind {
+	case DirPath:
+		addField(node, "make", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "true"})
+	case CopyPath:
+		if info.Info != "" && info.Info != path {
+			addField(node, "copy", stringNode(info.Info))
+		}
+	case TextPath:
+		addField(node, "text", stringNode(info.Info))
+	case SymlinkPath:
+		addField(node, "symlink", stringNode(info.Info))
+	case GeneratePath:
+		addField(node, "generate", stringNode(string(info.Generate)))
+	}
+	if info.Mode != 0 {
+		addField(node, "mode", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: fmt.Sprintf("%#o", info.Mode)})
+	}
+	if info.Mutable {
+		addField(node, "mutable", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "true"})
+	}
+	if info.Until != UntilNone {
+		addField(node, "until", stringNode(string(info.Until)))
+	}
+	if len(info.Arch) == 1 {
+		addField(node, "arch", stringNode(info.Arch[0]))
+	} else if len(info.Arch) > 1 {
+		arch := &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+		for _, a := range info.Arch {
+			arch.Content = append(arch.Content, stringNode(a))
+		}
+		addField(node, "arch", arch)
+	}
+	if info.Detail != DetailFull {
+		addField(node, "detail", stringNode(string(info.Detail)))
+	}
+	sortFields(node, pathFieldOrder)
+	if len(node.Content) == 0 {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null"}
+	}
+	return node
+}
@@ -0,0 +1,198 @@
+package setup_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+func (s *S) TestMarshalPackage(c *C) {
+	pkg := &setup.Package{
+		Name:            "mypkg",
+		Path:            "slices/mypkg.yaml",
+		Archive:         "ubuntu",
+		ExplicitArchive: true,
+		Deprecated:      "Use otherpkg instead.",
+		Slices: map[string]*setup.Slice{
+			"bins": {
+				Package:     "mypkg",
+				Name:        "bins",
+				Summary:     "Binaries",
+				Description: "The binaries.\nAll of them.\n",
+				Deprecated:  "Use otherpkg instead.",
+				Essential:   []setup.SliceKey{{"mypkg", "config"}},
+				Contents: map[string]setup.PathInfo{
+					"/usr/bin/foo":       {Kind: setup.CopyPath},
+					"/usr/bin/bar":       {Kind: setup.CopyPath, Info: "/usr/bin/foo", Mode: 0755},
+					"/usr/bin/baz*":      {Kind: setup.GlobPath, Arch: []string{"amd64", "arm64"}},
+					"/usr/bin/link":      {Kind: setup.SymlinkPath, Info: "foo", Until: setup.UntilMutate},
+					"/var/lib/mypkg/":    {Kind: setup.DirPath, Mode: 01777},
+					"/var/lib/chisel/**": {Kind: setup.GeneratePath, Generate: setup.GenerateManifest, Detail: setup.DetailPathsOnly},
+				},
+				Scripts: setup.SliceScripts{
+					Mutate:      "content.list(\"/\")\ncontent.list(\"/usr/\")\n",
+					MutateAfter: []setup.SliceKey{{"otherpkg", "bins"}},
+				},
+			},
+			"config": {
+				Package:    "mypkg",
+				Name:       "config",
+				Deprecated: "Not needed anymore.",
+				Contents: map[string]setup.PathInfo{
+					"/etc/foo.conf": {Kind: setup.TextPath, Info: "true", Mutable: true, Arch: []string{"amd64"}},
+				},
+			},
+		},
+	}
+	data, err := setup.MarshalPackage(pkg)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, ""+
+		"package: mypkg\n"+
+		"archive: ubuntu\n"+
+		"deprecated: Use otherpkg instead.\n"+
+		"slices:\n"+
+		"  bins:\n"+
+		"    summary: Binaries\n"+
+		"    description: |\n"+
+		"      The binaries.\n"+
+		"      All of them.\n"+
+		"    essential:\n"+
+		"      - mypkg_config\n"+
+		"    mutate-after:\n"+
+		"      - otherpkg_bins\n"+
+		"    contents:\n"+
+		"      /usr/bin/bar: {mode: 0755, copy: /usr/bin/foo}\n"+
+		"      /usr/bin/baz*: {arch: [amd64, arm64]}\n"+
+		"      /usr/bin/foo:\n"+
+		"      /usr/bin/link: {symlink: foo, until: mutate}\n"+
+		"      /var/lib/chisel/**: {generate: manifest, detail: paths-only}\n"+
+		"      /var/lib/mypkg/: {make: true, mode: 01777}\n"+
+		"    mutate: |\n"+
+		"      content.list(\"/\")\n"+
+		"      content.list(\"/usr/\")\n"+
+		"  config:\n"+
+		"    deprecated: Not needed anymore.\n"+
+		"    contents:\n"+
+		"      /etc/foo.conf: {text: \"true\", mutable: true, arch: amd64}\n")
+
+	// The package is read back as it was provided.
+	dir := c.MkDir()
+	c.Assert(os.WriteFile(filepath.Join(dir, "chisel.yaml"), testutil.Reindent(defaultChiselYaml), 0644), IsNil)
+	c.Assert(os.MkdirAll(filepath.Join(dir, "slices"), 0755), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(dir, "slices/mypkg.yaml"), data, 0644), IsNil)
+	otherpkg := "package: otherpkg\nslices:\n  bins: {}\n"
+	c.Assert(os.WriteFile(filepath.Join(dir, "slices/otherpkg.yaml"), []byte(otherpkg), 0644), IsNil)
+	release, err := setup.ReadRelease(dir)
+	c.Assert(err, IsNil)
+	c.Assert(release.Packages["mypkg"], DeepEquals, pkg)
+}
+
+func (s *S) TestMarshalPackageDefaultArchive(c *C) {
+	pkg := &setup.Package{
+		Name:    "mypkg",
+		Archive: "ubuntu",
+		Slices: map[string]*setup.Slice{
+			"bins": {
+				Package: "mypkg",
+				Name:    "bins",
+			},
+		},
+	}
+	data, err := setup.MarshalPackage(pkg)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, ""+
+		"package: mypkg\n"+
+		"slices:\n"+
+		"  bins: {}\n")
+}
+
+func (s *S) TestMarshalPackageChanged(c *C) {
+	pkg := &setup.Package{
+		Name:       "mypkg",
+		Archive:    "ubuntu",
+		Deprecated: "Use otherpkg instead.",
+		Slices: map[string]*setup.Slice{
+			"bins": {
+				Package: "mypkg",
+				Name:    "bins",
+			},
+		},
+	}
+	_, err := setup.MarshalPackage(pkg)
+	c.Assert(err, ErrorMatches, `cannot marshal package "mypkg" slice definitions: cannot be read back as provided`)
+}
+
+func (s *S) TestWriteSliceDefinitions(c *C) {
+	dir := c.MkDir()
+	input := map[string]string{
+		"chisel.yaml": string(defaultChiselYaml),
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			essential:
+				- mypkg_config
+			slices:
+				bins:
+					contents:
+						/usr/bin/foo: {copy: /usr/bin/foo}
+						/usr/bin/bar: {mode: 0755}
+						/etc/motd: {text: "Hello\nworld\n", mutable: true}
+					mutate: |
+						content.write("/etc/motd", "Bye")
+		`,
+		"slices/mydir/mypkg_config.yaml": `
+			package: mypkg
+			slices:
+				config:
+					summary: Configuration
+					contents:
+						/etc/foo.conf: {arch: [amd64, i386]}
+		`,
+		"slices/otherpkg.yaml": `
+			package: otherpkg
+			archive: ubuntu
+			slices:
+				libs:
+					essential:
+						- mypkg_config
+					contents:
+						/usr/lib/libfoo.so*:
+						/usr/lib/link: {symlink: libfoo.so.1}
+		`,
+	}
+	for path, data := range input {
+		fpath := filepath.Join(dir, path)
+		c.Assert(os.MkdirAll(filepath.Dir(fpath), 0755), IsNil)
+		c.Assert(os.WriteFile(fpath, testutil.Reindent(data), 0644), IsNil)
+	}
+	release, err := setup.ReadRelease(dir)
+	c.Assert(err, IsNil)
+
+	// The definitions are rewritten in place, replacing the files the
+	// packages were split across.
+	err = setup.WriteSliceDefinitions(release, dir)
+	c.Assert(err, IsNil)
+	paths, err := filepath.Glob(filepath.Join(dir, "slices/*/*.yaml"))
+	c.Assert(err, IsNil)
+	c.Assert(paths, HasLen, 0)
+	data, err := os.ReadFile(filepath.Join(dir, "slices/mypkg.yaml"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Not(Matches), `(?s).*\narchive:.*`)
+	data, err = os.ReadFile(filepath.Join(dir, "slices/otherpkg.yaml"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Matches, `(?s).*\narchive: ubuntu\n.*`)
+
+	newRelease, err := setup.ReadRelease(dir)
+	c.Assert(err, IsNil)
+
+	for _, pkg := range release.Packages {
+		pkg.Path = ""
+	}
+	for _, pkg := range newRelease.Packages {
+		pkg.Path = ""
+	}
+	c.Assert(newRelease.Packages, DeepEquals, release.Packages)
+}
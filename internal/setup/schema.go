@@ -0,0 +1,60 @@
+package setup
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/canonical/chisel/internal/jsonschema"
+)
+
+// ReleaseSchema returns the JSON Schema of the chisel.yaml file of releases.
+func ReleaseSchema() *jsonschema.Schema {
+	schema := jsonschema.Generate(reflect.TypeOf(yamlRelease{}), "yaml")
+	schema.Schema = jsonschema.Draft
+	schema.Title = "Chisel release configuration"
+	schema.Required = []string{"format", "archives"}
+	schema.Properties["format"].Enum = []string{"v1", "chisel-v1"}
+	schema.Properties["min-chisel-version"] = &jsonschema.Schema{Type: "string"}
+	archive := schema.Properties["archives"].AdditionalProperties
+	archive.Required = []string{"version", "components"}
+	archive.Properties["suite-policy"].Enum = []string{"highest-version", "first-match"}
+	// Versions such as 22.04 are numbers in YAML unless quoted.
+	archive.Properties["version"].Type = []string{"string", "number"}
+	return schema
+}
+
+// SliceDefinitionSchema returns the JSON Schema of the slice definition
+// files of releases, which define the slices of one package each.
+func SliceDefinitionSchema() *jsonschema.Schema {
+	schema := jsonschema.Generate(reflect.TypeOf(yamlPackage{}), "yaml")
+	schema.Schema = jsonschema.Draft
+	schema.Title = "Chisel slice definition"
+	schema.Required = []string{"package"}
+	return schema
+}
+
+func (yamlArch) JSONSchema() *jsonschema.Schema {
+	return &jsonschema.Schema{
+		OneOf: []*jsonschema.Schema{
+			{Type: "string"},
+			{Type: "array", Items: &jsonschema.Schema{Type: "string"}},
+		},
+	}
+}
+
+func (PathUntil) JSONSchema() *jsonschema.Schema {
+	return &jsonschema.Schema{Type: "string", Enum: []string{string(UntilMutate)}}
+}
+
+func (GenerateKind) JSONSchema() *jsonschema.Schema {
+	var kinds []string
+	for kind := range generateKinds {
+		kinds = append(kinds, string(kind))
+	}
+	sort.Strings(kinds)
+	return &jsonschema.Schema{Type: "string", Enum: kinds}
+}
+
+func (ManifestDetail) JSONSchema() *jsonschema.Schema {
+	return &jsonschema.Schema{Type: "string", Enum: []string{string(DetailPathsOnly)}}
+}
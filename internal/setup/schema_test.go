@@ -0,0 +1,52 @@
+package setup_test
+
+import (
+	"sort"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/jsonschema"
+	"github.com/canonical/chisel/internal/setup"
+)
+
+func propertyNames(schema *jsonschema.Schema) []string {
+	var names []string
+	for name := range schema.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *S) TestReleaseSchema(c *C) {
+	schema := setup.ReleaseSchema()
+	c.Assert(schema.Required, DeepEquals, []string{"format", "archives"})
+	c.Assert(propertyNames(schema), DeepEquals, []string{
+		"archives", "format", "min-chisel-version", "public-keys", "require-copyright", "sets", "v1-public-keys",
+	})
+	archive := schema.Properties["archives"].AdditionalProperties
+	c.Assert(propertyNames(archive), DeepEquals, []string{
+		"ca-cert", "components", "default", "public-keys", "suite-policy", "suites", "v1-public-keys", "version",
+	})
+	c.Assert(archive.Properties["version"].Type, DeepEquals, []string{"string", "number"})
+}
+
+func (s *S) TestSliceDefinitionSchema(c *C) {
+	schema := setup.SliceDefinitionSchema()
+	c.Assert(schema.Required, DeepEquals, []string{"package"})
+	c.Assert(propertyNames(schema), DeepEquals, []string{"archive", "deprecated", "essential", "package", "slices"})
+
+	slice := schema.Properties["slices"].AdditionalProperties
+	c.Assert(propertyNames(slice), DeepEquals, []string{"contents", "deprecated", "description", "essential", "mutate", "mutate-after", "summary"})
+
+	// Paths may have no details at all.
+	path := slice.Properties["contents"].AdditionalProperties
+	c.Assert(path.Type, DeepEquals, []string{"object", "null"})
+	c.Assert(propertyNames(path), DeepEquals, []string{
+		"arch", "copy", "detail", "generate", "make", "mode", "mutable", "symlink", "text", "until",
+	})
+	c.Assert(path.Properties["detail"].Enum, DeepEquals, []string{"paths-only"})
+	c.Assert(path.Properties["until"].Enum, DeepEquals, []string{"mutate"})
+	c.Assert(path.Properties["generate"].Enum, DeepEquals, []string{"manifest"})
+	c.Assert(path.Properties["arch"].OneOf, HasLen, 2)
+}
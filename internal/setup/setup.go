@@ -2,12 +2,15 @@ package setup
 
 import (
 	"bytes"
+	"crypto/x509"
 	"fmt"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
 	"slices"
+	"sort"
+	"strconv"
 	"strings"
 
 	"golang.org/x/crypto/openpgp/packet"
@@ -25,8 +28,43 @@ type Release struct {
 	Packages       map[string]*Package
 	Archives       map[string]*Archive
 	DefaultArchive string
+
+	// Commit identifies the revision of the release repository the
+	// release was fetched from, when known.
+	Commit string
+
+	// ConflictPolicy is the policy the release was validated with, and
+	// which is also used when selecting slices from it. It defaults to
+	// ConflictStrict when empty.
+	ConflictPolicy ConflictPolicy
+
+	// RequireCopyright is set when every slice must include the copyright
+	// file of its package, either directly or via its essential slices.
+	RequireCopyright bool
+
+	// Sets holds named selections of slices, defined either in chisel.yaml
+	// or in sets.yaml, so that they may be selected all at once.
+	Sets map[string][]SliceKey
+
+	// Partial is set when only the slice definitions needed by some
+	// packages and sets were read, as requested via ReadOptions. Packages
+	// then holds none of the other packages of the release.
+	Partial bool
 }
 
+// ConflictPolicy defines how to handle slices extracting the same content
+// from different packages into the same location. Such content cannot be
+// proven to be equal before the packages are downloaded.
+type ConflictPolicy string
+
+const (
+	// ConflictStrict reports those cases as conflicts.
+	ConflictStrict ConflictPolicy = "strict"
+	// ConflictWarn logs a warning instead, leaving it to the slicer to
+	// verify that the extracted content is in fact the same.
+	ConflictWarn ConflictPolicy = "warn"
+)
+
 // Archive is the location from which binary packages are obtained.
 type Archive struct {
 	Name       string
@@ -34,14 +72,29 @@ type Archive struct {
 	Suites     []string
 	Components []string
 	PubKeys    []*packet.PublicKey
+	// SuitePolicy defines which of the suites a package is selected from
+	// when several provide it: "highest-version", the default when empty,
+	// or "first-match".
+	SuitePolicy string
+	// CACerts holds PEM-encoded certificates trusted when talking to the
+	// archive, in addition to the ones trusted by the system.
+	CACerts []byte
 }
 
 // Package holds a collection of slices that represent parts of themselves.
+// The slices may be split across several slice definition files, in which
+// case Path is the first of them.
 type Package struct {
 	Name    string
 	Path    string
 	Archive string
-	Slices  map[string]*Slice
+	// ExplicitArchive tells whether Archive was selected in the slice
+	// definitions, rather than being the default archive of the release.
+	ExplicitArchive bool
+	Slices          map[string]*Slice
+	// Deprecated, if set, explains why the package should not be used
+	// anymore and what to use instead.
+	Deprecated string
 }
 
 // Slice holds the details about a package slice.
@@ -51,10 +104,21 @@ type Slice struct {
 	Essential []SliceKey
 	Contents  map[string]PathInfo
 	Scripts   SliceScripts
+	// Summary is a single line describing the slice, and Description
+	// optionally extends it with further details.
+	Summary     string
+	Description string
+	// Deprecated, if set, explains why the slice should not be used
+	// anymore and what to use instead. Slices of deprecated packages
+	// have the message of their package unless they have their own.
+	Deprecated string
 }
 
 type SliceScripts struct {
 	Mutate string
+	// MutateAfter lists the slices whose mutation scripts must run before
+	// this one when they are also selected, without selecting them.
+	MutateAfter []SliceKey
 }
 
 type PathKind string
@@ -81,10 +145,32 @@ const (
 type GenerateKind string
 
 const (
-	GenerateNone     GenerateKind = ""
-	GenerateManifest GenerateKind = "manifest"
+	GenerateNone      GenerateKind = ""
+	GenerateManifest  GenerateKind = "manifest"
+	GenerateImageInfo GenerateKind = "image-info"
 )
 
+var generateKinds = map[GenerateKind]bool{
+	GenerateManifest: true,
+}
+
+// ManifestDetail is the level of detail of generated manifests.
+type ManifestDetail string
+
+const (
+	DetailFull ManifestDetail = ""
+	// DetailPathsOnly leaves the content entries out of the manifest, as
+	// they duplicate the slices of its path entries.
+	DetailPathsOnly ManifestDetail = "paths-only"
+)
+
+// RegisterGenerateKind makes kind a valid "generate" value for the paths of
+// selected slices. It is meant to be called by the packages implementing
+// the respective generators, before any selection is made.
+func RegisterGenerateKind(kind GenerateKind) {
+	generateKinds[kind] = true
+}
+
 type PathInfo struct {
 	Kind PathKind
 	Info string
@@ -94,6 +180,7 @@ type PathInfo struct {
 	Until    PathUntil
 	Arch     []string
 	Generate GenerateKind
+	Detail   ManifestDetail
 }
 
 // SameContent returns whether the path has the same content properties as some
@@ -105,7 +192,8 @@ func (pi *PathInfo) SameContent(other *PathInfo) bool {
 		pi.Info == other.Info &&
 		pi.Mode == other.Mode &&
 		pi.Mutable == other.Mutable &&
-		pi.Generate == other.Generate)
+		pi.Generate == other.Generate &&
+		pi.Detail == other.Detail)
 }
 
 type SliceKey struct {
@@ -116,16 +204,131 @@ type SliceKey struct {
 func (s *Slice) String() string   { return s.Package + "_" + s.Name }
 func (s SliceKey) String() string { return s.Package + "_" + s.Slice }
 
+// ConflictError is returned when two slices would create different content
+// at the same location.
+type ConflictError struct {
+	// Slices holds the two conflicting slices.
+	Slices [2]SliceKey
+	// Paths holds the conflicting path of each slice, which are the same
+	// unless one of them is a glob or generate path.
+	Paths [2]string
+}
+
+func newConflictError(old, new *Slice, oldPath, newPath string) *ConflictError {
+	return &ConflictError{
+		Slices: [2]SliceKey{{old.Package, old.Name}, {new.Package, new.Name}},
+		Paths:  [2]string{oldPath, newPath},
+	}
+}
+
+// tolerates returns whether the conflict err may be logged as a warning
+// instead of failing, per the conflict policy of the release. Only conflicts
+// between content extracted from different packages, for which uncertain is
+// true, are ever tolerated.
+func (r *Release) tolerates(err *ConflictError, uncertain bool) bool {
+	if !uncertain || r.ConflictPolicy != ConflictWarn {
+		debugf("Conflict not tolerated (policy %q, uncertain %v): %s", r.ConflictPolicy, uncertain, err)
+		return false
+	}
+	logf("Warning: %s, content will be verified when extracting", err)
+	return true
+}
+
+func (e *ConflictError) Error() string {
+	if e.Paths[0] == e.Paths[1] {
+		return fmt.Sprintf("slices %s and %s conflict on %s", e.Slices[0], e.Slices[1], e.Paths[0])
+	}
+	return fmt.Sprintf("slices %s and %s conflict on %s and %s", e.Slices[0], e.Slices[1], e.Paths[0], e.Paths[1])
+}
+
+// Conflict describes two slices which would create content at the same
+// location, and how the release resolves it.
+type Conflict struct {
+	ConflictError
+	// Uncertain is set when the content is extracted from different
+	// packages, and may turn out to be the same once they are downloaded.
+	Uncertain bool
+	// Tolerated is set when the conflict policy of the release lets the
+	// content be verified when extracting, rather than failing.
+	Tolerated bool
+}
+
+// Conflicts returns every conflict between the slices of the release,
+// sorted by slices and paths. Only tolerated conflicts are found in a
+// release read successfully, so tools looking for all of them should read
+// it with the ConflictWarn policy.
+func (r *Release) Conflicts() []*Conflict {
+	var conflicts []*Conflict
+	seen := make(map[ConflictError]bool)
+	r.walkConflicts(func(err *ConflictError, uncertain bool) error {
+		if !seen[*err] {
+			seen[*err] = true
+			conflicts = append(conflicts, &Conflict{
+				ConflictError: *err,
+				Uncertain:     uncertain,
+				Tolerated:     uncertain && r.ConflictPolicy == ConflictWarn,
+			})
+		}
+		return nil
+	})
+	sort.Slice(conflicts, func(i, j int) bool {
+		a, b := conflicts[i], conflicts[j]
+		for k := 0; k < 2; k++ {
+			if a.Slices[k] != b.Slices[k] {
+				return a.Slices[k].String() < b.Slices[k].String()
+			}
+			if a.Paths[k] != b.Paths[k] {
+				return a.Paths[k] < b.Paths[k]
+			}
+		}
+		return false
+	})
+	return conflicts
+}
+
 // Selection holds the required configuration to create a Build for a selection
 // of slices from a Release. It's still an abstract proposal in the sense that
 // the real information coming from pacakges is still unknown, so referenced
 // paths could potentially be missing, for example.
 type Selection struct {
 	Release *Release
-	Slices  []*Slice
+	// Slices holds the selected slices in the order defined by Order.
+	Slices []*Slice
+}
+
+// ReadOptions holds the options for reading a release.
+type ReadOptions struct {
+	// ConflictPolicy defaults to ConflictStrict.
+	ConflictPolicy ConflictPolicy
+	// ExtraSlices lists directories with slice definitions overlaid on
+	// top of the ones of the release, in increasing order of precedence.
+	ExtraSlices []string
+	// Packages and Sets, if either is not empty, restrict reading to the
+	// slice definitions of the named packages, of the packages of the
+	// slices in the named sets, and of the packages their slices depend
+	// on, transitively. This is faster for large releases, but conflicts
+	// with the slices of other packages are then not reported.
+	Packages []string
+	Sets     []string
 }
 
 func ReadRelease(dir string) (*Release, error) {
+	return ReadReleaseWithOptions(dir, nil)
+}
+
+// ReadReleaseWithOptions reads and validates the release in dir according to
+// the provided options, which may be nil.
+func ReadReleaseWithOptions(dir string, options *ReadOptions) (*Release, error) {
+	if options == nil {
+		options = &ReadOptions{}
+	}
+	policy := options.ConflictPolicy
+	switch policy {
+	case "", ConflictStrict, ConflictWarn:
+	default:
+		return nil, fmt.Errorf("invalid conflict policy %q", policy)
+	}
+
 	logDir := dir
 	if strings.Contains(dir, "/.cache/") {
 		logDir = filepath.Base(dir)
@@ -137,10 +340,25 @@ func ReadRelease(dir string) (*Release, error) {
 		Packages: make(map[string]*Package),
 	}
 
-	release, err := readRelease(dir)
-	if err != nil {
-		return nil, err
+	var err error
+	if len(options.Packages) > 0 || len(options.Sets) > 0 {
+		release, err = readPartialRelease(dir, options)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		release, err = readRelease(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, extraDir := range options.ExtraSlices {
+			err = readExtraSlices(release, extraDir)
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
+	release.ConflictPolicy = policy
 
 	err = release.validate()
 	if err != nil {
@@ -151,7 +369,80 @@ func ReadRelease(dir string) (*Release, error) {
 
 func (r *Release) validate() error {
 	keys := []SliceKey(nil)
+	for _, pkg := range r.Packages {
+		for _, slice := range pkg.Slices {
+			keys = append(keys, SliceKey{pkg.Name, slice.Name})
+		}
+	}
 
+	err := r.walkConflicts(func(err *ConflictError, uncertain bool) error {
+		if !r.tolerates(err, uncertain) {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Check for cycles.
+	_, err = order(r.Packages, keys)
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+	for _, key := range keys {
+		slice := r.Packages[key.Package].Slices[key.Slice]
+		for _, req := range slice.Scripts.MutateAfter {
+			pkg, ok := r.Packages[req.Package]
+			if !ok && r.Partial {
+				// The slice cannot be selected.
+				continue
+			}
+			if !ok || pkg.Slices[req.Slice] == nil {
+				return fmt.Errorf("%s mutates after %s, but slice is missing", slice, req)
+			}
+		}
+	}
+
+	setNames := make([]string, 0, len(r.Sets))
+	for name := range r.Sets {
+		setNames = append(setNames, name)
+	}
+	sort.Strings(setNames)
+	for _, name := range setNames {
+		for _, key := range r.Sets[name] {
+			pkg, ok := r.Packages[key.Package]
+			if !ok && r.Partial {
+				// The set was not needed.
+				break
+			}
+			if !ok || pkg.Slices[key.Slice] == nil {
+				return fmt.Errorf("set %q refers to undefined slice %s", name, key)
+			}
+		}
+	}
+
+	if r.RequireCopyright {
+		for _, key := range keys {
+			slice := r.Packages[key.Package].Slices[key.Slice]
+			if !r.hasCopyright(slice) {
+				return fmt.Errorf("slice %s does not include %s directly or via essential slices", slice, copyrightPath(slice.Package))
+			}
+		}
+	}
+
+	return nil
+}
+
+// walkConflicts calls f with every conflict between the slices of the
+// release, and whether it is uncertain, as the conflicting content is
+// extracted from different packages and may turn out to be the same.
+// Walking stops at the first error returned by f.
+func (r *Release) walkConflicts(f func(err *ConflictError, uncertain bool) error) error {
 	// Check for info conflicts and prepare for following checks. A conflict
 	// means that two slices attempt to extract different files or directories
 	// to the same location.
@@ -163,38 +454,76 @@ func (r *Release) validate() error {
 	// The above also means that generated content (e.g. text files, directories
 	// with make:true) will always conflict with extracted content, because we
 	// cannot validate that they are the same without downloading the package.
+	// Slices are visited in order, so that slices agreeing on some content
+	// are always compared to the same one of them.
+	var sorted []*Slice
+	for _, pkg := range r.Packages {
+		for _, slice := range pkg.Slices {
+			sorted = append(sorted, slice)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].String() < sorted[j].String()
+	})
 	paths := make(map[string]*Slice)
 	globs := make(map[string]*Slice)
-	for _, pkg := range r.Packages {
-		for _, new := range pkg.Slices {
-			keys = append(keys, SliceKey{pkg.Name, new.Name})
-			for newPath, newInfo := range new.Contents {
-				if old, ok := paths[newPath]; ok {
-					oldInfo := old.Contents[newPath]
-					if !newInfo.SameContent(&oldInfo) || (newInfo.Kind == CopyPath || newInfo.Kind == GlobPath) && new.Package != old.Package {
-						if old.Package > new.Package || old.Package == new.Package && old.Name > new.Name {
-							old, new = new, old
-						}
-						return fmt.Errorf("slices %s and %s conflict on %s", old, new, newPath)
+	for _, new := range sorted {
+		for newPath, newInfo := range new.Contents {
+			if old, ok := paths[newPath]; ok {
+				oldInfo := old.Contents[newPath]
+				if !newInfo.SameContent(&oldInfo) || (newInfo.Kind == CopyPath || newInfo.Kind == GlobPath) && new.Package != old.Package {
+					first, second := old, new
+					if old.Package > new.Package || old.Package == new.Package && old.Name > new.Name {
+						first, second = new, old
 					}
-					// Note: Because for conflict resolution we only check that
-					// the created file would be the same and we know newInfo and
-					// oldInfo produce the same one, we do not have to record
-					// newInfo.
-				} else {
-					paths[newPath] = new
-					if newInfo.Kind == GeneratePath || newInfo.Kind == GlobPath {
-						globs[newPath] = new
+					err := f(newConflictError(first, second, newPath, newPath), newInfo.SameContent(&oldInfo))
+					if err != nil {
+						return err
 					}
+				} else {
+					debugf("Slices %s and %s agree on %s", old, new, newPath)
+				}
+				// Note: Because for conflict resolution we only check that
+				// the created file would be the same and we know newInfo and
+				// oldInfo produce the same one, we do not have to record
+				// newInfo.
+			} else {
+				paths[newPath] = new
+				if newInfo.Kind == GeneratePath || newInfo.Kind == GlobPath {
+					globs[newPath] = new
 				}
 			}
 		}
 	}
 
-	// Check for glob and generate conflicts.
+	// Check for glob and generate conflicts. Two paths may only match if
+	// the literal prefix of either, up to its first wildcard, is a prefix
+	// of the other, so only those are compared instead of every path.
+	sortedPaths := make([]string, 0, len(paths))
+	wildPaths := make(map[string][]string)
+	for path := range paths {
+		sortedPaths = append(sortedPaths, path)
+		if prefix, wild := literalPrefix(path); wild {
+			wildPaths[prefix] = append(wildPaths[prefix], path)
+		}
+	}
+	sort.Strings(sortedPaths)
 	for oldPath, old := range globs {
 		oldInfo := old.Contents[oldPath]
-		for newPath, new := range paths {
+		prefix, _ := literalPrefix(oldPath)
+		var candidates []string
+		for i := 0; i < len(prefix); i++ {
+			candidates = append(candidates, wildPaths[prefix[:i]]...)
+		}
+		start := sort.SearchStrings(sortedPaths, prefix)
+		for _, path := range sortedPaths[start:] {
+			if !strings.HasPrefix(path, prefix) {
+				break
+			}
+			candidates = append(candidates, path)
+		}
+		for _, newPath := range candidates {
+			new := paths[newPath]
 			if oldPath == newPath {
 				// Identical paths have been filtered earlier. This must be the
 				// exact same entry.
@@ -203,27 +532,65 @@ func (r *Release) validate() error {
 			newInfo := new.Contents[newPath]
 			if oldInfo.Kind == GlobPath && (newInfo.Kind == GlobPath || newInfo.Kind == CopyPath) {
 				if new.Package == old.Package {
+					debugf("Slices %s and %s may share %s and %s, as they extract from the same package", old, new, oldPath, newPath)
 					continue
 				}
 			}
 			if strdist.GlobPath(newPath, oldPath) {
+				extracted := oldInfo.Kind == GlobPath && (newInfo.Kind == GlobPath || newInfo.Kind == CopyPath)
+				first, second, firstPath, secondPath := old, new, oldPath, newPath
 				if (old.Package > new.Package) || (old.Package == new.Package && old.Name > new.Name) ||
 					(old.Package == new.Package && old.Name == new.Name && oldPath > newPath) {
-					old, new = new, old
-					oldPath, newPath = newPath, oldPath
+					first, second = new, old
+					firstPath, secondPath = newPath, oldPath
+				}
+				err := f(newConflictError(first, second, firstPath, secondPath), extracted)
+				if err != nil {
+					return err
 				}
-				return fmt.Errorf("slices %s and %s conflict on %s and %s", old, new, oldPath, newPath)
 			}
 		}
 	}
+	return nil
+}
 
-	// Check for cycles.
-	_, err := order(r.Packages, keys)
-	if err != nil {
-		return err
+// literalPrefix returns the part of path before its first wildcard, and
+// whether it has any.
+func literalPrefix(path string) (prefix string, wild bool) {
+	if i := strings.IndexAny(path, "*?"); i >= 0 {
+		return path[:i], true
 	}
+	return path, false
+}
 
-	return nil
+func copyrightPath(pkgName string) string {
+	return "/usr/share/doc/" + pkgName + "/copyright"
+}
+
+// hasCopyright returns whether slice, or any of the slices it depends on,
+// extracts the copyright file of the package of slice.
+func (r *Release) hasCopyright(slice *Slice) bool {
+	copyright := copyrightPath(slice.Package)
+	seen := make(map[SliceKey]bool)
+	pending := []SliceKey{{slice.Package, slice.Name}}
+	for len(pending) > 0 {
+		key := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		current := r.Packages[key.Package].Slices[key.Slice]
+		if current.Package == slice.Package {
+			for path, info := range current.Contents {
+				if (info.Kind == CopyPath || info.Kind == GlobPath) && strdist.GlobPath(path, copyright) {
+					return true
+				}
+			}
+		}
+		pending = append(pending, current.Essential...)
+	}
+	return false
 }
 
 func order(pkgs map[string]*Package, keys []SliceKey) ([]SliceKey, error) {
@@ -263,22 +630,85 @@ func order(pkgs map[string]*Package, keys []SliceKey) ([]SliceKey, error) {
 		pending = append(pending, slice.Essential...)
 	}
 
-	// Sort them up.
-	var order []SliceKey
+	// Check for loops.
 	for _, names := range tarjanSort(successors) {
 		if len(names) > 1 {
 			return nil, fmt.Errorf("essential loop detected: %s", strings.Join(names, ", "))
 		}
-		name := names[0]
+	}
+
+	// Slices listed in mutate-after only come first when they are selected
+	// as well, and may not form loops together with the essential ones.
+	mutateAfter := false
+	for key := range seen {
+		slice := pkgs[key.Package].Slices[key.Slice]
+		fqslice := slice.String()
+		for _, req := range slice.Scripts.MutateAfter {
+			fqreq := req.String()
+			if seen[req] && !slices.Contains(successors[fqslice], fqreq) {
+				successors[fqslice] = append(successors[fqslice], fqreq)
+				mutateAfter = true
+			}
+		}
+	}
+	if mutateAfter {
+		for _, names := range tarjanSort(successors) {
+			if len(names) > 1 {
+				return nil, fmt.Errorf("mutate-after loop detected: %s", strings.Join(names, ", "))
+			}
+		}
+	}
+
+	// Sort them up. Among the slices whose essentials were all placed
+	// already, the one first in lexicographic order always goes next, so
+	// that the result does not depend on the order slices and essentials
+	// are listed in.
+	pendingCount := make(map[string]int, len(successors))
+	dependents := make(map[string][]string, len(successors))
+	var ready []string
+	for name, reqs := range successors {
+		for _, req := range reqs {
+			if !slices.Contains(dependents[req], name) {
+				dependents[req] = append(dependents[req], name)
+				pendingCount[name]++
+			}
+		}
+		if pendingCount[name] == 0 {
+			ready = append(ready, name)
+		}
+	}
+	var order []SliceKey
+	for len(ready) > 0 {
+		sort.Strings(ready)
+		name := ready[0]
+		ready = ready[1:]
 		dot := strings.IndexByte(name, '_')
 		order = append(order, SliceKey{name[:dot], name[dot+1:]})
+		for _, dependent := range dependents[name] {
+			pendingCount[dependent]--
+			if pendingCount[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
 	}
 
 	return order, nil
 }
 
-// fnameExp matches the slice definition file basename.
-var fnameExp = regexp.MustCompile(`^([a-z0-9](?:-?[.a-z0-9+]){1,})\.yaml$`)
+// Order returns the slices identified by keys together with all of their
+// essential slices, sorted so that every slice comes after its essentials
+// and after the selected slices listed in its mutate-after field.
+// Slices without such a constraint between them are sorted by name, so
+// that the order, which is also the one mutation scripts run in, is the
+// same however the slices and their essentials are listed.
+func Order(release *Release, keys []SliceKey) ([]SliceKey, error) {
+	return order(release.Packages, keys)
+}
+
+// fnameExp matches the slice definition file basename, which is the package
+// name optionally followed by an underscore and a part name, as in
+// openssl_extra.yaml, so that a package may be split across several files.
+var fnameExp = regexp.MustCompile(`^([a-z0-9](?:-?[.a-z0-9+]){1,})(?:_[a-z](?:-?[a-z0-9]){2,})?\.yaml$`)
 
 // snameExp matches only the slice name, without the leading package name.
 var snameExp = regexp.MustCompile(`^([a-z](?:-?[a-z0-9]){2,})$`)
@@ -296,6 +726,87 @@ func ParseSliceKey(sliceKey string) (SliceKey, error) {
 
 func readRelease(baseDir string) (*Release, error) {
 	baseDir = filepath.Clean(baseDir)
+	release, err := readReleaseFiles(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	parts := make(map[string][]*Package)
+	err = readSlices(release, baseDir, filepath.Join(baseDir, "slices"), parts)
+	if err != nil {
+		return nil, err
+	}
+	err = mergePackages(release, parts)
+	if err != nil {
+		return nil, err
+	}
+	return release, err
+}
+
+// readPartialRelease reads the release in baseDir with only the slice
+// definitions needed by the packages and sets in options, and overlays
+// the extra slices in options on top of it.
+func readPartialRelease(baseDir string, options *ReadOptions) (*Release, error) {
+	baseDir = filepath.Clean(baseDir)
+	release, err := readReleaseFiles(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	release.Partial = true
+	index := make(map[string][]string)
+	err = indexSlices(baseDir, filepath.Join(baseDir, "slices"), index)
+	if err != nil {
+		return nil, err
+	}
+
+	pkgNames := append([]string(nil), options.Packages...)
+	for _, setName := range options.Sets {
+		for _, key := range release.Sets[setName] {
+			pkgNames = append(pkgNames, key.Package)
+		}
+	}
+	// Packages overlaid by extra slices are read from the release first,
+	// so that they are merged as when reading the whole release.
+	for _, extraDir := range options.ExtraSlices {
+		extraDir = filepath.Clean(extraDir)
+		extraIndex := make(map[string][]string)
+		err = indexSlices(filepath.Dir(extraDir), extraDir, extraIndex)
+		if err != nil {
+			return nil, err
+		}
+		for pkgName := range extraIndex {
+			pkgNames = append(pkgNames, pkgName)
+		}
+	}
+	sort.Strings(pkgNames)
+	err = readNeededSlices(release, baseDir, index, pkgNames)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(options.ExtraSlices) > 0 {
+		for _, extraDir := range options.ExtraSlices {
+			err = readExtraSlices(release, extraDir)
+			if err != nil {
+				return nil, err
+			}
+		}
+		// Extra slices may depend on packages not read yet.
+		pkgNames = nil
+		for _, pkg := range release.Packages {
+			pkgNames = append(pkgNames, essentialPackages(pkg)...)
+		}
+		sort.Strings(pkgNames)
+		err = readNeededSlices(release, baseDir, index, pkgNames)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return release, nil
+}
+
+// readReleaseFiles reads the release definition in baseDir, along with
+// its sets, but none of its slice definitions.
+func readReleaseFiles(baseDir string) (*Release, error) {
 	filePath := filepath.Join(baseDir, "chisel.yaml")
 	data, err := os.ReadFile(filePath)
 	if err != nil {
@@ -305,14 +816,70 @@ func readRelease(baseDir string) (*Release, error) {
 	if err != nil {
 		return nil, err
 	}
-	err = readSlices(release, baseDir, filepath.Join(baseDir, "slices"))
+	err = readSets(release, baseDir)
 	if err != nil {
 		return nil, err
 	}
-	return release, err
+	return release, nil
 }
 
-func readSlices(release *Release, baseDir, dirName string) error {
+// readSets adds to release the sets defined in the optional sets.yaml file
+// of the release, in addition to those defined in chisel.yaml.
+func readSets(release *Release, baseDir string) error {
+	filePath := filepath.Join(baseDir, "sets.yaml")
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot read sets: %s", err)
+	}
+	fileName := stripBase(baseDir, filePath)
+	yamlVar := yamlSets{}
+	err = yaml.Unmarshal(data, &yamlVar)
+	if err != nil {
+		return fmt.Errorf("%s: cannot parse sets: %v", fileName, err)
+	}
+	return addSets(release, fileName, yamlVar.Sets)
+}
+
+// addSets adds to release the provided sets, defined in fileName.
+func addSets(release *Release, fileName string, sets map[string][]string) error {
+	names := make([]string, 0, len(sets))
+	for name := range sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		// Set names follow the same rules as slice names.
+		if !snameExp.MatchString(name) {
+			return fmt.Errorf("%s: invalid set name %q", fileName, name)
+		}
+		if _, ok := release.Sets[name]; ok {
+			return fmt.Errorf("%s: set %q defined more than once", fileName, name)
+		}
+		if len(sets[name]) == 0 {
+			return fmt.Errorf("%s: set %q has no slices", fileName, name)
+		}
+		keys := make([]SliceKey, 0, len(sets[name]))
+		for _, ref := range sets[name] {
+			key, err := ParseSliceKey(ref)
+			if err != nil {
+				return fmt.Errorf("%s: set %q has invalid slice reference %q", fileName, name, ref)
+			}
+			keys = append(keys, key)
+		}
+		if release.Sets == nil {
+			release.Sets = make(map[string][]SliceKey)
+		}
+		release.Sets[name] = keys
+	}
+	return nil
+}
+
+// readSlices parses the slice definition files under dirName, and adds to
+// parts the packages defined by each of them, indexed by package name.
+func readSlices(release *Release, baseDir, dirName string, parts map[string][]*Package) error {
 	entries, err := os.ReadDir(dirName)
 	if err != nil {
 		return fmt.Errorf("cannot read %s%c directory", stripBase(baseDir, dirName), filepath.Separator)
@@ -320,7 +887,7 @@ func readSlices(release *Release, baseDir, dirName string) error {
 
 	for _, entry := range entries {
 		if entry.IsDir() {
-			err := readSlices(release, baseDir, filepath.Join(dirName, entry.Name()))
+			err := readSlices(release, baseDir, filepath.Join(dirName, entry.Name()), parts)
 			if err != nil {
 				return err
 			}
@@ -334,26 +901,206 @@ func readSlices(release *Release, baseDir, dirName string) error {
 			return fmt.Errorf("invalid slice definition filename: %q", entry.Name())
 		}
 
-		pkgName := match[1]
-		pkgPath := filepath.Join(dirName, entry.Name())
-		if pkg, ok := release.Packages[pkgName]; ok {
-			return fmt.Errorf("package %q slices defined more than once: %s and %s\")", pkgName, pkg.Path, pkgPath)
-		}
-		data, err := os.ReadFile(pkgPath)
+		pkg, err := readSliceFile(release, baseDir, match[1], filepath.Join(dirName, entry.Name()))
 		if err != nil {
-			// Errors from package os generally include the path.
-			return fmt.Errorf("cannot read slice definition file: %v", err)
+			return err
+		}
+		parts[pkg.Name] = append(parts[pkg.Name], pkg)
+	}
+	return nil
+}
+
+// readSliceFile parses the slice definition file of pkgName at pkgPath.
+func readSliceFile(release *Release, baseDir, pkgName, pkgPath string) (*Package, error) {
+	data, err := os.ReadFile(pkgPath)
+	if err != nil {
+		// Errors from package os generally include the path.
+		return nil, fmt.Errorf("cannot read slice definition file: %v", err)
+	}
+
+	pkg, err := parsePackage(baseDir, pkgName, stripBase(baseDir, pkgPath), data)
+	if err != nil {
+		return nil, err
+	}
+	// Archives other than the default one are only used by packages
+	// which explicitly select them.
+	if pkg.Archive == "" {
+		if release.DefaultArchive == "" {
+			return nil, fmt.Errorf("%s: package %q does not select an archive and there is no default one", pkg.Path, pkg.Name)
 		}
+		pkg.Archive = release.DefaultArchive
+	} else if _, ok := release.Archives[pkg.Archive]; !ok {
+		return nil, fmt.Errorf("%s: package %q refers to undefined archive %q", pkg.Path, pkg.Name, pkg.Archive)
+	}
+	return pkg, nil
+}
 
-		pkg, err := parsePackage(baseDir, pkgName, stripBase(baseDir, pkgPath), data)
+// indexSlices adds to index the paths of the slice definition files under
+// dirName, indexed by the package name in their file names, without reading
+// them. All the files a package is split across are thus indexed under it.
+func indexSlices(baseDir, dirName string, index map[string][]string) error {
+	entries, err := os.ReadDir(dirName)
+	if err != nil {
+		return fmt.Errorf("cannot read %s%c directory", stripBase(baseDir, dirName), filepath.Separator)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			err := indexSlices(baseDir, filepath.Join(dirName, entry.Name()), index)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if !strings.HasSuffix(entry.Name(), ".yaml") {
+			continue
+		}
+		match := fnameExp.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return fmt.Errorf("invalid slice definition filename: %q", entry.Name())
+		}
+		index[match[1]] = append(index[match[1]], filepath.Join(dirName, entry.Name()))
+	}
+	return nil
+}
+
+// readNeededSlices adds to release the packages in pkgNames, and the ones
+// their slices depend on, transitively, reading their slice definitions
+// from the files in index. Packages already in release and packages with
+// no definition files are skipped.
+func readNeededSlices(release *Release, baseDir string, index map[string][]string, pkgNames []string) error {
+	for len(pkgNames) > 0 {
+		parts := make(map[string][]*Package)
+		for _, pkgName := range pkgNames {
+			if _, ok := release.Packages[pkgName]; ok || len(parts[pkgName]) > 0 {
+				continue
+			}
+			for _, pkgPath := range index[pkgName] {
+				debugf("Reading slice definitions of %s from %s", pkgName, stripBase(baseDir, pkgPath))
+				pkg, err := readSliceFile(release, baseDir, pkgName, pkgPath)
+				if err != nil {
+					return err
+				}
+				parts[pkgName] = append(parts[pkgName], pkg)
+			}
+		}
+		err := mergePackages(release, parts)
 		if err != nil {
 			return err
 		}
-		if pkg.Archive == "" {
-			pkg.Archive = release.DefaultArchive
+		pkgNames = nil
+		for pkgName := range parts {
+			pkgNames = append(pkgNames, essentialPackages(release.Packages[pkgName])...)
+		}
+	}
+	return nil
+}
+
+// essentialPackages returns the names of the packages of the slices which
+// the slices of pkg depend on.
+func essentialPackages(pkg *Package) []string {
+	var pkgNames []string
+	for _, slice := range pkg.Slices {
+		for _, key := range slice.Essential {
+			pkgNames = append(pkgNames, key.Package)
+		}
+	}
+	return pkgNames
+}
+
+// readExtraSlices overlays on release the slice definitions under dir.
+// Slices of packages already in the release are added to them, replacing
+// any slice of the same name, and their definitions must agree with the
+// release on the archive and deprecation of those packages.
+func readExtraSlices(release *Release, dir string) error {
+	logf("Processing extra slices in %s...", dir)
+	dir = filepath.Clean(dir)
+	parts := make(map[string][]*Package)
+	err := readSlices(release, filepath.Dir(dir), dir, parts)
+	if err != nil {
+		return err
+	}
+	extra := &Release{Packages: make(map[string]*Package)}
+	err = mergePackages(extra, parts)
+	if err != nil {
+		return err
+	}
+
+	pkgNames := make([]string, 0, len(extra.Packages))
+	for pkgName := range extra.Packages {
+		pkgNames = append(pkgNames, pkgName)
+	}
+	sort.Strings(pkgNames)
+
+	for _, pkgName := range pkgNames {
+		extraPkg := extra.Packages[pkgName]
+		pkg, ok := release.Packages[pkgName]
+		if !ok {
+			release.Packages[pkgName] = extraPkg
+			continue
+		}
+		if extraPkg.Archive != pkg.Archive {
+			return fmt.Errorf("package %q has different archives in %s and %s", pkgName, pkg.Path, extraPkg.Path)
+		}
+		pkg.ExplicitArchive = pkg.ExplicitArchive || extraPkg.ExplicitArchive
+		if extraPkg.Deprecated != "" && extraPkg.Deprecated != pkg.Deprecated {
+			return fmt.Errorf("package %q is deprecated differently in %s and %s", pkgName, pkg.Path, extraPkg.Path)
+		}
+		for sliceName, slice := range extraPkg.Slices {
+			if _, ok := pkg.Slices[sliceName]; ok {
+				logf("Slice %s from %s replaces the one defined before", slice, extraPkg.Path)
+			}
+			if slice.Deprecated == "" {
+				slice.Deprecated = pkg.Deprecated
+			}
+			pkg.Slices[sliceName] = slice
 		}
+	}
+	return nil
+}
 
-		release.Packages[pkg.Name] = pkg
+// mergePackages adds to release the packages in parts, merging the slices
+// of packages defined across several files. Those files must agree on the
+// archive of the package, and on its deprecation if stated by more than one
+// of them, which then applies to all slices without a deprecation of their
+// own.
+func mergePackages(release *Release, parts map[string][]*Package) error {
+	pkgNames := make([]string, 0, len(parts))
+	for pkgName := range parts {
+		pkgNames = append(pkgNames, pkgName)
+	}
+	sort.Strings(pkgNames)
+
+	for _, pkgName := range pkgNames {
+		pkg := parts[pkgName][0]
+		slicePaths := make(map[string]string)
+		for sliceName := range pkg.Slices {
+			slicePaths[sliceName] = pkg.Path
+		}
+		for _, part := range parts[pkgName][1:] {
+			if part.Archive != pkg.Archive {
+				return fmt.Errorf("package %q has different archives in %s and %s", pkgName, pkg.Path, part.Path)
+			}
+			pkg.ExplicitArchive = pkg.ExplicitArchive || part.ExplicitArchive
+			if part.Deprecated != "" {
+				if pkg.Deprecated != "" && pkg.Deprecated != part.Deprecated {
+					return fmt.Errorf("package %q is deprecated differently in %s and %s", pkgName, pkg.Path, part.Path)
+				}
+				pkg.Deprecated = part.Deprecated
+			}
+			for sliceName, slice := range part.Slices {
+				if slicePath, ok := slicePaths[sliceName]; ok {
+					return fmt.Errorf("slice %s defined more than once: %s and %s", slice, slicePath, part.Path)
+				}
+				slicePaths[sliceName] = part.Path
+				pkg.Slices[sliceName] = slice
+			}
+		}
+		for _, slice := range pkg.Slices {
+			if slice.Deprecated == "" {
+				slice.Deprecated = pkg.Deprecated
+			}
+		}
+		release.Packages[pkgName] = pkg
 	}
 	return nil
 }
@@ -362,8 +1109,16 @@ type yamlRelease struct {
 	Format   string                 `yaml:"format"`
 	Archives map[string]yamlArchive `yaml:"archives"`
 	PubKeys  map[string]yamlPubKey  `yaml:"public-keys"`
+	// RequireCopyright enforces that every slice includes the copyright
+	// file of its package.
+	RequireCopyright bool `yaml:"require-copyright"`
 	// V1PubKeys is used for compatibility with format "chisel-v1".
 	V1PubKeys map[string]yamlPubKey `yaml:"v1-public-keys"`
+	Sets      map[string][]string   `yaml:"sets"`
+}
+
+type yamlSets struct {
+	Sets map[string][]string `yaml:"sets"`
 }
 
 type yamlArchive struct {
@@ -373,7 +1128,9 @@ type yamlArchive struct {
 	Default    bool     `yaml:"default"`
 	PubKeys    []string `yaml:"public-keys"`
 	// V1PubKeys is used for compatibility with format "chisel-v1".
-	V1PubKeys []string `yaml:"v1-public-keys"`
+	V1PubKeys   []string `yaml:"v1-public-keys"`
+	SuitePolicy string   `yaml:"suite-policy"`
+	CACert      string   `yaml:"ca-cert"`
 }
 
 type yamlPackage struct {
@@ -381,6 +1138,9 @@ type yamlPackage struct {
 	Archive   string               `yaml:"archive"`
 	Essential []string             `yaml:"essential"`
 	Slices    map[string]yamlSlice `yaml:"slices"`
+	// Deprecated holds the message shown when slices of the package are
+	// selected.
+	Deprecated string `yaml:"deprecated"`
 }
 
 type yamlPath struct {
@@ -391,9 +1151,10 @@ type yamlPath struct {
 	Symlink string  `yaml:"symlink"`
 	Mutable bool    `yaml:"mutable"`
 
-	Until    PathUntil    `yaml:"until"`
-	Arch     yamlArch     `yaml:"arch"`
-	Generate GenerateKind `yaml:"generate"`
+	Until    PathUntil      `yaml:"until"`
+	Arch     yamlArch       `yaml:"arch"`
+	Generate GenerateKind   `yaml:"generate"`
+	Detail   ManifestDetail `yaml:"detail"`
 }
 
 // SameContent returns whether the path has the same content properties as some
@@ -431,6 +1192,13 @@ type yamlSlice struct {
 	Essential []string             `yaml:"essential"`
 	Contents  map[string]*yamlPath `yaml:"contents"`
 	Mutate    string               `yaml:"mutate"`
+	// MutateAfter lists the slices whose mutation scripts must run first.
+	MutateAfter []string `yaml:"mutate-after"`
+
+	Summary     string `yaml:"summary"`
+	Description string `yaml:"description"`
+	// Deprecated holds the message shown when the slice is selected.
+	Deprecated string `yaml:"deprecated"`
 }
 
 type yamlPubKey struct {
@@ -445,6 +1213,26 @@ var ubuntuAdjectives = map[string]string{
 	"22.10": "kinetic",
 }
 
+// yamlReleaseHeader holds the fields of chisel.yaml which are read before
+// its format is known, and which must therefore be kept by every format.
+type yamlReleaseHeader struct {
+	Format string `yaml:"format"`
+	// MinChiselVersion is the earliest chisel version supporting the
+	// format, reported to users of older versions.
+	MinChiselVersion string `yaml:"min-chisel-version"`
+}
+
+// releaseParsers holds the parser of chisel.yaml for every supported format.
+var releaseParsers = map[string]func(release *Release, fileName string, data []byte) error{
+	"chisel-v1": parseReleaseV1,
+	"v1":        parseReleaseV1,
+}
+
+var formatExp = regexp.MustCompile(`^v([0-9]+)$`)
+
+// latestFormat is the number of the newest "v<number>" format supported.
+const latestFormat = 1
+
 func parseRelease(baseDir, filePath string, data []byte) (*Release, error) {
 	release := &Release{
 		Path:     baseDir,
@@ -454,15 +1242,45 @@ func parseRelease(baseDir, filePath string, data []byte) (*Release, error) {
 
 	fileName := stripBase(baseDir, filePath)
 
-	yamlVar := yamlRelease{}
+	header := yamlReleaseHeader{}
 	dec := yaml.NewDecoder(bytes.NewBuffer(data))
 	dec.KnownFields(false)
-	err := dec.Decode(&yamlVar)
+	err := dec.Decode(&header)
 	if err != nil {
 		return nil, fmt.Errorf("%s: cannot parse release definition: %v", fileName, err)
 	}
-	if yamlVar.Format != "chisel-v1" && yamlVar.Format != "v1" {
-		return nil, fmt.Errorf("%s: unknown format %q", fileName, yamlVar.Format)
+	parser, ok := releaseParsers[header.Format]
+	if !ok {
+		if match := formatExp.FindStringSubmatch(header.Format); match != nil && isNewerFormat(match[1]) {
+			upgrade := "please upgrade chisel"
+			if header.MinChiselVersion != "" {
+				upgrade += " to " + header.MinChiselVersion + " or later"
+			}
+			return nil, fmt.Errorf("%s: format %q is not supported by this version of chisel, %s", fileName, header.Format, upgrade)
+		}
+		return nil, fmt.Errorf("%s: unknown format %q", fileName, header.Format)
+	}
+	err = parser(release, fileName, data)
+	if err != nil {
+		return nil, err
+	}
+	return release, nil
+}
+
+func isNewerFormat(number string) bool {
+	n, err := strconv.Atoi(number)
+	return err == nil && n > latestFormat
+}
+
+// parseReleaseV1 parses into release the chisel.yaml data of formats
+// "chisel-v1" and "v1", which only differ on the name of public key fields.
+func parseReleaseV1(release *Release, fileName string, data []byte) error {
+	yamlVar := yamlRelease{}
+	dec := yaml.NewDecoder(bytes.NewBuffer(data))
+	dec.KnownFields(false)
+	err := dec.Decode(&yamlVar)
+	if err != nil {
+		return fmt.Errorf("%s: cannot parse release definition: %v", fileName, err)
 	}
 	// If format is "chisel-v1" we have to translate from the yaml key "v1-public-keys" to
 	// "public-keys".
@@ -474,7 +1292,12 @@ func parseRelease(baseDir, filePath string, data []byte) (*Release, error) {
 		}
 	}
 	if len(yamlVar.Archives) == 0 {
-		return nil, fmt.Errorf("%s: no archives defined", fileName)
+		return fmt.Errorf("%s: no archives defined", fileName)
+	}
+	release.RequireCopyright = yamlVar.RequireCopyright
+	err = addSets(release, fileName, yamlVar.Sets)
+	if err != nil {
+		return err
 	}
 
 	// Decode the public keys and match against provided IDs.
@@ -482,61 +1305,75 @@ func parseRelease(baseDir, filePath string, data []byte) (*Release, error) {
 	for keyName, yamlPubKey := range yamlVar.PubKeys {
 		key, err := pgputil.DecodePubKey([]byte(yamlPubKey.Armor))
 		if err != nil {
-			return nil, fmt.Errorf("%s: cannot decode public key %q: %w", fileName, keyName, err)
+			return fmt.Errorf("%s: cannot decode public key %q: %w", fileName, keyName, err)
 		}
 		if yamlPubKey.ID != key.KeyIdString() {
-			return nil, fmt.Errorf("%s: public key %q armor has incorrect ID: expected %q, got %q", fileName, keyName, yamlPubKey.ID, key.KeyIdString())
+			return fmt.Errorf("%s: public key %q armor has incorrect ID: expected %q, got %q", fileName, keyName, yamlPubKey.ID, key.KeyIdString())
 		}
 		pubKeys[keyName] = key
 	}
 
 	for archiveName, details := range yamlVar.Archives {
 		if details.Version == "" {
-			return nil, fmt.Errorf("%s: archive %q missing version field", fileName, archiveName)
+			return fmt.Errorf("%s: archive %q missing version field", fileName, archiveName)
 		}
 		if len(details.Suites) == 0 {
 			adjective := ubuntuAdjectives[details.Version]
 			if adjective == "" {
-				return nil, fmt.Errorf("%s: archive %q missing suites field", fileName, archiveName)
+				return fmt.Errorf("%s: archive %q missing suites field", fileName, archiveName)
 			}
 			details.Suites = []string{adjective}
 		}
 		if len(details.Components) == 0 {
-			return nil, fmt.Errorf("%s: archive %q missing components field", fileName, archiveName)
+			return fmt.Errorf("%s: archive %q missing components field", fileName, archiveName)
+		}
+		switch details.SuitePolicy {
+		case "", "highest-version", "first-match":
+		default:
+			return fmt.Errorf("%s: archive %q has invalid suite-policy %q", fileName, archiveName, details.SuitePolicy)
+		}
+		var caCerts []byte
+		if details.CACert != "" {
+			caCerts = []byte(details.CACert)
+			if !x509.NewCertPool().AppendCertsFromPEM(caCerts) {
+				return fmt.Errorf("%s: archive %q has no valid certificate in ca-cert", fileName, archiveName)
+			}
 		}
 		if len(yamlVar.Archives) == 1 {
 			details.Default = true
 		} else if details.Default && release.DefaultArchive != "" {
-			return nil, fmt.Errorf("%s: more than one default archive: %s, %s", fileName, release.DefaultArchive, archiveName)
+			return fmt.Errorf("%s: more than one default archive: %s, %s", fileName, release.DefaultArchive, archiveName)
 		}
 		if details.Default {
 			release.DefaultArchive = archiveName
 		}
 		if len(details.PubKeys) == 0 {
 			if yamlVar.Format == "chisel-v1" {
-				return nil, fmt.Errorf("%s: archive %q missing v1-public-keys field", fileName, archiveName)
+				return fmt.Errorf("%s: archive %q missing v1-public-keys field", fileName, archiveName)
 			} else {
-				return nil, fmt.Errorf("%s: archive %q missing public-keys field", fileName, archiveName)
+				return fmt.Errorf("%s: archive %q missing public-keys field", fileName, archiveName)
 			}
 		}
 		var archiveKeys []*packet.PublicKey
 		for _, keyName := range details.PubKeys {
 			key, ok := pubKeys[keyName]
 			if !ok {
-				return nil, fmt.Errorf("%s: archive %q refers to undefined public key %q", fileName, archiveName, keyName)
+				return fmt.Errorf("%s: archive %q refers to undefined public key %q", fileName, archiveName, keyName)
 			}
 			archiveKeys = append(archiveKeys, key)
 		}
 		release.Archives[archiveName] = &Archive{
-			Name:       archiveName,
-			Version:    details.Version,
-			Suites:     details.Suites,
-			Components: details.Components,
-			PubKeys:    archiveKeys,
+			Name:        archiveName,
+			Version:     details.Version,
+			Suites:      details.Suites,
+			Components:  details.Components,
+			PubKeys:     archiveKeys,
+			SuitePolicy: details.SuitePolicy,
+			CACerts:     caCerts,
 		}
 	}
 
-	return release, err
+	return nil
 }
 
 func parsePackage(baseDir, pkgName, pkgPath string, data []byte) (*Package, error) {
@@ -553,10 +1390,14 @@ func parsePackage(baseDir, pkgName, pkgPath string, data []byte) (*Package, erro
 	if err != nil {
 		return nil, fmt.Errorf("cannot parse package %q slice definitions: %v", pkgName, err)
 	}
-	if yamlPkg.Name != pkg.Name {
+	if yamlPkg.Name != pkgName {
 		return nil, fmt.Errorf("%s: filename and 'package' field (%q) disagree", pkgPath, yamlPkg.Name)
 	}
+	pkgName = yamlPkg.Name
+	pkg.Name = pkgName
 	pkg.Archive = yamlPkg.Archive
+	pkg.ExplicitArchive = yamlPkg.Archive != ""
+	pkg.Deprecated = yamlPkg.Deprecated
 
 	zeroPath := yamlPath{}
 	for sliceName, yamlSlice := range yamlPkg.Slices {
@@ -571,6 +1412,12 @@ func parsePackage(baseDir, pkgName, pkgPath string, data []byte) (*Package, erro
 			Scripts: SliceScripts{
 				Mutate: yamlSlice.Mutate,
 			},
+			Summary:     strings.TrimSpace(yamlSlice.Summary),
+			Description: yamlSlice.Description,
+			Deprecated:  yamlSlice.Deprecated,
+		}
+		if strings.Contains(slice.Summary, "\n") {
+			return nil, fmt.Errorf("%s: slice %s has a summary with more than one line", pkgPath, slice)
 		}
 		for _, refName := range yamlPkg.Essential {
 			sliceKey, err := ParseSliceKey(refName)
@@ -599,6 +1446,19 @@ func parsePackage(baseDir, pkgName, pkgPath string, data []byte) (*Package, erro
 			}
 			slice.Essential = append(slice.Essential, sliceKey)
 		}
+		for _, refName := range yamlSlice.MutateAfter {
+			sliceKey, err := ParseSliceKey(refName)
+			if err != nil {
+				return nil, fmt.Errorf("package %q has invalid mutate-after slice reference: %q", pkgName, refName)
+			}
+			if sliceKey.Package == slice.Package && sliceKey.Slice == slice.Name {
+				return nil, fmt.Errorf("cannot mutate slice after itself %q in %s", refName, pkgPath)
+			}
+			if slices.Contains(slice.Scripts.MutateAfter, sliceKey) {
+				return nil, fmt.Errorf("slice %s defined with redundant mutate-after slice: %s", slice, refName)
+			}
+			slice.Scripts.MutateAfter = append(slice.Scripts.MutateAfter, sliceKey)
+		}
 
 		if len(yamlSlice.Contents) > 0 {
 			slice.Contents = make(map[string]PathInfo, len(yamlSlice.Contents))
@@ -619,6 +1479,7 @@ func parsePackage(baseDir, pkgName, pkgPath string, data []byte) (*Package, erro
 			var until PathUntil
 			var arch []string
 			var generate GenerateKind
+			var detail ManifestDetail
 			if yamlPath != nil && yamlPath.Generate != "" {
 				zeroPathGenerate := zeroPath
 				zeroPathGenerate.Generate = yamlPath.Generate
@@ -643,6 +1504,15 @@ func parsePackage(baseDir, pkgName, pkgPath string, data []byte) (*Package, erro
 				mode = yamlPath.Mode
 				mutable = yamlPath.Mutable
 				generate = yamlPath.Generate
+				detail = yamlPath.Detail
+				switch detail {
+				case DetailFull, DetailPathsOnly:
+				default:
+					return nil, fmt.Errorf("slice %s_%s has invalid 'detail' for path %s: %q", pkgName, sliceName, contPath, detail)
+				}
+				if detail != DetailFull && generate != GenerateManifest {
+					return nil, fmt.Errorf("slice %s_%s path %s has 'detail' without 'generate: manifest'", pkgName, sliceName, contPath)
+				}
 				if yamlPath.Dir {
 					if !strings.HasSuffix(contPath, "/") {
 						return nil, fmt.Errorf("slice %s_%s path %s must end in / for 'make' to be valid",
@@ -699,6 +1569,7 @@ func parsePackage(baseDir, pkgName, pkgPath string, data []byte) (*Package, erro
 				Until:    until,
 				Arch:     arch,
 				Generate: generate,
+				Detail:   detail,
 			}
 		}
 
@@ -740,9 +1611,16 @@ func Select(release *Release, slices []SliceKey) (*Selection, error) {
 	if err != nil {
 		return nil, err
 	}
+	requested := make(map[SliceKey]bool, len(slices))
+	for _, key := range slices {
+		requested[key] = true
+	}
 	selection.Slices = make([]*Slice, len(sorted))
 	for i, key := range sorted {
 		selection.Slices[i] = release.Packages[key.Package].Slices[key.Slice]
+		if !requested[key] {
+			debugf("Selecting %s as an essential slice", key)
+		}
 	}
 
 	paths := make(map[string]*Slice)
@@ -751,24 +1629,83 @@ func Select(release *Release, slices []SliceKey) (*Selection, error) {
 			if old, ok := paths[newPath]; ok {
 				oldInfo := old.Contents[newPath]
 				if !newInfo.SameContent(&oldInfo) || (newInfo.Kind == CopyPath || newInfo.Kind == GlobPath) && new.Package != old.Package {
+					first, second := old, new
 					if old.Package > new.Package || old.Package == new.Package && old.Name > new.Name {
-						old, new = new, old
+						first, second = new, old
+					}
+					err := newConflictError(first, second, newPath, newPath)
+					if !release.tolerates(err, newInfo.SameContent(&oldInfo)) {
+						return nil, err
 					}
-					return nil, fmt.Errorf("slices %s and %s conflict on %s", old, new, newPath)
 				}
 			} else {
 				paths[newPath] = new
 			}
 			// An invalid "generate" value should only throw an error if that
 			// particular slice is selected. Hence, the check is here.
-			switch newInfo.Generate {
-			case GenerateNone, GenerateManifest:
-			default:
+			if newInfo.Generate != GenerateNone && !generateKinds[newInfo.Generate] {
 				return nil, fmt.Errorf("slice %s has invalid 'generate' for path %s: %q, consider an update if available",
 					new, newPath, newInfo.Generate)
 			}
 		}
 	}
 
+	for _, slice := range selection.Slices {
+		if slice.Deprecated != "" {
+			logf("Warning: slice %s is deprecated: %s", slice, slice.Deprecated)
+		}
+		release.warnRedundantEssentials(slice)
+	}
+
 	return selection, nil
 }
+
+// warnRedundantEssentials logs a warning for every essential slice of slice
+// which is already implied by another of its essential slices, or which
+// contributes nothing that slice does not already provide itself.
+func (r *Release) warnRedundantEssentials(slice *Slice) {
+	for _, key := range slice.Essential {
+		for _, other := range slice.Essential {
+			if other != key && r.impliesEssential(other, key) {
+				logf("Warning: slice %s lists %s as essential, which is already implied by %s", slice, key, other)
+				break
+			}
+		}
+		essential := r.Packages[key.Package].Slices[key.Slice]
+		if len(essential.Contents) == 0 || len(essential.Essential) > 0 || essential.Scripts.Mutate != "" {
+			continue
+		}
+		shadowed := true
+		for path, info := range essential.Contents {
+			own, ok := slice.Contents[path]
+			if !ok || !own.SameContent(&info) {
+				shadowed = false
+				break
+			}
+		}
+		if shadowed {
+			logf("Warning: slice %s lists %s as essential, but already provides all of its contents", slice, key)
+		}
+	}
+}
+
+// impliesEssential returns whether the slice identified by from has target
+// among its essential slices, directly or transitively.
+func (r *Release) impliesEssential(from, target SliceKey) bool {
+	seen := make(map[SliceKey]bool)
+	queue := []SliceKey{from}
+	for len(queue) > 0 {
+		key := queue[0]
+		queue = queue[1:]
+		for _, next := range r.Packages[key.Package].Slices[key.Slice].Essential {
+			if next == target {
+				return true
+			}
+			if !seen[next] {
+				seen[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+	return false
+}
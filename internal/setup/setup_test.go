@@ -1,9 +1,12 @@
 package setup_test
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"testing"
 
 	"golang.org/x/crypto/openpgp/packet"
 	. "gopkg.in/check.v1"
@@ -25,6 +28,9 @@ type setupTest struct {
 	selslices []setup.SliceKey
 	selection *setup.Selection
 	selerror  string
+
+	conflictPolicy setup.ConflictPolicy
+	extraSlices    []string
 }
 
 var setupTests = []setupTest{{
@@ -35,6 +41,34 @@ var setupTests = []setupTest{{
 		`,
 	},
 	relerror: `chisel.yaml: unknown format "foobar"`,
+}, {
+	summary: "Newer formats require upgrading",
+	input: map[string]string{
+		"chisel.yaml": `
+			format: v2
+			archives:
+				ubuntu:
+					layout: unknown
+		`,
+	},
+	relerror: `chisel.yaml: format "v2" is not supported by this version of chisel, please upgrade chisel`,
+}, {
+	summary: "Newer formats may state the chisel version supporting them",
+	input: map[string]string{
+		"chisel.yaml": `
+			format: v3
+			min-chisel-version: v1.4.0
+		`,
+	},
+	relerror: `chisel.yaml: format "v3" is not supported by this version of chisel, please upgrade chisel to v1.4.0 or later`,
+}, {
+	summary: "Older formats are unknown",
+	input: map[string]string{
+		"chisel.yaml": `
+			format: v0
+		`,
+	},
+	relerror: `chisel.yaml: unknown format "v0"`,
 }, {
 	summary: "Missing archives",
 	input: map[string]string{
@@ -167,14 +201,18 @@ var setupTests = []setupTest{{
 		},
 	},
 }, {
-	summary: "Empty contents",
+	summary: "Slice summary and description",
 	input: map[string]string{
 		"slices/mydir/mypkg.yaml": `
 			package: mypkg
 			slices:
-				myslice1:
-				myslice2:
-					contents:
+				bins:
+					summary: >
+						The mypkg executables
+					description: |
+						Includes mybin and its helpers.
+						Configuration is in mypkg_config.
+				config:
 		`,
 	},
 	release: &setup.Release{
@@ -195,169 +233,678 @@ var setupTests = []setupTest{{
 				Name:    "mypkg",
 				Path:    "slices/mydir/mypkg.yaml",
 				Slices: map[string]*setup.Slice{
-					"myslice1": {
-						Package: "mypkg",
-						Name:    "myslice1",
+					"bins": {
+						Package:     "mypkg",
+						Name:        "bins",
+						Summary:     "The mypkg executables",
+						Description: "Includes mybin and its helpers.\nConfiguration is in mypkg_config.\n",
 					},
-					"myslice2": {
+					"config": {
 						Package: "mypkg",
-						Name:    "myslice2",
+						Name:    "config",
 					},
 				},
 			},
 		},
 	},
 }, {
-	summary: "Cycles are detected within packages",
+	summary: "Slice summary must be a single line",
 	input: map[string]string{
 		"slices/mydir/mypkg.yaml": `
 			package: mypkg
 			slices:
-				myslice1:
-					essential:
-						- mypkg_myslice2
-				myslice2:
-					essential:
-						- mypkg_myslice3
-				myslice3:
-					essential:
-						- mypkg_myslice1
+				bins:
+					summary: |
+						The mypkg
+						executables
 		`,
 	},
-	relerror: `essential loop detected: mypkg_myslice1, mypkg_myslice2, mypkg_myslice3`,
+	relerror: `slices/mydir/mypkg.yaml: slice mypkg_bins has a summary with more than one line`,
 }, {
-	summary: "Cycles are detected across packages",
+	summary: "Slices of a package split across files",
 	input: map[string]string{
-		"slices/mydir/mypkg1.yaml": `
-			package: mypkg1
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			deprecated: use otherpkg instead
 			slices:
-				myslice:
-					essential:
-						- mypkg2_myslice
+				bins:
+					contents:
+						/usr/bin/foo:
 		`,
-		"slices/mydir/mypkg2.yaml": `
-			package: mypkg2
+		"slices/extra/mypkg.yaml": `
+			package: mypkg
+			essential:
+				- mypkg_bins
 			slices:
-				myslice:
-					essential:
-						- mypkg3_myslice
+				libs:
+					contents:
+						/usr/lib/libfoo.so:
 		`,
-		"slices/mydir/mypkg3.yaml": `
-			package: mypkg3
+		"slices/extra/mypkg_config.yaml": `
+			package: mypkg
 			slices:
-				myslice:
-					essential:
-						- mypkg1_myslice
+				config:
+					deprecated: no longer needed
 		`,
 	},
-	relerror: `essential loop detected: mypkg1_myslice, mypkg2_myslice, mypkg3_myslice`,
+	release: &setup.Release{
+		DefaultArchive: "ubuntu",
+
+		Archives: map[string]*setup.Archive{
+			"ubuntu": {
+				Name:       "ubuntu",
+				Version:    "22.04",
+				Suites:     []string{"jammy"},
+				Components: []string{"main", "universe"},
+				PubKeys:    []*packet.PublicKey{testKey.PubKey},
+			},
+		},
+		Packages: map[string]*setup.Package{
+			"mypkg": {
+				Archive:    "ubuntu",
+				Name:       "mypkg",
+				Path:       "slices/extra/mypkg.yaml",
+				Deprecated: "use otherpkg instead",
+				Slices: map[string]*setup.Slice{
+					"bins": {
+						Package:    "mypkg",
+						Name:       "bins",
+						Deprecated: "use otherpkg instead",
+						Contents: map[string]setup.PathInfo{
+							"/usr/bin/foo": {Kind: "copy"},
+						},
+					},
+					"libs": {
+						Package:    "mypkg",
+						Name:       "libs",
+						Essential:  []setup.SliceKey{{"mypkg", "bins"}},
+						Deprecated: "use otherpkg instead",
+						Contents: map[string]setup.PathInfo{
+							"/usr/lib/libfoo.so": {Kind: "copy"},
+						},
+					},
+					"config": {
+						Package:    "mypkg",
+						Name:       "config",
+						Deprecated: "no longer needed",
+					},
+				},
+			},
+		},
+	},
 }, {
-	summary: "Missing package dependency",
+	summary: "Slices defined in several files of a package",
 	input: map[string]string{
-		"slices/mydir/mypkg1.yaml": `
-			package: mypkg1
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
 			slices:
-				myslice:
-					essential:
-						- mypkg2_myslice
+				bins:
+		`,
+		"slices/other/mypkg_extra.yaml": `
+			package: mypkg
+			slices:
+				bins:
 		`,
 	},
-	relerror: `mypkg1_myslice requires mypkg2_myslice, but slice is missing`,
+	relerror: `slice mypkg_bins defined more than once: slices/mydir/mypkg.yaml and slices/other/mypkg_extra.yaml`,
 }, {
-	summary: "Missing slice dependency",
+	summary: "Files named after another package are not merged",
 	input: map[string]string{
 		"slices/mydir/mypkg.yaml": `
 			package: mypkg
 			slices:
-				myslice1:
-					essential:
-						- mypkg_myslice2
+				bins:
+		`,
+		"slices/mydir/mypkg-dev.yaml": `
+			package: mypkg
+			slices:
+				headers:
 		`,
 	},
-	relerror: `mypkg_myslice1 requires mypkg_myslice2, but slice is missing`,
+	relerror: `slices/mydir/mypkg-dev.yaml: filename and 'package' field \("mypkg"\) disagree`,
 }, {
-	summary: "Selection with no dependencies",
+	summary: "Files of a package must agree on its archive",
 	input: map[string]string{
-		"slices/mydir/mypkg1.yaml": `
-			package: mypkg1
-			slices:
-				myslice1: {}
-				myslice2: {essential: [mypkg2_myslice1]}
+		"chisel.yaml": `
+			format: chisel-v1
+			archives:
+				ubuntu:
+					version: 22.04
+					components: [main]
+					default: true
+					v1-public-keys: [test-key]
+				other:
+					version: 22.04
+					components: [main]
+					v1-public-keys: [test-key]
+			v1-public-keys:
+				test-key:
+					id: ` + testKey.ID + `
+					armor: |` + "\n" + testutil.PrefixEachLine(testKey.PubKeyArmor, "\t\t\t\t\t\t") + `
 		`,
-		"slices/mydir/mypkg2.yaml": `
-			package: mypkg2
-			slices:
-				myslice1: {}
-				myslice2: {essential: [mypkg1_myslice1]}
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			archive: other
+		`,
+		"slices/other/mypkg.yaml": `
+			package: mypkg
 		`,
 	},
-	selslices: []setup.SliceKey{{"mypkg1", "myslice1"}},
-	selection: &setup.Selection{
-		Slices: []*setup.Slice{{
-			Package: "mypkg1",
-			Name:    "myslice1",
-		}},
+	relerror: `package "mypkg" has different archives in slices/mydir/mypkg.yaml and slices/other/mypkg.yaml`,
+}, {
+	summary: "Files of a package must agree on its deprecation",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			deprecated: use foo
+		`,
+		"slices/other/mypkg.yaml": `
+			package: mypkg
+			deprecated: use bar
+		`,
 	},
+	relerror: `package "mypkg" is deprecated differently in slices/mydir/mypkg.yaml and slices/other/mypkg.yaml`,
 }, {
-	summary: "Selection with dependencies",
+	summary: "Extra slices overlay those of the release",
 	input: map[string]string{
-		"slices/mydir/mypkg1.yaml": `
-			package: mypkg1
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			deprecated: use otherpkg instead
 			slices:
-				myslice1: {}
-				myslice2: {essential: [mypkg2_myslice1]}
+				bins:
+					contents:
+						/usr/bin/foo:
+				libs:
+					contents:
+						/usr/lib/libfoo.so:
 		`,
-		"slices/mydir/mypkg2.yaml": `
-			package: mypkg2
+		"overlay1/mypkg.yaml": `
+			package: mypkg
 			slices:
-				myslice1: {}
-				myslice2: {essential: [mypkg1_myslice1]}
+				bins:
+					contents:
+						/usr/bin/bar:
+		`,
+		"overlay2/mypkg.yaml": `
+			package: mypkg
+			slices:
+				bins:
+					contents:
+						/usr/bin/baz:
+		`,
+		"overlay2/otherpkg.yaml": `
+			package: otherpkg
+			slices:
+				config:
+					contents:
+						/etc/other.conf:
 		`,
 	},
-	selslices: []setup.SliceKey{{"mypkg2", "myslice2"}},
-	selection: &setup.Selection{
-		Slices: []*setup.Slice{{
-			Package: "mypkg1",
-			Name:    "myslice1",
-		}, {
-			Package: "mypkg2",
-			Name:    "myslice2",
-			Essential: []setup.SliceKey{
-				{"mypkg1", "myslice1"},
+	extraSlices: []string{"overlay1", "overlay2"},
+	release: &setup.Release{
+		DefaultArchive: "ubuntu",
+
+		Archives: map[string]*setup.Archive{
+			"ubuntu": {
+				Name:       "ubuntu",
+				Version:    "22.04",
+				Suites:     []string{"jammy"},
+				Components: []string{"main", "universe"},
+				PubKeys:    []*packet.PublicKey{testKey.PubKey},
 			},
-		}},
+		},
+		Packages: map[string]*setup.Package{
+			"mypkg": {
+				Archive:    "ubuntu",
+				Name:       "mypkg",
+				Path:       "slices/mydir/mypkg.yaml",
+				Deprecated: "use otherpkg instead",
+				Slices: map[string]*setup.Slice{
+					"bins": {
+						Package:    "mypkg",
+						Name:       "bins",
+						Deprecated: "use otherpkg instead",
+						Contents: map[string]setup.PathInfo{
+							"/usr/bin/baz": {Kind: "copy"},
+						},
+					},
+					"libs": {
+						Package:    "mypkg",
+						Name:       "libs",
+						Deprecated: "use otherpkg instead",
+						Contents: map[string]setup.PathInfo{
+							"/usr/lib/libfoo.so": {Kind: "copy"},
+						},
+					},
+				},
+			},
+			"otherpkg": {
+				Archive: "ubuntu",
+				Name:    "otherpkg",
+				Path:    "overlay2/otherpkg.yaml",
+				Slices: map[string]*setup.Slice{
+					"config": {
+						Package: "otherpkg",
+						Name:    "config",
+						Contents: map[string]setup.PathInfo{
+							"/etc/other.conf": {Kind: "copy"},
+						},
+					},
+				},
+			},
+		},
 	},
 }, {
-	summary: "Selection with matching paths don't conflict",
+	summary: "Extra slices must agree with the release on the package deprecation",
 	input: map[string]string{
-		"slices/mydir/mypkg1.yaml": `
-			package: mypkg1
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			deprecated: use foo
+		`,
+		"overlay/mypkg.yaml": `
+			package: mypkg
+			deprecated: use bar
+		`,
+	},
+	extraSlices: []string{"overlay"},
+	relerror:    `package "mypkg" is deprecated differently in slices/mydir/mypkg.yaml and overlay/mypkg.yaml`,
+}, {
+	summary: "Extra slices are checked for conflicts with the release",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
 			slices:
-				myslice1:
-					contents:
-						/path1:
-						/path2: {text: same}
-						/path3: {symlink: /link}
-				myslice2:
+				bins:
 					contents:
-						/path1: {copy: /path1}
-						/path2: {text: same}
-						/path3: {symlink: /link}
+						/usr/bin/foo:
 		`,
-		"slices/mydir/mypkg2.yaml": `
-			package: mypkg2
+		"overlay/otherpkg.yaml": `
+			package: otherpkg
 			slices:
-				myslice1:
+				bins:
 					contents:
-						/path2: {text: same}
-						/path3: {symlink: /link}
+						/usr/bin/foo:
 		`,
 	},
-	selslices: []setup.SliceKey{{"mypkg1", "myslice1"}, {"mypkg1", "myslice2"}, {"mypkg2", "myslice1"}},
+	extraSlices: []string{"overlay"},
+	relerror:    `slices mypkg_bins and otherpkg_bins conflict on /usr/bin/foo`,
 }, {
-	summary: "Conflicting paths across slices",
+	summary: "Deprecated packages and slices",
 	input: map[string]string{
-		"slices/mydir/mypkg1.yaml": `
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				old:
+					deprecated: use mypkg_new instead
+				new:
+		`,
+		"slices/mydir/otherpkg.yaml": `
+			package: otherpkg
+			deprecated: use mypkg instead
+			slices:
+				bins:
+				libs:
+					deprecated: use mypkg_new instead
+		`,
+	},
+	release: &setup.Release{
+		DefaultArchive: "ubuntu",
+
+		Archives: map[string]*setup.Archive{
+			"ubuntu": {
+				Name:       "ubuntu",
+				Version:    "22.04",
+				Suites:     []string{"jammy"},
+				Components: []string{"main", "universe"},
+				PubKeys:    []*packet.PublicKey{testKey.PubKey},
+			},
+		},
+		Packages: map[string]*setup.Package{
+			"mypkg": {
+				Archive: "ubuntu",
+				Name:    "mypkg",
+				Path:    "slices/mydir/mypkg.yaml",
+				Slices: map[string]*setup.Slice{
+					"old": {
+						Package:    "mypkg",
+						Name:       "old",
+						Deprecated: "use mypkg_new instead",
+					},
+					"new": {
+						Package: "mypkg",
+						Name:    "new",
+					},
+				},
+			},
+			"otherpkg": {
+				Archive:    "ubuntu",
+				Name:       "otherpkg",
+				Path:       "slices/mydir/otherpkg.yaml",
+				Deprecated: "use mypkg instead",
+				Slices: map[string]*setup.Slice{
+					"bins": {
+						Package:    "otherpkg",
+						Name:       "bins",
+						Deprecated: "use mypkg instead",
+					},
+					"libs": {
+						Package:    "otherpkg",
+						Name:       "libs",
+						Deprecated: "use mypkg_new instead",
+					},
+				},
+			},
+		},
+	},
+	selslices: []setup.SliceKey{{"mypkg", "old"}, {"otherpkg", "bins"}},
+}, {
+	summary: "Empty contents",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice1:
+				myslice2:
+					contents:
+		`,
+	},
+	release: &setup.Release{
+		DefaultArchive: "ubuntu",
+
+		Archives: map[string]*setup.Archive{
+			"ubuntu": {
+				Name:       "ubuntu",
+				Version:    "22.04",
+				Suites:     []string{"jammy"},
+				Components: []string{"main", "universe"},
+				PubKeys:    []*packet.PublicKey{testKey.PubKey},
+			},
+		},
+		Packages: map[string]*setup.Package{
+			"mypkg": {
+				Archive: "ubuntu",
+				Name:    "mypkg",
+				Path:    "slices/mydir/mypkg.yaml",
+				Slices: map[string]*setup.Slice{
+					"myslice1": {
+						Package: "mypkg",
+						Name:    "myslice1",
+					},
+					"myslice2": {
+						Package: "mypkg",
+						Name:    "myslice2",
+					},
+				},
+			},
+		},
+	},
+}, {
+	summary: "Cycles are detected within packages",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice1:
+					essential:
+						- mypkg_myslice2
+				myslice2:
+					essential:
+						- mypkg_myslice3
+				myslice3:
+					essential:
+						- mypkg_myslice1
+		`,
+	},
+	relerror: `essential loop detected: mypkg_myslice1, mypkg_myslice2, mypkg_myslice3`,
+}, {
+	summary: "Cycles are detected across packages",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice:
+					essential:
+						- mypkg2_myslice
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice:
+					essential:
+						- mypkg3_myslice
+		`,
+		"slices/mydir/mypkg3.yaml": `
+			package: mypkg3
+			slices:
+				myslice:
+					essential:
+						- mypkg1_myslice
+		`,
+	},
+	relerror: `essential loop detected: mypkg1_myslice, mypkg2_myslice, mypkg3_myslice`,
+}, {
+	summary: "Mutate-after orders selected slices only",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice:
+					mutate-after:
+						- mypkg2_myslice
+					mutate: |
+						content.list("/")
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice:
+				other:
+					mutate-after:
+						- mypkg1_myslice
+		`,
+	},
+	selslices: []setup.SliceKey{{"mypkg1", "myslice"}, {"mypkg2", "myslice"}},
+	selection: &setup.Selection{
+		Slices: []*setup.Slice{{
+			Package: "mypkg2",
+			Name:    "myslice",
+		}, {
+			Package: "mypkg1",
+			Name:    "myslice",
+			Scripts: setup.SliceScripts{
+				Mutate:      "content.list(\"/\")\n",
+				MutateAfter: []setup.SliceKey{{"mypkg2", "myslice"}},
+			},
+		}},
+	},
+}, {
+	summary: "Mutate-after does not select slices",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice:
+					mutate-after:
+						- mypkg2_myslice
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice:
+		`,
+	},
+	selslices: []setup.SliceKey{{"mypkg1", "myslice"}},
+	selection: &setup.Selection{
+		Slices: []*setup.Slice{{
+			Package: "mypkg1",
+			Name:    "myslice",
+			Scripts: setup.SliceScripts{
+				MutateAfter: []setup.SliceKey{{"mypkg2", "myslice"}},
+			},
+		}},
+	},
+}, {
+	summary: "Cycles are detected across essential and mutate-after",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice:
+					essential:
+						- mypkg2_myslice
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice:
+					mutate-after:
+						- mypkg1_myslice
+		`,
+	},
+	relerror: `mutate-after loop detected: mypkg1_myslice, mypkg2_myslice`,
+}, {
+	summary: "Mutate-after slices must exist",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					mutate-after:
+						- mypkg_other
+		`,
+	},
+	relerror: `mypkg_myslice mutates after mypkg_other, but slice is missing`,
+}, {
+	summary: "Cannot mutate slice after itself",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					mutate-after:
+						- mypkg_myslice
+		`,
+	},
+	relerror: `cannot mutate slice after itself "mypkg_myslice" in slices/mydir/mypkg.yaml`,
+}, {
+	summary: "Invalid mutate-after slice reference",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					mutate-after:
+						- mypkg-other
+		`,
+	},
+	relerror: `package "mypkg" has invalid mutate-after slice reference: "mypkg-other"`,
+}, {
+	summary: "Missing package dependency",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice:
+					essential:
+						- mypkg2_myslice
+		`,
+	},
+	relerror: `mypkg1_myslice requires mypkg2_myslice, but slice is missing`,
+}, {
+	summary: "Missing slice dependency",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice1:
+					essential:
+						- mypkg_myslice2
+		`,
+	},
+	relerror: `mypkg_myslice1 requires mypkg_myslice2, but slice is missing`,
+}, {
+	summary: "Selection with no dependencies",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice1: {}
+				myslice2: {essential: [mypkg2_myslice1]}
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice1: {}
+				myslice2: {essential: [mypkg1_myslice1]}
+		`,
+	},
+	selslices: []setup.SliceKey{{"mypkg1", "myslice1"}},
+	selection: &setup.Selection{
+		Slices: []*setup.Slice{{
+			Package: "mypkg1",
+			Name:    "myslice1",
+		}},
+	},
+}, {
+	summary: "Selection with dependencies",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice1: {}
+				myslice2: {essential: [mypkg2_myslice1]}
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice1: {}
+				myslice2: {essential: [mypkg1_myslice1]}
+		`,
+	},
+	selslices: []setup.SliceKey{{"mypkg2", "myslice2"}},
+	selection: &setup.Selection{
+		Slices: []*setup.Slice{{
+			Package: "mypkg1",
+			Name:    "myslice1",
+		}, {
+			Package: "mypkg2",
+			Name:    "myslice2",
+			Essential: []setup.SliceKey{
+				{"mypkg1", "myslice1"},
+			},
+		}},
+	},
+}, {
+	summary: "Selection with matching paths don't conflict",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice1:
+					contents:
+						/path1:
+						/path2: {text: same}
+						/path3: {symlink: /link}
+				myslice2:
+					contents:
+						/path1: {copy: /path1}
+						/path2: {text: same}
+						/path3: {symlink: /link}
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice1:
+					contents:
+						/path2: {text: same}
+						/path3: {symlink: /link}
+		`,
+	},
+	selslices: []setup.SliceKey{{"mypkg1", "myslice1"}, {"mypkg1", "myslice2"}, {"mypkg2", "myslice1"}},
+}, {
+	summary: "Conflicting paths across slices",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
 			package: mypkg1
 			slices:
 				myslice1:
@@ -368,74 +915,276 @@ var setupTests = []setupTest{{
 						/path1: {copy: /other}
 		`,
 	},
-	relerror: "slices mypkg1_myslice1 and mypkg1_myslice2 conflict on /path1",
+	relerror: "slices mypkg1_myslice1 and mypkg1_myslice2 conflict on /path1",
+}, {
+	summary: "Conflicting paths across packages",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice1:
+					contents:
+						/path1:
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice1:
+					contents:
+						/path1:
+		`,
+	},
+	relerror: "slices mypkg1_myslice1 and mypkg2_myslice1 conflict on /path1",
+}, {
+	summary: "Directories must be suffixed with /",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/foo: {make: true}
+		`,
+	},
+	relerror: `slice mypkg_myslice path /foo must end in / for 'make' to be valid`,
+}, {
+	summary: "Slice path must be clean",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/foo/../:
+		`,
+	},
+	relerror: `slice mypkg_myslice has invalid content path: /foo/../`,
+}, {
+	summary: "Slice path must be absolute",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						./foo/:
+		`,
+	},
+	relerror: `slice mypkg_myslice has invalid content path: ./foo/`,
+}, {
+	summary: "Globbing support",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice1:
+					contents:
+						/file/*:
+				myslice2:
+					contents:
+						/another/**:
+		`,
+	},
+	release: &setup.Release{
+		DefaultArchive: "ubuntu",
+
+		Archives: map[string]*setup.Archive{
+			"ubuntu": {
+				Name:       "ubuntu",
+				Version:    "22.04",
+				Suites:     []string{"jammy"},
+				Components: []string{"main", "universe"},
+				PubKeys:    []*packet.PublicKey{testKey.PubKey},
+			},
+		},
+		Packages: map[string]*setup.Package{
+			"mypkg": {
+				Archive: "ubuntu",
+				Name:    "mypkg",
+				Path:    "slices/mydir/mypkg.yaml",
+				Slices: map[string]*setup.Slice{
+					"myslice1": {
+						Package: "mypkg",
+						Name:    "myslice1",
+						Contents: map[string]setup.PathInfo{
+							"/file/*": {Kind: "glob"},
+						},
+					},
+					"myslice2": {
+						Package: "mypkg",
+						Name:    "myslice2",
+						Contents: map[string]setup.PathInfo{
+							"/another/**": {Kind: "glob"},
+						},
+					},
+				},
+			},
+		},
+	},
+}, {
+	summary: "Conflicting globs",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice:
+					contents:
+						/file/f*obar:
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice:
+					contents:
+						/file/foob*r:
+		`,
+	},
+	relerror: `slices mypkg1_myslice and mypkg2_myslice conflict on /file/f\*obar and /file/foob\*r`,
+}, {
+	summary: "Conflicting globs and plain copies",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice:
+					contents:
+						/file/foobar:
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice:
+					contents:
+						/file/foob*r:
+		`,
+	},
+	relerror: `slices mypkg1_myslice and mypkg2_myslice conflict on /file/foobar and /file/foob\*r`,
+}, {
+	summary: "Conflicting globs with wildcards in the first segment",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice:
+					contents:
+						/fi*/foobar:
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice:
+					contents:
+						/file/foob*r:
+		`,
+	},
+	relerror: `slices mypkg1_myslice and mypkg2_myslice conflict on /fi\*/foobar and /file/foob\*r`,
+}, {
+	summary: "Globs with different first segments do not conflict",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice:
+					contents:
+						/file/foob*r:
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice:
+					contents:
+						/other/foob*r:
+		`,
+	},
+}, {
+	summary: "Conflicting matching globs",
+	input: map[string]string{
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice:
+					contents:
+						/file/foob*r:
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice:
+					contents:
+						/file/foob*r:
+		`,
+	},
+	relerror: `slices mypkg1_myslice and mypkg2_myslice conflict on /file/foob\*r`,
 }, {
-	summary: "Conflicting paths across packages",
+	summary:        "Conflicting copies and globs are tolerated with warn policy",
+	conflictPolicy: setup.ConflictWarn,
 	input: map[string]string{
 		"slices/mydir/mypkg1.yaml": `
 			package: mypkg1
 			slices:
-				myslice1:
+				myslice:
 					contents:
-						/path1:
+						/file/foobar:
+						/file/foob*r:
 		`,
 		"slices/mydir/mypkg2.yaml": `
 			package: mypkg2
 			slices:
-				myslice1:
+				myslice:
 					contents:
-						/path1:
+						/file/foobar:
+						/file/f*obar:
 		`,
 	},
-	relerror: "slices mypkg1_myslice1 and mypkg2_myslice1 conflict on /path1",
+	selslices: []setup.SliceKey{{"mypkg1", "myslice"}, {"mypkg2", "myslice"}},
 }, {
-	summary: "Directories must be suffixed with /",
+	summary:        "Conflicting content is not tolerated with warn policy",
+	conflictPolicy: setup.ConflictWarn,
 	input: map[string]string{
-		"slices/mydir/mypkg.yaml": `
-			package: mypkg
+		"slices/mydir/mypkg1.yaml": `
+			package: mypkg1
 			slices:
 				myslice:
 					contents:
-						/foo: {make: true}
+						/file/foobar: {text: data}
 		`,
-	},
-	relerror: `slice mypkg_myslice path /foo must end in / for 'make' to be valid`,
-}, {
-	summary: "Slice path must be clean",
-	input: map[string]string{
-		"slices/mydir/mypkg.yaml": `
-			package: mypkg
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
 			slices:
 				myslice:
 					contents:
-						/foo/../:
+						/file/foob*r:
 		`,
 	},
-	relerror: `slice mypkg_myslice has invalid content path: /foo/../`,
+	relerror: `slices mypkg1_myslice and mypkg2_myslice conflict on /file/foobar and /file/foob\*r`,
 }, {
-	summary: "Slice path must be absolute",
+	summary:        "Conflict policy must be valid",
+	conflictPolicy: "foo",
 	input: map[string]string{
 		"slices/mydir/mypkg.yaml": `
 			package: mypkg
-			slices:
-				myslice:
-					contents:
-						./foo/:
 		`,
 	},
-	relerror: `slice mypkg_myslice has invalid content path: ./foo/`,
+	relerror: `invalid conflict policy "foo"`,
 }, {
-	summary: "Globbing support",
+	summary: "Slice sets in chisel.yaml and sets.yaml",
 	input: map[string]string{
+		"chisel.yaml": defaultChiselYaml + "\tsets:\n\t\truntime: [mypkg_libs, otherpkg_all]\n",
+		"sets.yaml": `
+			sets:
+				tools:
+					- mypkg_bins
+		`,
 		"slices/mydir/mypkg.yaml": `
 			package: mypkg
 			slices:
-				myslice1:
-					contents:
-						/file/*:
-				myslice2:
-					contents:
-						/another/**:
+				bins:
+				libs:
+		`,
+		"slices/mydir/otherpkg.yaml": `
+			package: otherpkg
+			slices:
+				all:
 		`,
 	},
 	release: &setup.Release{
@@ -456,81 +1205,127 @@ var setupTests = []setupTest{{
 				Name:    "mypkg",
 				Path:    "slices/mydir/mypkg.yaml",
 				Slices: map[string]*setup.Slice{
-					"myslice1": {
-						Package: "mypkg",
-						Name:    "myslice1",
-						Contents: map[string]setup.PathInfo{
-							"/file/*": {Kind: "glob"},
-						},
-					},
-					"myslice2": {
-						Package: "mypkg",
-						Name:    "myslice2",
-						Contents: map[string]setup.PathInfo{
-							"/another/**": {Kind: "glob"},
-						},
-					},
+					"bins": {Package: "mypkg", Name: "bins"},
+					"libs": {Package: "mypkg", Name: "libs"},
+				},
+			},
+			"otherpkg": {
+				Archive: "ubuntu",
+				Name:    "otherpkg",
+				Path:    "slices/mydir/otherpkg.yaml",
+				Slices: map[string]*setup.Slice{
+					"all": {Package: "otherpkg", Name: "all"},
 				},
 			},
 		},
+		Sets: map[string][]setup.SliceKey{
+			"runtime": {{"mypkg", "libs"}, {"otherpkg", "all"}},
+			"tools":   {{"mypkg", "bins"}},
+		},
 	},
 }, {
-	summary: "Conflicting globs",
+	summary: "Slice sets are only defined once",
 	input: map[string]string{
-		"slices/mydir/mypkg1.yaml": `
-			package: mypkg1
+		"chisel.yaml": defaultChiselYaml + "\tsets:\n\t\ttools: [mypkg_bins]\n",
+		"sets.yaml": `
+			sets:
+				tools: [mypkg_bins]
+		`,
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
 			slices:
-				myslice:
-					contents:
-						/file/f*obar:
+				bins:
 		`,
-		"slices/mydir/mypkg2.yaml": `
-			package: mypkg2
+	},
+	relerror: `sets.yaml: set "tools" defined more than once`,
+}, {
+	summary: "Slice sets must refer to valid slices",
+	input: map[string]string{
+		"chisel.yaml": defaultChiselYaml + "\tsets:\n\t\ttools: [mypkg-bins]\n",
+	},
+	relerror: `chisel.yaml: set "tools" has invalid slice reference "mypkg-bins"`,
+}, {
+	summary: "Slice sets must refer to existing slices",
+	input: map[string]string{
+		"chisel.yaml": defaultChiselYaml + "\tsets:\n\t\ttools: [mypkg_bins, mypkg_other]\n",
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
 			slices:
-				myslice:
-					contents:
-						/file/foob*r:
+				bins:
 		`,
 	},
-	relerror: `slices mypkg1_myslice and mypkg2_myslice conflict on /file/f\*obar and /file/foob\*r`,
+	relerror: `set "tools" refers to undefined slice mypkg_other`,
 }, {
-	summary: "Conflicting globs and plain copies",
+	summary: "Slice set names are validated",
 	input: map[string]string{
-		"slices/mydir/mypkg1.yaml": `
-			package: mypkg1
+		"chisel.yaml": defaultChiselYaml + "\tsets:\n\t\tMy_Set: [mypkg_bins]\n",
+	},
+	relerror: `chisel.yaml: invalid set name "My_Set"`,
+}, {
+	summary: "Copyright is required via essential slices",
+	input: map[string]string{
+		"chisel.yaml": defaultChiselYaml + "\trequire-copyright: true\n",
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			essential:
+				- mypkg_copyright
 			slices:
-				myslice:
+				bins:
 					contents:
-						/file/foobar:
+						/usr/bin/foo:
+				libs:
+					essential:
+						- mypkg_bins
+					contents:
+						/usr/lib/libfoo.so:
+				copyright:
+					contents:
+						/usr/share/doc/mypkg/copyright:
 		`,
-		"slices/mydir/mypkg2.yaml": `
-			package: mypkg2
+		"slices/mydir/otherpkg.yaml": `
+			package: otherpkg
 			slices:
-				myslice:
+				all:
 					contents:
-						/file/foob*r:
+						/usr/share/doc/otherpkg/**:
+						/usr/bin/other:
 		`,
 	},
-	relerror: `slices mypkg1_myslice and mypkg2_myslice conflict on /file/foobar and /file/foob\*r`,
+	selslices: []setup.SliceKey{{"mypkg", "libs"}},
 }, {
-	summary: "Conflicting matching globs",
+	summary: "Missing copyright is reported",
 	input: map[string]string{
-		"slices/mydir/mypkg1.yaml": `
-			package: mypkg1
+		"chisel.yaml": defaultChiselYaml + "\trequire-copyright: true\n",
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
 			slices:
-				myslice:
+				bins:
+					essential:
+						- otherpkg_copyright
 					contents:
-						/file/foob*r:
+						/usr/bin/foo:
 		`,
-		"slices/mydir/mypkg2.yaml": `
-			package: mypkg2
+		"slices/mydir/otherpkg.yaml": `
+			package: otherpkg
 			slices:
-				myslice:
+				copyright:
 					contents:
-						/file/foob*r:
+						/usr/share/doc/otherpkg/copyright:
 		`,
 	},
-	relerror: `slices mypkg1_myslice and mypkg2_myslice conflict on /file/foob\*r`,
+	relerror: `slice mypkg_bins does not include /usr/share/doc/mypkg/copyright directly or via essential slices`,
+}, {
+	summary: "Copyright is not required by default",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				bins:
+					contents:
+						/usr/bin/foo:
+		`,
+	},
+	selslices: []setup.SliceKey{{"mypkg", "bins"}},
 }, {
 	summary: "Conflicting globs in same package is okay",
 	input: map[string]string{
@@ -764,6 +1559,91 @@ var setupTests = []setupTest{{
 			},
 		},
 	},
+}, {
+	summary: "Archive suite policy",
+	input: map[string]string{
+		"chisel.yaml": `
+			format: chisel-v1
+			archives:
+				ubuntu:
+					version: 22.04
+					components: [main]
+					suites: [jammy-security, jammy-updates, jammy]
+					suite-policy: first-match
+					v1-public-keys: [test-key]
+			v1-public-keys:
+				test-key:
+					id: ` + testKey.ID + `
+					armor: |` + "\n" + testutil.PrefixEachLine(testKey.PubKeyArmor, "\t\t\t\t\t\t") + `
+		`,
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+		`,
+	},
+	release: &setup.Release{
+		DefaultArchive: "ubuntu",
+
+		Archives: map[string]*setup.Archive{
+			"ubuntu": {
+				Name:        "ubuntu",
+				Version:     "22.04",
+				Suites:      []string{"jammy-security", "jammy-updates", "jammy"},
+				Components:  []string{"main"},
+				PubKeys:     []*packet.PublicKey{testKey.PubKey},
+				SuitePolicy: "first-match",
+			},
+		},
+		Packages: map[string]*setup.Package{
+			"mypkg": {
+				Archive: "ubuntu",
+				Name:    "mypkg",
+				Path:    "slices/mydir/mypkg.yaml",
+				Slices:  map[string]*setup.Slice{},
+			},
+		},
+	},
+}, {
+	summary: "Invalid archive suite policy",
+	input: map[string]string{
+		"chisel.yaml": strings.Replace(defaultChiselYaml, "components: [main, universe]", "components: [main, universe]\n\t\t\tsuite-policy: newest", 1),
+	},
+	relerror: `chisel.yaml: archive "ubuntu" has invalid suite-policy "newest"`,
+}, {
+	summary: "Archive CA certificate",
+	input: map[string]string{
+		"chisel.yaml": strings.Replace(defaultChiselYaml, "components: [main, universe]", "components: [main, universe]\n\t\t\tca-cert: |\n"+testutil.PrefixEachLine(testCACert, "\t\t\t\t"), 1),
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+		`,
+	},
+	release: &setup.Release{
+		DefaultArchive: "ubuntu",
+
+		Archives: map[string]*setup.Archive{
+			"ubuntu": {
+				Name:       "ubuntu",
+				Version:    "22.04",
+				Suites:     []string{"jammy"},
+				Components: []string{"main", "universe"},
+				PubKeys:    []*packet.PublicKey{testKey.PubKey},
+				CACerts:    []byte(testCACert),
+			},
+		},
+		Packages: map[string]*setup.Package{
+			"mypkg": {
+				Archive: "ubuntu",
+				Name:    "mypkg",
+				Path:    "slices/mydir/mypkg.yaml",
+				Slices:  map[string]*setup.Slice{},
+			},
+		},
+	},
+}, {
+	summary: "Invalid archive CA certificate",
+	input: map[string]string{
+		"chisel.yaml": strings.Replace(defaultChiselYaml, "components: [main, universe]", "components: [main, universe]\n\t\t\tca-cert: invalid", 1),
+	},
+	relerror: `chisel.yaml: archive "ubuntu" has no valid certificate in ca-cert`,
 }, {
 	summary: "Multiple archives",
 	input: map[string]string{
@@ -804,7 +1684,65 @@ var setupTests = []setupTest{{
 			"bar": {
 				Name:       "bar",
 				Version:    "22.04",
-				Suites:     []string{"jammy-updates"},
+				Suites:     []string{"jammy-updates"},
+				Components: []string{"universe"},
+				PubKeys:    []*packet.PublicKey{testKey.PubKey},
+			},
+		},
+		Packages: map[string]*setup.Package{
+			"mypkg": {
+				Archive: "foo",
+				Name:    "mypkg",
+				Path:    "slices/mydir/mypkg.yaml",
+				Slices:  map[string]*setup.Slice{},
+			},
+		},
+	},
+}, {
+	summary: "Non-default archives are only used by packages selecting them",
+	input: map[string]string{
+		"chisel.yaml": `
+			format: chisel-v1
+			archives:
+				foo:
+					version: 22.04
+					components: [main, universe]
+					suites: [jammy]
+					default: true
+					v1-public-keys: [test-key]
+				bar:
+					version: 22.04
+					components: [universe]
+					suites: [jammy-backports]
+					v1-public-keys: [test-key]
+			v1-public-keys:
+				test-key:
+					id: ` + testKey.ID + `
+					armor: |` + "\n" + testutil.PrefixEachLine(testKey.PubKeyArmor, "\t\t\t\t\t\t") + `
+		`,
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+		`,
+		"slices/mydir/otherpkg.yaml": `
+			package: otherpkg
+			archive: bar
+		`,
+	},
+	release: &setup.Release{
+		DefaultArchive: "foo",
+
+		Archives: map[string]*setup.Archive{
+			"foo": {
+				Name:       "foo",
+				Version:    "22.04",
+				Suites:     []string{"jammy"},
+				Components: []string{"main", "universe"},
+				PubKeys:    []*packet.PublicKey{testKey.PubKey},
+			},
+			"bar": {
+				Name:       "bar",
+				Version:    "22.04",
+				Suites:     []string{"jammy-backports"},
 				Components: []string{"universe"},
 				PubKeys:    []*packet.PublicKey{testKey.PubKey},
 			},
@@ -816,8 +1754,50 @@ var setupTests = []setupTest{{
 				Path:    "slices/mydir/mypkg.yaml",
 				Slices:  map[string]*setup.Slice{},
 			},
+			"otherpkg": {
+				Archive:         "bar",
+				ExplicitArchive: true,
+				Name:            "otherpkg",
+				Path:            "slices/mydir/otherpkg.yaml",
+				Slices:          map[string]*setup.Slice{},
+			},
 		},
 	},
+}, {
+	summary: "Package selecting an undefined archive",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			archive: foo
+		`,
+	},
+	relerror: `slices/mydir/mypkg.yaml: package "mypkg" refers to undefined archive "foo"`,
+}, {
+	summary: "Package without archive and no default archive",
+	input: map[string]string{
+		"chisel.yaml": `
+			format: chisel-v1
+			archives:
+				foo:
+					version: 22.04
+					components: [main, universe]
+					suites: [jammy]
+					v1-public-keys: [test-key]
+				bar:
+					version: 22.04
+					components: [universe]
+					suites: [jammy-backports]
+					v1-public-keys: [test-key]
+			v1-public-keys:
+				test-key:
+					id: ` + testKey.ID + `
+					armor: |` + "\n" + testutil.PrefixEachLine(testKey.PubKeyArmor, "\t\t\t\t\t\t") + `
+		`,
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+		`,
+	},
+	relerror: `slices/mydir/mypkg.yaml: package "mypkg" does not select an archive and there is no default one`,
 }, {
 	summary: "Extra fields in YAML are ignored (necessary for forward compatibility)",
 	input: map[string]string{
@@ -1561,6 +2541,88 @@ var setupTests = []setupTest{{
 		`,
 	},
 	relerror: `slice mypkg_myslice path /path/\*\* has invalid generate options`,
+}, {
+	summary: "Manifests may leave out content entries",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/path/**: {generate: manifest, detail: paths-only}
+		`,
+	},
+	release: &setup.Release{
+		DefaultArchive: "ubuntu",
+		Archives: map[string]*setup.Archive{
+			"ubuntu": {
+				Name:       "ubuntu",
+				Version:    "22.04",
+				Suites:     []string{"jammy"},
+				Components: []string{"main", "universe"},
+				PubKeys:    []*packet.PublicKey{testKey.PubKey},
+			},
+		},
+		Packages: map[string]*setup.Package{
+			"mypkg": {
+				Archive: "ubuntu",
+				Name:    "mypkg",
+				Path:    "slices/mydir/mypkg.yaml",
+				Slices: map[string]*setup.Slice{
+					"myslice": {
+						Package: "mypkg",
+						Name:    "myslice",
+						Contents: map[string]setup.PathInfo{
+							"/path/**": {Kind: "generate", Generate: "manifest", Detail: "paths-only"},
+						},
+					},
+				},
+			},
+		},
+	},
+}, {
+	summary: "Manifest detail must be known",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/path/**: {generate: manifest, detail: foo}
+		`,
+	},
+	relerror: `slice mypkg_myslice has invalid 'detail' for path /path/\*\*: "foo"`,
+}, {
+	summary: "Manifest detail requires a manifest",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/path/file: {detail: paths-only}
+		`,
+	},
+	relerror: `slice mypkg_myslice path /path/file has 'detail' without 'generate: manifest'`,
+}, {
+	summary: "Manifest detail must match across packages",
+	input: map[string]string{
+		"slices/mydir/mypkg.yaml": `
+			package: mypkg
+			slices:
+				myslice:
+					contents:
+						/path/**: {generate: manifest, detail: paths-only}
+		`,
+		"slices/mydir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice:
+					contents:
+						/path/**: {generate: manifest}
+		`,
+	},
+	relerror: `slices mypkg_myslice and mypkg2_myslice conflict on /path/\*\*`,
 }}
 
 var defaultChiselYaml = `
@@ -1576,6 +2638,20 @@ var defaultChiselYaml = `
 			armor: |` + "\n" + testutil.PrefixEachLine(testKey.PubKeyArmor, "\t\t\t\t\t\t") + `
 `
 
+// testCACert is a self-signed certificate of a certificate authority.
+const testCACert = `-----BEGIN CERTIFICATE-----
+MIIBiTCCAS+gAwIBAgIUFbe2L6he6GW2k2uGJYjtMvm7eM8wCgYIKoZIzj0EAwIw
+GTEXMBUGA1UEAwwOQ2hpc2VsIFRlc3QgQ0EwIBcNMjYxMDE1MDUzOTEzWhgPMjEy
+NjA5MjEwNTM5MTNaMBkxFzAVBgNVBAMMDkNoaXNlbCBUZXN0IENBMFkwEwYHKoZI
+zj0CAQYIKoZIzj0DAQcDQgAEIyvdEudf9i+OWKS4D23vUM6mGKdqFrkPxSnIFqlq
+MjycQjwwRG10fxWoIQ7qwWKFUopGBM+r+Jsh8VX9KcHmhqNTMFEwHQYDVR0OBBYE
+FAULE73nYXfng6UH73sMPUz/BzQKMB8GA1UdIwQYMBaAFAULE73nYXfng6UH73sM
+PUz/BzQKMA8GA1UdEwEB/wQFMAMBAf8wCgYIKoZIzj0EAwIDSAAwRQIgZ2AKbAf8
+RYGbMvNJogLrgBkG3DVLq52vkRBSYYKONEECIQDppR7TGXCtPkhklPXlqO4JlsU9
+ydSzRHvmOc5GR6wAyQ==
+-----END CERTIFICATE-----
+`
+
 func (s *S) TestParseRelease(c *C) {
 	// Run tests for format chisel-v1.
 	runParseReleaseTests(c, setupTests)
@@ -1614,7 +2690,14 @@ func runParseReleaseTests(c *C, tests []setupTest) {
 			c.Assert(err, IsNil)
 		}
 
-		release, err := setup.ReadRelease(dir)
+		var extraSlices []string
+		for _, extraDir := range test.extraSlices {
+			extraSlices = append(extraSlices, filepath.Join(dir, extraDir))
+		}
+		release, err := setup.ReadReleaseWithOptions(dir, &setup.ReadOptions{
+			ConflictPolicy: test.conflictPolicy,
+			ExtraSlices:    extraSlices,
+		})
 		if err != nil || test.relerror != "" {
 			if test.relerror != "" {
 				c.Assert(err, ErrorMatches, test.relerror)
@@ -1625,6 +2708,7 @@ func runParseReleaseTests(c *C, tests []setupTest) {
 		}
 
 		c.Assert(release.Path, Equals, dir)
+		c.Assert(release.ConflictPolicy, Equals, test.conflictPolicy)
 		release.Path = ""
 
 		if test.release != nil {
@@ -1734,3 +2818,392 @@ func (s *S) TestParseSliceKey(c *C) {
 		c.Assert(key, DeepEquals, test.expected)
 	}
 }
+
+var partialReleaseTests = []struct {
+	summary     string
+	packages    []string
+	sets        []string
+	extraSlices map[string]string
+	loaded      []string
+	slices      []string
+	error       string
+}{{
+	summary:  "Packages and their essential slices are read",
+	packages: []string{"mypkg1"},
+	loaded:   []string{"mypkg1", "mypkg2", "mypkg3"},
+}, {
+	summary:  "Packages without essential slices",
+	packages: []string{"mypkg3"},
+	loaded:   []string{"mypkg3"},
+}, {
+	summary: "Packages of sets",
+	sets:    []string{"myset"},
+	loaded:  []string{"mypkg3"},
+}, {
+	summary:  "Packages which are not defined are skipped",
+	packages: []string{"mypkg3", "unknown"},
+	loaded:   []string{"mypkg3"},
+}, {
+	summary:  "Packages of extra slices, and their essential slices",
+	packages: []string{"mypkg3"},
+	extraSlices: map[string]string{
+		"mypkg4.yaml": `
+			package: mypkg4
+			slices:
+				myslice:
+					essential:
+						- mypkg2_myslice
+		`,
+	},
+	loaded: []string{"mypkg2", "mypkg3", "mypkg4"},
+}, {
+	summary:  "Packages split across files are read from all of them",
+	packages: []string{"mypkg3"},
+	loaded:   []string{"mypkg3"},
+	slices:   []string{"mypkg3_extra", "mypkg3_myslice"},
+}, {
+	summary:  "Invalid definitions of needed packages are reported",
+	packages: []string{"broken"},
+	error:    `(?s)cannot parse package "broken" slice definitions: .*`,
+}}
+
+func (s *S) TestReadPartialRelease(c *C) {
+	input := map[string]string{
+		"chisel.yaml": string(defaultChiselYaml),
+		"sets.yaml": `
+			sets:
+				myset: [mypkg3_myslice]
+				otherset: [broken_myslice]
+		`,
+		"slices/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice:
+					essential:
+						- mypkg2_myslice
+		`,
+		"slices/dir/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice:
+					essential:
+						- mypkg3_myslice
+		`,
+		"slices/mypkg3.yaml": `
+			package: mypkg3
+			slices:
+				myslice:
+					contents:
+						/file:
+		`,
+		"slices/other/mypkg3_extra.yaml": `
+			package: mypkg3
+			slices:
+				extra:
+					contents:
+						/other-file:
+		`,
+		"slices/broken.yaml": `
+			package: broken
+			slices: invalid
+		`,
+	}
+	for _, test := range partialReleaseTests {
+		c.Logf("Summary: %s", test.summary)
+		dir := c.MkDir()
+		for path, data := range input {
+			fpath := filepath.Join(dir, path)
+			c.Assert(os.MkdirAll(filepath.Dir(fpath), 0755), IsNil)
+			c.Assert(os.WriteFile(fpath, testutil.Reindent(data), 0644), IsNil)
+		}
+		var extraSlices []string
+		if test.extraSlices != nil {
+			extraDir := filepath.Join(dir, "overlay")
+			c.Assert(os.Mkdir(extraDir, 0755), IsNil)
+			for path, data := range test.extraSlices {
+				c.Assert(os.WriteFile(filepath.Join(extraDir, path), testutil.Reindent(data), 0644), IsNil)
+			}
+			extraSlices = []string{extraDir}
+		}
+
+		release, err := setup.ReadReleaseWithOptions(dir, &setup.ReadOptions{
+			Packages:    test.packages,
+			Sets:        test.sets,
+			ExtraSlices: extraSlices,
+		})
+		if test.error != "" {
+			c.Assert(err, ErrorMatches, test.error)
+			continue
+		}
+		c.Assert(err, IsNil)
+		c.Assert(release.Partial, Equals, true)
+		var loaded []string
+		for pkgName := range release.Packages {
+			loaded = append(loaded, pkgName)
+		}
+		sort.Strings(loaded)
+		c.Assert(loaded, DeepEquals, test.loaded)
+		if test.slices != nil {
+			var slices []string
+			for _, pkg := range release.Packages {
+				for _, slice := range pkg.Slices {
+					slices = append(slices, slice.String())
+				}
+			}
+			sort.Strings(slices)
+			c.Assert(slices, DeepEquals, test.slices)
+		}
+	}
+}
+
+// BenchmarkReadRelease reads a release with many packages, each with globs
+// and plain paths under a few top-level directories, as in Ubuntu releases.
+func BenchmarkReadRelease(b *testing.B) {
+	dir := b.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "chisel.yaml"), testutil.Reindent(defaultChiselYaml), 0644)
+	if err != nil {
+		b.Fatal(err)
+	}
+	err = os.Mkdir(filepath.Join(dir, "slices"), 0755)
+	if err != nil {
+		b.Fatal(err)
+	}
+	topDirs := []string{"etc", "lib", "opt", "usr", "var"}
+	for i := 0; i < 1000; i++ {
+		pkgName := fmt.Sprintf("pkg%d", i)
+		topDir := topDirs[i%len(topDirs)]
+		data := fmt.Sprintf(`
+			package: %[1]s
+			slices:
+				bins:
+					contents:
+						/%[2]s/bin/%[1]s:
+						/%[2]s/share/%[1]s/**:
+				libs:
+					contents:
+						/%[2]s/lib/lib%[1]s.so.*:
+						/%[2]s/lib/%[1]s/plugin.so:
+		`, pkgName, topDir)
+		err = os.WriteFile(filepath.Join(dir, "slices", pkgName+".yaml"), testutil.Reindent(data), 0644)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := setup.ReadRelease(dir)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func (s *S) TestSelectDebug(c *C) {
+	dir := c.MkDir()
+	input := map[string]string{
+		"chisel.yaml": string(defaultChiselYaml),
+		"slices/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice:
+					essential:
+						- mypkg2_myslice
+		`,
+		"slices/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice:
+					contents:
+						/file:
+		`,
+	}
+	for path, data := range input {
+		fpath := filepath.Join(dir, path)
+		c.Assert(os.MkdirAll(filepath.Dir(fpath), 0755), IsNil)
+		c.Assert(os.WriteFile(fpath, testutil.Reindent(data), 0644), IsNil)
+	}
+	release, err := setup.ReadRelease(dir)
+	c.Assert(err, IsNil)
+
+	// Slices selected as essential are only traced when debugging.
+	setup.SetDebug(false)
+	_, err = setup.Select(release, []setup.SliceKey{{"mypkg1", "myslice"}})
+	c.Assert(err, IsNil)
+	c.Assert(c.GetTestLog(), Not(Matches), `(?s).*as an essential slice.*`)
+
+	setup.SetDebug(true)
+	_, err = setup.Select(release, []setup.SliceKey{{"mypkg1", "myslice"}})
+	c.Assert(err, IsNil)
+	c.Assert(c.GetTestLog(), Matches, `(?s).*Selecting mypkg2_myslice as an essential slice\n.*`)
+	c.Assert(c.GetTestLog(), Not(Matches), `(?s).*Selecting mypkg1_myslice as an essential slice.*`)
+}
+
+func (s *S) TestSelectRedundantEssentials(c *C) {
+	dir := c.MkDir()
+	input := map[string]string{
+		"chisel.yaml": string(defaultChiselYaml),
+		"slices/mypkg.yaml": `
+			package: mypkg
+			slices:
+				implied:
+					essential:
+						- mypkg_base
+						- mypkg_lib
+				shadowed:
+					essential:
+						- mypkg_data
+					contents:
+						/data:
+						/bin:
+				lib:
+					essential:
+						- mypkg_base
+					contents:
+						/lib:
+				base:
+					contents:
+						/base:
+				data:
+					contents:
+						/data:
+		`,
+	}
+	for path, data := range input {
+		fpath := filepath.Join(dir, path)
+		c.Assert(os.MkdirAll(filepath.Dir(fpath), 0755), IsNil)
+		c.Assert(os.WriteFile(fpath, testutil.Reindent(data), 0644), IsNil)
+	}
+	release, err := setup.ReadRelease(dir)
+	c.Assert(err, IsNil)
+
+	_, err = setup.Select(release, []setup.SliceKey{{"mypkg", "lib"}})
+	c.Assert(err, IsNil)
+	c.Assert(c.GetTestLog(), Not(Matches), `(?s).*Warning: slice .* lists .*`)
+
+	_, err = setup.Select(release, []setup.SliceKey{{"mypkg", "implied"}, {"mypkg", "shadowed"}})
+	c.Assert(err, IsNil)
+	c.Assert(c.GetTestLog(), Matches, `(?s).*Warning: slice mypkg_implied lists mypkg_base as essential, which is already implied by mypkg_lib\n.*`)
+	c.Assert(c.GetTestLog(), Matches, `(?s).*Warning: slice mypkg_shadowed lists mypkg_data as essential, but already provides all of its contents\n.*`)
+	c.Assert(c.GetTestLog(), Not(Matches), `(?s).*Warning: slice mypkg_implied lists mypkg_lib .*`)
+}
+
+func (s *S) TestOrder(c *C) {
+	dir := c.MkDir()
+	input := map[string]string{
+		"chisel.yaml": string(defaultChiselYaml),
+		"slices/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice:
+					essential:
+						- mypkg3_myslice
+						- mypkg2_myslice
+		`,
+		"slices/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice:
+					essential:
+						- mypkg4_myslice
+		`,
+		"slices/mypkg3.yaml": `
+			package: mypkg3
+			slices:
+				myslice:
+		`,
+		"slices/mypkg4.yaml": `
+			package: mypkg4
+			slices:
+				myslice:
+				other:
+		`,
+	}
+	for path, data := range input {
+		fpath := filepath.Join(dir, path)
+		c.Assert(os.MkdirAll(filepath.Dir(fpath), 0755), IsNil)
+		c.Assert(os.WriteFile(fpath, testutil.Reindent(data), 0644), IsNil)
+	}
+	release, err := setup.ReadRelease(dir)
+	c.Assert(err, IsNil)
+
+	expected := []setup.SliceKey{
+		{"mypkg3", "myslice"},
+		{"mypkg4", "myslice"},
+		{"mypkg2", "myslice"},
+		{"mypkg1", "myslice"},
+		{"mypkg4", "other"},
+	}
+	// The order does not depend on how the slices are listed.
+	for _, keys := range [][]setup.SliceKey{
+		{{"mypkg1", "myslice"}, {"mypkg4", "other"}},
+		{{"mypkg4", "other"}, {"mypkg1", "myslice"}},
+		{{"mypkg4", "other"}, {"mypkg2", "myslice"}, {"mypkg1", "myslice"}},
+	} {
+		order, err := setup.Order(release, keys)
+		c.Assert(err, IsNil)
+		c.Assert(order, DeepEquals, expected)
+
+		selection, err := setup.Select(release, keys)
+		c.Assert(err, IsNil)
+		var selected []setup.SliceKey
+		for _, slice := range selection.Slices {
+			selected = append(selected, setup.SliceKey{slice.Package, slice.Name})
+		}
+		c.Assert(selected, DeepEquals, expected)
+	}
+
+	_, err = setup.Order(release, []setup.SliceKey{{"mypkg5", "myslice"}})
+	c.Assert(err, ErrorMatches, `slices of package "mypkg5" not found`)
+}
+
+func (s *S) TestReleaseConflicts(c *C) {
+	dir := c.MkDir()
+	input := map[string]string{
+		"chisel.yaml": string(defaultChiselYaml),
+		"slices/mypkg1.yaml": `
+			package: mypkg1
+			slices:
+				myslice:
+					contents:
+						/file:
+						/dir/**:
+				other:
+					contents:
+						/file:
+		`,
+		"slices/mypkg2.yaml": `
+			package: mypkg2
+			slices:
+				myslice:
+					contents:
+						/file:
+						/dir/sub/**:
+		`,
+	}
+	for path, data := range input {
+		fpath := filepath.Join(dir, path)
+		c.Assert(os.MkdirAll(filepath.Dir(fpath), 0755), IsNil)
+		c.Assert(os.WriteFile(fpath, testutil.Reindent(data), 0644), IsNil)
+	}
+
+	_, err := setup.ReadRelease(dir)
+	c.Assert(err, ErrorMatches, `slices mypkg1_myslice and mypkg2_myslice conflict on .*`)
+
+	release, err := setup.ReadReleaseWithOptions(dir, &setup.ReadOptions{ConflictPolicy: setup.ConflictWarn})
+	c.Assert(err, IsNil)
+	c.Assert(release.Conflicts(), DeepEquals, []*setup.Conflict{{
+		ConflictError: setup.ConflictError{
+			Slices: [2]setup.SliceKey{{"mypkg1", "myslice"}, {"mypkg2", "myslice"}},
+			Paths:  [2]string{"/dir/**", "/dir/sub/**"},
+		},
+		Uncertain: true,
+		Tolerated: true,
+	}, {
+		ConflictError: setup.ConflictError{
+			Slices: [2]setup.SliceKey{{"mypkg1", "myslice"}, {"mypkg2", "myslice"}},
+			Paths:  [2]string{"/file", "/file"},
+		},
+		Uncertain: true,
+		Tolerated: true,
+	}})
+}
@@ -0,0 +1,64 @@
+package slicer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// dedupeModes lists the supported ways of deduplicating the files of a tree
+// which have the same content.
+var dedupeModes = []string{"hardlink"}
+
+// DedupeModes returns the names of the supported ways of deduplicating the
+// files of a tree which have the same content, sorted.
+func DedupeModes() []string {
+	return append([]string(nil), dedupeModes...)
+}
+
+// dedupe replaces every reported regular file in targetDir which has the
+// same content, mode and extended attributes as another one by a hard link
+// to it, and records the latter in the HardLink field of its entry. Within
+// each group of identical files, the one with the first path is kept.
+func dedupe(targetDir string, report *Report) error {
+	groups := make(map[string][]string)
+	for relPath, entry := range report.Entries {
+		// Empty files are left alone as there is nothing to be saved.
+		if !entry.Mode.IsRegular() || entry.Pruned || entry.Size == 0 {
+			continue
+		}
+		hash := entry.FinalHash
+		if hash == "" {
+			hash = entry.Hash
+		}
+		key := fmt.Sprintf("%s %s %v", hash, entry.Mode, entry.Xattrs)
+		groups[key] = append(groups[key], relPath)
+	}
+	for _, paths := range groups {
+		if len(paths) < 2 {
+			continue
+		}
+		sort.Strings(paths)
+		linkTarget := filepath.Join(targetDir, paths[0])
+		for _, relPath := range paths[1:] {
+			debugf("Hardlinking path: %s => %s", relPath, paths[0])
+			path := filepath.Join(targetDir, relPath)
+			// Link under a temporary name first so the file is
+			// replaced atomically.
+			tmpPath := path + ".chisel-dedupe"
+			err := os.Link(linkTarget, tmpPath)
+			if err == nil {
+				err = os.Rename(tmpPath, path)
+			}
+			if err != nil {
+				os.Remove(tmpPath)
+				return fmt.Errorf("cannot deduplicate path: %w", err)
+			}
+			entry := report.Entries[relPath]
+			entry.HardLink = paths[0]
+			report.Entries[relPath] = entry
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package slicer
+
+import (
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+)
+
+// MissingLibrary describes a shared library required by an ELF file in the
+// sliced tree which is not part of the tree itself.
+type MissingLibrary struct {
+	// Path is the ELF file requiring the library, relative to the root.
+	Path string
+	// Soname is the name of the missing library, as listed in the
+	// DT_NEEDED entries of the ELF file.
+	Soname string
+}
+
+// CheckLibraries inspects the regular files listed in report and returns
+// the shared libraries required by the ELF files among them which are not
+// listed in the report as well, sorted by path and soname. A library is
+// considered present when any file or symlink in the report has its soname
+// as name, regardless of the directory holding it. Pruned paths are ignored.
+func CheckLibraries(report *Report) ([]MissingLibrary, error) {
+	present := make(map[string]bool)
+	for relPath, entry := range report.Entries {
+		if !entry.Mode.IsDir() && !entry.Pruned {
+			present[filepath.Base(relPath)] = true
+		}
+	}
+
+	var missing []MissingLibrary
+	for relPath, entry := range report.Entries {
+		if !entry.Mode.IsRegular() || entry.Pruned {
+			continue
+		}
+		needed, err := neededLibraries(filepath.Join(report.Root, relPath))
+		if err != nil {
+			return nil, fmt.Errorf("cannot check libraries of %s: %w", relPath, err)
+		}
+		for _, soname := range needed {
+			if !present[soname] {
+				missing = append(missing, MissingLibrary{Path: relPath, Soname: soname})
+			}
+		}
+	}
+	sort.Slice(missing, func(i, j int) bool {
+		if missing[i].Path != missing[j].Path {
+			return missing[i].Path < missing[j].Path
+		}
+		return missing[i].Soname < missing[j].Soname
+	})
+	return missing, nil
+}
+
+// neededLibraries returns the DT_NEEDED entries of the ELF file at path, or
+// nothing if the file is not in the ELF format.
+func neededLibraries(path string) ([]string, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		var formatErr *elf.FormatError
+		if errors.As(err, &formatErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	// Files without a dynamic section have no dependencies.
+	return f.DynString(elf.DT_NEEDED)
+}
@@ -0,0 +1,70 @@
+package slicer_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/internal/slicer"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+func (s *S) TestCheckLibraries(c *C) {
+	releaseDir := c.MkDir()
+	release := map[string]string{
+		"chisel.yaml": defaultChiselYaml,
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/usr/bin/app:
+						/usr/bin/script:
+						/usr/lib/libfoo.so.1:
+						/usr/lib/libfoo.so.1.0:
+		`,
+	}
+	for path, data := range release {
+		fpath := filepath.Join(releaseDir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+	r, err := setup.ReadRelease(releaseDir)
+	c.Assert(err, IsNil)
+	selection, err := setup.Select(r, []setup.SliceKey{{"test-package", "myslice"}})
+	c.Assert(err, IsNil)
+
+	pkgData := testutil.MustMakeDeb([]testutil.TarEntry{
+		testutil.Dir(0755, "./"),
+		testutil.Dir(0755, "./usr/"),
+		testutil.Dir(0755, "./usr/bin/"),
+		testutil.Reg(0755, "./usr/bin/app", string(testutil.MustMakeELF([]string{"libfoo.so.1", "libc.so.6"}))),
+		testutil.Reg(0755, "./usr/bin/script", "#!/bin/sh\n"),
+		testutil.Dir(0755, "./usr/lib/"),
+		testutil.Lnk(0777, "./usr/lib/libfoo.so.1", "libfoo.so.1.0"),
+		testutil.Reg(0644, "./usr/lib/libfoo.so.1.0", string(testutil.MustMakeELF([]string{"libbar.so.2"}))),
+	})
+	report, err := slicer.Run(&slicer.RunOptions{
+		Selection: selection,
+		Archives: map[string]archive.Archive{
+			"ubuntu": &testArchive{
+				options: archive.Options{Arch: "amd64"},
+				pkgs:    map[string][]byte{"test-package": pkgData},
+			},
+		},
+		TargetDir: c.MkDir(),
+	})
+	c.Assert(err, IsNil)
+
+	missing, err := slicer.CheckLibraries(report)
+	c.Assert(err, IsNil)
+	c.Assert(missing, DeepEquals, []slicer.MissingLibrary{
+		{Path: "/usr/bin/app", Soname: "libc.so.6"},
+		{Path: "/usr/lib/libfoo.so.1.0", Soname: "libbar.so.2"},
+	})
+}
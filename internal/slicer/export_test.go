@@ -0,0 +1,13 @@
+package slicer
+
+func StripELF(data []byte) (stripped []byte, ok bool, err error) {
+	layout := readELFLayout(data)
+	if layout == nil {
+		return nil, false, nil
+	}
+	return layout.strip()
+}
+
+func DebugOnlyELF(data []byte) ([]byte, error) {
+	return readELFLayout(data).debugOnly()
+}
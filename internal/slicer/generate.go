@@ -0,0 +1,100 @@
+package slicer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/setup"
+)
+
+// GenerateContext holds the information available to a generator once all
+// the selected content was extracted and mutated.
+type GenerateContext struct {
+	// TargetDir is the root directory where content was sliced into.
+	TargetDir string
+	// Dirs maps each directory, relative to TargetDir, where the generator
+	// must write its content to the slices that requested it via a
+	// "generate" path. Excluded directories are left out.
+	Dirs map[string][]*setup.Slice
+	// Options are the options provided to Run.
+	Options *RunOptions
+	// Report holds the content sliced so far. Generators may add the
+	// files they write to it so that later generators are aware of them.
+	Report *Report
+	// Packages holds the information of every package extracted, keyed
+	// by package name.
+	Packages map[string]*archive.PackageInfo
+}
+
+// Generator writes the content for a "generate" kind.
+type Generator func(ctx *GenerateContext) error
+
+var generators = make(map[setup.GenerateKind]Generator)
+
+// RegisterGenerator makes generator responsible for writing the content of
+// paths with the provided "generate" kind. Generators are run in the order
+// of their kind names, except for the manifest one which always runs last
+// so that it can list the content written by all the others.
+func RegisterGenerator(kind setup.GenerateKind, generator Generator) {
+	if kind == setup.GenerateNone {
+		panic("cannot register generator without a kind")
+	}
+	if _, ok := generators[kind]; ok {
+		panic(fmt.Sprintf("generator for %q registered twice", kind))
+	}
+	generators[kind] = generator
+	setup.RegisterGenerateKind(kind)
+}
+
+// generateDirs returns the directories, relative to the target directory,
+// where content of the provided kind must be generated, mapped to the slices
+// that requested each of them.
+func generateDirs(selection *setup.Selection, kind setup.GenerateKind) map[string][]*setup.Slice {
+	dirs := make(map[string][]*setup.Slice)
+	for _, slice := range selection.Slices {
+		for relPath, pathInfo := range slice.Contents {
+			if pathInfo.Kind == setup.GeneratePath && pathInfo.Generate == kind {
+				dir := strings.TrimSuffix(relPath, "**")
+				dirs[dir] = append(dirs[dir], slice)
+			}
+		}
+	}
+	return dirs
+}
+
+// runGenerators runs every registered generator, including those for which
+// no "generate" paths were selected, as generators may be enabled by other
+// options as well.
+func runGenerators(targetDir string, options *RunOptions, report *Report, pkgInfos map[string]*archive.PackageInfo) error {
+	kinds := make([]setup.GenerateKind, 0, len(generators))
+	for kind := range generators {
+		kinds = append(kinds, kind)
+	}
+	sort.Slice(kinds, func(i, j int) bool {
+		if kinds[i] == setup.GenerateManifest || kinds[j] == setup.GenerateManifest {
+			return kinds[j] == setup.GenerateManifest
+		}
+		return kinds[i] < kinds[j]
+	})
+	for _, kind := range kinds {
+		ctx := &GenerateContext{
+			TargetDir: targetDir,
+			Dirs:      generateDirs(options.Selection, kind),
+			Options:   options,
+			Report:    report,
+			Packages:  pkgInfos,
+		}
+		for dir := range ctx.Dirs {
+			if options.excludes(dir) {
+				delete(ctx.Dirs, dir)
+			}
+		}
+		err := generators[kind](ctx)
+		if err != nil {
+			return fmt.Errorf("cannot generate %s: %w", kind, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package slicer
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/canonical/chisel/internal/fsutil"
+	"github.com/canonical/chisel/internal/setup"
+)
+
+// imageInfoFilename is the name of the file written into every directory
+// listed as a "generate: image-info" path.
+const imageInfoFilename = "image-info"
+
+func init() {
+	RegisterGenerator(setup.GenerateImageInfo, generateImageInfo)
+}
+
+// generateImageInfo writes a file describing the chiselled image into every
+// directory in ctx. The format follows the one of /etc/os-release so that it
+// may be easily inspected at runtime:
+//
+//	ID=ubuntu
+//	VERSION_ID="22.04"
+//	ARCH=amd64
+//	CHISEL_VERSION="v1.0.0"
+//	CHISEL_RELEASE_COMMIT=...
+//	SLICES="base-files_base hello_bins"
+//
+// Fields are omitted when their value is unknown.
+func generateImageInfo(ctx *GenerateContext) error {
+	if len(ctx.Dirs) == 0 {
+		return nil
+	}
+	release := ctx.Options.Selection.Release
+
+	var distro, version, arch string
+	if archiveInfo, ok := release.Archives[release.DefaultArchive]; ok {
+		distro, version = archiveInfo.Name, archiveInfo.Version
+	}
+	if archive, ok := ctx.Options.Archives[release.DefaultArchive]; ok {
+		arch = archive.Options().Arch
+	}
+	slices := make([]string, 0, len(ctx.Options.Selection.Slices))
+	for _, slice := range ctx.Options.Selection.Slices {
+		slices = append(slices, slice.String())
+	}
+
+	var buf bytes.Buffer
+	for _, field := range [][2]string{
+		{"ID", distro},
+		{"VERSION_ID", version},
+		{"ARCH", arch},
+		{"CHISEL_VERSION", ctx.Options.ChiselVersion},
+		{"CHISEL_RELEASE_COMMIT", release.Commit},
+		{"SLICES", strings.Join(slices, " ")},
+	} {
+		if field[1] != "" {
+			fmt.Fprintf(&buf, "%s=%s\n", field[0], quoteInfoValue(field[1]))
+		}
+	}
+	data := buf.Bytes()
+
+	for dir, dirSlices := range ctx.Dirs {
+		path := filepath.Join(ctx.TargetDir, dir, imageInfoFilename)
+		logf("Writing image info to %s...", path)
+		var creator fsutil.Creator = &fsutil.DiskCreator{SHA512: ctx.Options.SHA512}
+		if ctx.Options.PathCreated != nil {
+			creator = &trackingCreator{Creator: creator, options: ctx.Options}
+		}
+		entry, err := creator.Create(&fsutil.CreateOptions{
+			Path: path,
+			Mode: 0644,
+			Data: bytes.NewReader(data),
+		})
+		if err != nil {
+			return err
+		}
+		for _, slice := range dirSlices {
+			err := ctx.Report.Add(slice, entry)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// quoteInfoValue quotes value as in os-release files, where values must be
+// enclosed in double quotes if they contain anything other than letters,
+// digits and some punctuation.
+func quoteInfoValue(value string) string {
+	plain := strings.IndexFunc(value, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_+:", r))
+	}) < 0
+	if plain {
+		return value
+	}
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`")
+	return `"` + escaper.Replace(value) + `"`
+}
@@ -0,0 +1,279 @@
+package slicer
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/fsutil"
+	"github.com/canonical/chisel/internal/manifest"
+	"github.com/canonical/chisel/internal/setup"
+)
+
+func init() {
+	RegisterGenerator(setup.GenerateManifest, generateManifests)
+}
+
+// manifestDirs returns the directories from ctx where a manifest must be
+// generated, adding those in extraDirs without any slices.
+func manifestDirs(ctx *GenerateContext, extraDirs []string) map[string][]*setup.Slice {
+	dirs := ctx.Dirs
+	for _, dir := range extraDirs {
+		dir = filepath.Clean("/"+dir) + "/"
+		if _, ok := dirs[dir]; !ok {
+			dirs[dir] = nil
+		}
+	}
+	return dirs
+}
+
+// manifestDetail returns the detail of the manifest generated into dir for
+// dirSlices, which all agree on it as the release forbids conflicting
+// definitions of the same path.
+func manifestDetail(dir string, dirSlices []*setup.Slice) setup.ManifestDetail {
+	for _, slice := range dirSlices {
+		return slice.Contents[dir+"**"].Detail
+	}
+	return setup.DetailFull
+}
+
+// generateManifests writes a manifest describing the selection and the
+// reported content into every directory returned by manifestDirs, unless
+// disabled, and into every file in the ManifestPaths option. Directories
+// with a "paths-only" detail get a manifest without content entries.
+func generateManifests(ctx *GenerateContext) error {
+	options := ctx.Options
+	dirs := manifestDirs(ctx, options.ManifestDirs)
+	if options.NoTreeManifests {
+		dirs = nil
+	}
+	if len(dirs) == 0 && len(options.ManifestPaths) == 0 {
+		return nil
+	}
+
+	writers := make(map[bool]*manifest.Writer)
+	writer := func(pathsOnly bool) (*manifest.Writer, error) {
+		if mw, ok := writers[pathsOnly]; ok {
+			return mw, nil
+		}
+		mw, err := buildManifest(ctx, dirs, pathsOnly)
+		if err != nil {
+			return nil, err
+		}
+		writers[pathsOnly] = mw
+		return mw, nil
+	}
+	for dir, dirSlices := range dirs {
+		mw, err := writer(manifestDetail(dir, dirSlices) == setup.DetailPathsOnly)
+		if err != nil {
+			return err
+		}
+		err = writeManifest(options, filepath.Join(ctx.TargetDir, dir, manifest.Filename), mw)
+		if err != nil {
+			return err
+		}
+	}
+	for _, path := range options.ManifestPaths {
+		mw, err := writer(false)
+		if err != nil {
+			return err
+		}
+		err = writeManifest(options, path, mw)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildManifest returns a writer with the entries of a manifest describing
+// the selection and the reported content, and listing the manifests
+// generated into dirs.
+func buildManifest(ctx *GenerateContext, dirs map[string][]*setup.Slice, pathsOnly bool) (*manifest.Writer, error) {
+	options, report, pkgInfos := ctx.Options, ctx.Report, ctx.Packages
+	selection := options.Selection
+
+	mw := manifest.NewWriterWithOptions(&manifest.WriterOptions{PathsOnly: pathsOnly})
+	if options.ChiselVersion != "" {
+		err := mw.SetGenerator(&manifest.Generator{Name: "chisel", Version: options.ChiselVersion})
+		if err != nil {
+			return nil, err
+		}
+	}
+	usage := report.Usage()
+	for _, info := range pkgInfos {
+		var sha512 string
+		if options.SHA512 {
+			// The digest is taken from the signed index, as for SHA256.
+			sha512 = info.Get("SHA512")
+		}
+		err := mw.AddPackage(&manifest.Package{
+			Name:    info.Name,
+			Version: info.Version,
+			Digest:  info.SHA256,
+			Arch:    info.Arch,
+			Suite:   info.Suite,
+			SHA512:  sha512,
+			Size:    uint64(usage.Packages[info.Name]),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, slice := range selection.Slices {
+		err := mw.AddSlice(&manifest.Slice{Name: slice.String(), Summary: slice.Summary, Size: uint64(usage.Slices[slice])})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, entry := range report.Entries {
+		slices := sliceNames(entry.Slices)
+		var size uint64
+		if entry.Mode.IsRegular() {
+			size = uint64(entry.Size)
+		}
+		err := mw.AddPath(&manifest.Path{
+			Path:        entry.Path,
+			Mode:        fmt.Sprintf("0%o", unixPerm(entry.Mode)),
+			Slices:      slices,
+			SHA256:      entry.Hash,
+			FinalSHA256: entry.FinalHash,
+			Size:        size,
+			Link:        entry.Link,
+			Xattrs:      manifestXattrs(entry.Xattrs),
+			Implicit:    entry.Implicit,
+			Pruned:      entry.Pruned,
+			HardLink:    entry.HardLink,
+			SHA512:      entry.SHA512,
+			FinalSHA512: entry.FinalSHA512,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, slice := range slices {
+			err := mw.AddContent(&manifest.Content{Slice: slice, Path: entry.Path})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	// The manifests list themselves, although without a digest as that
+	// would depend on their own content.
+	for dir, dirSlices := range dirs {
+		relPath := dir + manifest.Filename
+		slices := make(map[*setup.Slice]bool)
+		for _, slice := range dirSlices {
+			slices[slice] = true
+		}
+		names := sliceNames(slices)
+		err := mw.AddPath(&manifest.Path{
+			Path:   relPath,
+			Mode:   fmt.Sprintf("0%o", manifestMode),
+			Slices: names,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, slice := range names {
+			err := mw.AddContent(&manifest.Content{Slice: slice, Path: relPath})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return mw, nil
+}
+
+// writeDebugManifest writes a manifest describing the selection and the
+// debug information in debugReport into /var/lib/chisel/ within its root.
+func writeDebugManifest(options *RunOptions, debugReport *Report, pkgInfos map[string]*archive.PackageInfo) error {
+	ctx := &GenerateContext{
+		TargetDir: debugReport.Root,
+		Options:   options,
+		Report:    debugReport,
+		Packages:  pkgInfos,
+	}
+	dirs := map[string][]*setup.Slice{debugManifestDir: nil}
+	mw, err := buildManifest(ctx, dirs, false)
+	if err != nil {
+		return err
+	}
+	return writeManifest(options, filepath.Join(debugReport.Root, debugManifestDir, manifest.Filename), mw)
+}
+
+// debugManifestDir is where the manifest of the debug tree is written.
+const debugManifestDir = "/var/lib/chisel/"
+
+const manifestMode fs.FileMode = 0644
+
+func writeManifest(options *RunOptions, path string, mw *manifest.Writer) error {
+	logf("Writing manifest to %s...", path)
+	err := trackCreated(options, path)
+	if err != nil {
+		return err
+	}
+	_, err = fsutil.Create(&fsutil.CreateOptions{
+		Path:        filepath.Dir(path),
+		Mode:        fs.ModeDir | 0755,
+		MakeParents: true,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot write manifest: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, manifestMode)
+	if err != nil {
+		return fmt.Errorf("cannot write manifest: %w", err)
+	}
+	err = mw.Write(file)
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(path, manifestMode)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot write manifest: %w", err)
+	}
+	return nil
+}
+
+func manifestXattrs(xattrs map[string]string) map[string]string {
+	if len(xattrs) == 0 {
+		return nil
+	}
+	encoded := make(map[string]string, len(xattrs))
+	for name, value := range xattrs {
+		encoded[name] = base64.StdEncoding.EncodeToString([]byte(value))
+	}
+	return encoded
+}
+
+func sliceNames(slices map[*setup.Slice]bool) []string {
+	names := make([]string, 0, len(slices))
+	for slice := range slices {
+		names = append(names, slice.String())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// unixPerm returns the permission bits of mode, including the setuid, setgid
+// and sticky bits, as traditionally represented in Unix.
+func unixPerm(mode fs.FileMode) (perm uint32) {
+	perm = uint32(mode.Perm())
+	if mode&fs.ModeSticky != 0 {
+		perm |= 01000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		perm |= 02000
+	}
+	if mode&fs.ModeSetuid != 0 {
+		perm |= 04000
+	}
+	return perm
+}
@@ -0,0 +1,96 @@
+package slicer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// pruneClass describes well-known paths which are not essential for most
+// uses of a tree, and may be removed from it once cut.
+type pruneClass struct {
+	patterns []string
+	// keep lists patterns of paths which must be kept even though they
+	// match patterns.
+	keep []string
+}
+
+var pruneClasses = map[string]pruneClass{
+	"docs": {
+		patterns: []string{"/usr/share/doc/**", "/usr/share/info/**"},
+		// Copyright files must accompany the content of packages.
+		keep: []string{"/usr/share/doc/*/copyright"},
+	},
+	"locales": {
+		patterns: []string{"/usr/share/locale/**", "/usr/lib/locale/**"},
+	},
+	"man": {
+		patterns: []string{"/usr/share/man/**"},
+	},
+}
+
+// PruneClasses returns the names of the classes of paths that may be pruned,
+// sorted.
+func PruneClasses() []string {
+	names := make([]string, 0, len(pruneClasses))
+	for name := range pruneClasses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// isPruned returns whether relPath belongs to any of the provided classes.
+func isPruned(classes []string, relPath string) bool {
+	for _, name := range classes {
+		class := pruneClasses[name]
+		if isExcluded(class.patterns, relPath) && !isExcluded(class.keep, relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// prune removes from targetDir the reported paths which belong to any of the
+// provided classes, and marks them as pruned in the report. Directories are
+// only removed, and marked, when nothing else is left in them.
+func prune(targetDir string, report *Report, classes []string) error {
+	var dirs []string
+	for relPath, entry := range report.Entries {
+		if !isPruned(classes, relPath) {
+			continue
+		}
+		if entry.Mode.IsDir() {
+			dirs = append(dirs, relPath)
+			continue
+		}
+		debugf("Pruning path: %s", relPath)
+		err := os.Remove(filepath.Join(targetDir, relPath))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("cannot prune path: %w", err)
+		}
+		entry.Pruned = true
+		report.Entries[relPath] = entry
+	}
+	// Order the directories so the deepest ones appear first, this way we
+	// can check for empty directories properly.
+	sort.Slice(dirs, func(i, j int) bool {
+		return dirs[i] > dirs[j]
+	})
+	for _, relPath := range dirs {
+		err := os.Remove(filepath.Join(targetDir, relPath))
+		// The non-empty directory error is caught by IsExist as well.
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("cannot prune path: %w", err)
+		}
+		debugf("Pruning path: %s", relPath)
+		entry := report.Entries[relPath]
+		entry.Pruned = true
+		report.Entries[relPath] = entry
+	}
+	return nil
+}
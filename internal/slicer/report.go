@@ -18,6 +18,20 @@ type ReportEntry struct {
 	Slices    map[*setup.Slice]bool
 	Link      string
 	FinalHash string
+	Xattrs    map[string]string
+	// SHA512 and FinalSHA512 hold the SHA512 digests matching Hash and
+	// FinalHash, if requested when running the slicer.
+	SHA512      string
+	FinalSHA512 string
+	// Implicit is true for parent directories which were not selected
+	// themselves, but were created to hold the paths of Slices.
+	Implicit bool
+	// Pruned is true for paths which were removed once cut, as they
+	// belong to one of the classes of paths pruned from the tree.
+	Pruned bool
+	// HardLink is the path of the entry this regular file was hard linked
+	// to, as they had the same content.
+	HardLink string
 }
 
 // Report holds the information about files and directories created when slicing
@@ -27,6 +41,16 @@ type Report struct {
 	Root string
 	// Entries holds all reported content, indexed by their path.
 	Entries map[string]ReportEntry
+	// Excluded holds the selected paths that were intentionally not
+	// created, as requested when running the slicer.
+	Excluded map[string]bool
+	// Special holds the original mode of device nodes and FIFOs which
+	// were not created, and of files created without their setuid and
+	// setgid bits, because special files were not allowed.
+	Special map[string]fs.FileMode
+	// Debug holds the debug information written into a separate tree for
+	// the stripped files, if requested when running the slicer.
+	Debug *Report
 }
 
 // NewReport returns an empty report for content that will be based at the
@@ -36,8 +60,10 @@ func NewReport(root string) (*Report, error) {
 		return nil, fmt.Errorf("cannot use relative path for report root: %q", root)
 	}
 	report := &Report{
-		Root:    filepath.Clean(root) + "/",
-		Entries: make(map[string]ReportEntry),
+		Root:     filepath.Clean(root) + "/",
+		Entries:  make(map[string]ReportEntry),
+		Excluded: make(map[string]bool),
+		Special:  make(map[string]fs.FileMode),
 	}
 	return report, nil
 }
@@ -68,12 +94,104 @@ func (r *Report) Add(slice *setup.Slice, fsEntry *fsutil.Entry) error {
 			Size:   fsEntry.Size,
 			Slices: map[*setup.Slice]bool{slice: true},
 			Link:   fsEntry.Link,
+			Xattrs: fsEntry.Xattrs,
+			SHA512: fsEntry.SHA512,
 		}
 	}
 	return nil
 }
 
-// Mutate updates the FinalHash and Size of an existing path entry.
+// Usage holds the disk usage in bytes of the content of a report. Only
+// regular files and symlinks which are still in the tree are accounted for.
+type Usage struct {
+	// Slices holds the size of the files of each slice. Files shared by
+	// several slices are accounted for in all of them.
+	Slices map[*setup.Slice]int64
+	// Packages holds the size of the files of the slices of each package,
+	// with files shared by slices of the same package counted once.
+	Packages map[string]int64
+	// Total is the size of all files, with files shared by several slices
+	// and files hard linked to others counted once.
+	Total int64
+}
+
+// Usage returns the disk usage of the reported content.
+func (r *Report) Usage() *Usage {
+	usage := &Usage{
+		Slices:   make(map[*setup.Slice]int64),
+		Packages: make(map[string]int64),
+	}
+	for _, entry := range r.Entries {
+		if entry.Mode.IsDir() || entry.Pruned {
+			continue
+		}
+		size := int64(entry.Size)
+		packages := make(map[string]bool)
+		for slice := range entry.Slices {
+			usage.Slices[slice] += size
+			packages[slice.Package] = true
+		}
+		for pkg := range packages {
+			usage.Packages[pkg] += size
+		}
+		if entry.HardLink == "" {
+			usage.Total += size
+		}
+	}
+	return usage
+}
+
+// AddImplicit reports the parent directories of every reported path which are
+// not reported themselves, as implicit entries owned by the slices of the
+// paths they hold. The mode of those directories is taken from dirModes,
+// indexed by their path relative to the root, or is 0755 otherwise.
+func (r *Report) AddImplicit(dirModes map[string]fs.FileMode) {
+	var entries []ReportEntry
+	for _, entry := range r.Entries {
+		if !entry.Implicit {
+			entries = append(entries, entry)
+		}
+	}
+	for _, entry := range entries {
+		dir := filepath.Dir(strings.TrimSuffix(entry.Path, "/"))
+		for ; dir != "/"; dir = filepath.Dir(dir) {
+			relPath := dir + "/"
+			parent, ok := r.Entries[relPath]
+			if ok && !parent.Implicit {
+				continue
+			}
+			if !ok {
+				mode, ok := dirModes[relPath]
+				if !ok {
+					mode = fs.ModeDir | 0755
+				}
+				parent = ReportEntry{
+					Path:     relPath,
+					Mode:     mode,
+					Slices:   make(map[*setup.Slice]bool),
+					Implicit: true,
+				}
+			}
+			for slice := range entry.Slices {
+				parent.Slices[slice] = true
+			}
+			r.Entries[relPath] = parent
+		}
+	}
+}
+
+// Exclude records that the selected relPath was intentionally not created.
+func (r *Report) Exclude(relPath string) {
+	r.Excluded[relPath] = true
+}
+
+// AddSpecial records that the special file at relPath, with the provided mode,
+// was either not created or created without its special bits.
+func (r *Report) AddSpecial(relPath string, mode fs.FileMode) {
+	r.Special[relPath] = mode
+}
+
+// Mutate updates the FinalHash, FinalSHA512 and Size of an existing path entry.
 func (r *Report) Mutate(fsEntry *fsutil.Entry) error {
 	relPath, err := r.sanitizeAbsPath(fsEntry.Path, fsEntry.Mode.IsDir())
 	if err != nil {
@@ -92,6 +210,7 @@ func (r *Report) Mutate(fsEntry *fsutil.Entry) error {
 		return nil
 	}
 	entry.FinalHash = fsEntry.Hash
+	entry.FinalSHA512 = fsEntry.SHA512
 	entry.Size = fsEntry.Size
 	r.Entries[relPath] = entry
 	return nil
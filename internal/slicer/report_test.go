@@ -293,3 +293,26 @@ func (s *S) TestRootRelativePath(c *C) {
 	_, err := slicer.NewReport("../base/")
 	c.Assert(err, ErrorMatches, `cannot use relative path for report root: "../base/"`)
 }
+
+func (s *S) TestReportUsage(c *C) {
+	libSlice := &setup.Slice{Package: "libfoo", Name: "libs"}
+	report := &slicer.Report{Entries: map[string]slicer.ReportEntry{
+		"/dir/":       {Mode: fs.ModeDir | 0755, Slices: map[*setup.Slice]bool{oneSlice: true}},
+		"/file":       {Mode: 0644, Size: 100, Slices: map[*setup.Slice]bool{oneSlice: true}},
+		"/shared":     {Mode: 0644, Size: 10, Slices: map[*setup.Slice]bool{oneSlice: true, otherSlice: true, libSlice: true}},
+		"/link":       {Mode: fs.ModeSymlink | 0777, Size: 4, Link: "file", Slices: map[*setup.Slice]bool{otherSlice: true}},
+		"/hardlink":   {Mode: 0644, Size: 100, HardLink: "/file", Slices: map[*setup.Slice]bool{libSlice: true}},
+		"/pruned-doc": {Mode: 0644, Size: 1000, Pruned: true, Slices: map[*setup.Slice]bool{libSlice: true}},
+	}}
+	usage := report.Usage()
+	c.Assert(usage.Slices, DeepEquals, map[*setup.Slice]int64{
+		oneSlice:   110,
+		otherSlice: 14,
+		libSlice:   110,
+	})
+	c.Assert(usage.Packages, DeepEquals, map[string]int64{
+		"base-files": 114,
+		"libfoo":     110,
+	})
+	c.Assert(usage.Total, Equals, int64(114))
+}
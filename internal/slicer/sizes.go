@@ -0,0 +1,125 @@
+package slicer
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/deb"
+	"github.com/canonical/chisel/internal/fsutil"
+	"github.com/canonical/chisel/internal/setup"
+)
+
+// Sizes holds the estimated on-disk footprint of a selection of slices.
+type Sizes struct {
+	// Slices holds the estimated size in bytes of the content of each slice.
+	// Paths shared by several slices are accounted for in all of them.
+	Slices map[*setup.Slice]int64
+	// Packages holds the details of every package involved in the selection,
+	// as listed in the archive index.
+	Packages map[string]*archive.PackageInfo
+	// Total is the estimated size in bytes of the whole selection, with
+	// paths shared by several slices counted only once.
+	Total int64
+}
+
+// EstimateSizes computes the size of the content that would be written by
+// running the slicer with the provided options, without writing anything to
+// disk. The packages are still fetched so that the size of the individual
+// paths may be obtained from them. Content removed after mutation scripts run
+// is not accounted for, and neither are changes made by the scripts.
+func EstimateSizes(options *RunOptions) (*Sizes, error) {
+	extract, archives, err := prepareExtract(options)
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := &Sizes{
+		Slices:   make(map[*setup.Slice]int64),
+		Packages: make(map[string]*archive.PackageInfo),
+	}
+	seen := make(map[string]bool)
+	add := func(slice *setup.Slice, path string, size int64) {
+		sizes.Slices[slice] += size
+		if !seen[path] {
+			seen[path] = true
+			sizes.Total += size
+		}
+	}
+
+	// Nothing is written to disk, the creation of each entry only accounts for
+	// the size of its content.
+	create := func(extractInfos []deb.ExtractInfo, o *fsutil.CreateOptions) error {
+		if len(extractInfos) == 0 {
+			return nil
+		}
+		var size int64
+		if o.Mode&fs.ModeType == 0 && o.Data != nil {
+			n, err := io.Copy(io.Discard, o.Data)
+			if err != nil {
+				return err
+			}
+			size = n
+		}
+		for _, extractInfo := range extractInfos {
+			if extractInfo.Context == nil {
+				continue
+			}
+			slice, ok := extractInfo.Context.(*setup.Slice)
+			if !ok {
+				return fmt.Errorf("internal error: invalid Context of type %T in extractInfo", extractInfo.Context)
+			}
+			pathInfo, ok := slice.Contents[extractInfo.Path]
+			if !ok {
+				return fmt.Errorf("internal error: path %q not listed in slice contents", extractInfo.Path)
+			}
+			if pathInfo.Until != setup.UntilMutate {
+				add(slice, o.Path, size)
+			}
+		}
+		return nil
+	}
+
+	for _, slice := range options.Selection.Slices {
+		if _, ok := sizes.Packages[slice.Package]; ok {
+			continue
+		}
+		info, err := archive.Info(archives[slice.Package], slice.Package)
+		if err != nil {
+			return nil, err
+		}
+		sizes.Packages[slice.Package] = info
+		reader, err := archives[slice.Package].Fetch(slice.Package)
+		if err != nil {
+			return nil, err
+		}
+		err = deb.Extract(reader, &deb.ExtractOptions{
+			Package:   slice.Package,
+			Extract:   extract[slice.Package],
+			TargetDir: "/",
+			Create:    create,
+		})
+		reader.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Account for content not coming from packages.
+	for _, slice := range options.Selection.Slices {
+		arch := archives[slice.Package].Options().Arch
+		for relPath, pathInfo := range slice.Contents {
+			if len(pathInfo.Arch) > 0 && !slices.Contains(pathInfo.Arch, arch) {
+				continue
+			}
+			if pathInfo.Kind != setup.TextPath || pathInfo.Until == setup.UntilMutate {
+				continue
+			}
+			add(slice, relPath, int64(len(pathInfo.Info)))
+		}
+	}
+
+	return sizes, nil
+}
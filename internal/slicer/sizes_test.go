@@ -0,0 +1,178 @@
+package slicer_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/internal/slicer"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+type sizesTest struct {
+	summary string
+	arch    string
+	release map[string]string
+	slices  []setup.SliceKey
+	sizes   map[string]int64
+	total   int64
+	error   string
+}
+
+var sizesTests = []sizesTest{{
+	summary: "Sizes of copied, globbed and generated content",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+						/dir/file-copy:    {copy: /dir/file}
+						/dir/nested/**:
+						/other-dir/file:   {symlink: ../dir/file}
+						/other-dir/text:   {text: data1}
+						/other-dir/empty/: {make: true}
+		`,
+	},
+	sizes: map[string]int64{
+		"test-package_myslice": 14 + 14 + 5 + 1 + 5,
+	},
+	total: 14 + 14 + 5 + 1 + 5,
+}, {
+	summary: "Shared paths are counted once in the total",
+	slices:  []setup.SliceKey{{"test-package", "myslice1"}, {"test-package", "myslice2"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice1:
+					contents:
+						/dir/file:
+						/dir/other-file:
+				myslice2:
+					contents:
+						/dir/file:
+						/dir/several/levels/deep/file:
+		`,
+	},
+	sizes: map[string]int64{
+		"test-package_myslice1": 14 + 7,
+		"test-package_myslice2": 14 + 9,
+	},
+	total: 14 + 7 + 9,
+}, {
+	summary: "Content removed after mutation is not accounted for",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:       {until: mutate}
+						/dir/other-file:
+						/dir/text:       {text: foo, until: mutate}
+		`,
+	},
+	sizes: map[string]int64{
+		"test-package_myslice": 7,
+	},
+	total: 7,
+}, {
+	summary: "Paths restricted to other architectures are ignored",
+	arch:    "amd64",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:       {arch: amd64}
+						/dir/other-file: {arch: [i386, arm64]}
+						/dir/text:       {text: foo, arch: s390x}
+		`,
+	},
+	sizes: map[string]int64{
+		"test-package_myslice": 14,
+	},
+	total: 14,
+}, {
+	summary: "Missing content is reported",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/missing:
+		`,
+	},
+	error: `cannot extract from package "test-package": no content at /dir/missing`,
+}}
+
+func (s *S) TestEstimateSizes(c *C) {
+	for _, test := range sizesTests {
+		c.Logf("Summary: %s", test.summary)
+
+		if _, ok := test.release["chisel.yaml"]; !ok {
+			test.release["chisel.yaml"] = string(defaultChiselYaml)
+		}
+
+		releaseDir := c.MkDir()
+		for path, data := range test.release {
+			fpath := filepath.Join(releaseDir, path)
+			err := os.MkdirAll(filepath.Dir(fpath), 0755)
+			c.Assert(err, IsNil)
+			err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+			c.Assert(err, IsNil)
+		}
+
+		release, err := setup.ReadRelease(releaseDir)
+		c.Assert(err, IsNil)
+
+		selection, err := setup.Select(release, test.slices)
+		c.Assert(err, IsNil)
+
+		pkgs := map[string][]byte{
+			"test-package": testutil.PackageData["test-package"],
+		}
+		archives := map[string]archive.Archive{}
+		for name, setupArchive := range release.Archives {
+			archives[name] = &testArchive{
+				options: archive.Options{
+					Label:      setupArchive.Name,
+					Version:    setupArchive.Version,
+					Suites:     setupArchive.Suites,
+					Components: setupArchive.Components,
+					Arch:       test.arch,
+				},
+				pkgs: pkgs,
+			}
+		}
+
+		sizes, err := slicer.EstimateSizes(&slicer.RunOptions{
+			Selection: selection,
+			Archives:  archives,
+		})
+		if test.error != "" {
+			c.Assert(err, ErrorMatches, test.error)
+			continue
+		}
+		c.Assert(err, IsNil)
+
+		sliceSizes := make(map[string]int64)
+		for slice, size := range sizes.Slices {
+			sliceSizes[slice.String()] = size
+		}
+		c.Assert(sliceSizes, DeepEquals, test.sizes)
+		c.Assert(sizes.Total, Equals, test.total)
+		c.Assert(sizes.Packages["test-package"].Size, Equals, int64(len(pkgs["test-package"])))
+	}
+}
@@ -3,26 +3,126 @@ package slicer
 import (
 	"archive/tar"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"slices"
 	"sort"
 	"strings"
-	"syscall"
+	"time"
 
 	"github.com/canonical/chisel/internal/archive"
 	"github.com/canonical/chisel/internal/deb"
 	"github.com/canonical/chisel/internal/fsutil"
+	"github.com/canonical/chisel/internal/progress"
 	"github.com/canonical/chisel/internal/scripts"
 	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/internal/strdist"
 )
 
 type RunOptions struct {
 	Selection *setup.Selection
 	Archives  map[string]archive.Archive
 	TargetDir string
+	// Progress, if set, is notified about the progress of the extraction
+	// of each package.
+	Progress progress.Reporter
+	// Timestamp, if not zero, is the latest modification time given to the
+	// reported entries once the run is complete. Extracted entries keep the
+	// modification time from their package, and those modified after
+	// Timestamp, including all generated content, are set to Timestamp
+	// itself so that the result is reproducible.
+	Timestamp time.Time
+	// ManifestDirs lists additional directories, relative to TargetDir,
+	// where a manifest is generated as if they had been selected with
+	// "generate: manifest" paths.
+	ManifestDirs []string
+	// ManifestPaths lists files outside of TargetDir where a manifest is
+	// also written, such as next to the tree.
+	ManifestPaths []string
+	// NoTreeManifests prevents manifests from being written into
+	// TargetDir, including those of "generate: manifest" paths and of
+	// ManifestDirs, so that they are only written to ManifestPaths.
+	NoTreeManifests bool
+	// ChiselVersion, if set, is recorded in the generated manifests.
+	ChiselVersion string
+	// Exclude holds glob patterns for paths that must not be created in
+	// TargetDir even though they were selected. Excluded paths are recorded
+	// in the Excluded field of the report.
+	Exclude []string
+	// Include, if set, is called with every selected path that does not
+	// match Exclude, relative to TargetDir and with a trailing slash for
+	// directories. Paths for which it returns false are excluded as if
+	// they matched Exclude.
+	Include func(relPath string) bool
+	// AllowSpecial enables the creation of device nodes and FIFOs, and of
+	// files with the setuid or setgid bits set. Otherwise the former are
+	// skipped and the latter are created without those bits, and both are
+	// recorded in the Special field of the report. Creating device nodes
+	// requires privileges.
+	AllowSpecial bool
+	// DryRun prevents anything from being written to TargetDir, which
+	// need not exist. The report still holds every entry that would be
+	// created from packages or from slice definitions, with the hashes of
+	// their content, but mutation scripts are not run, "until: mutate"
+	// paths are kept, and no content is generated or pruned.
+	DryRun bool
+	// Sync flushes the content of every file created to stable storage
+	// before closing it, so that it survives a crash.
+	Sync bool
+	// SHA512 also records the SHA512 digest of regular files in the
+	// report, and of packages and paths in the generated manifests.
+	SHA512 bool
+	// Placeholders creates symlinks in TargetDir as empty regular files,
+	// while still reporting them as symlinks. This is only suitable for
+	// trees that are archived afterwards using the report, on systems
+	// which may not be able to create symlinks.
+	Placeholders bool
+	// Prune lists classes of paths, as returned by PruneClasses, which are
+	// removed from TargetDir once the mutation scripts have run. Pruned
+	// paths are still reported, and marked as such.
+	Prune []string
+	// Strip removes the content of the debug sections of the ELF
+	// executables and shared libraries in TargetDir once pruning is done,
+	// and records their final digests in the report.
+	Strip bool
+	// DebugDir, if set along with Strip, is where the debug information
+	// removed from each file is written, as a separate ELF file under
+	// /usr/lib/debug/, named after its GNU build ID when it has one. The
+	// files are reported in the Debug field of the report, and a manifest
+	// describing them is written into /var/lib/chisel/ within DebugDir,
+	// unless NoTreeManifests is set.
+	DebugDir string
+	// Dedupe, if set, is one of the modes returned by DedupeModes. With
+	// "hardlink", regular files with the same content, mode and extended
+	// attributes are hard linked together once pruning is done, and the
+	// HardLink field of their report entries is set.
+	Dedupe string
+	// BeforeExtract and AfterExtract, if set, are called before and after
+	// the content of each package is extracted into TargetDir. An error
+	// returned by either of them aborts the run.
+	BeforeExtract func(info *archive.PackageInfo) error
+	AfterExtract  func(info *archive.PackageInfo) error
+	// PathWritten, if set, is called after each path is written to
+	// TargetDir and added to the report, with its report entry so far.
+	// Paths written from packages are notified during the extraction of
+	// the package. An error returned by it aborts the run.
+	PathWritten func(entry ReportEntry) error
+	// PathCreated, if set, is called with the absolute path of every
+	// file or directory about to be created by the run that does not
+	// exist yet, including missing parent directories, which are notified
+	// first. Paths outside of TargetDir, such as those in DebugDir and
+	// ManifestPaths, are notified as well. An error returned by it aborts
+	// the run before the path is created.
+	PathCreated func(path string) error
+	// Context, if set, aborts the run once done, with its error. It is
+	// checked as packages are fetched and extracted, and before mutation
+	// scripts run and content is generated, leaving whatever was written
+	// to TargetDir so far.
+	Context context.Context
 }
 
 type pathData struct {
@@ -32,6 +132,11 @@ type pathData struct {
 
 type contentChecker struct {
 	knownPaths map[string]pathData
+	// slicePaths holds, for every known path, the names of the slices
+	// which declared it or a path within it.
+	slicePaths map[string]map[string]bool
+	// slice is the name of the slice whose mutation script is running.
+	slice string
 }
 
 func (cc *contentChecker) checkMutable(path string) error {
@@ -61,15 +166,37 @@ func (cc *contentChecker) checkKnown(path string) error {
 				err = fmt.Errorf("cannot read file which is not selected: %s", path)
 			}
 		}
+		return err
+	}
+	// Scripts may only read the paths of other slices which are mutable,
+	// as otherwise the result would depend on which slices are selected.
+	if path != "/" && !cc.slicePaths[path][cc.slice] && !cc.knownPaths[path].mutable {
+		if path[len(path)-1] == '/' {
+			err = fmt.Errorf("cannot list directory which is not declared by the slice: %s", path)
+		} else {
+			err = fmt.Errorf("cannot read file which is not declared by the slice: %s", path)
+		}
 	}
 	return err
 }
 
 func Run(options *RunOptions) (*Report, error) {
-	oldUmask := syscall.Umask(0)
-	defer func() {
-		syscall.Umask(oldUmask)
-	}()
+	for _, pattern := range options.Exclude {
+		if !strings.HasPrefix(pattern, "/") {
+			return nil, fmt.Errorf("invalid exclude pattern %q: must be an absolute path", pattern)
+		}
+	}
+	for _, class := range options.Prune {
+		if _, ok := pruneClasses[class]; !ok {
+			return nil, fmt.Errorf("invalid prune class %q, must be one of: %s", class, strings.Join(PruneClasses(), ", "))
+		}
+	}
+	if options.DebugDir != "" && !options.Strip {
+		return nil, fmt.Errorf("cannot write debug information without stripping")
+	}
+	if options.Dedupe != "" && !slices.Contains(dedupeModes, options.Dedupe) {
+		return nil, fmt.Errorf("invalid dedupe mode %q, must be one of: %s", options.Dedupe, strings.Join(DedupeModes(), ", "))
+	}
 
 	targetDir := filepath.Clean(options.TargetDir)
 	if !filepath.IsAbs(targetDir) {
@@ -80,67 +207,26 @@ func Run(options *RunOptions) (*Report, error) {
 		targetDir = filepath.Join(dir, targetDir)
 	}
 
-	// Build information to process the selection.
-	extract := make(map[string]map[string][]deb.ExtractInfo)
-	archives := make(map[string]archive.Archive)
-	for _, slice := range options.Selection.Slices {
-		extractPackage := extract[slice.Package]
-		if extractPackage == nil {
-			archiveName := options.Selection.Release.Packages[slice.Package].Archive
-			archive := options.Archives[archiveName]
-			if archive == nil {
-				return nil, fmt.Errorf("archive %q not defined", archiveName)
-			}
-			if !archive.Exists(slice.Package) {
-				return nil, fmt.Errorf("slice package %q missing from archive", slice.Package)
-			}
-			archives[slice.Package] = archive
-			extractPackage = make(map[string][]deb.ExtractInfo)
-			extract[slice.Package] = extractPackage
-		}
-		arch := archives[slice.Package].Options().Arch
-		copyrightPath := "/usr/share/doc/" + slice.Package + "/copyright"
-		hasCopyright := false
-		for targetPath, pathInfo := range slice.Contents {
-			if targetPath == "" {
-				continue
-			}
-			if len(pathInfo.Arch) > 0 && !slices.Contains(pathInfo.Arch, arch) {
-				continue
-			}
+	var creator fsutil.Creator = &fsutil.DiskCreator{Sync: options.Sync, SHA512: options.SHA512, Placeholders: options.Placeholders}
+	extractDir := targetDir
+	if options.DryRun {
+		creator = &fsutil.DryRunCreator{SHA512: options.SHA512}
+		// Paths are extracted relative to the host root, which exists,
+		// and reported under targetDir.
+		extractDir = "/"
+	} else if options.PathCreated != nil {
+		creator = &trackingCreator{Creator: creator, options: options}
+	}
 
-			if pathInfo.Kind == setup.CopyPath || pathInfo.Kind == setup.GlobPath {
-				sourcePath := pathInfo.Info
-				if sourcePath == "" {
-					sourcePath = targetPath
-				}
-				extractPackage[sourcePath] = append(extractPackage[sourcePath], deb.ExtractInfo{
-					Path:    targetPath,
-					Context: slice,
-				})
-				if sourcePath == copyrightPath && targetPath == copyrightPath {
-					hasCopyright = true
-				}
-			} else {
-				// When the content is not extracted from the package (i.e. path is
-				// not glob or copy), we add a ExtractInfo for the parent directory
-				// to preserve the permissions from the tarball where possible.
-				targetDir := filepath.Dir(strings.TrimRight(targetPath, "/")) + "/"
-				if targetDir == "" || targetDir == "/" {
-					continue
-				}
-				extractPackage[targetDir] = append(extractPackage[targetDir], deb.ExtractInfo{
-					Path:     targetDir,
-					Optional: true,
-				})
-			}
-		}
-		if !hasCopyright {
-			extractPackage[copyrightPath] = append(extractPackage[copyrightPath], deb.ExtractInfo{
-				Path:     copyrightPath,
-				Optional: true,
-			})
-		}
+	ctx := options.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	// Build information to process the selection.
+	extract, archives, err := prepareExtract(options)
+	if err != nil {
+		return nil, err
 	}
 
 	// Fetch all packages, using the selection order.
@@ -149,6 +235,9 @@ func Run(options *RunOptions) (*Report, error) {
 		if packages[slice.Package] != nil {
 			continue
 		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		reader, err := archives[slice.Package].Fetch(slice.Package)
 		if err != nil {
 			return nil, err
@@ -161,30 +250,79 @@ func Run(options *RunOptions) (*Report, error) {
 	// listed as until: mutate in all the slices that reference them.
 	knownPaths := map[string]pathData{}
 	addKnownPath(knownPaths, "/", pathData{})
+	slicePaths := map[string]map[string]bool{}
 
 	report, err := NewReport(targetDir)
 	if err != nil {
 		return nil, fmt.Errorf("internal error: cannot create report: %w", err)
 	}
 
+	// Records the package which extracted each path listed in the slice
+	// contents, so that paths extracted from several packages may be
+	// verified to have the same content.
+	extracted := make(map[string]extractedPath)
+
+	// Records the mode of the parent directories created from packages
+	// without being listed in the slice contents, so they may be reported
+	// as implicit entries.
+	dirModes := make(map[string]fs.FileMode)
+
+	pathWritten := func(relPath string) error {
+		if options.PathWritten == nil {
+			return nil
+		}
+		return options.PathWritten(report.Entries[relPath])
+	}
+
 	// Creates the filesystem entry and adds it to the report. It also updates
 	// knownPaths with the files created.
 	create := func(extractInfos []deb.ExtractInfo, o *fsutil.CreateOptions) error {
-		entry, err := fsutil.Create(o)
+		relPath := filepath.Clean("/" + strings.TrimPrefix(o.Path, extractDir))
+		if o.Mode.IsDir() {
+			relPath = relPath + "/"
+		}
+		if options.DryRun {
+			o.Path = filepath.Join(targetDir, relPath)
+		}
+		if options.excludes(relPath) {
+			if len(extractInfos) > 0 {
+				debugf("Excluding path: %s", relPath)
+				report.Exclude(relPath)
+			}
+			return nil
+		}
+
+		if !options.AllowSpecial && len(extractInfos) > 0 {
+			switch {
+			case o.Mode&(fs.ModeDevice|fs.ModeNamedPipe) != 0:
+				logf("Warning: skipping special file %s (mode %s)", relPath, o.Mode)
+				report.AddSpecial(relPath, o.Mode)
+				return nil
+			case o.Mode.IsRegular() && o.Mode&(fs.ModeSetuid|fs.ModeSetgid) != 0:
+				logf("Warning: dropping setuid and setgid bits of %s (mode %s)", relPath, o.Mode)
+				report.AddSpecial(relPath, o.Mode)
+				o.Mode &^= fs.ModeSetuid | fs.ModeSetgid
+			}
+		}
+
+		if !options.Timestamp.IsZero() && o.MTime.After(options.Timestamp) {
+			o.MTime = options.Timestamp
+		}
+		entry, err := creator.Create(o)
 		if err != nil {
 			return err
 		}
 		// Content created was not listed in a slice contents because extractInfo
 		// is empty.
 		if len(extractInfos) == 0 {
+			if o.Mode.IsDir() {
+				dirModes[relPath] = o.Mode
+			}
 			return nil
 		}
 
-		relPath := filepath.Clean("/" + strings.TrimPrefix(o.Path, targetDir))
-		if o.Mode.IsDir() {
-			relPath = relPath + "/"
-		}
 		inSliceContents := false
+		reported := false
 		until := setup.UntilMutate
 		mutable := false
 		for _, extractInfo := range extractInfos {
@@ -195,11 +333,26 @@ func Run(options *RunOptions) (*Report, error) {
 			if !ok {
 				return fmt.Errorf("internal error: invalid Context of type %T in extractInfo", extractInfo.Context)
 			}
+			if !inSliceContents && !o.Mode.IsDir() {
+				// The mode of the entry is the one requested, as the mode
+				// of existing files is not changed when overwriting them.
+				current := extractedPath{slice.Package, *entry}
+				current.entry.Mode = o.Mode
+				if previous, ok := extracted[relPath]; !ok {
+					extracted[relPath] = current
+				} else if previous.pkg != slice.Package {
+					err := checkSameContent(relPath, &previous, &current)
+					if err != nil {
+						return err
+					}
+				}
+			}
 			pathInfo, ok := slice.Contents[extractInfo.Path]
 			if !ok {
 				return fmt.Errorf("internal error: path %q not listed in slice contents", extractInfo.Path)
 			}
 			inSliceContents = true
+			addSlicePath(slicePaths, slice, relPath)
 			mutable = mutable || pathInfo.Mutable
 			if pathInfo.Until == setup.UntilNone {
 				until = setup.UntilNone
@@ -210,6 +363,7 @@ func Run(options *RunOptions) (*Report, error) {
 				if err != nil {
 					return err
 				}
+				reported = true
 			}
 		}
 
@@ -217,26 +371,60 @@ func Run(options *RunOptions) (*Report, error) {
 			data := pathData{mutable: mutable, until: until}
 			addKnownPath(knownPaths, relPath, data)
 		}
+		if reported {
+			return pathWritten(relPath)
+		}
 		return nil
 	}
 
+	reporter := options.Progress
+	if reporter == nil {
+		reporter = progress.Null
+	}
+
 	// Extract all packages, also using the selection order.
+	pkgInfos := make(map[string]*archive.PackageInfo)
 	for _, slice := range options.Selection.Slices {
 		reader := packages[slice.Package]
 		if reader == nil {
 			continue
 		}
-		err := deb.Extract(reader, &deb.ExtractOptions{
+		// Archives which cannot tell package details only have them named.
+		info := &archive.PackageInfo{Name: slice.Package}
+		if informer, ok := archives[slice.Package].(archive.PackageInformer); ok {
+			info, err = informer.Info(slice.Package)
+			if err != nil {
+				return nil, err
+			}
+		}
+		pkgInfos[slice.Package] = info
+		if options.BeforeExtract != nil {
+			err = options.BeforeExtract(info)
+			if err != nil {
+				return nil, err
+			}
+		}
+		task := reporter.Start("Extracting "+slice.Package, info.Size)
+		err = deb.Extract(progress.Reader(&contextReader{ctx: ctx, inner: reader}, task), &deb.ExtractOptions{
 			Package:   slice.Package,
 			Extract:   extract[slice.Package],
-			TargetDir: targetDir,
+			TargetDir: extractDir,
 			Create:    create,
+			// Package modification times are only kept to be clamped.
+			KeepMTimes: !options.Timestamp.IsZero(),
 		})
+		task.Done()
 		reader.Close()
 		packages[slice.Package] = nil
 		if err != nil {
 			return nil, err
 		}
+		if options.AfterExtract != nil {
+			err = options.AfterExtract(info)
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	// Create new content not coming from packages.
@@ -247,7 +435,22 @@ func Run(options *RunOptions) (*Report, error) {
 			if len(pathInfo.Arch) > 0 && !slices.Contains(pathInfo.Arch, arch) {
 				continue
 			}
-			if done[relPath] || pathInfo.Kind == setup.CopyPath || pathInfo.Kind == setup.GlobPath {
+			if pathInfo.Kind == setup.CopyPath || pathInfo.Kind == setup.GlobPath {
+				continue
+			}
+			if pathInfo.Kind == setup.GeneratePath {
+				relPath = strings.TrimSuffix(relPath, "**")
+			}
+			if options.excludes(relPath) {
+				if !done[relPath] {
+					debugf("Excluding path: %s", relPath)
+					report.Exclude(relPath)
+				}
+				done[relPath] = true
+				continue
+			}
+			addSlicePath(slicePaths, slice, relPath)
+			if done[relPath] {
 				continue
 			}
 			done[relPath] = true
@@ -257,7 +460,7 @@ func Run(options *RunOptions) (*Report, error) {
 			}
 			addKnownPath(knownPaths, relPath, data)
 			targetPath := filepath.Join(targetDir, relPath)
-			entry, err := createFile(targetPath, pathInfo)
+			entry, err := createFile(creator, targetPath, pathInfo, options.Timestamp)
 			if err != nil {
 				return nil, err
 			}
@@ -268,20 +471,35 @@ func Run(options *RunOptions) (*Report, error) {
 				if err != nil {
 					return nil, err
 				}
+				err = pathWritten(relPath)
+				if err != nil {
+					return nil, err
+				}
 			}
 		}
 	}
 
+	report.AddImplicit(dirModes)
+
+	if options.DryRun {
+		return report, nil
+	}
+
 	// Run mutation scripts. Order is fundamental here as
 	// dependencies must run before dependents.
-	checker := contentChecker{knownPaths}
+	checker := contentChecker{knownPaths: knownPaths, slicePaths: slicePaths}
 	content := &scripts.ContentValue{
 		RootDir:    targetDir,
 		CheckWrite: checker.checkMutable,
 		CheckRead:  checker.checkKnown,
 		OnWrite:    report.Mutate,
+		Creator:    creator,
 	}
 	for _, slice := range options.Selection.Slices {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		checker.slice = slice.String()
 		opts := scripts.RunOptions{
 			Label:  "mutate",
 			Script: slice.Scripts.Mutate,
@@ -300,9 +518,197 @@ func Run(options *RunOptions) (*Report, error) {
 		return nil, err
 	}
 
+	err = prune(targetDir, report, options.Prune)
+	if err != nil {
+		return nil, err
+	}
+
+	var debugCreator fsutil.Creator
+	if options.DebugDir != "" {
+		debugDir, err := filepath.Abs(options.DebugDir)
+		if err != nil {
+			return nil, fmt.Errorf("cannot obtain debug directory: %w", err)
+		}
+		report.Debug, err = NewReport(debugDir)
+		if err != nil {
+			return nil, fmt.Errorf("internal error: cannot create report: %w", err)
+		}
+		debugCreator = &fsutil.DiskCreator{Sync: options.Sync, SHA512: options.SHA512}
+		if options.PathCreated != nil {
+			debugCreator = &trackingCreator{Creator: debugCreator, options: options}
+		}
+	}
+
+	if options.Strip {
+		err = stripFiles(targetDir, report, creator, report.Debug, debugCreator)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if options.Dedupe != "" {
+		err = dedupe(targetDir, report)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	err = runGenerators(targetDir, options, report, pkgInfos)
+	if err != nil {
+		return nil, err
+	}
+
+	if report.Debug != nil && !options.NoTreeManifests {
+		err = writeDebugManifest(options, report.Debug, pkgInfos)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !options.Timestamp.IsZero() {
+		err = clampMTimes(report, options.Timestamp)
+		if err == nil && report.Debug != nil {
+			err = clampMTimes(report.Debug, options.Timestamp)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return report, nil
 }
 
+// contextReader fails once ctx is done, so that the extraction of a package
+// stops promptly when the run is cancelled.
+type contextReader struct {
+	ctx   context.Context
+	inner io.Reader
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.inner.Read(p)
+}
+
+// prepareExtract computes, for every package in the selection, the paths
+// that must be extracted from it. It also returns the archive from which
+// each package must be obtained.
+func prepareExtract(options *RunOptions) (map[string]map[string][]deb.ExtractInfo, map[string]archive.Archive, error) {
+	extract := make(map[string]map[string][]deb.ExtractInfo)
+	archives := make(map[string]archive.Archive)
+	for _, slice := range options.Selection.Slices {
+		extractPackage := extract[slice.Package]
+		if extractPackage == nil {
+			archiveName := options.Selection.Release.Packages[slice.Package].Archive
+			archive := options.Archives[archiveName]
+			if archive == nil {
+				return nil, nil, fmt.Errorf("archive %q not defined", archiveName)
+			}
+			if !archive.Exists(slice.Package) {
+				return nil, nil, fmt.Errorf("slice package %q missing from archive", slice.Package)
+			}
+			archives[slice.Package] = archive
+			extractPackage = make(map[string][]deb.ExtractInfo)
+			extract[slice.Package] = extractPackage
+		}
+		arch := archives[slice.Package].Options().Arch
+		copyrightPath := "/usr/share/doc/" + slice.Package + "/copyright"
+		hasCopyright := false
+		for targetPath, pathInfo := range slice.Contents {
+			if targetPath == "" {
+				continue
+			}
+			if len(pathInfo.Arch) > 0 && !slices.Contains(pathInfo.Arch, arch) {
+				continue
+			}
+
+			if pathInfo.Kind == setup.CopyPath || pathInfo.Kind == setup.GlobPath {
+				sourcePath := pathInfo.Info
+				if sourcePath == "" {
+					sourcePath = targetPath
+				}
+				extractPackage[sourcePath] = append(extractPackage[sourcePath], deb.ExtractInfo{
+					Path:    targetPath,
+					Context: slice,
+				})
+				if sourcePath == copyrightPath && targetPath == copyrightPath {
+					hasCopyright = true
+				}
+			} else {
+				// When the content is not extracted from the package (i.e. path is
+				// not glob or copy), we add a ExtractInfo for the parent directory
+				// to preserve the permissions from the tarball where possible.
+				if pathInfo.Kind == setup.GeneratePath {
+					targetPath = strings.TrimSuffix(targetPath, "**")
+				}
+				targetDir := filepath.Dir(strings.TrimRight(targetPath, "/")) + "/"
+				if targetDir == "" || targetDir == "/" {
+					continue
+				}
+				extractPackage[targetDir] = append(extractPackage[targetDir], deb.ExtractInfo{
+					Path:     targetDir,
+					Optional: true,
+				})
+			}
+		}
+		if !hasCopyright {
+			extractPackage[copyrightPath] = append(extractPackage[copyrightPath], deb.ExtractInfo{
+				Path:     copyrightPath,
+				Optional: true,
+			})
+		}
+	}
+	return extract, archives, nil
+}
+
+// excludes returns whether relPath must not be created in TargetDir, as it
+// either matches Exclude or is not accepted by Include.
+func (o *RunOptions) excludes(relPath string) bool {
+	if isExcluded(o.Exclude, relPath) {
+		return true
+	}
+	return o.Include != nil && !o.Include(relPath)
+}
+
+// isExcluded returns whether relPath matches any of the provided patterns.
+func isExcluded(patterns []string, relPath string) bool {
+	for _, pattern := range patterns {
+		if strdist.GlobPath(pattern, relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// extractedPath records the package which extracted a path listed in the
+// slice contents, and the entry it created.
+type extractedPath struct {
+	pkg   string
+	entry fsutil.Entry
+}
+
+// checkSameContent returns an error describing the differences between the
+// entries extracted for relPath from two different packages, if any.
+func checkSameContent(relPath string, a, b *extractedPath) error {
+	describe := func(e *fsutil.Entry) string {
+		if e.Mode&fs.ModeSymlink != 0 {
+			return fmt.Sprintf("symlink %s", e.Link)
+		}
+		return fmt.Sprintf("file 0%o size %d sha256 %s", unixPerm(e.Mode), e.Size, e.Hash)
+	}
+	descA, descB := describe(&a.entry), describe(&b.entry)
+	if descA == descB {
+		return nil
+	}
+	return fmt.Errorf("path %s has diverging content in packages %s and %s:\n--- %s\n+++ %s\n-%s\n+%s",
+		relPath, a.pkg, b.pkg, a.pkg, b.pkg, descA, descB)
+}
+
 // removeAfterMutate removes entries marked with until: mutate. A path is marked
 // only when all slices that refer to the path mark it with until: mutate.
 func removeAfterMutate(rootDir string, knownPaths map[string]pathData) error {
@@ -362,10 +768,97 @@ func addKnownPath(knownPaths map[string]pathData, path string, data pathData) {
 	}
 }
 
-func createFile(targetPath string, pathInfo setup.PathInfo) (*fsutil.Entry, error) {
+// addSlicePath records that slice declared path, which allows its mutation
+// script to read path and to list its parent directories.
+func addSlicePath(slicePaths map[string]map[string]bool, slice *setup.Slice, path string) {
+	cleanPath := filepath.Clean(path)
+	slashPath := cleanPath
+	if strings.HasSuffix(path, "/") && cleanPath != "/" {
+		slashPath += "/"
+	}
+	name := slice.String()
+	for cleanPath != "/" {
+		if slicePaths[slashPath] == nil {
+			slicePaths[slashPath] = make(map[string]bool)
+		}
+		slicePaths[slashPath][name] = true
+		cleanPath = filepath.Dir(cleanPath)
+		slashPath = cleanPath + "/"
+	}
+}
+
+// clampMTimes sets the modification time of every entry in report which is
+// later than timestamp to timestamp itself, leaving alone the root and any
+// other content which was not cut. Directories need this to happen after all
+// the content is in place, as creating or removing entries within them
+// changes their modification time.
+func clampMTimes(report *Report, timestamp time.Time) error {
+	for relPath := range report.Entries {
+		path := filepath.Join(report.Root, relPath)
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			// Pruned or removed after mutate.
+			continue
+		} else if err != nil {
+			return err
+		}
+		if !info.ModTime().After(timestamp) {
+			continue
+		}
+		err = fsutil.SetMTime(path, timestamp)
+		if err != nil {
+			return fmt.Errorf("cannot set modification time: %w", err)
+		}
+	}
+	return nil
+}
+
+// trackingCreator notifies options.PathCreated about the paths that are
+// about to be created before creating them.
+type trackingCreator struct {
+	fsutil.Creator
+	options *RunOptions
+}
+
+func (tc *trackingCreator) Create(o *fsutil.CreateOptions) (*fsutil.Entry, error) {
+	err := trackCreated(tc.options, o.Path)
+	if err != nil {
+		return nil, err
+	}
+	return tc.Creator.Create(o)
+}
+
+// trackCreated calls options.PathCreated, if set, with path and then each
+// of its parent directories that do not exist yet, parents first.
+func trackCreated(options *RunOptions, path string) error {
+	if options.PathCreated == nil {
+		return nil
+	}
+	var missing []string
+	for path = filepath.Clean(path); ; path = filepath.Dir(path) {
+		_, err := os.Lstat(path)
+		if !os.IsNotExist(err) {
+			// Other errors are left to the creation of the path.
+			break
+		}
+		missing = append(missing, path)
+		if filepath.Dir(path) == path {
+			break
+		}
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		err := options.PathCreated(missing[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createFile(creator fsutil.Creator, targetPath string, pathInfo setup.PathInfo, mtime time.Time) (*fsutil.Entry, error) {
 	targetMode := pathInfo.Mode
 	if targetMode == 0 {
-		if pathInfo.Kind == setup.DirPath {
+		if pathInfo.Kind == setup.DirPath || pathInfo.Kind == setup.GeneratePath {
 			targetMode = 0755
 		} else {
 			targetMode = 0644
@@ -380,7 +873,7 @@ func createFile(targetPath string, pathInfo setup.PathInfo) (*fsutil.Entry, erro
 	case setup.TextPath:
 		tarHeader.Typeflag = tar.TypeReg
 		fileContent = bytes.NewBufferString(pathInfo.Info)
-	case setup.DirPath:
+	case setup.DirPath, setup.GeneratePath:
 		tarHeader.Typeflag = tar.TypeDir
 	case setup.SymlinkPath:
 		tarHeader.Typeflag = tar.TypeSymlink
@@ -389,11 +882,12 @@ func createFile(targetPath string, pathInfo setup.PathInfo) (*fsutil.Entry, erro
 		return nil, fmt.Errorf("internal error: cannot extract path of kind %q", pathInfo.Kind)
 	}
 
-	return fsutil.Create(&fsutil.CreateOptions{
+	return creator.Create(&fsutil.CreateOptions{
 		Path:        targetPath,
 		Mode:        tarHeader.FileInfo().Mode(),
 		Data:        fileContent,
 		Link:        linkTarget,
 		MakeParents: true,
+		MTime:       mtime,
 	})
 }
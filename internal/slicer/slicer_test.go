@@ -3,6 +3,10 @@ package slicer_test
 import (
 	"archive/tar"
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
@@ -10,10 +14,15 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
 	. "gopkg.in/check.v1"
 
 	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/control"
+	"github.com/canonical/chisel/internal/fsutil"
+	"github.com/canonical/chisel/internal/manifest"
+	"github.com/canonical/chisel/internal/progress"
 	"github.com/canonical/chisel/internal/setup"
 	"github.com/canonical/chisel/internal/slicer"
 	"github.com/canonical/chisel/internal/testutil"
@@ -32,7 +41,11 @@ type slicerTest struct {
 	hackopt    func(c *C, opts *slicer.RunOptions)
 	filesystem map[string]string
 	report     map[string]string
+	excluded   []string
+	special    []string
 	error      string
+
+	conflictPolicy setup.ConflictPolicy
 }
 
 var packageEntries = map[string][]testutil.TarEntry{
@@ -579,6 +592,21 @@ var slicerTests = []slicerTest{{
 	error: `slice test-package_myslice2: cannot read file which is not selected: /dir/text-file`,
 }, {
 	summary: "Script: can read globbed content",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/nested/fil*:
+					mutate: |
+						content.read("/dir/nested/file")
+						content.list("/dir/nested")
+		`,
+	},
+}, {
+	summary: "Script: cannot read content of other slices",
 	slices:  []setup.SliceKey{{"test-package", "myslice1"}, {"test-package", "myslice2"}},
 	release: map[string]string{
 		"slices/mydir/test-package.yaml": `
@@ -592,6 +620,51 @@ var slicerTests = []slicerTest{{
 						content.read("/dir/nested/file")
 		`,
 	},
+	error: `slice test-package_myslice2: cannot read file which is not declared by the slice: /dir/nested/file`,
+}, {
+	summary: "Script: cannot list directories of other slices",
+	slices:  []setup.SliceKey{{"test-package", "myslice1"}, {"test-package", "myslice2"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice1:
+					contents:
+						/dir/nested/fil*:
+				myslice2:
+					contents:
+						/dir/text-file: {text: data1}
+					mutate: |
+						content.list("/dir")
+						content.list("/dir/nested")
+		`,
+	},
+	error: `slice test-package_myslice2: cannot list directory which is not declared by the slice: /dir/nested/`,
+}, {
+	summary: "Script: can read mutable content of other slices",
+	slices:  []setup.SliceKey{{"test-package", "myslice1"}, {"test-package", "myslice2"}},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice1:
+					contents:
+						/dir/text-file: {text: data1, mutable: true}
+				myslice2:
+					essential:
+						- test-package_myslice1
+					mutate: |
+						data = content.read("/dir/text-file")
+						content.write("/dir/text-file", data + "2")
+		`,
+	},
+	filesystem: map[string]string{
+		"/dir/":          "dir 0755",
+		"/dir/text-file": "file 0644 f4c7ef27",
+	},
+	report: map[string]string{
+		"/dir/text-file": "file 0644 5b41362b f4c7ef27 {test-package_myslice1}",
+	},
 }, {
 	summary: "Relative content root directory must not error",
 	slices:  []setup.SliceKey{{"test-package", "myslice"}},
@@ -895,7 +968,7 @@ var slicerTests = []slicerTest{{
 					contents:
 						/dir/nested/**: {until: mutate}
 					mutate: |
-						content.read("/dir/file")
+						content.read("/dir/nested/file")
 		`,
 	},
 	filesystem: map[string]string{
@@ -1051,170 +1124,1731 @@ var slicerTests = []slicerTest{{
 						content.list("/foo-bar/")
 		`,
 	},
-}}
-
-var defaultChiselYaml = `
-	format: chisel-v1
-	archives:
-		ubuntu:
-			version: 22.04
-			components: [main, universe]
-			v1-public-keys: [test-key]
-	v1-public-keys:
-		test-key:
-			id: ` + testKey.ID + `
-			armor: |` + "\n" + testutil.PrefixEachLine(testKey.PubKeyArmor, "\t\t\t\t\t\t") + `
-`
-
-type testArchive struct {
-	options archive.Options
-	pkgs    map[string][]byte
-}
-
-func (a *testArchive) Options() *archive.Options {
-	return &a.options
-}
-
-func (a *testArchive) Fetch(pkg string) (io.ReadCloser, error) {
-	if data, ok := a.pkgs[pkg]; ok {
-		return io.NopCloser(bytes.NewBuffer(data)), nil
-	}
-	return nil, fmt.Errorf("attempted to open %q package", pkg)
-}
-
-func (a *testArchive) Exists(pkg string) bool {
-	_, ok := a.pkgs[pkg]
-	return ok
-}
-
-func (s *S) TestRun(c *C) {
-	// Run tests for format chisel-v1.
-	runSlicerTests(c, slicerTests)
-
-	// Run tests for format v1.
-	v1SlicerTests := make([]slicerTest, len(slicerTests))
-	for i, t := range slicerTests {
-		t.error = strings.Replace(t.error, "chisel-v1", "v1", -1)
-		t.error = strings.Replace(t.error, "v1-public-keys", "public-keys", -1)
-		m := map[string]string{}
-		for k, v := range t.release {
-			v = strings.Replace(v, "chisel-v1", "v1", -1)
-			v = strings.Replace(v, "v1-public-keys", "public-keys", -1)
-			m[k] = v
-		}
-		t.release = m
-		v1SlicerTests[i] = t
-	}
-	runSlicerTests(c, v1SlicerTests)
-}
-
-func runSlicerTests(c *C, tests []slicerTest) {
-	for _, test := range tests {
-		for _, slices := range testutil.Permutations(test.slices) {
-			c.Logf("Summary: %s", test.summary)
-
-			if _, ok := test.release["chisel.yaml"]; !ok {
-				test.release["chisel.yaml"] = string(defaultChiselYaml)
-			}
-
-			if test.pkgs == nil {
-				test.pkgs = map[string][]byte{
-					"test-package": testutil.PackageData["test-package"],
-				}
-			}
-
-			releaseDir := c.MkDir()
-			for path, data := range test.release {
-				fpath := filepath.Join(releaseDir, path)
-				err := os.MkdirAll(filepath.Dir(fpath), 0755)
-				c.Assert(err, IsNil)
-				err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
-				c.Assert(err, IsNil)
-			}
-
-			release, err := setup.ReadRelease(releaseDir)
-			c.Assert(err, IsNil)
-
-			selection, err := setup.Select(release, slices)
-			c.Assert(err, IsNil)
-
-			archives := map[string]archive.Archive{}
-			for name, setupArchive := range release.Archives {
-				archive := &testArchive{
-					options: archive.Options{
-						Label:      setupArchive.Name,
-						Version:    setupArchive.Version,
-						Suites:     setupArchive.Suites,
-						Components: setupArchive.Components,
-						Arch:       test.arch,
-					},
-					pkgs: test.pkgs,
-				}
-				archives[name] = archive
-			}
-
-			targetDir := c.MkDir()
-			options := slicer.RunOptions{
-				Selection: selection,
-				Archives:  archives,
-				TargetDir: targetDir,
-			}
-			if test.hackopt != nil {
-				test.hackopt(c, &options)
-			}
-			report, err := slicer.Run(&options)
-			if test.error == "" {
-				c.Assert(err, IsNil)
-			} else {
-				c.Assert(err, ErrorMatches, test.error)
-				continue
-			}
-
-			if test.filesystem != nil {
-				c.Assert(testutil.TreeDump(targetDir), DeepEquals, test.filesystem)
-			}
-
-			if test.report != nil {
-				c.Assert(treeDumpReport(report), DeepEquals, test.report)
-			}
-		}
-	}
-}
-
-// treeDumpReport returns the file information in the same format as
-// [testutil.TreeDump] with the added slices that have installed each path.
-func treeDumpReport(report *slicer.Report) map[string]string {
-	result := make(map[string]string)
-	for _, entry := range report.Entries {
-		fperm := entry.Mode.Perm()
-		if entry.Mode&fs.ModeSticky != 0 {
-			fperm |= 01000
-		}
-		var fsDump string
-		switch entry.Mode.Type() {
-		case fs.ModeDir:
-			fsDump = fmt.Sprintf("dir %#o", fperm)
-		case fs.ModeSymlink:
-			fsDump = fmt.Sprintf("symlink %s", entry.Link)
-		case 0: // Regular
-			if entry.Size == 0 {
-				fsDump = fmt.Sprintf("file %#o empty", entry.Mode.Perm())
-			} else if entry.FinalHash != "" {
-				fsDump = fmt.Sprintf("file %#o %s %s", fperm, entry.Hash[:8], entry.FinalHash[:8])
-			} else {
-				fsDump = fmt.Sprintf("file %#o %s", fperm, entry.Hash[:8])
-			}
-		default:
-			panic(fmt.Errorf("unknown file type %d: %s", entry.Mode.Type(), entry.Path))
+}, {
+	summary: "Archives need not tell package details",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		for name, a := range opts.Archives {
+			opts.Archives[name] = struct{ archive.Archive }{a}
 		}
-
-		// append {slice1, ..., sliceN} to the end of the entry dump.
-		slicesStr := make([]string, 0, len(entry.Slices))
-		for slice := range entry.Slices {
-			slicesStr = append(slicesStr, slice.String())
+	},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+		`,
+	},
+	filesystem: map[string]string{
+		"/dir/":     "dir 0755",
+		"/dir/file": "file 0644 cc55e2ec",
+	},
+}, {
+	summary: "Excluded paths are not created",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.Exclude = []string{"/dir/nested/**", "/dir/*-file", "/other-dir/link"}
+	},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+						/dir/other-file:
+						/dir/nested/**:
+						/dir/text-file:  {text: data1}
+						/other-dir/link: {symlink: ../dir/file}
+		`,
+	},
+	filesystem: map[string]string{
+		"/dir/":       "dir 0755",
+		"/dir/file":   "file 0644 cc55e2ec",
+		"/other-dir/": "dir 0755",
+	},
+	report: map[string]string{
+		"/dir/file": "file 0644 cc55e2ec {test-package_myslice}",
+	},
+	excluded: []string{
+		"/dir/nested/",
+		"/dir/nested/file",
+		"/dir/nested/other-file",
+		"/dir/other-file",
+		"/dir/text-file",
+		"/other-dir/link",
+	},
+}, {
+	summary: "Paths not included are not created",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.Exclude = []string{"/dir/other-file"}
+		opts.Include = func(relPath string) bool {
+			return !strings.HasPrefix(relPath, "/dir/nested/") && relPath != "/other-dir/link"
 		}
-		sort.Strings(slicesStr)
-		result[entry.Path] = fmt.Sprintf("%s {%s}", fsDump, strings.Join(slicesStr, ","))
+	},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+						/dir/other-file:
+						/dir/nested/**:
+						/dir/text-file:  {text: data1}
+						/other-dir/link: {symlink: ../dir/file}
+		`,
+	},
+	filesystem: map[string]string{
+		"/dir/":          "dir 0755",
+		"/dir/file":      "file 0644 cc55e2ec",
+		"/dir/text-file": "file 0644 5b41362b",
+		"/other-dir/":    "dir 0755",
+	},
+	report: map[string]string{
+		"/dir/file":      "file 0644 cc55e2ec {test-package_myslice}",
+		"/dir/text-file": "file 0644 5b41362b {test-package_myslice}",
+	},
+	excluded: []string{
+		"/dir/nested/",
+		"/dir/nested/file",
+		"/dir/nested/other-file",
+		"/dir/other-file",
+		"/other-dir/link",
+	},
+}, {
+	summary: "Pruned paths are removed once cut",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: map[string][]byte{
+		"test-package": testutil.MustMakeDeb(append(testutil.TestPackageEntries, append(testPackageCopyrightEntries,
+			testutil.Reg(0644, "./usr/share/doc/test-package/README", "readme"),
+			testutil.Dir(0755, "./usr/share/man/"),
+			testutil.Dir(0755, "./usr/share/man/man1/"),
+			testutil.Reg(0644, "./usr/share/man/man1/test.1", "manual"),
+		)...)),
+	},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.Prune = []string{"docs", "man"}
+	},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+						/usr/share/doc/test-package/**:
+						/usr/share/man/**:
+		`,
+	},
+	filesystem: map[string]string{
+		"/dir/":                                 "dir 0755",
+		"/dir/file":                             "file 0644 cc55e2ec",
+		"/usr/":                                 "dir 0755",
+		"/usr/share/":                           "dir 0755",
+		"/usr/share/doc/":                       "dir 0755",
+		"/usr/share/doc/test-package/":          "dir 0755",
+		"/usr/share/doc/test-package/copyright": "file 0644 c2fca2aa",
+	},
+	report: map[string]string{
+		"/dir/file":                             "file 0644 cc55e2ec {test-package_myslice}",
+		"/usr/share/doc/test-package/":          "dir 0755 {test-package_myslice}",
+		"/usr/share/doc/test-package/README":    "file 0644 711a6108 {test-package_myslice} pruned",
+		"/usr/share/doc/test-package/copyright": "file 0644 c2fca2aa {test-package_myslice}",
+		"/usr/share/man/":                       "dir 0755 {test-package_myslice} pruned",
+		"/usr/share/man/man1/":                  "dir 0755 {test-package_myslice} pruned",
+		"/usr/share/man/man1/test.1":            "file 0644 36bde66f {test-package_myslice} pruned",
+	},
+}, {
+	summary: "Debug sections of ELF files are stripped",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: map[string][]byte{
+		"test-package": testutil.MustMakeDeb(append(testutil.TestPackageEntries, append(testPackageCopyrightEntries,
+			testutil.Dir(0755, "./usr/bin/"),
+			testutil.Reg(0755, "./usr/bin/app", string(testutil.MustMakeDebugELF([]string{"libc.so.6"}, nil, "debug data"))),
+			testutil.Reg(0755, "./usr/bin/other", string(testutil.MustMakeELF([]string{"libc.so.6"}))),
+		)...)),
+	},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.Strip = true
+	},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+						/usr/bin/app:
+						/usr/bin/other:
+		`,
+	},
+	report: map[string]string{
+		"/dir/file":      "file 0644 cc55e2ec {test-package_myslice}",
+		"/usr/bin/app":   "file 0755 cfddd51d f90eea4e {test-package_myslice}",
+		"/usr/bin/other": "file 0755 abf9e933 {test-package_myslice}",
+	},
+}, {
+	summary: "Files with the same content are hard linked",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: map[string][]byte{
+		"test-package": testutil.MustMakeDeb(append(testutil.TestPackageEntries, append(testPackageCopyrightEntries,
+			testutil.Dir(0755, "./data/"),
+			testutil.Reg(0644, "./data/a", "data"),
+			testutil.Reg(0644, "./data/b", "data"),
+			testutil.Reg(0755, "./data/c", "data"),
+			testutil.Reg(0644, "./data/d", "other"),
+			testutil.Reg(0644, "./data/empty1", ""),
+			testutil.Reg(0644, "./data/empty2", ""),
+		)...)),
+	},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.Dedupe = "hardlink"
+	},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/data/*:
+						/mutated: {text: other, mutable: true}
+						/text: {text: data}
+					mutate: |
+						content.write("/mutated", "data")
+		`,
+	},
+	report: map[string]string{
+		"/data/a":      "file 0644 3a6eb079 {test-package_myslice}",
+		"/data/b":      "file 0644 3a6eb079 {test-package_myslice} hardlink:/data/a",
+		"/data/c":      "file 0755 3a6eb079 {test-package_myslice}",
+		"/data/d":      "file 0644 d9298a10 {test-package_myslice}",
+		"/data/empty1": "file 0644 empty {test-package_myslice}",
+		"/data/empty2": "file 0644 empty {test-package_myslice}",
+		"/mutated":     "file 0644 d9298a10 3a6eb079 {test-package_myslice} hardlink:/data/a",
+		"/text":        "file 0644 3a6eb079 {test-package_myslice} hardlink:/data/a",
+	},
+}, {
+	summary: "Dedupe mode must be known",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.Dedupe = "reflink"
+	},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+		`,
+	},
+	error: `invalid dedupe mode "reflink", must be one of: hardlink`,
+}, {
+	summary: "Prune classes must be known",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.Prune = []string{"tests"}
+	},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+		`,
+	},
+	error: `invalid prune class "tests", must be one of: docs, locales, man`,
+}, {
+	summary: "Exclude patterns must be absolute",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.Exclude = []string{"dir/file"}
+	},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+		`,
+	},
+	error: `invalid exclude pattern "dir/file": must be an absolute path`,
+}, {
+	summary:        "Same path from different packages with warn conflict policy",
+	conflictPolicy: setup.ConflictWarn,
+	slices: []setup.SliceKey{
+		{"test-package", "myslice"},
+		{"other-package", "myslice"}},
+	pkgs: map[string][]byte{
+		"test-package": testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Dir(0755, "./dir/"),
+			testutil.Reg(0644, "./dir/file", "data"),
+		}),
+		"other-package": testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Dir(0755, "./dir/"),
+			testutil.Reg(0644, "./dir/file", "data"),
+		}),
+	},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+		`,
+		"slices/mydir/other-package.yaml": `
+			package: other-package
+			slices:
+				myslice:
+					contents:
+						/dir/f*:
+		`,
+	},
+	filesystem: map[string]string{
+		"/dir/":     "dir 0755",
+		"/dir/file": "file 0644 3a6eb079",
+	},
+	report: map[string]string{
+		"/dir/file": "file 0644 3a6eb079 {other-package_myslice,test-package_myslice}",
+	},
+}, {
+	summary:        "Diverging content from different packages with warn conflict policy",
+	conflictPolicy: setup.ConflictWarn,
+	slices: []setup.SliceKey{
+		{"test-package", "myslice"},
+		{"other-package", "myslice"}},
+	pkgs: map[string][]byte{
+		"test-package": testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Dir(0755, "./dir/"),
+			testutil.Reg(0644, "./dir/file", "data"),
+		}),
+		"other-package": testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Dir(0755, "./dir/"),
+			testutil.Reg(0644, "./dir/file", "other"),
+		}),
+	},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+		`,
+		"slices/mydir/other-package.yaml": `
+			package: other-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+		`,
+	},
+	error: `cannot extract from package "test-package": path /dir/file has diverging content in packages other-package and test-package:
+--- other-package
+\+\+\+ test-package
+-file 0644 size 5 sha256 d9298a10\w+
+\+file 0644 size 4 sha256 3a6eb079\w+|` +
+		`cannot extract from package "other-package": path /dir/file has diverging content in packages test-package and other-package:
+--- test-package
+\+\+\+ other-package
+-file 0644 size 4 sha256 3a6eb079\w+
+\+file 0644 size 5 sha256 d9298a10\w+`,
+}, {
+	summary:        "Diverging modes from different packages with warn conflict policy",
+	conflictPolicy: setup.ConflictWarn,
+	slices: []setup.SliceKey{
+		{"test-package", "myslice"},
+		{"other-package", "myslice"}},
+	pkgs: map[string][]byte{
+		"test-package": testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Reg(0644, "./file", "data"),
+		}),
+		"other-package": testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Reg(0755, "./file", "data"),
+		}),
+	},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/file:
+		`,
+		"slices/mydir/other-package.yaml": `
+			package: other-package
+			slices:
+				myslice:
+					contents:
+						/file:
+		`,
+	},
+	error: `cannot extract from package ".*": path /file has diverging content in packages .*
+--- .*
+\+\+\+ .*
+-file 0(644|755) size 4 sha256 3a6eb079\w+
+\+file 0(644|755) size 4 sha256 3a6eb079\w+`,
+}, {
+	summary: "Special files are restricted by default",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	pkgs: map[string][]byte{
+		"test-package": testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Reg(04755, "./suid", "data"),
+			testutil.Reg(02755, "./sgid", "data"),
+			{Header: tar.Header{Name: "./fifo", Typeflag: tar.TypeFifo}},
+		}),
+	},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/suid:
+						/sgid:
+						/fifo:
+		`,
+	},
+	filesystem: map[string]string{
+		"/sgid": "file 0755 3a6eb079",
+		"/suid": "file 0755 3a6eb079",
+	},
+	report: map[string]string{
+		"/sgid": "file 0755 3a6eb079 {test-package_myslice}",
+		"/suid": "file 0755 3a6eb079 {test-package_myslice}",
+	},
+	special: []string{
+		"/fifo prw-r--r--",
+		"/sgid grwxr-xr-x",
+		"/suid urwxr-xr-x",
+	},
+}, {
+	summary: "Special files may be allowed",
+	slices:  []setup.SliceKey{{"test-package", "myslice"}},
+	hackopt: func(c *C, opts *slicer.RunOptions) {
+		opts.AllowSpecial = true
+	},
+	pkgs: map[string][]byte{
+		"test-package": testutil.MustMakeDeb([]testutil.TarEntry{
+			testutil.Dir(0755, "./"),
+			testutil.Reg(04755, "./suid", "data"),
+			{Header: tar.Header{Name: "./fifo", Typeflag: tar.TypeFifo}},
+		}),
+	},
+	release: map[string]string{
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/suid:
+						/fifo:
+		`,
+	},
+	filesystem: map[string]string{
+		"/fifo": "fifo 0644",
+		"/suid": "file 04755 3a6eb079",
+	},
+	special: []string{},
+}}
+
+var defaultChiselYaml = `
+	format: chisel-v1
+	archives:
+		ubuntu:
+			version: 22.04
+			components: [main, universe]
+			v1-public-keys: [test-key]
+	v1-public-keys:
+		test-key:
+			id: ` + testKey.ID + `
+			armor: |` + "\n" + testutil.PrefixEachLine(testKey.PubKeyArmor, "\t\t\t\t\t\t") + `
+`
+
+type testArchive struct {
+	options archive.Options
+	pkgs    map[string][]byte
+}
+
+func (a *testArchive) Options() *archive.Options {
+	return &a.options
+}
+
+func (a *testArchive) Fetch(pkg string) (io.ReadCloser, error) {
+	if data, ok := a.pkgs[pkg]; ok {
+		return io.NopCloser(bytes.NewBuffer(data)), nil
+	}
+	return nil, fmt.Errorf("attempted to open %q package", pkg)
+}
+
+func (a *testArchive) Exists(pkg string) bool {
+	_, ok := a.pkgs[pkg]
+	return ok
+}
+
+func (a *testArchive) Packages() []string {
+	var names []string
+	for name := range a.pkgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (a *testArchive) Info(pkg string) (*archive.PackageInfo, error) {
+	data, ok := a.pkgs[pkg]
+	if !ok {
+		return nil, fmt.Errorf("cannot find package %q in archive", pkg)
+	}
+	file, err := control.ParseString("Package", fmt.Sprintf(
+		"Package: %s\nVersion: 1.0\nArchitecture: %s\nSHA256: %x\nSHA512: %x\nSize: %d\n",
+		pkg, a.options.Arch, sha256.Sum256(data), sha512.Sum512(data), len(data)))
+	if err != nil {
+		return nil, err
+	}
+	return archive.NewPackageInfo(file.Section(pkg))
+}
+
+func (s *S) TestRun(c *C) {
+	// Run tests for format chisel-v1.
+	runSlicerTests(c, slicerTests)
+
+	// Run tests for format v1.
+	v1SlicerTests := make([]slicerTest, len(slicerTests))
+	for i, t := range slicerTests {
+		t.error = strings.Replace(t.error, "chisel-v1", "v1", -1)
+		t.error = strings.Replace(t.error, "v1-public-keys", "public-keys", -1)
+		m := map[string]string{}
+		for k, v := range t.release {
+			v = strings.Replace(v, "chisel-v1", "v1", -1)
+			v = strings.Replace(v, "v1-public-keys", "public-keys", -1)
+			m[k] = v
+		}
+		t.release = m
+		v1SlicerTests[i] = t
+	}
+	runSlicerTests(c, v1SlicerTests)
+}
+
+func runSlicerTests(c *C, tests []slicerTest) {
+	for _, test := range tests {
+		for _, slices := range testutil.Permutations(test.slices) {
+			c.Logf("Summary: %s", test.summary)
+
+			if _, ok := test.release["chisel.yaml"]; !ok {
+				test.release["chisel.yaml"] = string(defaultChiselYaml)
+			}
+
+			if test.pkgs == nil {
+				test.pkgs = map[string][]byte{
+					"test-package": testutil.PackageData["test-package"],
+				}
+			}
+
+			releaseDir := c.MkDir()
+			for path, data := range test.release {
+				fpath := filepath.Join(releaseDir, path)
+				err := os.MkdirAll(filepath.Dir(fpath), 0755)
+				c.Assert(err, IsNil)
+				err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+				c.Assert(err, IsNil)
+			}
+
+			release, err := setup.ReadReleaseWithOptions(releaseDir, &setup.ReadOptions{
+				ConflictPolicy: test.conflictPolicy,
+			})
+			c.Assert(err, IsNil)
+
+			selection, err := setup.Select(release, slices)
+			c.Assert(err, IsNil)
+
+			archives := map[string]archive.Archive{}
+			for name, setupArchive := range release.Archives {
+				archive := &testArchive{
+					options: archive.Options{
+						Label:      setupArchive.Name,
+						Version:    setupArchive.Version,
+						Suites:     setupArchive.Suites,
+						Components: setupArchive.Components,
+						Arch:       test.arch,
+					},
+					pkgs: test.pkgs,
+				}
+				archives[name] = archive
+			}
+
+			targetDir := c.MkDir()
+			options := slicer.RunOptions{
+				Selection: selection,
+				Archives:  archives,
+				TargetDir: targetDir,
+			}
+			if test.hackopt != nil {
+				test.hackopt(c, &options)
+			}
+			report, err := slicer.Run(&options)
+			if test.error == "" {
+				c.Assert(err, IsNil)
+			} else {
+				c.Assert(err, ErrorMatches, test.error)
+				continue
+			}
+
+			if test.filesystem != nil {
+				c.Assert(testutil.TreeDump(targetDir), DeepEquals, test.filesystem)
+			}
+
+			if test.report != nil {
+				c.Assert(treeDumpReport(report), DeepEquals, test.report)
+			}
+
+			if test.excluded != nil {
+				excluded := make([]string, 0, len(report.Excluded))
+				for path := range report.Excluded {
+					excluded = append(excluded, path)
+				}
+				sort.Strings(excluded)
+				c.Assert(excluded, DeepEquals, test.excluded)
+			}
+
+			if test.special != nil {
+				special := make([]string, 0, len(report.Special))
+				for path, mode := range report.Special {
+					special = append(special, fmt.Sprintf("%s %s", path, mode))
+				}
+				sort.Strings(special)
+				c.Assert(special, DeepEquals, test.special)
+			}
+		}
+	}
+}
+
+// treeDumpReport returns the file information in the same format as
+// [testutil.TreeDump] with the added slices that have installed each path.
+// Implicit parent directories are left out, see TestRunImplicitDirs.
+func treeDumpReport(report *slicer.Report) map[string]string {
+	result := make(map[string]string)
+	for _, entry := range report.Entries {
+		if entry.Implicit {
+			continue
+		}
+		fperm := entry.Mode.Perm()
+		if entry.Mode&fs.ModeSticky != 0 {
+			fperm |= 01000
+		}
+		var fsDump string
+		switch entry.Mode.Type() {
+		case fs.ModeDir:
+			fsDump = fmt.Sprintf("dir %#o", fperm)
+		case fs.ModeSymlink:
+			fsDump = fmt.Sprintf("symlink %s", entry.Link)
+		case 0: // Regular
+			if entry.Size == 0 {
+				fsDump = fmt.Sprintf("file %#o empty", entry.Mode.Perm())
+			} else if entry.FinalHash != "" {
+				fsDump = fmt.Sprintf("file %#o %s %s", fperm, entry.Hash[:8], entry.FinalHash[:8])
+			} else {
+				fsDump = fmt.Sprintf("file %#o %s", fperm, entry.Hash[:8])
+			}
+		default:
+			panic(fmt.Errorf("unknown file type %d: %s", entry.Mode.Type(), entry.Path))
+		}
+
+		// append {slice1, ..., sliceN} to the end of the entry dump.
+		slicesStr := make([]string, 0, len(entry.Slices))
+		for slice := range entry.Slices {
+			slicesStr = append(slicesStr, slice.String())
+		}
+		sort.Strings(slicesStr)
+		result[entry.Path] = fmt.Sprintf("%s {%s}", fsDump, strings.Join(slicesStr, ","))
+		if entry.Pruned {
+			result[entry.Path] += " pruned"
+		}
+		if entry.HardLink != "" {
+			result[entry.Path] += " hardlink:" + entry.HardLink
+		}
+	}
+	return result
+}
+
+type recordingReporter struct {
+	tasks []*recordingTask
+}
+
+type recordingTask struct {
+	label string
+	total int64
+	added int64
+	done  bool
+}
+
+func (r *recordingReporter) Start(label string, total int64) progress.Task {
+	task := &recordingTask{label: label, total: total}
+	r.tasks = append(r.tasks, task)
+	return task
+}
+
+func (t *recordingTask) Add(n int64) { t.added += n }
+func (t *recordingTask) Done()       { t.done = true }
+
+func (s *S) TestRunProgress(c *C) {
+	releaseDir := c.MkDir()
+	release := map[string]string{
+		"chisel.yaml": defaultChiselYaml,
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+		`,
+	}
+	for path, data := range release {
+		fpath := filepath.Join(releaseDir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+	r, err := setup.ReadRelease(releaseDir)
+	c.Assert(err, IsNil)
+	selection, err := setup.Select(r, []setup.SliceKey{{"test-package", "myslice"}})
+	c.Assert(err, IsNil)
+
+	pkgData := testutil.PackageData["test-package"]
+	reporter := &recordingReporter{}
+	_, err = slicer.Run(&slicer.RunOptions{
+		Selection: selection,
+		Archives: map[string]archive.Archive{
+			"ubuntu": &testArchive{pkgs: map[string][]byte{"test-package": pkgData}},
+		},
+		TargetDir: c.MkDir(),
+		Progress:  reporter,
+	})
+	c.Assert(err, IsNil)
+
+	c.Assert(reporter.tasks, HasLen, 1)
+	task := reporter.tasks[0]
+	c.Assert(task.label, Equals, "Extracting test-package")
+	c.Assert(task.total, Equals, int64(len(pkgData)))
+	c.Assert(task.added > 0, Equals, true)
+	c.Assert(task.done, Equals, true)
+}
+
+func (s *S) TestRunHooks(c *C) {
+	releaseDir := c.MkDir()
+	release := map[string]string{
+		"chisel.yaml": defaultChiselYaml,
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+						/dir/text-file: {text: data1}
+						/dir/until-file: {text: data1, until: mutate}
+						/other-dir/: {make: true}
+		`,
+	}
+	for path, data := range release {
+		fpath := filepath.Join(releaseDir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+	r, err := setup.ReadRelease(releaseDir)
+	c.Assert(err, IsNil)
+	selection, err := setup.Select(r, []setup.SliceKey{{"test-package", "myslice"}})
+	c.Assert(err, IsNil)
+
+	var events []string
+	options := &slicer.RunOptions{
+		Selection: selection,
+		Archives: map[string]archive.Archive{
+			"ubuntu": &testArchive{pkgs: map[string][]byte{"test-package": testutil.PackageData["test-package"]}},
+		},
+		TargetDir: c.MkDir(),
+		BeforeExtract: func(info *archive.PackageInfo) error {
+			events = append(events, "before "+info.Name)
+			return nil
+		},
+		AfterExtract: func(info *archive.PackageInfo) error {
+			events = append(events, "after "+info.Name)
+			return nil
+		},
+		PathWritten: func(entry slicer.ReportEntry) error {
+			c.Assert(entry.Slices, HasLen, 1)
+			events = append(events, "path "+entry.Path)
+			return nil
+		},
+	}
+	_, err = slicer.Run(options)
+	c.Assert(err, IsNil)
+
+	// Paths not coming from packages are created in no particular order.
+	c.Assert(len(events) > 3, Equals, true)
+	sort.Strings(events[3:])
+	c.Assert(events, DeepEquals, []string{
+		"before test-package",
+		"path /dir/file",
+		"after test-package",
+		"path /dir/text-file",
+		"path /other-dir/",
+	})
+
+	// Errors returned by the hooks abort the run.
+	options.TargetDir = c.MkDir()
+	options.PathWritten = func(entry slicer.ReportEntry) error {
+		return fmt.Errorf("invalid path %s", entry.Path)
+	}
+	_, err = slicer.Run(options)
+	c.Assert(err, ErrorMatches, `cannot extract from package "test-package": invalid path /dir/file`)
+}
+
+func (s *S) TestRunPathCreated(c *C) {
+	releaseDir := c.MkDir()
+	release := map[string]string{
+		"chisel.yaml": defaultChiselYaml,
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+						/dir/nested/text-file: {text: data1}
+						/other-dir/: {make: true}
+						/var/lib/chisel/**: {generate: manifest}
+		`,
+	}
+	for path, data := range release {
+		fpath := filepath.Join(releaseDir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+	r, err := setup.ReadRelease(releaseDir)
+	c.Assert(err, IsNil)
+	selection, err := setup.Select(r, []setup.SliceKey{{"test-package", "myslice"}})
+	c.Assert(err, IsNil)
+
+	// Paths which exist before the run are not notified.
+	targetDir := c.MkDir()
+	c.Assert(os.Mkdir(filepath.Join(targetDir, "dir"), 0755), IsNil)
+	outDir := c.MkDir()
+
+	var created []string
+	options := &slicer.RunOptions{
+		Selection: selection,
+		Archives: map[string]archive.Archive{
+			"ubuntu": &testArchive{pkgs: map[string][]byte{"test-package": testutil.PackageData["test-package"]}},
+		},
+		TargetDir:     targetDir,
+		ManifestPaths: []string{filepath.Join(outDir, "out", "manifest.wall")},
+		PathCreated: func(path string) error {
+			// Paths are notified before they are created.
+			_, err := os.Lstat(path)
+			c.Assert(os.IsNotExist(err), Equals, true)
+			if strings.HasPrefix(path, outDir) {
+				created = append(created, "out:"+strings.TrimPrefix(path, outDir))
+			} else {
+				created = append(created, strings.TrimPrefix(path, targetDir))
+			}
+			return nil
+		},
+	}
+	_, err = slicer.Run(options)
+	c.Assert(err, IsNil)
+	sort.Strings(created)
+	c.Assert(created, DeepEquals, []string{
+		"/dir/file",
+		"/dir/nested",
+		"/dir/nested/text-file",
+		"/other-dir",
+		"/var",
+		"/var/lib",
+		"/var/lib/chisel",
+		"/var/lib/chisel/manifest.wall",
+		"out:/out",
+		"out:/out/manifest.wall",
+	})
+
+	// Errors returned by the hook abort the run.
+	options.TargetDir = c.MkDir()
+	options.ManifestPaths = nil
+	options.PathCreated = func(path string) error {
+		return fmt.Errorf("cannot track %s", strings.TrimPrefix(path, options.TargetDir))
+	}
+	_, err = slicer.Run(options)
+	c.Assert(err, ErrorMatches, `cannot extract from package "test-package": cannot track /dir`)
+}
+
+func (s *S) TestRunCancel(c *C) {
+	releaseDir := c.MkDir()
+	release := map[string]string{
+		"chisel.yaml": defaultChiselYaml,
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+		`,
+	}
+	for path, data := range release {
+		fpath := filepath.Join(releaseDir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+	r, err := setup.ReadRelease(releaseDir)
+	c.Assert(err, IsNil)
+	selection, err := setup.Select(r, []setup.SliceKey{{"test-package", "myslice"}})
+	c.Assert(err, IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	options := &slicer.RunOptions{
+		Selection: selection,
+		Archives: map[string]archive.Archive{
+			"ubuntu": &testArchive{pkgs: map[string][]byte{"test-package": testutil.PackageData["test-package"]}},
+		},
+		TargetDir: c.MkDir(),
+		Context:   ctx,
+		BeforeExtract: func(info *archive.PackageInfo) error {
+			cancel()
+			return nil
+		},
+	}
+
+	// Packages being extracted are not read any further.
+	_, err = slicer.Run(options)
+	c.Assert(err, ErrorMatches, `cannot extract from package "test-package": .*context canceled`)
+	c.Assert(errors.Is(err, context.Canceled), Equals, true)
+	c.Assert(testutil.TreeDump(options.TargetDir), DeepEquals, map[string]string{})
+
+	// Nothing is fetched once cancelled.
+	options.TargetDir = c.MkDir()
+	options.BeforeExtract = func(info *archive.PackageInfo) error {
+		c.Fatalf("package extracted after cancel")
+		return nil
+	}
+	_, err = slicer.Run(options)
+	c.Assert(err, Equals, context.Canceled)
+}
+
+func (s *S) TestRunTimestamp(c *C) {
+	releaseDir := c.MkDir()
+	release := map[string]string{
+		"chisel.yaml": defaultChiselYaml,
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+						/dir/text-file: {text: data1}
+						/dir/mutable-file: {text: data1, mutable: true}
+						/other-dir/: {make: true}
+						/link: {symlink: /dir/file}
+					mutate: |
+						content.write("/dir/mutable-file", "data2")
+		`,
+	}
+	for path, data := range release {
+		fpath := filepath.Join(releaseDir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+	r, err := setup.ReadRelease(releaseDir)
+	c.Assert(err, IsNil)
+	selection, err := setup.Select(r, []setup.SliceKey{{"test-package", "myslice"}})
+	c.Assert(err, IsNil)
+
+	timestamp := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	targetDir := c.MkDir()
+	// Content already in the root is not clamped, and neither is the root.
+	later := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	c.Assert(os.WriteFile(filepath.Join(targetDir, "existing"), nil, 0644), IsNil)
+	c.Assert(os.Chtimes(filepath.Join(targetDir, "existing"), later, later), IsNil)
+	c.Assert(os.Chtimes(targetDir, later, later), IsNil)
+	_, err = slicer.Run(&slicer.RunOptions{
+		Selection: selection,
+		Archives: map[string]archive.Archive{
+			"ubuntu": &testArchive{pkgs: map[string][]byte{"test-package": testutil.PackageData["test-package"]}},
+		},
+		TargetDir: targetDir,
+		Timestamp: timestamp,
+	})
+	c.Assert(err, IsNil)
+
+	mtimes := map[string]time.Time{}
+	err = filepath.WalkDir(targetDir, func(path string, d fs.DirEntry, err error) error {
+		c.Assert(err, IsNil)
+		info, err := d.Info()
+		c.Assert(err, IsNil)
+		relPath, err := filepath.Rel(targetDir, path)
+		c.Assert(err, IsNil)
+		mtimes["/"+relPath] = info.ModTime().UTC()
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(mtimes["/."].After(later), Equals, true)
+	delete(mtimes, "/.")
+	c.Assert(mtimes, DeepEquals, map[string]time.Time{
+		"/existing": later,
+		"/dir":      timestamp,
+		// The modification time from the package is older and thus kept.
+		"/dir/file":         time.Unix(0, 0).UTC(),
+		"/dir/text-file":    timestamp,
+		"/dir/mutable-file": timestamp,
+		"/other-dir":        timestamp,
+		"/link":             timestamp,
+	})
+
+	// Without a timestamp the modification time from the package is not
+	// kept either.
+	start := time.Now().Add(-time.Second)
+	targetDir = c.MkDir()
+	_, err = slicer.Run(&slicer.RunOptions{
+		Selection: selection,
+		Archives: map[string]archive.Archive{
+			"ubuntu": &testArchive{pkgs: map[string][]byte{"test-package": testutil.PackageData["test-package"]}},
+		},
+		TargetDir: targetDir,
+	})
+	c.Assert(err, IsNil)
+	info, err := os.Stat(filepath.Join(targetDir, "dir", "file"))
+	c.Assert(err, IsNil)
+	c.Assert(info.ModTime().After(start), Equals, true)
+}
+
+func (s *S) TestRunImplicitDirs(c *C) {
+	releaseDir := c.MkDir()
+	release := map[string]string{
+		"chisel.yaml": defaultChiselYaml,
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				slice-a:
+					contents:
+						/dir/nested/file:
+						/parent/permissions/file:
+				slice-b:
+					contents:
+						/dir/file:
+						/dir/several/levels/deep/:
+						/other/new/file: {text: data1}
+		`,
+	}
+	for path, data := range release {
+		fpath := filepath.Join(releaseDir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+	r, err := setup.ReadRelease(releaseDir)
+	c.Assert(err, IsNil)
+	selection, err := setup.Select(r, []setup.SliceKey{{"test-package", "slice-a"}, {"test-package", "slice-b"}})
+	c.Assert(err, IsNil)
+
+	report, err := slicer.Run(&slicer.RunOptions{
+		Selection: selection,
+		Archives: map[string]archive.Archive{
+			"ubuntu": &testArchive{pkgs: map[string][]byte{"test-package": testutil.PackageData["test-package"]}},
+		},
+		TargetDir: c.MkDir(),
+	})
+	c.Assert(err, IsNil)
+
+	implicit := make(map[string]string)
+	for path, entry := range report.Entries {
+		if !entry.Implicit {
+			continue
+		}
+		var slices []string
+		for slice := range entry.Slices {
+			slices = append(slices, slice.Name)
+		}
+		sort.Strings(slices)
+		implicit[path] = fmt.Sprintf("%s {%s}", entry.Mode, strings.Join(slices, ","))
+	}
+	// Parent directories take their mode from the package when possible.
+	c.Assert(implicit, DeepEquals, map[string]string{
+		"/dir/":                "drwxr-xr-x {slice-a,slice-b}",
+		"/dir/nested/":         "drwxr-xr-x {slice-a}",
+		"/dir/several/":        "drwxr-xr-x {slice-b}",
+		"/dir/several/levels/": "drwxr-xr-x {slice-b}",
+		"/other/":              "drwxr-xr-x {slice-b}",
+		"/other/new/":          "drwxr-xr-x {slice-b}",
+		"/parent/":             "dtrwxrwxrwx {slice-a}",
+		"/parent/permissions/": "drwxrw-r-- {slice-a}",
+	})
+}
+
+func (s *S) TestRunManifest(c *C) {
+	releaseDir := c.MkDir()
+	release := map[string]string{
+		"chisel.yaml": defaultChiselYaml,
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+						/dir/text-file: {text: data1}
+						/link: {symlink: /dir/file}
+				manifest:
+					essential:
+						- test-package_myslice
+					contents:
+						/db/**: {generate: manifest}
+		`,
+	}
+	for path, data := range release {
+		fpath := filepath.Join(releaseDir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+	r, err := setup.ReadRelease(releaseDir)
+	c.Assert(err, IsNil)
+	selection, err := setup.Select(r, []setup.SliceKey{{"test-package", "manifest"}})
+	c.Assert(err, IsNil)
+
+	pkgData := testutil.PackageData["test-package"]
+	targetDir := c.MkDir()
+	_, err = slicer.Run(&slicer.RunOptions{
+		Selection: selection,
+		Archives: map[string]archive.Archive{
+			"ubuntu": &testArchive{
+				options: archive.Options{Arch: "amd64"},
+				pkgs:    map[string][]byte{"test-package": pkgData},
+			},
+		},
+		TargetDir:     targetDir,
+		ManifestDirs:  []string{"/other/dir"},
+		ChiselVersion: "1.2.3",
+	})
+	c.Assert(err, IsNil)
+
+	expected := map[string]string{
+		"generator chisel":              "1.2.3",
+		"package test-package":          fmt.Sprintf("1.0 amd64 %x size:19", sha256.Sum256(pkgData)),
+		"slice test-package_manifest":   "",
+		"slice test-package_myslice":    "size:19",
+		"path /db/":                     "0755 {test-package_manifest}",
+		"path /db/manifest.wall":        "0644 {test-package_manifest}",
+		"path /dir/":                    "0755 {test-package_myslice} implicit",
+		"path /dir/file":                "0644 cc55e2ec {test-package_myslice}",
+		"path /dir/text-file":           "0644 5b41362b {test-package_myslice}",
+		"path /link":                    "0777 /dir/file {test-package_myslice}",
+		"path /other/dir/manifest.wall": "0644 {}",
+	}
+	for _, path := range []string{"/db/manifest.wall", "/other/dir/manifest.wall"} {
+		c.Assert(dumpManifest(c, filepath.Join(targetDir, path)), DeepEquals, expected)
+	}
+}
+
+func (s *S) TestRunManifestReproducible(c *C) {
+	releaseDir := c.MkDir()
+	release := map[string]string{
+		"chisel.yaml": defaultChiselYaml,
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice1:
+					contents:
+						/dir/file:
+						/dir/other-file:
+						/dir/text-file: {text: data1}
+				myslice2:
+					contents:
+						/dir/several/levels/deep/file:
+						/link: {symlink: /dir/file}
+				manifest:
+					essential:
+						- test-package_myslice1
+						- test-package_myslice2
+						- other-package_myslice
+					contents:
+						/db/**: {generate: manifest}
+		`,
+		"slices/mydir/other-package.yaml": `
+			package: other-package
+			slices:
+				myslice:
+					contents:
+						/file:
+						/other-dir/: {make: true}
+		`,
+	}
+	for path, data := range release {
+		fpath := filepath.Join(releaseDir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+	r, err := setup.ReadRelease(releaseDir)
+	c.Assert(err, IsNil)
+	selection, err := setup.Select(r, []setup.SliceKey{{"test-package", "manifest"}})
+	c.Assert(err, IsNil)
+
+	// Cutting the same selection twice writes the very same manifest.
+	var manifests [][]byte
+	for i := 0; i < 2; i++ {
+		targetDir := c.MkDir()
+		_, err = slicer.Run(&slicer.RunOptions{
+			Selection: selection,
+			Archives: map[string]archive.Archive{
+				"ubuntu": &testArchive{
+					options: archive.Options{Arch: "amd64"},
+					pkgs: map[string][]byte{
+						"test-package":  testutil.PackageData["test-package"],
+						"other-package": testutil.PackageData["other-package"],
+					},
+				},
+			},
+			TargetDir:     targetDir,
+			ChiselVersion: "1.2.3",
+		})
+		c.Assert(err, IsNil)
+		data, err := os.ReadFile(filepath.Join(targetDir, "db/manifest.wall"))
+		c.Assert(err, IsNil)
+		manifests = append(manifests, data)
+	}
+	c.Assert(string(manifests[1]), Equals, string(manifests[0]))
+}
+
+func (s *S) TestRunManifestPaths(c *C) {
+	releaseDir := c.MkDir()
+	release := map[string]string{
+		"chisel.yaml": defaultChiselYaml,
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+				manifest:
+					essential:
+						- test-package_myslice
+					contents:
+						/db/**: {generate: manifest}
+		`,
+	}
+	for path, data := range release {
+		fpath := filepath.Join(releaseDir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+	r, err := setup.ReadRelease(releaseDir)
+	c.Assert(err, IsNil)
+	selection, err := setup.Select(r, []setup.SliceKey{{"test-package", "manifest"}})
+	c.Assert(err, IsNil)
+
+	pkgData := testutil.PackageData["test-package"]
+	targetDir := c.MkDir()
+	manifestPath := filepath.Join(c.MkDir(), "nested", "manifest.wall")
+	_, err = slicer.Run(&slicer.RunOptions{
+		Selection: selection,
+		Archives: map[string]archive.Archive{
+			"ubuntu": &testArchive{
+				options: archive.Options{Arch: "amd64"},
+				pkgs:    map[string][]byte{"test-package": pkgData},
+			},
+		},
+		TargetDir:       targetDir,
+		ManifestDirs:    []string{"/other/dir"},
+		ManifestPaths:   []string{manifestPath},
+		NoTreeManifests: true,
+	})
+	c.Assert(err, IsNil)
+
+	// Manifests are not written into the tree, nor listed.
+	for _, path := range []string{"/db/manifest.wall", "/other/dir/manifest.wall"} {
+		_, err := os.Lstat(filepath.Join(targetDir, path))
+		c.Assert(os.IsNotExist(err), Equals, true)
+	}
+	c.Assert(dumpManifest(c, manifestPath), DeepEquals, map[string]string{
+		"package test-package":        fmt.Sprintf("1.0 amd64 %x size:14", sha256.Sum256(pkgData)),
+		"slice test-package_manifest": "",
+		"slice test-package_myslice":  "size:14",
+		"path /db/":                   "0755 {test-package_manifest}",
+		"path /dir/":                  "0755 {test-package_myslice} implicit",
+		"path /dir/file":              "0644 cc55e2ec {test-package_myslice}",
+	})
+}
+
+func (s *S) TestRunSHA512(c *C) {
+	releaseDir := c.MkDir()
+	release := map[string]string{
+		"chisel.yaml": defaultChiselYaml,
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+						/dir/text: {text: data1, mutable: true}
+					mutate: |
+						content.write("/dir/text", "data2")
+				manifest:
+					essential:
+						- test-package_myslice
+					contents:
+						/db/**: {generate: manifest}
+		`,
+	}
+	for path, data := range release {
+		fpath := filepath.Join(releaseDir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+	r, err := setup.ReadRelease(releaseDir)
+	c.Assert(err, IsNil)
+	selection, err := setup.Select(r, []setup.SliceKey{{"test-package", "manifest"}})
+	c.Assert(err, IsNil)
+
+	pkgData := testutil.PackageData["test-package"]
+	for _, withSHA512 := range []bool{false, true} {
+		targetDir := c.MkDir()
+		_, err = slicer.Run(&slicer.RunOptions{
+			Selection: selection,
+			Archives: map[string]archive.Archive{
+				"ubuntu": &testArchive{
+					options: archive.Options{Arch: "amd64"},
+					pkgs:    map[string][]byte{"test-package": pkgData},
+				},
+			},
+			TargetDir: targetDir,
+			SHA512:    withSHA512,
+		})
+		c.Assert(err, IsNil)
+
+		f, err := os.Open(filepath.Join(targetDir, "db", "manifest.wall"))
+		c.Assert(err, IsNil)
+		m, err := manifest.Read(f)
+		f.Close()
+		c.Assert(err, IsNil)
+		digests := make(map[string]string)
+		err = m.IteratePackages(func(pkg *manifest.Package) error {
+			digests[pkg.Name] = pkg.SHA512
+			return nil
+		})
+		c.Assert(err, IsNil)
+		err = m.IteratePaths("/dir/", func(path *manifest.Path) error {
+			digests[path.Path] = path.SHA512 + " " + path.FinalSHA512
+			return nil
+		})
+		c.Assert(err, IsNil)
+
+		if !withSHA512 {
+			c.Assert(digests, DeepEquals, map[string]string{
+				"test-package": "",
+				"/dir/":        " ",
+				"/dir/file":    " ",
+				"/dir/text":    " ",
+			})
+			continue
+		}
+		fileData, err := os.ReadFile(filepath.Join(targetDir, "dir", "file"))
+		c.Assert(err, IsNil)
+		c.Assert(digests, DeepEquals, map[string]string{
+			"test-package": fmt.Sprintf("%x", sha512.Sum512(pkgData)),
+			"/dir/":        " ",
+			"/dir/file":    fmt.Sprintf("%x ", sha512.Sum512(fileData)),
+			"/dir/text":    fmt.Sprintf("%x %x", sha512.Sum512([]byte("data1")), sha512.Sum512([]byte("data2"))),
+		})
+	}
+}
+
+func (s *S) TestRunManifestDetail(c *C) {
+	releaseDir := c.MkDir()
+	release := map[string]string{
+		"chisel.yaml": defaultChiselYaml,
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+				manifest:
+					essential:
+						- test-package_myslice
+					contents:
+						/db/**: {generate: manifest}
+						/small-db/**: {generate: manifest, detail: paths-only}
+		`,
+	}
+	for path, data := range release {
+		fpath := filepath.Join(releaseDir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+	r, err := setup.ReadRelease(releaseDir)
+	c.Assert(err, IsNil)
+	selection, err := setup.Select(r, []setup.SliceKey{{"test-package", "manifest"}})
+	c.Assert(err, IsNil)
+
+	targetDir := c.MkDir()
+	_, err = slicer.Run(&slicer.RunOptions{
+		Selection: selection,
+		Archives: map[string]archive.Archive{
+			"ubuntu": &testArchive{
+				options: archive.Options{Arch: "amd64"},
+				pkgs:    map[string][]byte{"test-package": testutil.PackageData["test-package"]},
+			},
+		},
+		TargetDir: targetDir,
+	})
+	c.Assert(err, IsNil)
+
+	// Both manifests describe the same content, but only one of them
+	// records the content entries.
+	for dir, pathsOnly := range map[string]bool{"/db/": false, "/small-db/": true} {
+		f, err := os.Open(filepath.Join(targetDir, dir, "manifest.wall"))
+		c.Assert(err, IsNil)
+		m, err := manifest.Read(f)
+		f.Close()
+		c.Assert(err, IsNil)
+		c.Assert(m.PathsOnly(), Equals, pathsOnly, Commentf("Manifest in %s", dir))
+	}
+	fullManifest := dumpManifest(c, filepath.Join(targetDir, "db", "manifest.wall"))
+	c.Assert(dumpManifest(c, filepath.Join(targetDir, "small-db", "manifest.wall")), DeepEquals, fullManifest)
+	c.Assert(fullManifest["path /small-db/manifest.wall"], Equals, "0644 {test-package_manifest}")
+}
+
+func init() {
+	slicer.RegisterGenerator("test-stamp", func(ctx *slicer.GenerateContext) error {
+		for dir, slices := range ctx.Dirs {
+			entry, err := fsutil.Create(&fsutil.CreateOptions{
+				Path: filepath.Join(ctx.TargetDir, dir, "stamp"),
+				Mode: 0644,
+				Data: strings.NewReader(fmt.Sprintf("%d packages\n", len(ctx.Packages))),
+			})
+			if err != nil {
+				return err
+			}
+			for _, slice := range slices {
+				err := ctx.Report.Add(slice, entry)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (s *S) TestRunGenerator(c *C) {
+	releaseDir := c.MkDir()
+	release := map[string]string{
+		"chisel.yaml": defaultChiselYaml,
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/stamp/**: {generate: test-stamp}
+						/db/**: {generate: manifest}
+		`,
+	}
+	for path, data := range release {
+		fpath := filepath.Join(releaseDir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+	r, err := setup.ReadRelease(releaseDir)
+	c.Assert(err, IsNil)
+	selection, err := setup.Select(r, []setup.SliceKey{{"test-package", "myslice"}})
+	c.Assert(err, IsNil)
+
+	targetDir := c.MkDir()
+	_, err = slicer.Run(&slicer.RunOptions{
+		Selection: selection,
+		Archives: map[string]archive.Archive{
+			"ubuntu": &testArchive{
+				options: archive.Options{Arch: "amd64"},
+				pkgs:    map[string][]byte{"test-package": testutil.PackageData["test-package"]},
+			},
+		},
+		TargetDir: targetDir,
+	})
+	c.Assert(err, IsNil)
+
+	data, err := os.ReadFile(filepath.Join(targetDir, "stamp/stamp"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "1 packages\n")
+
+	// The manifest is generated last, so it includes the stamp.
+	entries := dumpManifest(c, filepath.Join(targetDir, "db/manifest.wall"))
+	c.Assert(entries["path /stamp/stamp"], Equals, "0644 c0a6e8e3 {test-package_myslice}")
+}
+
+func (s *S) TestRunImageInfo(c *C) {
+	releaseDir := c.MkDir()
+	release := map[string]string{
+		"chisel.yaml": defaultChiselYaml,
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+				info:
+					essential:
+						- test-package_myslice
+					contents:
+						/etc/chisel/**: {generate: image-info}
+		`,
+	}
+	for path, data := range release {
+		fpath := filepath.Join(releaseDir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+	r, err := setup.ReadRelease(releaseDir)
+	c.Assert(err, IsNil)
+	r.Commit = "0123abcd"
+	selection, err := setup.Select(r, []setup.SliceKey{{"test-package", "info"}})
+	c.Assert(err, IsNil)
+
+	targetDir := c.MkDir()
+	report, err := slicer.Run(&slicer.RunOptions{
+		Selection: selection,
+		Archives: map[string]archive.Archive{
+			"ubuntu": &testArchive{
+				options: archive.Options{Arch: "amd64"},
+				pkgs:    map[string][]byte{"test-package": testutil.PackageData["test-package"]},
+			},
+		},
+		TargetDir:     targetDir,
+		ChiselVersion: "v1.0.0",
+	})
+	c.Assert(err, IsNil)
+
+	data, err := os.ReadFile(filepath.Join(targetDir, "etc/chisel/image-info"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, ""+
+		"ID=ubuntu\n"+
+		"VERSION_ID=\"22.04\"\n"+
+		"ARCH=amd64\n"+
+		"CHISEL_VERSION=\"v1.0.0\"\n"+
+		"CHISEL_RELEASE_COMMIT=0123abcd\n"+
+		"SLICES=\"test-package_myslice test-package_info\"\n")
+	c.Assert(report.Entries["/etc/chisel/image-info"].Mode, Equals, fs.FileMode(0644))
+}
+
+// dumpManifest returns the entries of the manifest at path in a compact format
+// which is convenient for comparison.
+func dumpManifest(c *C, path string) map[string]string {
+	f, err := os.Open(path)
+	c.Assert(err, IsNil)
+	defer f.Close()
+	m, err := manifest.Read(f)
+	c.Assert(err, IsNil)
+
+	result := make(map[string]string)
+	generator, err := m.Generator()
+	c.Assert(err, IsNil)
+	if generator != nil {
+		result["generator "+generator.Name] = generator.Version
 	}
+	err = m.IteratePackages(func(pkg *manifest.Package) error {
+		result["package "+pkg.Name] = fmt.Sprintf("%s %s %s", pkg.Version, pkg.Arch, pkg.Digest)
+		if pkg.Size != 0 {
+			result["package "+pkg.Name] += fmt.Sprintf(" size:%d", pkg.Size)
+		}
+		return nil
+	})
+	c.Assert(err, IsNil)
+	err = m.IterateSlices("", func(slice *manifest.Slice) error {
+		result["slice "+slice.Name] = ""
+		if slice.Size != 0 {
+			result["slice "+slice.Name] = fmt.Sprintf("size:%d", slice.Size)
+		}
+		return nil
+	})
+	c.Assert(err, IsNil)
+	contents := make(map[string][]string)
+	err = m.IterateContents("", func(content *manifest.Content) error {
+		contents[content.Path] = append(contents[content.Path], content.Slice)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	err = m.IteratePaths("", func(path *manifest.Path) error {
+		fields := []string{path.Mode}
+		if path.SHA256 != "" {
+			fields = append(fields, path.SHA256[:8])
+		}
+		if path.FinalSHA256 != "" {
+			fields = append(fields, path.FinalSHA256[:8])
+		}
+		if path.Link != "" {
+			fields = append(fields, path.Link)
+		}
+		// Contents must agree with the slices listed for each path.
+		c.Assert(contents[path.Path], DeepEquals, path.Slices, Commentf("%s", path.Path))
+		fields = append(fields, "{"+strings.Join(path.Slices, ",")+"}")
+		if path.Implicit {
+			fields = append(fields, "implicit")
+		}
+		result["path "+path.Path] = strings.Join(fields, " ")
+		return nil
+	})
+	c.Assert(err, IsNil)
 	return result
 }
+
+func (s *S) TestRunManifestXattrs(c *C) {
+	releaseDir := c.MkDir()
+	release := map[string]string{
+		"chisel.yaml": defaultChiselYaml,
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/ping:
+						/db/**: {generate: manifest}
+		`,
+	}
+	for path, data := range release {
+		fpath := filepath.Join(releaseDir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+	r, err := setup.ReadRelease(releaseDir)
+	c.Assert(err, IsNil)
+	selection, err := setup.Select(r, []setup.SliceKey{{"test-package", "myslice"}})
+	c.Assert(err, IsNil)
+
+	pkgData := testutil.MustMakeDeb([]testutil.TarEntry{
+		testutil.Dir(0755, "./"),
+		{
+			Header: tar.Header{
+				Name:   "./ping",
+				Mode:   0755,
+				Format: tar.FormatPAX,
+				PAXRecords: map[string]string{
+					"SCHILY.xattr.security.capability": "\x01\x00\x00\x02",
+				},
+			},
+			Content: []byte("data"),
+		},
+	})
+	targetDir := c.MkDir()
+	report, err := slicer.Run(&slicer.RunOptions{
+		Selection: selection,
+		Archives: map[string]archive.Archive{
+			"ubuntu": &testArchive{
+				options: archive.Options{Arch: "amd64"},
+				pkgs:    map[string][]byte{"test-package": pkgData},
+			},
+		},
+		TargetDir: targetDir,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(report.Entries["/ping"].Xattrs, DeepEquals, map[string]string{"security.capability": "\x01\x00\x00\x02"})
+
+	f, err := os.Open(filepath.Join(targetDir, "db/manifest.wall"))
+	c.Assert(err, IsNil)
+	defer f.Close()
+	m, err := manifest.Read(f)
+	c.Assert(err, IsNil)
+	var paths []*manifest.Path
+	err = m.IteratePaths("/ping", func(path *manifest.Path) error {
+		paths = append(paths, path)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(paths, HasLen, 1)
+	c.Assert(paths[0].Xattrs, DeepEquals, map[string]string{"security.capability": "AQAAAg=="})
+}
+
+func (s *S) TestRunDryRun(c *C) {
+	releaseDir := c.MkDir()
+	release := map[string]string{
+		"chisel.yaml": defaultChiselYaml,
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+						/dir/text-file: {text: data1}
+						/dir/mutable-file: {text: data1, mutable: true}
+						/link: {symlink: /dir/file}
+					mutate: |
+						content.write("/dir/mutable-file", "data2")
+		`,
+	}
+	for path, data := range release {
+		fpath := filepath.Join(releaseDir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+	r, err := setup.ReadRelease(releaseDir)
+	c.Assert(err, IsNil)
+	selection, err := setup.Select(r, []setup.SliceKey{{"test-package", "myslice"}})
+	c.Assert(err, IsNil)
+
+	// The target directory is not even created.
+	targetDir := filepath.Join(c.MkDir(), "missing")
+	report, err := slicer.Run(&slicer.RunOptions{
+		Selection: selection,
+		Archives: map[string]archive.Archive{
+			"ubuntu": &testArchive{pkgs: map[string][]byte{"test-package": testutil.PackageData["test-package"]}},
+		},
+		TargetDir:    targetDir,
+		ManifestDirs: []string{"/var/lib/chisel/"},
+		DryRun:       true,
+	})
+	c.Assert(err, IsNil)
+	_, err = os.Lstat(targetDir)
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	c.Assert(report.Root, Equals, targetDir+"/")
+	// Mutation scripts are not run, and nothing is generated.
+	c.Assert(treeDumpReport(report), DeepEquals, map[string]string{
+		"/dir/file":         "file 0644 cc55e2ec {test-package_myslice}",
+		"/dir/text-file":    "file 0644 5b41362b {test-package_myslice}",
+		"/dir/mutable-file": "file 0644 5b41362b {test-package_myslice}",
+		"/link":             "symlink /dir/file {test-package_myslice}",
+	})
+}
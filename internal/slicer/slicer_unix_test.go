@@ -0,0 +1,78 @@
+//go:build linux || darwin
+
+package slicer_test
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/internal/slicer"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+func (s *S) TestRunUmask(c *C) {
+	releaseDir := c.MkDir()
+	release := map[string]string{
+		"chisel.yaml": defaultChiselYaml,
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/dir/file:
+						/parent/permissions/file:
+						/dir/text-file: {text: data1}
+						/other-dir/: {make: true, mode: 0775}
+		`,
+	}
+	for path, data := range release {
+		fpath := filepath.Join(releaseDir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+	r, err := setup.ReadRelease(releaseDir)
+	c.Assert(err, IsNil)
+	selection, err := setup.Select(r, []setup.SliceKey{{"test-package", "myslice"}})
+	c.Assert(err, IsNil)
+
+	// Concurrent runs create the same modes whatever the umask of the
+	// process, which is left alone.
+	oldUmask := syscall.Umask(0077)
+	defer syscall.Umask(oldUmask)
+	targetDirs := []string{c.MkDir(), c.MkDir()}
+	errs := make(chan error, len(targetDirs))
+	for _, targetDir := range targetDirs {
+		go func(targetDir string) {
+			_, err := slicer.Run(&slicer.RunOptions{
+				Selection: selection,
+				Archives: map[string]archive.Archive{
+					"ubuntu": &testArchive{pkgs: map[string][]byte{"test-package": testutil.PackageData["test-package"]}},
+				},
+				TargetDir: targetDir,
+			})
+			errs <- err
+		}(targetDir)
+	}
+	for range targetDirs {
+		c.Assert(<-errs, IsNil)
+	}
+	c.Assert(syscall.Umask(0077), Equals, 0077)
+	for _, targetDir := range targetDirs {
+		c.Assert(testutil.TreeDump(targetDir), DeepEquals, map[string]string{
+			"/dir/":                    "dir 0755",
+			"/dir/file":                "file 0644 cc55e2ec",
+			"/dir/text-file":           "file 0644 5b41362b",
+			"/other-dir/":              "dir 0775",
+			"/parent/":                 "dir 01777",
+			"/parent/permissions/":     "dir 0764",
+			"/parent/permissions/file": "file 0755 722c14b3",
+		})
+	}
+}
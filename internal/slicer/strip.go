@@ -0,0 +1,344 @@
+package slicer
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/canonical/chisel/internal/fsutil"
+)
+
+// debugFilesDir is where the debug information of stripped files is written
+// into the debug tree, as done by debug packages.
+const debugFilesDir = "/usr/lib/debug/"
+
+// stripFiles removes the debug sections of the ELF executables and shared
+// libraries among the reported regular files in targetDir, updating the
+// final digests of their entries in the report. If debugReport is not nil,
+// the debug information removed is written into its root and reported in
+// it, as done by debugFilePath.
+func stripFiles(targetDir string, report *Report, creator fsutil.Creator, debugReport *Report, debugCreator fsutil.Creator) error {
+	relPaths := make([]string, 0, len(report.Entries))
+	for relPath, entry := range report.Entries {
+		if entry.Mode.IsRegular() && !entry.Pruned {
+			relPaths = append(relPaths, relPath)
+		}
+	}
+	sort.Strings(relPaths)
+	for _, relPath := range relPaths {
+		path := filepath.Join(targetDir, relPath)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("cannot strip path: %w", err)
+		}
+		layout := readELFLayout(data)
+		if layout == nil {
+			continue
+		}
+		stripped, ok, err := layout.strip()
+		if err != nil {
+			return fmt.Errorf("cannot strip %s: %w", relPath, err)
+		}
+		if !ok {
+			continue
+		}
+		debugf("Stripping path: %s (%d to %d bytes)", relPath, len(data), len(stripped))
+		if debugReport != nil {
+			debugData, err := layout.debugOnly()
+			if err != nil {
+				return fmt.Errorf("cannot strip %s: %w", relPath, err)
+			}
+			debugPath := filepath.Join(debugReport.Root, debugFilePath(layout.file, relPath))
+			debugEntry, err := debugCreator.Create(&fsutil.CreateOptions{
+				Path:        debugPath,
+				Mode:        0644,
+				Data:        bytes.NewReader(debugData),
+				MakeParents: true,
+			})
+			if err != nil {
+				return err
+			}
+			for slice := range report.Entries[relPath].Slices {
+				err = debugReport.Add(slice, debugEntry)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		// Writing the file drops its capabilities, so its extended
+		// attributes are set again.
+		entry, err := creator.Create(&fsutil.CreateOptions{
+			Path:   path,
+			Mode:   report.Entries[relPath].Mode,
+			Data:   bytes.NewReader(stripped),
+			Xattrs: report.Entries[relPath].Xattrs,
+		})
+		if err != nil {
+			return err
+		}
+		err = report.Mutate(entry)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// debugFilePath returns the path, relative to the debug tree, where the
+// debug information of the ELF file at relPath is written. It is named after
+// the GNU build ID of the file when it has one, so debuggers may find it.
+func debugFilePath(f *elf.File, relPath string) string {
+	if section := f.Section(".note.gnu.build-id"); section != nil {
+		note, err := section.Data()
+		// The note holds the sizes of the name and of the ID, its type,
+		// the "GNU" name padded to four bytes, and the ID itself.
+		if err == nil && len(note) > 16 {
+			nameSize := f.ByteOrder.Uint32(note[0:])
+			idSize := f.ByteOrder.Uint32(note[4:])
+			if nameSize == 4 && string(note[12:16]) == "GNU\x00" && idSize > 1 && uint64(len(note)) >= 16+uint64(idSize) {
+				id := hex.EncodeToString(note[16 : 16+idSize])
+				return debugFilesDir + ".build-id/" + id[:2] + "/" + id[2:] + ".debug"
+			}
+		}
+	}
+	return filepath.Join(debugFilesDir, relPath) + ".debug"
+}
+
+// elfLayout holds the location of the headers of an ELF executable or
+// shared library, and where their fields are found.
+type elfLayout struct {
+	file     *elf.File
+	data     []byte
+	order    binary.ByteOrder
+	wordSize int
+	// Offsets of the fields which are read or updated in the file header
+	// and in section headers.
+	phoffAt, shoffAt, ehsizeAt, phentsizeAt, phnumAt, shentsizeAt, shnumAt int
+	shOffsetAt                                                             int
+
+	shoff, shentsize, shnum uint64
+}
+
+// readELFLayout returns the layout of the ELF executable or shared library
+// in data, or nil if data is not in the ELF format, if it holds any other
+// kind of ELF file, or if its headers are not supported.
+func readELFLayout(data []byte) *elfLayout {
+	if !bytes.HasPrefix(data, []byte(elf.ELFMAG)) {
+		return nil
+	}
+	f, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil || (f.Type != elf.ET_EXEC && f.Type != elf.ET_DYN) {
+		return nil
+	}
+	l := &elfLayout{file: f, data: data, order: f.ByteOrder}
+	if f.Class == elf.ELFCLASS64 {
+		l.phoffAt, l.shoffAt, l.ehsizeAt, l.phentsizeAt, l.phnumAt, l.shentsizeAt, l.shnumAt = 32, 40, 52, 54, 56, 58, 60
+		l.shOffsetAt, l.wordSize = 24, 8
+	} else {
+		l.phoffAt, l.shoffAt, l.ehsizeAt, l.phentsizeAt, l.phnumAt, l.shentsizeAt, l.shnumAt = 28, 32, 40, 42, 44, 46, 48
+		l.shOffsetAt, l.wordSize = 16, 4
+	}
+	l.shoff = l.word(data[l.shoffAt:])
+	l.shentsize = uint64(l.order.Uint16(data[l.shentsizeAt:]))
+	l.shnum = uint64(l.order.Uint16(data[l.shnumAt:]))
+	if l.shnum == 0 || l.shnum != uint64(len(f.Sections)) || l.shoff+l.shnum*l.shentsize > uint64(len(data)) {
+		// Extended section numbering is not supported.
+		return nil
+	}
+	return l
+}
+
+func (l *elfLayout) word(b []byte) uint64 {
+	if l.wordSize == 8 {
+		return l.order.Uint64(b)
+	}
+	return uint64(l.order.Uint32(b))
+}
+
+func (l *elfLayout) putWord(b []byte, v uint64) {
+	if l.wordSize == 8 {
+		l.order.PutUint64(b, v)
+	} else {
+		l.order.PutUint32(b, uint32(v))
+	}
+}
+
+// isDebugSection returns whether the section only holds debug information.
+func isDebugSection(section *elf.Section) bool {
+	if section.Flags&elf.SHF_ALLOC != 0 {
+		return false
+	}
+	return strings.HasPrefix(section.Name, ".debug") || strings.HasPrefix(section.Name, ".zdebug")
+}
+
+// strip returns the content of the file without the content of its debug
+// sections, and whether anything was removed. The headers of debug sections
+// are kept, with no content, so that the indexes of the other sections do
+// not change, and only content placed after everything loaded at runtime is
+// moved. Files whose layout does not allow for that are left alone.
+func (l *elfLayout) strip() (stripped []byte, ok bool, err error) {
+	f, data := l.file, l.data
+
+	// Everything up to end is kept in place.
+	end := uint64(l.order.Uint16(data[l.ehsizeAt:]))
+	phend := l.word(data[l.phoffAt:]) + uint64(l.order.Uint16(data[l.phentsizeAt:]))*uint64(l.order.Uint16(data[l.phnumAt:]))
+	end = max(end, phend)
+	for _, prog := range f.Progs {
+		end = max(end, prog.Off+prog.Filesz)
+	}
+	var removed, moved []int
+	for i, section := range f.Sections {
+		if i == 0 || section.Type == elf.SHT_NOBITS {
+			continue
+		}
+		if section.Flags&elf.SHF_ALLOC != 0 {
+			end = max(end, section.Offset+section.FileSize)
+		} else if isDebugSection(section) {
+			removed = append(removed, i)
+		} else {
+			moved = append(moved, i)
+		}
+	}
+	if len(removed) == 0 || end > uint64(len(data)) {
+		return nil, false, nil
+	}
+	// Content which is not described by any section, such as a payload
+	// appended to the file, must not be lost.
+	covered := max(end, l.shoff+l.shnum*l.shentsize)
+	for i, section := range f.Sections {
+		if i > 0 && section.Type != elf.SHT_NOBITS {
+			covered = max(covered, section.Offset+section.FileSize)
+		}
+	}
+	if covered < uint64(len(data)) {
+		return nil, false, nil
+	}
+	for _, i := range removed {
+		if f.Sections[i].Offset < end {
+			return nil, false, nil
+		}
+	}
+
+	out := append([]byte(nil), data[:end]...)
+	offsets := make(map[int]uint64)
+	for _, i := range moved {
+		if f.Sections[i].Offset >= end {
+			offsets[i] = 0
+		}
+	}
+	out, ok = l.appendSections(out, offsets)
+	if !ok {
+		return nil, false, nil
+	}
+	for _, i := range removed {
+		offsets[i] = uint64(len(out))
+	}
+	out, err = l.appendSectionHeaders(out, offsets, removed)
+	if err != nil {
+		return nil, false, err
+	}
+	return out, true, nil
+}
+
+// debugOnly returns the content of a file holding the debug information of
+// this one, to be used by debuggers along with the stripped file. As in the
+// files shipped in debug packages, every section is kept, but only debug
+// sections, symbol tables and notes, which hold the build ID, have content.
+func (l *elfLayout) debugOnly() ([]byte, error) {
+	f, data := l.file, l.data
+
+	out := append([]byte(nil), data[:l.order.Uint16(data[l.ehsizeAt:])]...)
+	// Program headers would refer to content which is not in the file.
+	l.putWord(out[l.phoffAt:], 0)
+	l.order.PutUint16(out[l.phnumAt:], 0)
+
+	offsets := make(map[int]uint64)
+	var emptied []int
+	for i, section := range f.Sections {
+		if i == 0 {
+			continue
+		}
+		switch {
+		case section.Type == elf.SHT_NOBITS:
+			emptied = append(emptied, i)
+		case isDebugSection(section), section.Type == elf.SHT_SYMTAB, section.Type == elf.SHT_NOTE,
+			section.Type == elf.SHT_STRTAB && section.Flags&elf.SHF_ALLOC == 0:
+			offsets[i] = 0
+		default:
+			emptied = append(emptied, i)
+		}
+	}
+	out, ok := l.appendSections(out, offsets)
+	if !ok {
+		return nil, fmt.Errorf("cannot read sections")
+	}
+	for _, i := range emptied {
+		offsets[i] = uint64(len(out))
+	}
+	return l.appendSectionHeaders(out, offsets, emptied)
+}
+
+// appendSections appends to out the content of the sections in offsets, by
+// order of their original offset, and sets their new offsets. It returns
+// false if any of them lies outside of the file.
+func (l *elfLayout) appendSections(out []byte, offsets map[int]uint64) ([]byte, bool) {
+	var indexes []int
+	for i := range offsets {
+		indexes = append(indexes, i)
+	}
+	sections := l.file.Sections
+	sort.Slice(indexes, func(i, j int) bool {
+		return sections[indexes[i]].Offset < sections[indexes[j]].Offset
+	})
+	for _, i := range indexes {
+		section := sections[i]
+		if section.Type == elf.SHT_NOBITS {
+			continue
+		}
+		if section.Offset+section.FileSize > uint64(len(l.data)) {
+			return nil, false
+		}
+		out = alignBytes(out, section.Addralign)
+		offsets[i] = uint64(len(out))
+		out = append(out, l.data[section.Offset:section.Offset+section.FileSize]...)
+	}
+	return out, true
+}
+
+// appendSectionHeaders appends to out the section headers of the file, with
+// the offsets of the sections in offsets updated and the sections in nobits
+// left without content, and points the file header at them. The result is
+// verified to be readable.
+func (l *elfLayout) appendSectionHeaders(out []byte, offsets map[int]uint64, nobits []int) ([]byte, error) {
+	out = alignBytes(out, uint64(l.wordSize))
+	shoff := uint64(len(out))
+	out = append(out, l.data[l.shoff:l.shoff+l.shnum*l.shentsize]...)
+	for i, offset := range offsets {
+		header := out[shoff+uint64(i)*l.shentsize:]
+		l.putWord(header[l.shOffsetAt:], offset)
+	}
+	for _, i := range nobits {
+		header := out[shoff+uint64(i)*l.shentsize:]
+		l.order.PutUint32(header[4:], uint32(elf.SHT_NOBITS))
+	}
+	l.putWord(out[l.shoffAt:], shoff)
+
+	_, err := elf.NewFile(bytes.NewReader(out))
+	if err != nil {
+		return nil, fmt.Errorf("internal error: cannot read rewritten file: %w", err)
+	}
+	return out, nil
+}
+
+func alignBytes(data []byte, alignment uint64) []byte {
+	if alignment > 1 && uint64(len(data))%alignment != 0 {
+		data = append(data, make([]byte, alignment-uint64(len(data))%alignment)...)
+	}
+	return data
+}
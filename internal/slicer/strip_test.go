@@ -0,0 +1,171 @@
+package slicer_test
+
+import (
+	"bytes"
+	"debug/elf"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/manifest"
+	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/internal/slicer"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+func (s *S) TestStripELF(c *C) {
+	data := testutil.MustMakeDebugELF([]string{"libc.so.6"}, nil, "debug data")
+	stripped, ok, err := slicer.StripELF(data)
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, true)
+	c.Assert(len(stripped) < len(data), Equals, true)
+	c.Assert(bytes.Contains(stripped, []byte("debug data")), Equals, false)
+
+	f, err := elf.NewFile(bytes.NewReader(stripped))
+	c.Assert(err, IsNil)
+	needed, err := f.ImportedLibraries()
+	c.Assert(err, IsNil)
+	c.Assert(needed, DeepEquals, []string{"libc.so.6"})
+	c.Assert(f.Section(".debug_info").Type, Equals, elf.SHT_NOBITS)
+
+	debugOnly, err := slicer.DebugOnlyELF(data)
+	c.Assert(err, IsNil)
+	f, err = elf.NewFile(bytes.NewReader(debugOnly))
+	c.Assert(err, IsNil)
+	debugInfo, err := f.Section(".debug_info").Data()
+	c.Assert(err, IsNil)
+	c.Assert(string(debugInfo), Equals, "debug data")
+	c.Assert(f.Section(".dynamic").Type, Equals, elf.SHT_NOBITS)
+
+	// Files without debug sections are left alone.
+	_, ok, err = slicer.StripELF(testutil.MustMakeELF([]string{"libc.so.6"}))
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, false)
+
+	// So are files which are not in the ELF format.
+	_, ok, err = slicer.StripELF([]byte("#!/bin/sh\n"))
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, false)
+}
+
+func (s *S) TestStripELFExecutable(c *C) {
+	// The test binary itself carries debug information.
+	data, err := os.ReadFile(os.Args[0])
+	c.Assert(err, IsNil)
+	f, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil || f.Section(".debug_info") == nil {
+		c.Skip("test binary has no debug information")
+	}
+
+	stripped, ok, err := slicer.StripELF(data)
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, true)
+	c.Assert(len(stripped) < len(data), Equals, true)
+	f, err = elf.NewFile(bytes.NewReader(stripped))
+	c.Assert(err, IsNil)
+	_, err = f.DWARF()
+	c.Assert(err, NotNil)
+
+	debugOnly, err := slicer.DebugOnlyELF(data)
+	c.Assert(err, IsNil)
+	c.Assert(len(debugOnly) < len(data), Equals, true)
+	f, err = elf.NewFile(bytes.NewReader(debugOnly))
+	c.Assert(err, IsNil)
+	_, err = f.DWARF()
+	c.Assert(err, IsNil)
+
+	path := filepath.Join(c.MkDir(), "test")
+	err = os.WriteFile(path, stripped, 0755)
+	c.Assert(err, IsNil)
+	output, err := exec.Command(path, "-test.list", "^TestNothing$").CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", output))
+}
+
+func (s *S) TestRunDebugDir(c *C) {
+	releaseDir := c.MkDir()
+	release := map[string]string{
+		"chisel.yaml": defaultChiselYaml,
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/usr/bin/app:
+						/usr/bin/other:
+		`,
+	}
+	for path, data := range release {
+		fpath := filepath.Join(releaseDir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+	r, err := setup.ReadRelease(releaseDir)
+	c.Assert(err, IsNil)
+	selection, err := setup.Select(r, []setup.SliceKey{{"test-package", "myslice"}})
+	c.Assert(err, IsNil)
+
+	pkgData := testutil.MustMakeDeb([]testutil.TarEntry{
+		testutil.Dir(0755, "./"),
+		testutil.Dir(0755, "./usr/"),
+		testutil.Dir(0755, "./usr/bin/"),
+		testutil.Reg(0755, "./usr/bin/app", string(testutil.MustMakeDebugELF(nil, []byte{0xab, 0xcd, 0xef}, "app debug data"))),
+		testutil.Reg(0755, "./usr/bin/other", string(testutil.MustMakeDebugELF(nil, nil, "other debug data"))),
+	})
+	options := &slicer.RunOptions{
+		Selection: selection,
+		Archives: map[string]archive.Archive{
+			"ubuntu": &testArchive{pkgs: map[string][]byte{"test-package": pkgData}},
+		},
+		TargetDir: c.MkDir(),
+		DebugDir:  c.MkDir(),
+	}
+	_, err = slicer.Run(options)
+	c.Assert(err, ErrorMatches, "cannot write debug information without stripping")
+
+	options.Strip = true
+	report, err := slicer.Run(options)
+	c.Assert(err, IsNil)
+
+	c.Assert(report.Debug, NotNil)
+	c.Assert(filepath.Clean(report.Debug.Root), Equals, options.DebugDir)
+	debugPaths := make([]string, 0, len(report.Debug.Entries))
+	for path, entry := range report.Debug.Entries {
+		c.Assert(entry.Slices, HasLen, 1)
+		debugPaths = append(debugPaths, path)
+	}
+	sort.Strings(debugPaths)
+	c.Assert(debugPaths, DeepEquals, []string{
+		"/usr/lib/debug/.build-id/ab/cdef.debug",
+		"/usr/lib/debug/usr/bin/other.debug",
+	})
+	data, err := os.ReadFile(filepath.Join(options.DebugDir, "usr/lib/debug/usr/bin/other.debug"))
+	c.Assert(err, IsNil)
+	c.Assert(bytes.Contains(data, []byte("other debug data")), Equals, true)
+	data, err = os.ReadFile(filepath.Join(options.TargetDir, "usr/bin/other"))
+	c.Assert(err, IsNil)
+	c.Assert(bytes.Contains(data, []byte("other debug data")), Equals, false)
+
+	// The debug tree has its own manifest.
+	file, err := os.Open(filepath.Join(options.DebugDir, "var/lib/chisel/manifest.wall"))
+	c.Assert(err, IsNil)
+	defer file.Close()
+	mfest, err := manifest.Read(file)
+	c.Assert(err, IsNil)
+	var manifestPaths []string
+	err = mfest.IteratePaths("", func(path *manifest.Path) error {
+		manifestPaths = append(manifestPaths, path.Path)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(manifestPaths, DeepEquals, []string{
+		"/usr/lib/debug/.build-id/ab/cdef.debug",
+		"/usr/lib/debug/usr/bin/other.debug",
+		"/var/lib/chisel/manifest.wall",
+	})
+}
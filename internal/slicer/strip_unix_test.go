@@ -0,0 +1,97 @@
+//go:build linux || darwin
+
+package slicer_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/archive"
+	"github.com/canonical/chisel/internal/setup"
+	"github.com/canonical/chisel/internal/slicer"
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+func (s *S) TestRunStripXattrs(c *C) {
+	releaseDir := c.MkDir()
+	release := map[string]string{
+		"chisel.yaml": defaultChiselYaml,
+		"slices/mydir/test-package.yaml": `
+			package: test-package
+			slices:
+				myslice:
+					contents:
+						/usr/bin/ping:
+		`,
+	}
+	for path, data := range release {
+		fpath := filepath.Join(releaseDir, path)
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		c.Assert(err, IsNil)
+		err = os.WriteFile(fpath, testutil.Reindent(data), 0644)
+		c.Assert(err, IsNil)
+	}
+	r, err := setup.ReadRelease(releaseDir)
+	c.Assert(err, IsNil)
+	selection, err := setup.Select(r, []setup.SliceKey{{"test-package", "myslice"}})
+	c.Assert(err, IsNil)
+
+	// Effective cap_net_raw, as set on ping.
+	capability := "\x01\x00\x00\x02\x00\x20\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"
+	probe := filepath.Join(c.MkDir(), "probe")
+	err = os.WriteFile(probe, nil, 0755)
+	c.Assert(err, IsNil)
+	err = unix.Lsetxattr(probe, "security.capability", []byte(capability), 0)
+	if err != nil {
+		c.Skip("cannot set file capabilities: " + err.Error())
+	}
+
+	pkgData := testutil.MustMakeDeb([]testutil.TarEntry{
+		testutil.Dir(0755, "./"),
+		testutil.Dir(0755, "./usr/"),
+		testutil.Dir(0755, "./usr/bin/"),
+		{
+			Header: tar.Header{
+				Name:   "./usr/bin/ping",
+				Mode:   0755,
+				Format: tar.FormatPAX,
+				PAXRecords: map[string]string{
+					"SCHILY.xattr.security.capability": capability,
+				},
+			},
+			Content: testutil.MustMakeDebugELF(nil, nil, "ping debug data"),
+		},
+	})
+	targetDir := c.MkDir()
+	report, err := slicer.Run(&slicer.RunOptions{
+		Selection: selection,
+		Archives: map[string]archive.Archive{
+			"ubuntu": &testArchive{pkgs: map[string][]byte{"test-package": pkgData}},
+		},
+		TargetDir: targetDir,
+		Strip:     true,
+	})
+	c.Assert(err, IsNil)
+
+	entry := report.Entries["/usr/bin/ping"]
+	c.Assert(entry.FinalHash, Not(Equals), "")
+	c.Assert(entry.Xattrs, DeepEquals, map[string]string{"security.capability": capability})
+
+	// Rewriting the file drops its capabilities, so they are set again.
+	path := filepath.Join(targetDir, "usr/bin/ping")
+	data, err := os.ReadFile(path)
+	c.Assert(err, IsNil)
+	c.Assert(bytes.Contains(data, []byte("ping debug data")), Equals, false)
+	buf := make([]byte, 64)
+	n, err := unix.Lgetxattr(path, "security.capability", buf)
+	c.Assert(err, IsNil)
+	c.Assert(string(buf[:n]), Equals, capability)
+	info, err := os.Stat(path)
+	c.Assert(err, IsNil)
+	c.Assert(info.Mode().Perm(), Equals, os.FileMode(0755))
+}
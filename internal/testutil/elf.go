@@ -0,0 +1,135 @@
+package testutil
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"strings"
+)
+
+// MustMakeELF returns the content of a minimal 64-bit little-endian ELF file
+// whose dynamic section lists the provided libraries as DT_NEEDED entries.
+// The file has no code and cannot be executed, but it is enough for tools
+// inspecting its dependencies.
+func MustMakeELF(needed []string) []byte {
+	return MustMakeDebugELF(needed, nil, "")
+}
+
+// MustMakeDebugELF returns the content of an ELF file as MustMakeELF, with
+// an additional .debug_info section holding debugInfo, and with a GNU build
+// ID note holding buildID, unless they are empty.
+func MustMakeDebugELF(needed []string, buildID []byte, debugInfo string) []byte {
+	dynstr := []byte{0}
+	var dynamic []elf.Dyn64
+	for _, soname := range needed {
+		dynamic = append(dynamic, elf.Dyn64{Tag: int64(elf.DT_NEEDED), Val: uint64(len(dynstr))})
+		dynstr = append(dynstr, soname...)
+		dynstr = append(dynstr, 0)
+	}
+	dynamic = append(dynamic, elf.Dyn64{Tag: int64(elf.DT_NULL)})
+	var dynamicData bytes.Buffer
+	mustWrite(&dynamicData, dynamic)
+
+	type extraSection struct {
+		name string
+		kind elf.SectionType
+		data []byte
+	}
+	var extra []extraSection
+	if debugInfo != "" {
+		extra = append(extra, extraSection{".debug_info", elf.SHT_PROGBITS, []byte(debugInfo)})
+	}
+	if len(buildID) > 0 {
+		var note bytes.Buffer
+		// The type of GNU build ID notes is NT_GNU_BUILD_ID.
+		mustWrite(&note, []uint32{4, uint32(len(buildID)), 3})
+		note.WriteString("GNU\x00")
+		note.Write(buildID)
+		extra = append(extra, extraSection{".note.gnu.build-id", elf.SHT_NOTE, note.Bytes()})
+	}
+
+	names := []string{"", ".dynstr", ".dynamic", ".shstrtab"}
+	for _, section := range extra {
+		names = append(names, section.name)
+	}
+	shstrtab := []byte(strings.Join(names, "\x00") + "\x00")
+	nameOffset := func(i int) uint32 {
+		return uint32(len(strings.Join(names[:i], "\x00")) + 1)
+	}
+
+	const headerSize = 64
+	const sectionSize = 64
+	dynstrOffset := uint64(headerSize)
+	dynamicOffset := dynstrOffset + uint64(len(dynstr))
+	shstrtabOffset := dynamicOffset + uint64(dynamicData.Len())
+	sectionsOffset := shstrtabOffset + uint64(len(shstrtab))
+	for _, section := range extra {
+		sectionsOffset += uint64(len(section.data))
+	}
+
+	header := elf.Header64{
+		Type:      uint16(elf.ET_DYN),
+		Machine:   uint16(elf.EM_X86_64),
+		Version:   uint32(elf.EV_CURRENT),
+		Shoff:     sectionsOffset,
+		Ehsize:    headerSize,
+		Shentsize: sectionSize,
+		Shnum:     uint16(len(names)),
+		Shstrndx:  3,
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	sections := []elf.Section64{{}, {
+		Name:      nameOffset(1),
+		Type:      uint32(elf.SHT_STRTAB),
+		Off:       dynstrOffset,
+		Size:      uint64(len(dynstr)),
+		Addralign: 1,
+	}, {
+		Name:      nameOffset(2),
+		Type:      uint32(elf.SHT_DYNAMIC),
+		Off:       dynamicOffset,
+		Size:      uint64(dynamicData.Len()),
+		Link:      1,
+		Addralign: 8,
+		Entsize:   16,
+	}, {
+		Name:      nameOffset(3),
+		Type:      uint32(elf.SHT_STRTAB),
+		Off:       shstrtabOffset,
+		Size:      uint64(len(shstrtab)),
+		Addralign: 1,
+	}}
+	extraOffset := shstrtabOffset + uint64(len(shstrtab))
+	for i, section := range extra {
+		sections = append(sections, elf.Section64{
+			Name:      nameOffset(4 + i),
+			Type:      uint32(section.kind),
+			Off:       extraOffset,
+			Size:      uint64(len(section.data)),
+			Addralign: 1,
+		})
+		extraOffset += uint64(len(section.data))
+	}
+
+	var buf bytes.Buffer
+	mustWrite(&buf, &header)
+	buf.Write(dynstr)
+	buf.Write(dynamicData.Bytes())
+	buf.Write(shstrtab)
+	for _, section := range extra {
+		buf.Write(section.data)
+	}
+	mustWrite(&buf, sections)
+	return buf.Bytes()
+}
+
+func mustWrite(buf *bytes.Buffer, data any) {
+	err := binary.Write(buf, binary.LittleEndian, data)
+	if err != nil {
+		panic(err)
+	}
+}
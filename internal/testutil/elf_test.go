@@ -0,0 +1,49 @@
+package testutil_test
+
+import (
+	"bytes"
+	"debug/elf"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/testutil"
+)
+
+type elfSuite struct{}
+
+var _ = Suite(&elfSuite{})
+
+func (s *elfSuite) TestMustMakeELF(c *C) {
+	data := testutil.MustMakeELF([]string{"libc.so.6", "libfoo.so.1"})
+	f, err := elf.NewFile(bytes.NewReader(data))
+	c.Assert(err, IsNil)
+	needed, err := f.ImportedLibraries()
+	c.Assert(err, IsNil)
+	c.Assert(needed, DeepEquals, []string{"libc.so.6", "libfoo.so.1"})
+
+	data = testutil.MustMakeELF(nil)
+	f, err = elf.NewFile(bytes.NewReader(data))
+	c.Assert(err, IsNil)
+	needed, err = f.ImportedLibraries()
+	c.Assert(err, IsNil)
+	c.Assert(needed, HasLen, 0)
+}
+
+func (s *elfSuite) TestMustMakeDebugELF(c *C) {
+	data := testutil.MustMakeDebugELF([]string{"libc.so.6"}, []byte{0xab, 0xcd}, "debug data")
+	f, err := elf.NewFile(bytes.NewReader(data))
+	c.Assert(err, IsNil)
+	needed, err := f.ImportedLibraries()
+	c.Assert(err, IsNil)
+	c.Assert(needed, DeepEquals, []string{"libc.so.6"})
+	section := f.Section(".debug_info")
+	c.Assert(section, NotNil)
+	debugInfo, err := section.Data()
+	c.Assert(err, IsNil)
+	c.Assert(string(debugInfo), Equals, "debug data")
+	section = f.Section(".note.gnu.build-id")
+	c.Assert(section, NotNil)
+	note, err := section.Data()
+	c.Assert(err, IsNil)
+	c.Assert(note, DeepEquals, []byte("\x04\x00\x00\x00\x02\x00\x00\x00\x03\x00\x00\x00GNU\x00\xab\xcd"))
+}
@@ -348,6 +348,12 @@ func (s *pkgdataSuite) TestMakeDeb(c *C) {
 
 	arHeader, err := arReader.Next()
 	c.Assert(err, IsNil)
+	c.Assert(arHeader.Name, Equals, "debian-binary")
+	arHeader, err = arReader.Next()
+	c.Assert(err, IsNil)
+	c.Assert(arHeader.Name, Equals, "control.tar.zst")
+	arHeader, err = arReader.Next()
+	c.Assert(err, IsNil)
 	c.Assert(arHeader.Name, Equals, "data.tar.zst")
 	c.Assert(arHeader.Mode, Equals, int64(0644))
 	c.Assert(int(arHeader.Size), testutil.IntGreaterThan, 0)
@@ -29,6 +29,12 @@ func TreeDump(dir string) map[string]string {
 		if finfo.Mode()&fs.ModeSticky != 0 {
 			fperm |= 01000
 		}
+		if finfo.Mode()&fs.ModeSetgid != 0 {
+			fperm |= 02000
+		}
+		if finfo.Mode()&fs.ModeSetuid != 0 {
+			fperm |= 04000
+		}
 		fpath := filepath.Join(dir, path)
 		switch ftype {
 		case fs.ModeDir:
@@ -52,6 +58,8 @@ func TreeDump(dir string) map[string]string {
 				entry = fmt.Sprintf("file %#o %.4x", fperm, sum)
 			}
 			result["/"+path] = entry
+		case fs.ModeNamedPipe:
+			result["/"+path] = fmt.Sprintf("fifo %#o", fperm)
 		default:
 			return fmt.Errorf("unknown file type %d: %s", ftype, fpath)
 		}
@@ -80,6 +88,8 @@ func TreeDumpEntry(entry *fsutil.Entry) string {
 		} else {
 			return fmt.Sprintf("file %#o %s", fperm, entry.Hash[:8])
 		}
+	case fs.ModeNamedPipe:
+		return fmt.Sprintf("fifo %#o", fperm)
 	default:
 		panic(fmt.Errorf("unknown file type %d: %s", entry.Mode.Type(), entry.Path))
 	}
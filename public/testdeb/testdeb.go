@@ -0,0 +1,302 @@
+// Package testdeb builds Debian packages holding arbitrary file trees, so
+// that programs using chisel as a library may be tested hermetically,
+// without fetching real packages from an archive.
+//
+// The packages built are valid .deb files, with a debian-binary member, a
+// control archive with a minimal control file and a data archive holding
+// the provided entries:
+//
+//	data, err := testdeb.Make([]testdeb.Entry{
+//		testdeb.Dir(0755, "./usr/"),
+//		testdeb.Dir(0755, "./usr/bin/"),
+//		testdeb.Reg(0755, "./usr/bin/hello", "#!/bin/sh\necho hello\n"),
+//		testdeb.Lnk(0777, "./usr/bin/hi", "hello"),
+//	}, &testdeb.Options{Package: "hello", Compression: testdeb.Gzip})
+package testdeb
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/blakesmith/ar"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// Entry is an entry of the data archive of a package.
+//
+// Unless NoFixup is set, fields left unset in Header are filled in with
+// defaults: the type is inferred from the name and link, the mode is 0755
+// for directories, 0777 for symlinks and 0644 otherwise, the size is the
+// length of Content, the owner is root, the modification time is the
+// start of the Unix epoch and the format is GNU.
+type Entry struct {
+	Header  tar.Header
+	NoFixup bool
+	Content []byte
+}
+
+// Reg is a shortcut for creating a regular file Entry structure (with
+// tar.Typeflag set tar.TypeReg). Reg stands for "REGular file".
+func Reg(mode int64, path, content string) Entry {
+	return Entry{
+		Header: tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     path,
+			Mode:     mode,
+		},
+		Content: []byte(content),
+	}
+}
+
+// Dir is a shortcut for creating a directory Entry structure (with
+// tar.Typeflag set to tar.TypeDir). Dir stands for "DIRectory".
+func Dir(mode int64, path string) Entry {
+	return Entry{
+		Header: tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     path,
+			Mode:     mode,
+		},
+	}
+}
+
+// Lnk is a shortcut for creating a symbolic link Entry structure (with
+// tar.Typeflag set to tar.TypeSymlink). Lnk stands for "symbolic LiNK".
+func Lnk(mode int64, path, target string) Entry {
+	return Entry{
+		Header: tar.Header{
+			Typeflag: tar.TypeSymlink,
+			Name:     path,
+			Mode:     mode,
+			Linkname: target,
+		},
+	}
+}
+
+// Hln is a shortcut for creating a hard link Entry structure (with
+// tar.Typeflag set to tar.TypeLink). Hln stands for "Hard LiNk".
+func Hln(mode int64, path, target string) Entry {
+	return Entry{
+		Header: tar.Header{
+			Typeflag: tar.TypeLink,
+			Name:     path,
+			Mode:     mode,
+			Linkname: target,
+		},
+	}
+}
+
+// Owned returns entry owned by the provided user and group.
+func Owned(entry Entry, uid, gid int, uname, gname string) Entry {
+	entry.Header.Uid, entry.Header.Gid = uid, gid
+	entry.Header.Uname, entry.Header.Gname = uname, gname
+	return entry
+}
+
+// Compression is the compression of the archives within a package, named
+// after the extension it gives to their names.
+type Compression string
+
+const (
+	Zstd Compression = "zst"
+	Gzip Compression = "gz"
+	Xz   Compression = "xz"
+	Lzma Compression = "lzma"
+	// None leaves the archives uncompressed, without any extension.
+	None Compression = "none"
+)
+
+// Options holds the details of the package built by Make.
+type Options struct {
+	// Package, Version and Arch are written into the control file, and
+	// default to "test-package", "1.0" and "all" respectively.
+	Package string
+	Version string
+	Arch    string
+	// Compression defaults to Zstd.
+	Compression Compression
+}
+
+var epochStartTime = time.Unix(0, 0)
+
+// Make returns a package whose data archive holds entries, in the order
+// provided. Options may be nil.
+func Make(entries []Entry, options *Options) ([]byte, error) {
+	o := Options{}
+	if options != nil {
+		o = *options
+	}
+	if o.Package == "" {
+		o.Package = "test-package"
+	}
+	if o.Version == "" {
+		o.Version = "1.0"
+	}
+	if o.Arch == "" {
+		o.Arch = "all"
+	}
+	if o.Compression == "" {
+		o.Compression = Zstd
+	}
+
+	control := fmt.Sprintf("Package: %s\nVersion: %s\nArchitecture: %s\nMaintainer: Nobody <nobody@example.com>\nDescription: Package built for testing\n", o.Package, o.Version, o.Arch)
+	controlTar, err := MakeTar([]Entry{
+		Dir(0755, "./"),
+		Reg(0644, "./control", control),
+	})
+	if err != nil {
+		return nil, err
+	}
+	dataTar, err := MakeTar(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	writer := ar.NewWriter(&buf)
+	if err := writer.WriteGlobalHeader(); err != nil {
+		return nil, err
+	}
+	err = writeMember(writer, "debian-binary", []byte("2.0\n"))
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range []struct {
+		name string
+		data []byte
+	}{{"control.tar", controlTar}, {"data.tar", dataTar}} {
+		compressed, err := compress(member.data, o.Compression)
+		if err != nil {
+			return nil, err
+		}
+		name := member.name
+		if o.Compression != None {
+			name += "." + string(o.Compression)
+		}
+		err = writeMember(writer, name, compressed)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// MustMake is like Make but panics on errors.
+func MustMake(entries []Entry, options *Options) []byte {
+	data, err := Make(entries, options)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// MakeTar returns an uncompressed tarball holding entries, with the same
+// defaults applied to them as in Make.
+func MakeTar(entries []Entry) ([]byte, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		fixupEntry(&entry)
+		if err := tw.WriteHeader(&entry.Header); err != nil {
+			return nil, err
+		}
+		if entry.Content != nil {
+			if _, err := tw.Write(entry.Content); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func fixupEntry(entry *Entry) {
+	if entry.NoFixup {
+		return
+	}
+	hdr := &entry.Header
+	if hdr.Typeflag == 0 {
+		if hdr.Linkname != "" {
+			hdr.Typeflag = tar.TypeSymlink
+		} else if strings.HasSuffix(hdr.Name, "/") {
+			hdr.Typeflag = tar.TypeDir
+		} else {
+			hdr.Typeflag = tar.TypeReg
+		}
+	}
+	if hdr.Mode == 0 {
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			hdr.Mode = 0755
+		case tar.TypeSymlink:
+			hdr.Mode = 0777
+		default:
+			hdr.Mode = 0644
+		}
+	}
+	if hdr.Size == 0 && entry.Content != nil {
+		hdr.Size = int64(len(entry.Content))
+	}
+	if hdr.Uid == 0 && hdr.Uname == "" {
+		hdr.Uname = "root"
+	}
+	if hdr.Gid == 0 && hdr.Gname == "" {
+		hdr.Gname = "root"
+	}
+	if hdr.ModTime.IsZero() {
+		hdr.ModTime = epochStartTime
+	}
+	if hdr.Format == 0 {
+		hdr.Format = tar.FormatGNU
+	}
+}
+
+func writeMember(writer *ar.Writer, name string, data []byte) error {
+	header := ar.Header{
+		Name: name,
+		Mode: 0644,
+		Size: int64(len(data)),
+	}
+	if err := writer.WriteHeader(&header); err != nil {
+		return err
+	}
+	_, err := writer.Write(data)
+	return err
+}
+
+func compress(input []byte, compression Compression) ([]byte, error) {
+	if compression == None {
+		return input, nil
+	}
+	var buf bytes.Buffer
+	var writer io.WriteCloser
+	var err error
+	switch compression {
+	case Zstd:
+		writer, err = zstd.NewWriter(&buf)
+	case Gzip:
+		writer = gzip.NewWriter(&buf)
+	case Xz:
+		writer, err = xz.NewWriter(&buf)
+	case Lzma:
+		writer, err = lzma.NewWriter(&buf)
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", compression)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err = writer.Write(input); err != nil {
+		return nil, err
+	}
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
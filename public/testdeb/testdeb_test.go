@@ -0,0 +1,99 @@
+package testdeb_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+
+	"github.com/blakesmith/ar"
+	. "gopkg.in/check.v1"
+
+	"github.com/canonical/chisel/internal/deb"
+	"github.com/canonical/chisel/internal/testutil"
+	"github.com/canonical/chisel/public/testdeb"
+)
+
+var testEntries = []testdeb.Entry{
+	testdeb.Dir(0755, "./"),
+	testdeb.Dir(0755, "./usr/"),
+	testdeb.Dir(0755, "./usr/bin/"),
+	testdeb.Reg(0755, "./usr/bin/hello", "echo hello"),
+	testdeb.Lnk(0777, "./usr/bin/hi", "hello"),
+	testdeb.Owned(testdeb.Reg(0600, "./usr/secret", "data"), 1000, 1000, "user", "user"),
+}
+
+func (s *S) TestMake(c *C) {
+	for _, compression := range []testdeb.Compression{testdeb.Zstd, testdeb.Gzip, testdeb.Xz, testdeb.Lzma, testdeb.None} {
+		c.Logf("Compression: %s", compression)
+		data, err := testdeb.Make(testEntries, &testdeb.Options{Compression: compression})
+		c.Assert(err, IsNil)
+
+		var names []string
+		arReader := ar.NewReader(bytes.NewReader(data))
+		for {
+			hdr, err := arReader.Next()
+			if err == io.EOF {
+				break
+			}
+			c.Assert(err, IsNil)
+			names = append(names, hdr.Name)
+		}
+		ext := "." + string(compression)
+		if compression == testdeb.None {
+			ext = ""
+		}
+		c.Assert(names, DeepEquals, []string{
+			"debian-binary",
+			"control.tar" + ext,
+			"data.tar" + ext,
+		})
+
+		dir := c.MkDir()
+		err = deb.Extract(bytes.NewReader(data), &deb.ExtractOptions{
+			Package:   "test-package",
+			TargetDir: dir,
+			Extract: map[string][]deb.ExtractInfo{
+				"/**": {{Path: "/**"}},
+			},
+		})
+		c.Assert(err, IsNil)
+		c.Assert(testutil.TreeDump(dir), DeepEquals, map[string]string{
+			"/usr/":          "dir 0755",
+			"/usr/bin/":      "dir 0755",
+			"/usr/bin/hello": "file 0755 584a331f",
+			"/usr/bin/hi":    "symlink hello",
+			"/usr/secret":    "file 0600 3a6eb079",
+		})
+	}
+}
+
+func (s *S) TestMakeTar(c *C) {
+	data, err := testdeb.MakeTar([]testdeb.Entry{
+		testdeb.Reg(0644, "./file", "data"),
+		testdeb.Hln(0644, "./link", "./file"),
+	})
+	c.Assert(err, IsNil)
+	tarReader := tar.NewReader(bytes.NewReader(data))
+	_, err = tarReader.Next()
+	c.Assert(err, IsNil)
+	hdr, err := tarReader.Next()
+	c.Assert(err, IsNil)
+	c.Assert(hdr.Typeflag, Equals, byte(tar.TypeLink))
+	c.Assert(hdr.Linkname, Equals, "./file")
+	c.Assert(hdr.Uname, Equals, "root")
+	_, err = tarReader.Next()
+	c.Assert(err, Equals, io.EOF)
+}
+
+func (s *S) TestMakeUnsupportedCompression(c *C) {
+	_, err := testdeb.Make(testEntries, &testdeb.Options{Compression: "bz2"})
+	c.Assert(err, ErrorMatches, `unsupported compression "bz2"`)
+}
+
+func (s *S) TestMustMake(c *C) {
+	defer func() {
+		err := recover()
+		c.Assert(err, ErrorMatches, `unsupported compression "bz2"`)
+	}()
+	testdeb.MustMake(testEntries, &testdeb.Options{Compression: "bz2"})
+}